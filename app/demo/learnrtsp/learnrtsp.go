@@ -34,7 +34,7 @@ var asc = []byte{
 type Obs struct {
 }
 
-func (obs *Obs) OnNewRTSPPubSession(session *rtsp.PubSession) {
+func (obs *Obs) OnNewRTSPPubSession(session *rtsp.PubSession) bool {
 	nazalog.Debugf("OnNewRTSPPubSession. %+v", session)
 
 	avcFp, err := os.Create("/tmp/rtsp.h264")
@@ -64,12 +64,27 @@ func (obs *Obs) OnNewRTSPPubSession(session *rtsp.PubSession) {
 			_ = aacFp.Sync()
 		}
 	})
+	return true
 }
 
 func (obs *Obs) OnDelRTSPPubSession(session *rtsp.PubSession) {
 	nazalog.Debugf("OnDelRTSPPubSession. %+v", session)
 }
 
+func (obs *Obs) OnNewRTSPSubSessionDescribe(session *rtsp.SubSession) bool {
+	nazalog.Debugf("OnNewRTSPSubSessionDescribe. %+v", session)
+	return false
+}
+
+func (obs *Obs) OnNewRTSPSubSessionPlay(session *rtsp.SubSession) bool {
+	nazalog.Debugf("OnNewRTSPSubSessionPlay. %+v", session)
+	return false
+}
+
+func (obs *Obs) OnDelRTSPSubSession(session *rtsp.SubSession) {
+	nazalog.Debugf("OnDelRTSPSubSession. %+v", session)
+}
+
 func main() {
 	var obs Obs
 	s := rtsp.NewServer(":5544", &obs)
@@ -165,3 +165,43 @@ func ParseAACSeqHeader(b []byte) (sh SequenceHeader, adts ADTS, err error) {
 	err = adts.InitWithAACAudioSpecificConfig(b[2:])
 	return
 }
+
+// 使用AudioSpecificConfig构造rtmp/flv的AAC Seq Header
+//
+// @param <asc> 2字节的AAC Audio Specifc Config
+//
+// @return rtmp/flv的message/tag的payload部分，包含前面2个字节
+func BuildAACSeqHeader(asc []byte) ([]byte, error) {
+	if len(asc) < 2 {
+		nazalog.Warnf("aac asc length invalid. len=%d", len(asc))
+		return nil, ErrAAC
+	}
+
+	// soundFormat=10(AAC), soundRate=3(44kHz), soundSize=1(16bit), soundType=1(stereo)
+	ret := make([]byte, 2+len(asc))
+	ret[0] = 0xAF
+	ret[1] = 0x00 // aacPacketType, 0=seq header
+	copy(ret[2:], asc)
+	return ret, nil
+}
+
+var samplingFrequencyTable = []int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// 解析AudioSpecificConfig，获取采样率以及声道数
+//
+// @param <asc> 2字节的AAC Audio Specifc Config
+//              注意，如果是rtmp/flv的message/tag，应去除Seq Header头部的2个字节
+func ParseSamplingFrequencyAndChannels(asc []byte) (samplingFrequency int, channels int, err error) {
+	var a ADTS
+	if err = a.InitWithAACAudioSpecificConfig(asc); err != nil {
+		return
+	}
+	if int(a.samplingFrequencyIndex) >= len(samplingFrequencyTable) {
+		nazalog.Warnf("aac sampling frequency index invalid. index=%d", a.samplingFrequencyIndex)
+		err = ErrAAC
+		return
+	}
+	return samplingFrequencyTable[a.samplingFrequencyIndex], int(a.channelConfiguration), nil
+}
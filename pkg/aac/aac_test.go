@@ -44,6 +44,24 @@ func TestADTS(t *testing.T) {
 	assert.Equal(t, nil, err)
 }
 
+func TestBuildAACSeqHeader(t *testing.T) {
+	sh, err := aac.BuildAACSeqHeader(goldenSH[2:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, goldenSH, sh)
+}
+
+func TestParseSamplingFrequencyAndChannels(t *testing.T) {
+	sf, ch, err := aac.ParseSamplingFrequencyAndChannels(goldenSH[2:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 48000, sf)
+	assert.Equal(t, 2, ch)
+
+	sf, ch, err = aac.ParseSamplingFrequencyAndChannels([]byte{0x12, 0x10})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 44100, sf)
+	assert.Equal(t, 2, ch)
+}
+
 func TestCorner(t *testing.T) {
 	var adts aac.ADTS
 	err := adts.InitWithAACAudioSpecificConfig(nil)
@@ -53,4 +71,10 @@ func TestCorner(t *testing.T) {
 
 	_, _, err = aac.ParseAACSeqHeader(nil)
 	assert.IsNotNil(t, err)
+
+	_, err = aac.BuildAACSeqHeader(nil)
+	assert.IsNotNil(t, err)
+
+	_, _, err = aac.ParseSamplingFrequencyAndChannels(nil)
+	assert.IsNotNil(t, err)
 }
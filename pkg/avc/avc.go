@@ -9,6 +9,7 @@
 package avc
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 
@@ -261,6 +262,42 @@ func ParseSPSPPSFromSeqHeader(payload []byte) (sps, pps []byte, err error) {
 	return
 }
 
+// 使用SPS和PPS构造AVCC格式的Seq Header，也即rtmp message或flv tag中视频Seq Header的payload部分
+//
+// @return 注意，返回的内存块为独立的内存块，包含头部2字节类型以及3字节的cts
+//
+func BuildSeqHeaderFromSPSPPS(sps, pps []byte) ([]byte, error) {
+	if len(sps) < 4 || len(pps) == 0 {
+		return nil, ErrAVC
+	}
+
+	// H.264-AVC-ISO_IEC_14496-15.pdf
+	// 5.2.4 Decoder configuration information
+	ret := make([]byte, 16+len(sps)+len(pps))
+	ret[0] = 0x17
+	ret[1] = 0x00
+	ret[2] = 0x00
+	ret[3] = 0x00
+	ret[4] = 0x00
+	ret[5] = 0x01   // configurationVersion
+	ret[6] = sps[1] // AVCProfileIndication
+	ret[7] = sps[2] // profile_compatibility
+	ret[8] = sps[3] // AVCLevelIndication
+	ret[9] = 0xFF   // '111111'b + lengthSizeMinusOne(3)
+	ret[10] = 0xE1  // '111'b + numOfSequenceParameterSets(1)
+	index := 11
+	binary.BigEndian.PutUint16(ret[index:], uint16(len(sps)))
+	index += 2
+	copy(ret[index:], sps)
+	index += len(sps)
+	ret[index] = 0x01 // numOfPictureParameterSets
+	index++
+	binary.BigEndian.PutUint16(ret[index:], uint16(len(pps)))
+	index += 2
+	copy(ret[index:], pps)
+	return ret, nil
+}
+
 // AVCC -> AnnexB
 //
 // @param <payload> rtmp message的payload部分或者flv tag的payload部分
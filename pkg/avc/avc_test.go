@@ -86,6 +86,15 @@ func TestSPSPPSSeqHeader2AnnexB(t *testing.T) {
 	assert.Equal(t, expected, out)
 }
 
+func TestBuildSeqHeaderFromSPSPPS(t *testing.T) {
+	sh, err := avc.BuildSeqHeaderFromSPSPPS(sps, pps)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, seqHeader, sh)
+
+	_, err = avc.BuildSeqHeaderFromSPSPPS(nil, pps)
+	assert.Equal(t, avc.ErrAVC, err)
+}
+
 func TestCaptureAVC(t *testing.T) {
 	b := &bytes.Buffer{}
 	err := avc.CaptureAVCC2AnnexB(b, []byte{0x17, 0x0, 0x0, 0x0, 0x0, 0x1, 0x64, 0x0, 0x1f, 0xff, 0xe1, 0x0, 0xa, 0x27, 0x64, 0x0, 0x1f, 0xac, 0x56, 0x80, 0xb4, 0xa, 0x19, 0x1, 0x0, 0x4, 0x28, 0xee, 0x3c, 0xb0})
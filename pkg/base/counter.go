@@ -0,0 +1,38 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import "sync/atomic"
+
+// 进程级别的计数器，各个包在对应的事件发生时累加，由上层（比如lalserver的/metrics）读取
+var (
+	// 写入connection时，由于异步发送的channel已满而被丢弃的次数
+	WriteChanFullDroppedCounter Counter
+
+	// rtmp握手失败的次数，包含服务端和客户端
+	RTMPHandshakeFailedCounter Counter
+)
+
+// 并发安全的计数器
+type Counter struct {
+	// 注意，原子操作的64位字段放在结构体的开头，保证在32位平台上也是8字节对齐的
+	n uint64
+}
+
+func (c *Counter) Incr() {
+	atomic.AddUint64(&c.n, 1)
+}
+
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.n, delta)
+}
+
+func (c *Counter) Get() uint64 {
+	return atomic.LoadUint64(&c.n)
+}
@@ -0,0 +1,60 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import "time"
+
+// 各协议的会话统一使用的统计信息，比如用于HTTP API
+
+const (
+	ProtocolRTMP    = "rtmp"
+	ProtocolHTTPFLV = "httpflv"
+	ProtocolRTSP    = "rtsp"
+)
+
+type StatSession struct {
+	Protocol      string `json:"protocol"`
+	SessionID     string `json:"session_id"`
+	StartTime     string `json:"start_time"`
+	RemoteAddr    string `json:"remote_addr"`
+	ReadBytesSum  uint64 `json:"read_bytes_sum"`
+	WroteBytesSum uint64 `json:"wrote_bytes_sum"`
+	ReadBitrate   int    `json:"read_bitrate"`   // 单位kbit/s
+	WroteBitrate  int    `json:"wrote_bitrate"`  // 单位kbit/s
+	AudioCount    uint64 `json:"audio_count"`    // 输入类型的会话为读取的帧数，输出类型的会话为写入的帧数
+	VideoCount    uint64 `json:"video_count"`    // 同上
+	LastTimestamp uint32 `json:"last_timestamp"` // 最后一帧音视频的时间戳，单位毫秒
+}
+
+// @param conn 为nil时，不填充字节数和码率
+// @param av   为nil时，不填充帧数和时间戳
+func NewStatSession(protocol string, sessionID string, startTime time.Time, remoteAddr string, conn *StatConn, av *StatAV) StatSession {
+	stat := StatSession{
+		Protocol:   protocol,
+		SessionID:  sessionID,
+		StartTime:  FormatTime(startTime),
+		RemoteAddr: remoteAddr,
+	}
+	if conn != nil {
+		stat.ReadBytesSum = conn.ReadBytes()
+		stat.WroteBytesSum = conn.WroteBytes()
+		stat.ReadBitrate = conn.ReadBitrate()
+		stat.WroteBitrate = conn.WroteBitrate()
+	}
+	if av != nil {
+		stat.AudioCount = av.AudioCount()
+		stat.VideoCount = av.VideoCount()
+		stat.LastTimestamp = av.LastTimestamp()
+	}
+	return stat
+}
+
+func FormatTime(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05.000")
+}
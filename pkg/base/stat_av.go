@@ -0,0 +1,45 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import "sync/atomic"
+
+// 统计会话读取或写入的音频帧数，视频帧数（包含音视频头），以及最后一帧的时间戳
+//
+// 输入类型的会话（pub，pull）统计读取的，输出类型的会话（sub，push）统计写入的
+type StatAV struct {
+	// 注意，原子操作的64位字段放在结构体的开头，保证在32位平台上也是8字节对齐的
+	audioCount uint64
+	videoCount uint64
+
+	lastTimestamp uint32
+}
+
+func (s *StatAV) FeedAudio(timestamp uint32) {
+	atomic.AddUint64(&s.audioCount, 1)
+	atomic.StoreUint32(&s.lastTimestamp, timestamp)
+}
+
+func (s *StatAV) FeedVideo(timestamp uint32) {
+	atomic.AddUint64(&s.videoCount, 1)
+	atomic.StoreUint32(&s.lastTimestamp, timestamp)
+}
+
+func (s *StatAV) AudioCount() uint64 {
+	return atomic.LoadUint64(&s.audioCount)
+}
+
+func (s *StatAV) VideoCount() uint64 {
+	return atomic.LoadUint64(&s.videoCount)
+}
+
+// 单位毫秒
+func (s *StatAV) LastTimestamp() uint32 {
+	return atomic.LoadUint32(&s.lastTimestamp)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package base
+
+import (
+	"sync/atomic"
+
+	"github.com/q191201771/naza/pkg/bitrate"
+	"github.com/q191201771/naza/pkg/connection"
+)
+
+// 计算码率的时间窗口
+var statBitrateWindowMS = 5000
+
+// 在connection.Connection的基础上，统计读取和写入的字节数以及码率
+//
+// 注意，写入的字节数在调用Write时统计，conn使用channel异步发送时，统计的是交给conn的字节数
+type StatConn struct {
+	// 注意，原子操作的64位字段放在结构体的开头，保证在32位平台上也是8字节对齐的
+	readBytes  uint64
+	wroteBytes uint64
+
+	readBitrate  bitrate.Bitrate
+	wroteBitrate bitrate.Bitrate
+
+	connection.Connection
+}
+
+func NewStatConn(conn connection.Connection) *StatConn {
+	return &StatConn{
+		readBitrate:  newBitrate(),
+		wroteBitrate: newBitrate(),
+		Connection:   conn,
+	}
+}
+
+func (c *StatConn) Read(b []byte) (n int, err error) {
+	n, err = c.Connection.Read(b)
+	atomic.AddUint64(&c.readBytes, uint64(n))
+	c.readBitrate.Add(n)
+	return
+}
+
+func (c *StatConn) Write(b []byte) (n int, err error) {
+	n, err = c.Connection.Write(b)
+	if err == connection.ErrWriteChanFull {
+		WriteChanFullDroppedCounter.Incr()
+	}
+	atomic.AddUint64(&c.wroteBytes, uint64(n))
+	c.wroteBitrate.Add(n)
+	return
+}
+
+// 统计不经过这个连接读取的数据，比如rtsp使用UDP方式接收的RTP/RTCP包
+func (c *StatConn) AddReadBytes(n int) {
+	atomic.AddUint64(&c.readBytes, uint64(n))
+	c.readBitrate.Add(n)
+}
+
+// 统计不经过这个连接写入的数据，比如rtsp使用UDP方式发送的RTP/RTCP包
+func (c *StatConn) AddWroteBytes(n int) {
+	atomic.AddUint64(&c.wroteBytes, uint64(n))
+	c.wroteBitrate.Add(n)
+}
+
+func (c *StatConn) ReadBytes() uint64 {
+	return atomic.LoadUint64(&c.readBytes)
+}
+
+func (c *StatConn) WroteBytes() uint64 {
+	return atomic.LoadUint64(&c.wroteBytes)
+}
+
+// 最近一段时间读取的码率，单位kbit/s
+func (c *StatConn) ReadBitrate() int {
+	return int(c.readBitrate.Rate())
+}
+
+// 最近一段时间写入的码率，单位kbit/s
+func (c *StatConn) WroteBitrate() int {
+	return int(c.wroteBitrate.Rate())
+}
+
+func newBitrate() bitrate.Bitrate {
+	return bitrate.New(func(option *bitrate.Option) {
+		option.WindowMS = statBitrateWindowMS
+		option.Unit = bitrate.UnitKBitPerSec
+	})
+}
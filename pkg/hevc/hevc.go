@@ -8,24 +8,117 @@
 
 package hevc
 
+import (
+	"errors"
+	"io"
+
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazabits"
+)
+
+// AnnexB和AVCC（HVCC）的说明见pkg/avc
+
+var ErrHEVC = errors.New("lal.hevc: fxxk")
+
+var (
+	NALUStartCode3 = []byte{0x0, 0x0, 0x1}
+	NALUStartCode4 = []byte{0x0, 0x0, 0x0, 0x1}
+)
+
 var NALUTypeMapping = map[uint8]string{
 	NALUTypeSliceTrailR: "SLICE",
 	NALUTypeSliceIDR:    "I",
 	NALUTypeSliceIDRNLP: "IDR",
+	NALUTypeSliceCRA:    "CRA",
+	NALUTypeVPS:         "VPS",
+	NALUTypeSPS:         "SPS",
+	NALUTypePPS:         "PPS",
+	NALUTypeAUD:         "AUD",
 	NALUTypeSEI:         "SEI",
 	NALUTypeSEISuffix:   "SEI",
 }
 var (
 	NALUTypeSliceTrailR uint8 = 1  // 0x01
+	NALUTypeSliceBLAWLP uint8 = 16 // 0x10
 	NALUTypeSliceIDR    uint8 = 19 // 0x13
 	NALUTypeSliceIDRNLP uint8 = 20 // 0x14
+	NALUTypeSliceCRA    uint8 = 21 // 0x15
 	NALUTypeVPS         uint8 = 32 // 0x20
 	NALUTypeSPS         uint8 = 33 // 0x21
 	NALUTypePPS         uint8 = 34 // 0x22
+	NALUTypeAUD         uint8 = 35 // 0x23
 	NALUTypeSEI         uint8 = 39 // 0x27
 	NALUTypeSEISuffix   uint8 = 40 // 0x28
 )
 
+// HEVCDecoderConfigurationRecord中，numOfArrays之前的定长部分的大小
+//
+// ISO_IEC_14496-15.pdf
+// 8.3.3.1 HEVC decoder configuration record
+const dcrFixedLen = 22
+
+// ISO_IEC_14496-15.pdf
+// 8.3.3.1 HEVC decoder configuration record
+type DecoderConfigurationRecord struct {
+	ConfigurationVersion             uint8
+	GeneralProfileSpace              uint8
+	GeneralTierFlag                  uint8
+	GeneralProfileIdc                uint8
+	GeneralProfileCompatibilityFlags uint32
+	GeneralConstraintIndicatorFlags  uint64 // 48b
+	GeneralLevelIdc                  uint8
+	MinSpatialSegmentationIdc        uint16
+	ParallelismType                  uint8
+	ChromaFormat                     uint8
+	BitDepthLumaMinus8               uint8
+	BitDepthChromaMinus8             uint8
+	AvgFrameRate                     uint16
+	ConstantFrameRate                uint8
+	NumTemporalLayers                uint8
+	TemporalIdNested                 uint8
+	LengthSizeMinusOne               uint8
+
+	// 不包含start code
+	VPS [][]byte
+	SPS [][]byte
+	PPS [][]byte
+}
+
+// H.265-ITU-T.pdf
+// 7.3.2.2 Sequence parameter set RBSP syntax
+// 7.4.3.2 Sequence parameter set RBSP semantics
+//
+// 只解析到bit_depth_chroma_minus8
+type SPS struct {
+	SPSMaxSubLayersMinus1    uint8 // sps_max_sub_layers_minus1
+	SPSTemporalIDNestingFlag uint8 // sps_temporal_id_nesting_flag
+
+	// profile_tier_level中的general部分
+	GeneralProfileSpace              uint8
+	GeneralTierFlag                  uint8
+	GeneralProfileIdc                uint8 // 1 Main, 2 Main 10, 3 Main Still Picture, 4 Range Extensions
+	GeneralProfileCompatibilityFlags uint32
+	GeneralConstraintIndicatorFlags  uint64 // 48b
+	GeneralLevelIdc                  uint8  // level乘以30，比如93表示3.1
+
+	SPSSeqParameterSetID    uint32 // sps_seq_parameter_set_id
+	ChromaFormatIdc         uint32 // chroma_format_idc，0 单色，1 4:2:0，2 4:2:2，3 4:4:4
+	SeparateColourPlaneFlag uint8  // separate_colour_plane_flag
+	PicWidthInLumaSamples   uint32 // pic_width_in_luma_samples
+	PicHeightInLumaSamples  uint32 // pic_height_in_luma_samples
+	ConformanceWindowFlag   uint8  // conformance_window_flag
+	ConfWinLeftOffset       uint32 // conf_win_left_offset
+	ConfWinRightOffset      uint32 // conf_win_right_offset
+	ConfWinTopOffset        uint32 // conf_win_top_offset
+	ConfWinBottomOffset     uint32 // conf_win_bottom_offset
+	BitDepthLumaMinus8      uint32 // bit_depth_luma_minus8
+	BitDepthChromaMinus8    uint32 // bit_depth_chroma_minus8
+
+	// 根据conformance window裁剪后的宽高
+	Width  uint32
+	Height uint32
+}
+
 func ParseNALUTypeReadable(v uint8) string {
 	b, ok := NALUTypeMapping[ParseNALUType(v)]
 	if !ok {
@@ -40,3 +133,455 @@ func ParseNALUType(v uint8) uint8 {
 	// or return (nalu[0] >> 1) & 0x3F
 	return (v & 0x7E) >> 1
 }
+
+// 是否为IRAP（Intra Random Access Point）帧，也即BLA、IDR、CRA，可以作为随机访问的起点
+//
+// H.265-ITU-T.pdf
+// Table 7-1 – NAL unit type codes and NAL unit type classes
+func IsIRAP(naluType uint8) bool {
+	return naluType >= NALUTypeSliceBLAWLP && naluType <= 23
+}
+
+// HVCC Seq Header -> AnnexB
+// 注意，返回的内存块为独立的内存块，不依赖指向传输参数<payload>内存块
+//
+func VPSSPSPPSSeqHeader2AnnexB(payload []byte) ([]byte, error) {
+	vps, sps, pps, err := ParseVPSSPSPPSFromSeqHeader(payload)
+	if err != nil {
+		return nil, err
+	}
+	var ret []byte
+	ret = append(ret, NALUStartCode4...)
+	ret = append(ret, vps...)
+	ret = append(ret, NALUStartCode4...)
+	ret = append(ret, sps...)
+	ret = append(ret, NALUStartCode4...)
+	ret = append(ret, pps...)
+	return ret, nil
+}
+
+// 从HVCC格式的Seq Header中得到VPS，SPS，PPS内存块
+// 如果某种类型存在多个，只取第一个
+//
+// @param <payload> rtmp message的payload部分或者flv tag的payload部分
+//                  注意，包含了头部2字节类型以及3字节的cts，或者Enhanced RTMP的1字节扩展头以及4字节FourCC
+//
+// @return 注意，返回的vps，sps，pps内存块指向的是传入参数<payload>内存块的内存
+//
+func ParseVPSSPSPPSFromSeqHeader(payload []byte) (vps, sps, pps []byte, err error) {
+	if len(payload) < 5 {
+		return nil, nil, nil, ErrHEVC
+	}
+	// 传统方式为0x1c 0x00以及3字节的cts，Enhanced RTMP扩展头方式为PacketType SequenceStart以及4字节的FourCC
+	isLegacy := payload[0] == 0x1c && payload[1] == 0x00 && payload[2] == 0 && payload[3] == 0 && payload[4] == 0
+	isEx := payload[0]&0x80 != 0 && payload[0]&0xF == 0 && string(payload[1:5]) == "hvc1"
+	if !isLegacy && !isEx {
+		return nil, nil, nil, ErrHEVC
+	}
+
+	dcr, err := ParseDecoderConfigurationRecord(payload[5:])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(dcr.VPS) == 0 || len(dcr.SPS) == 0 || len(dcr.PPS) == 0 {
+		return nil, nil, nil, ErrHEVC
+	}
+	return dcr.VPS[0], dcr.SPS[0], dcr.PPS[0], nil
+}
+
+// 解析HEVCDecoderConfigurationRecord
+//
+// @param b 不包含rtmp message或flv tag头部的2字节类型以及3字节的cts
+//
+// @return 注意，返回的VPS，SPS，PPS内存块指向的是传入参数<b>内存块的内存
+//
+func ParseDecoderConfigurationRecord(b []byte) (dcr DecoderConfigurationRecord, err error) {
+	if len(b) < dcrFixedLen+1 {
+		return dcr, ErrHEVC
+	}
+
+	// ISO_IEC_14496-15.pdf
+	// 8.3.3.1 HEVC decoder configuration record
+	br := nazabits.NewBitReader(b)
+	dcr.ConfigurationVersion, _ = br.ReadBits8(8)
+	dcr.GeneralProfileSpace, _ = br.ReadBits8(2)
+	dcr.GeneralTierFlag, _ = br.ReadBits8(1)
+	dcr.GeneralProfileIdc, _ = br.ReadBits8(5)
+	dcr.GeneralProfileCompatibilityFlags, _ = br.ReadBits32(32)
+	hi, _ := br.ReadBits16(16)
+	lo, _ := br.ReadBits32(32)
+	dcr.GeneralConstraintIndicatorFlags = uint64(hi)<<32 | uint64(lo)
+	dcr.GeneralLevelIdc, _ = br.ReadBits8(8)
+	_, _ = br.ReadBits8(4) // reserved = '1111'b
+	dcr.MinSpatialSegmentationIdc, _ = br.ReadBits16(12)
+	_, _ = br.ReadBits8(6) // reserved = '111111'b
+	dcr.ParallelismType, _ = br.ReadBits8(2)
+	_, _ = br.ReadBits8(6) // reserved = '111111'b
+	dcr.ChromaFormat, _ = br.ReadBits8(2)
+	_, _ = br.ReadBits8(5) // reserved = '11111'b
+	dcr.BitDepthLumaMinus8, _ = br.ReadBits8(3)
+	_, _ = br.ReadBits8(5) // reserved = '11111'b
+	dcr.BitDepthChromaMinus8, _ = br.ReadBits8(3)
+	dcr.AvgFrameRate, _ = br.ReadBits16(16)
+	dcr.ConstantFrameRate, _ = br.ReadBits8(2)
+	dcr.NumTemporalLayers, _ = br.ReadBits8(3)
+	dcr.TemporalIdNested, _ = br.ReadBits8(1)
+	dcr.LengthSizeMinusOne, _ = br.ReadBits8(2)
+
+	index := dcrFixedLen
+	numOfArrays := int(b[index])
+	index++
+	for i := 0; i < numOfArrays; i++ {
+		if len(b) < index+3 {
+			return dcr, ErrHEVC
+		}
+		// array_completeness(1) + reserved(1) + NAL_unit_type(6)
+		naluType := b[index] & 0x3F
+		index++
+		numNalus := int(bele.BEUint16(b[index:]))
+		index += 2
+		for j := 0; j < numNalus; j++ {
+			if len(b) < index+2 {
+				return dcr, ErrHEVC
+			}
+			naluLength := int(bele.BEUint16(b[index:]))
+			index += 2
+			if len(b) < index+naluLength {
+				return dcr, ErrHEVC
+			}
+			nalu := b[index : index+naluLength]
+			index += naluLength
+
+			switch naluType {
+			case NALUTypeVPS:
+				dcr.VPS = append(dcr.VPS, nalu)
+			case NALUTypeSPS:
+				dcr.SPS = append(dcr.SPS, nalu)
+			case NALUTypePPS:
+				dcr.PPS = append(dcr.PPS, nalu)
+			}
+		}
+	}
+	return dcr, nil
+}
+
+// 使用VPS，SPS，PPS构造HVCC格式的Seq Header，也即rtmp message或flv tag中视频Seq Header的payload部分
+//
+// HEVCDecoderConfigurationRecord中的profile，tier，level，chromaFormat，bitDepth等信息，取自SPS
+//
+// @param vps, sps, pps 不包含start code
+//
+// @return 注意，返回的内存块为独立的内存块，包含头部2字节类型以及3字节的cts
+//
+func BuildSeqHeaderFromVPSSPSPPS(vps, sps, pps []byte) ([]byte, error) {
+	if len(vps) < 2 || len(pps) < 2 {
+		return nil, ErrHEVC
+	}
+	ctx, err := ParseSPS(sps)
+	if err != nil {
+		return nil, ErrHEVC
+	}
+
+	// ISO_IEC_14496-15.pdf
+	// 8.3.3.1 HEVC decoder configuration record
+	ret := make([]byte, 5+dcrFixedLen, 5+dcrFixedLen+1+3*5+len(vps)+len(sps)+len(pps))
+	ret[0] = 0x1c
+	ret[1] = 0x00
+	ret[2] = 0x00
+	ret[3] = 0x00
+	ret[4] = 0x00
+	ret[5] = 0x01 // configurationVersion
+	// general_profile_space(2) + general_tier_flag(1) + general_profile_idc(5)
+	ret[6] = ctx.GeneralProfileSpace<<6 | ctx.GeneralTierFlag<<5 | ctx.GeneralProfileIdc
+	bele.BEPutUint32(ret[7:], ctx.GeneralProfileCompatibilityFlags)
+	// general_constraint_indicator_flags(48)
+	bele.BEPutUint32(ret[11:], uint32(ctx.GeneralConstraintIndicatorFlags>>16))
+	ret[15] = uint8(ctx.GeneralConstraintIndicatorFlags >> 8)
+	ret[16] = uint8(ctx.GeneralConstraintIndicatorFlags)
+	ret[17] = ctx.GeneralLevelIdc
+	ret[18] = 0xf0 // '1111'b + min_spatial_segmentation_idc(12)
+	ret[19] = 0x00
+	ret[20] = 0xfc                                   // '111111'b + parallelismType(2)
+	ret[21] = 0xfc | uint8(ctx.ChromaFormatIdc)      // '111111'b + chromaFormat(2)
+	ret[22] = 0xf8 | uint8(ctx.BitDepthLumaMinus8)   // '11111'b + bitDepthLumaMinus8(3)
+	ret[23] = 0xf8 | uint8(ctx.BitDepthChromaMinus8) // '11111'b + bitDepthChromaMinus8(3)
+	ret[24] = 0x00                                   // avgFrameRate
+	ret[25] = 0x00
+	// constantFrameRate(2) + numTemporalLayers(3) + temporalIdNested(1) + lengthSizeMinusOne(2)
+	ret[26] = (ctx.SPSMaxSubLayersMinus1+1)<<3 | ctx.SPSTemporalIDNestingFlag<<2 | 0x3
+	ret = append(ret, 0x03) // numOfArrays
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		// array_completeness(1) + reserved(1) + NAL_unit_type(6)
+		ret = append(ret, 0x80|ParseNALUType(nalu[0]))
+		ret = append(ret, 0x00, 0x01) // numNalus
+		ret = append(ret, uint8(len(nalu)>>8), uint8(len(nalu)))
+		ret = append(ret, nalu...)
+	}
+	return ret, nil
+}
+
+// 使用AnnexB格式的VPS，SPS，PPS构造HVCC格式的Seq Header
+//
+// @param b 包含VPS，SPS，PPS的AnnexB数据，每个NALU前有start code，也可以包含其他类型的NALU（会被忽略）
+//
+// @return 见BuildSeqHeaderFromVPSSPSPPS
+//
+func BuildSeqHeaderFromAnnexB(b []byte) ([]byte, error) {
+	var vps, sps, pps []byte
+	for _, nalu := range SplitNALUAnnexB(b) {
+		switch ParseNALUType(nalu[0]) {
+		case NALUTypeVPS:
+			vps = nalu
+		case NALUTypeSPS:
+			sps = nalu
+		case NALUTypePPS:
+			pps = nalu
+		}
+	}
+	if vps == nil || sps == nil || pps == nil {
+		return nil, ErrHEVC
+	}
+	return BuildSeqHeaderFromVPSSPSPPS(vps, sps, pps)
+}
+
+// 将AnnexB格式的数据拆分为多个NALU，start code可以是3字节或4字节
+//
+// @return 注意，返回的NALU内存块指向的是传入参数<b>内存块的内存，不包含start code
+//
+func SplitNALUAnnexB(b []byte) (nalus [][]byte) {
+	start := -1
+	for i := 0; i+3 <= len(b); {
+		if b[i] == 0 && b[i+1] == 0 && b[i+2] == 1 {
+			if start != -1 {
+				end := i
+				// 4字节start code的第一个0属于这个start code，不属于前一个NALU
+				if end > start && b[end-1] == 0 {
+					end--
+				}
+				if end > start {
+					nalus = append(nalus, b[start:end])
+				}
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start != -1 && start < len(b) {
+		nalus = append(nalus, b[start:])
+	}
+	return
+}
+
+// HVCC -> AnnexB
+//
+// @param <payload> rtmp message的payload部分或者flv tag的payload部分
+//                  注意，包含了头部2字节类型以及3字节的cts
+//
+func CaptureHVCC2AnnexB(w io.Writer, payload []byte) error {
+	if len(payload) < 5 {
+		return ErrHEVC
+	}
+
+	// vps sps pps
+	if payload[0] == 0x1c && payload[1] == 0x00 {
+		b, err := VPSSPSPPSSeqHeader2AnnexB(payload)
+		if err != nil {
+			return err
+		}
+		_, _ = w.Write(b)
+		return nil
+	}
+
+	// payload中可能存在多个nalu
+	for i := 5; i != len(payload); {
+		if i+4 > len(payload) {
+			return ErrHEVC
+		}
+		naluLen := int(bele.BEUint32(payload[i:]))
+		i += 4
+		if i+naluLen > len(payload) {
+			return ErrHEVC
+		}
+		_, _ = w.Write(NALUStartCode4)
+		_, _ = w.Write(payload[i : i+naluLen])
+		i += naluLen
+	}
+	return nil
+}
+
+// 解析SPS，得到profile，level，宽高，色度格式，位深等信息
+//
+// H.265-ITU-T.pdf
+// 7.3.2.2 Sequence parameter set RBSP syntax
+// 7.3.3 Profile, tier and level syntax
+//
+// @param sps 不包含start code，包含2字节的NAL头
+//
+func ParseSPS(sps []byte) (ctx SPS, err error) {
+	if len(sps) < 2 || ParseNALUType(sps[0]) != NALUTypeSPS {
+		return ctx, ErrHEVC
+	}
+
+	br := nazabits.NewBitReader(EBSP2RBSP(sps[2:]))
+
+	if _, err = br.ReadBits8(4); err != nil { // sps_video_parameter_set_id
+		return ctx, err
+	}
+	if ctx.SPSMaxSubLayersMinus1, err = br.ReadBits8(3); err != nil {
+		return ctx, err
+	}
+	if ctx.SPSTemporalIDNestingFlag, err = br.ReadBits8(1); err != nil {
+		return ctx, err
+	}
+
+	if err = parseProfileTierLevel(&br, &ctx); err != nil {
+		return ctx, err
+	}
+
+	if ctx.SPSSeqParameterSetID, err = br.ReadGolomb(); err != nil {
+		return ctx, err
+	}
+	if ctx.ChromaFormatIdc, err = br.ReadGolomb(); err != nil {
+		return ctx, err
+	}
+	if ctx.ChromaFormatIdc > 3 {
+		return ctx, ErrHEVC
+	}
+	if ctx.ChromaFormatIdc == 3 {
+		if ctx.SeparateColourPlaneFlag, err = br.ReadBits8(1); err != nil {
+			return ctx, err
+		}
+	}
+	if ctx.PicWidthInLumaSamples, err = br.ReadGolomb(); err != nil {
+		return ctx, err
+	}
+	if ctx.PicHeightInLumaSamples, err = br.ReadGolomb(); err != nil {
+		return ctx, err
+	}
+	if ctx.ConformanceWindowFlag, err = br.ReadBits8(1); err != nil {
+		return ctx, err
+	}
+	if ctx.ConformanceWindowFlag == 1 {
+		for _, v := range []*uint32{&ctx.ConfWinLeftOffset, &ctx.ConfWinRightOffset, &ctx.ConfWinTopOffset, &ctx.ConfWinBottomOffset} {
+			if *v, err = br.ReadGolomb(); err != nil {
+				return ctx, err
+			}
+		}
+	}
+	if ctx.BitDepthLumaMinus8, err = br.ReadGolomb(); err != nil {
+		return ctx, err
+	}
+	if ctx.BitDepthChromaMinus8, err = br.ReadGolomb(); err != nil {
+		return ctx, err
+	}
+	if ctx.BitDepthLumaMinus8 > 8 || ctx.BitDepthChromaMinus8 > 8 {
+		return ctx, ErrHEVC
+	}
+
+	// 6.2 Source, decoded and output picture formats
+	// Table 6-1 – SubWidthC and SubHeightC values derived from chroma_format_idc and separate_colour_plane_flag
+	subWidthC := uint32(1)
+	subHeightC := uint32(1)
+	if ctx.SeparateColourPlaneFlag == 0 {
+		switch ctx.ChromaFormatIdc {
+		case 1:
+			subWidthC, subHeightC = 2, 2
+		case 2:
+			subWidthC = 2
+		}
+	}
+	ctx.Width = ctx.PicWidthInLumaSamples - subWidthC*(ctx.ConfWinLeftOffset+ctx.ConfWinRightOffset)
+	ctx.Height = ctx.PicHeightInLumaSamples - subHeightC*(ctx.ConfWinTopOffset+ctx.ConfWinBottomOffset)
+	return ctx, nil
+}
+
+// 7.3.3 Profile, tier and level syntax
+// profilePresentFlag为1
+func parseProfileTierLevel(br *nazabits.BitReader, ctx *SPS) (err error) {
+	if ctx.GeneralProfileSpace, err = br.ReadBits8(2); err != nil {
+		return
+	}
+	if ctx.GeneralTierFlag, err = br.ReadBits8(1); err != nil {
+		return
+	}
+	if ctx.GeneralProfileIdc, err = br.ReadBits8(5); err != nil {
+		return
+	}
+	if ctx.GeneralProfileCompatibilityFlags, err = br.ReadBits32(32); err != nil {
+		return
+	}
+	// general_progressive_source_flag等标志位，共48比特
+	var hi uint16
+	var lo uint32
+	if hi, err = br.ReadBits16(16); err != nil {
+		return
+	}
+	if lo, err = br.ReadBits32(32); err != nil {
+		return
+	}
+	ctx.GeneralConstraintIndicatorFlags = uint64(hi)<<32 | uint64(lo)
+	if ctx.GeneralLevelIdc, err = br.ReadBits8(8); err != nil {
+		return
+	}
+
+	subLayerProfilePresentFlag := make([]uint8, ctx.SPSMaxSubLayersMinus1)
+	subLayerLevelPresentFlag := make([]uint8, ctx.SPSMaxSubLayersMinus1)
+	for i := uint8(0); i < ctx.SPSMaxSubLayersMinus1; i++ {
+		if subLayerProfilePresentFlag[i], err = br.ReadBits8(1); err != nil {
+			return
+		}
+		if subLayerLevelPresentFlag[i], err = br.ReadBits8(1); err != nil {
+			return
+		}
+	}
+	if ctx.SPSMaxSubLayersMinus1 > 0 {
+		for i := ctx.SPSMaxSubLayersMinus1; i < 8; i++ {
+			if _, err = br.ReadBits8(2); err != nil { // reserved_zero_2bits
+				return
+			}
+		}
+	}
+	for i := uint8(0); i < ctx.SPSMaxSubLayersMinus1; i++ {
+		if subLayerProfilePresentFlag[i] == 1 {
+			// sub_layer_profile_space ... sub_layer_inbld_flag/reserved，共88比特
+			if _, err = br.ReadBits32(32); err != nil {
+				return
+			}
+			if _, err = br.ReadBits32(32); err != nil {
+				return
+			}
+			if _, err = br.ReadBits32(24); err != nil {
+				return
+			}
+		}
+		if subLayerLevelPresentFlag[i] == 1 {
+			if _, err = br.ReadBits8(8); err != nil { // sub_layer_level_idc
+				return
+			}
+		}
+	}
+	return
+}
+
+// 去除防竞争字节，也即将 0x00 0x00 0x03 转换为 0x00 0x00
+//
+// @return 注意，返回的内存块为独立的内存块
+func EBSP2RBSP(b []byte) []byte {
+	ret := make([]byte, 0, len(b))
+	zeroCount := 0
+	for _, v := range b {
+		if zeroCount == 2 && v == 0x03 {
+			zeroCount = 0
+			continue
+		}
+		ret = append(ret, v)
+		if v == 0 {
+			zeroCount++
+		} else {
+			zeroCount = 0
+		}
+	}
+	return ret
+}
@@ -0,0 +1,179 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package hevc_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+var (
+	goldenVPS = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0x95, 0x98, 0x09}
+	goldenSPS = []byte{0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16, 0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70, 0x80, 0x00, 0x01, 0xf4, 0x80, 0x00, 0x3a, 0x98, 0x04}
+	goldenPPS = []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+)
+
+// 构造HVCC格式的Seq Header
+func buildSeqHeader() []byte {
+	b := []byte{0x1c, 0x00, 0x00, 0x00, 0x00}
+	// HEVCDecoderConfigurationRecord的定长部分
+	b = append(b, 0x01, 0x01, 0x60, 0x00, 0x00, 0x00, 0x90, 0x00, 0x00, 0x00, 0x00, 0x00, 0x5d, 0xf0, 0x00, 0xfc, 0xfd, 0xf8, 0xf8, 0x00, 0x00, 0x0f)
+	b = append(b, 0x03)
+	for _, nalu := range [][]byte{goldenVPS, goldenSPS, goldenPPS} {
+		b = append(b, 0x80|hevc.ParseNALUType(nalu[0]), 0x00, 0x01, uint8(len(nalu)>>8), uint8(len(nalu)))
+		b = append(b, nalu...)
+	}
+	return b
+}
+
+func TestParseNALUType(t *testing.T) {
+	golden := map[uint8]uint8{
+		0x02: hevc.NALUTypeSliceTrailR,
+		0x26: hevc.NALUTypeSliceIDR,
+		0x28: hevc.NALUTypeSliceIDRNLP,
+		0x2a: hevc.NALUTypeSliceCRA,
+		0x40: hevc.NALUTypeVPS,
+		0x42: hevc.NALUTypeSPS,
+		0x44: hevc.NALUTypePPS,
+		0x46: hevc.NALUTypeAUD,
+		0x4e: hevc.NALUTypeSEI,
+	}
+	for in, out := range golden {
+		assert.Equal(t, out, hevc.ParseNALUType(in))
+	}
+
+	assert.Equal(t, "CRA", hevc.ParseNALUTypeReadable(0x2a))
+	assert.Equal(t, true, hevc.IsIRAP(hevc.NALUTypeSliceIDR))
+	assert.Equal(t, true, hevc.IsIRAP(hevc.NALUTypeSliceCRA))
+	assert.Equal(t, false, hevc.IsIRAP(hevc.NALUTypeSliceTrailR))
+	assert.Equal(t, false, hevc.IsIRAP(hevc.NALUTypeVPS))
+}
+
+func TestParseVPSSPSPPSFromSeqHeader(t *testing.T) {
+	payload := buildSeqHeader()
+	vps, sps, pps, err := hevc.ParseVPSSPSPPSFromSeqHeader(payload)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, goldenVPS, vps)
+	assert.Equal(t, goldenSPS, sps)
+	assert.Equal(t, goldenPPS, pps)
+
+	annexB, err := hevc.VPSSPSPPSSeqHeader2AnnexB(payload)
+	assert.Equal(t, nil, err)
+	var expected []byte
+	for _, nalu := range [][]byte{goldenVPS, goldenSPS, goldenPPS} {
+		expected = append(expected, hevc.NALUStartCode4...)
+		expected = append(expected, nalu...)
+	}
+	assert.Equal(t, expected, annexB)
+
+	// Enhanced RTMP扩展头方式
+	exPayload := append([]byte{0x90, 'h', 'v', 'c', '1'}, payload[5:]...)
+	vps, sps, pps, err = hevc.ParseVPSSPSPPSFromSeqHeader(exPayload)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, goldenVPS, vps)
+	assert.Equal(t, goldenSPS, sps)
+	assert.Equal(t, goldenPPS, pps)
+	_, _, _, err = hevc.ParseVPSSPSPPSFromSeqHeader(append([]byte{0x90, 'a', 'v', '0', '1'}, payload[5:]...))
+	assert.Equal(t, hevc.ErrHEVC, err)
+
+	// 数据不完整
+	_, _, _, err = hevc.ParseVPSSPSPPSFromSeqHeader(payload[:len(payload)-1])
+	assert.Equal(t, hevc.ErrHEVC, err)
+	// 不是HEVC的Seq Header
+	_, err = hevc.VPSSPSPPSSeqHeader2AnnexB([]byte{0x17, 0x00, 0x00, 0x00, 0x00})
+	assert.Equal(t, hevc.ErrHEVC, err)
+}
+
+func TestBuildSeqHeaderFromVPSSPSPPS(t *testing.T) {
+	b, err := hevc.BuildSeqHeaderFromVPSSPSPPS(goldenVPS, goldenSPS, goldenPPS)
+	assert.Equal(t, nil, err)
+	// 和buildSeqHeader构造的seq header相同
+	assert.Equal(t, buildSeqHeader(), b)
+
+	vps, sps, pps, err := hevc.ParseVPSSPSPPSFromSeqHeader(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, goldenVPS, vps)
+	assert.Equal(t, goldenSPS, sps)
+	assert.Equal(t, goldenPPS, pps)
+
+	_, err = hevc.BuildSeqHeaderFromVPSSPSPPS(goldenVPS, goldenSPS[:10], goldenPPS)
+	assert.Equal(t, hevc.ErrHEVC, err)
+}
+
+func TestEBSP2RBSP(t *testing.T) {
+	assert.Equal(t, []byte{0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x03}, hevc.EBSP2RBSP([]byte{0x01, 0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00, 0x03}))
+}
+
+func TestParseDecoderConfigurationRecord(t *testing.T) {
+	dcr, err := hevc.ParseDecoderConfigurationRecord(buildSeqHeader()[5:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint8(1), dcr.ConfigurationVersion)
+	assert.Equal(t, uint8(1), dcr.GeneralProfileIdc)
+	assert.Equal(t, uint32(0x60000000), dcr.GeneralProfileCompatibilityFlags)
+	assert.Equal(t, uint64(0x900000000000), dcr.GeneralConstraintIndicatorFlags)
+	assert.Equal(t, uint8(93), dcr.GeneralLevelIdc)
+	assert.Equal(t, uint8(1), dcr.ChromaFormat)
+	assert.Equal(t, uint8(1), dcr.NumTemporalLayers)
+	assert.Equal(t, uint8(1), dcr.TemporalIdNested)
+	assert.Equal(t, uint8(3), dcr.LengthSizeMinusOne)
+	assert.Equal(t, [][]byte{goldenVPS}, dcr.VPS)
+	assert.Equal(t, [][]byte{goldenSPS}, dcr.SPS)
+	assert.Equal(t, [][]byte{goldenPPS}, dcr.PPS)
+}
+
+func TestParseSPS(t *testing.T) {
+	sps, err := hevc.ParseSPS(goldenSPS)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint8(1), sps.GeneralProfileIdc)
+	assert.Equal(t, uint8(93), sps.GeneralLevelIdc)
+	assert.Equal(t, uint32(1), sps.ChromaFormatIdc)
+	assert.Equal(t, uint32(0), sps.BitDepthLumaMinus8)
+	assert.Equal(t, uint32(1280), sps.Width)
+	assert.Equal(t, uint32(720), sps.Height)
+
+	_, err = hevc.ParseSPS(goldenPPS)
+	assert.Equal(t, hevc.ErrHEVC, err)
+}
+
+func TestBuildSeqHeaderFromAnnexB(t *testing.T) {
+	var b []byte
+	b = append(b, hevc.NALUStartCode4...)
+	b = append(b, goldenVPS...)
+	b = append(b, hevc.NALUStartCode3...)
+	b = append(b, goldenSPS...)
+	b = append(b, hevc.NALUStartCode4...)
+	b = append(b, goldenPPS...)
+	b = append(b, hevc.NALUStartCode3...)
+	b = append(b, 0x26, 0x01, 0xaf)
+
+	assert.Equal(t, [][]byte{goldenVPS, goldenSPS, goldenPPS, {0x26, 0x01, 0xaf}}, hevc.SplitNALUAnnexB(b))
+
+	seqHeader, err := hevc.BuildSeqHeaderFromAnnexB(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, buildSeqHeader(), seqHeader)
+
+	_, err = hevc.BuildSeqHeaderFromAnnexB(b[4+len(goldenVPS):])
+	assert.Equal(t, hevc.ErrHEVC, err)
+}
+
+func TestCaptureHVCC2AnnexB(t *testing.T) {
+	var out bytes.Buffer
+	err := hevc.CaptureHVCC2AnnexB(&out, buildSeqHeader())
+	assert.Equal(t, nil, err)
+	expected, _ := hevc.VPSSPSPPSSeqHeader2AnnexB(buildSeqHeader())
+	assert.Equal(t, expected, out.Bytes())
+
+	out.Reset()
+	err = hevc.CaptureHVCC2AnnexB(&out, []byte{0x1c, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0x26, 0x01, 0xaf, 0x00, 0x00, 0x00, 0x02, 0x4e, 0x01})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{0x00, 0x00, 0x00, 0x01, 0x26, 0x01, 0xaf, 0x00, 0x00, 0x00, 0x01, 0x4e, 0x01}, out.Bytes())
+}
@@ -26,12 +26,17 @@ type mpegTSFrame struct {
 	key bool // 关键帧
 }
 
-func (f *FragmentOP) OpenFile(filename string) (err error) {
+// @param isHEVC 视频是否为HEVC，用于确定PMT中视频的stream_type
+func (f *FragmentOP) OpenFile(filename string, isHEVC bool) (err error) {
 	f.fp, err = os.Create(filename)
 	if err != nil {
 		return
 	}
-	f.writeFile(FixedFragmentHeader)
+	if isHEVC {
+		f.writeFile(FixedFragmentHeaderHEVC)
+	} else {
+		f.writeFile(FixedFragmentHeader)
+	}
 	//TS包固定188-byte
 	f.packet = make([]byte, 188)
 	return nil
@@ -11,7 +11,6 @@ package hls
 // 声明，本package参考了c语言实现的开源项目nginx-rtmp-module
 
 // TODO chef:
-// - 支持HEVC
 // - 检查所有的容错处理，是否会出现
 // - 补充单元测试
 // - 配置项
@@ -91,10 +90,24 @@ var FixedFragmentHeader = []byte{
 	0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
 }
 
+// 视频为HEVC时使用，和FixedFragmentHeader相比，只有PMT中视频的stream_type以及CRC不同
+var FixedFragmentHeaderHEVC = func() []byte {
+	b := make([]byte, len(FixedFragmentHeader))
+	copy(b, FixedFragmentHeader)
+	b[188+17] = streamTypeHEVC
+	copy(b[188+27:], []byte{0xc7, 0x72, 0xb7, 0xcb}) /* crc for hevc */
+	return b
+}()
+
 var audNal = []byte{
 	0x00, 0x00, 0x00, 0x01, 0x09, 0xf0,
 }
 
+// HEVC的AUD，nal_unit_type为35，pic_type为2（I，P，B）
+var audNalHEVC = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50,
+}
+
 // TS Packet Header
 const (
 	syncByte uint8 = 0x47
@@ -116,9 +129,11 @@ const (
 	// <iso13818-1.pdf> <Table 2-29 Stream type assignments> <page 66/174>
 	// 0x0F ISO/IEC 13818-7 Audio with ADTS transport syntax
 	// 0x1B AVC video stream as defined in ITU-T Rec. H.264 | ISO/IEC 14496-10 Video
+	// 0x24 HEVC video stream as defined in ITU-T Rec. H.265 | ISO/IEC 23008-2
 	// -----------------------------------------------------------------------------
-	streamTypeAAC uint8 = 0x0F
-	streamTypeAVC uint8 = 0x1B
+	streamTypeAAC  uint8 = 0x0F
+	streamTypeAVC  uint8 = 0x1B
+	streamTypeHEVC uint8 = 0x24
 )
 
 // PES
@@ -14,6 +14,7 @@ import (
 	"github.com/q191201771/lal/pkg/innertest"
 
 	"github.com/q191201771/lal/pkg/hls"
+	"github.com/q191201771/naza/pkg/assert"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
 
@@ -27,6 +28,11 @@ func TestParseFixedTSPacket(t *testing.T) {
 	nazalog.Debugf("%+v", h)
 	pmt := hls.ParsePMT(hls.FixedFragmentHeader[188+5:])
 	nazalog.Debugf("%+v", pmt)
+
+	pmt = hls.ParsePMT(hls.FixedFragmentHeaderHEVC[188+5:])
+	nazalog.Debugf("%+v", pmt)
+	assert.Equal(t, uint8(0x24), pmt.SearchPID(hls.PidVideo).StreamType)
+	assert.Equal(t, uint8(0x0F), pmt.SearchPID(hls.PidAudio).StreamType)
 }
 
 func TestHls(t *testing.T) {
@@ -14,6 +14,7 @@ import (
 	"os"
 
 	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/hevc"
 
 	"github.com/q191201771/naza/pkg/unique"
 
@@ -37,6 +38,19 @@ type MuxerConfig struct {
 	FragmentNum        int    `json:"fragment_num"`
 }
 
+type MuxerObserver interface {
+	// ts文件写完，并且已经写入m3u8文件时回调
+	// 注意，回调在FeedRTMPMessage或Dispose的调用中同步发生
+	OnFragmentClose(info FragmentEventInfo)
+}
+
+type FragmentEventInfo struct {
+	ID           int     // fragment的自增序号
+	TSFile       string  // ts文件的路径
+	LiveM3U8File string  // m3u8文件的路径
+	Duration     float64 // ts文件的时长，单位秒
+}
+
 type Muxer struct {
 	UniqueKey string
 
@@ -45,12 +59,14 @@ type Muxer struct {
 	playlistFilename    string
 	playlistFilenameBak string
 
-	config *MuxerConfig
+	config   *MuxerConfig
+	observer MuxerObserver
 
 	fragmentOP FragmentOP
 	opened     bool
 	adts       aac.ADTS
-	spspps     []byte // AnnexB
+	isHEVC     bool   // 视频是否为HEVC，由视频的seq header决定
+	spspps     []byte // AnnexB，视频为HEVC时包含VPS，SPS，PPS
 	videoCC    uint8
 	audioCC    uint8
 	videoOut   []byte // 帧
@@ -65,11 +81,12 @@ type Muxer struct {
 	aframePTS uint64 // 最新音频帧的时间戳
 }
 
-func NewMuxer(streamName string, config *MuxerConfig) *Muxer {
+// @param observer 可以为nil
+func NewMuxer(appName string, streamName string, config *MuxerConfig, observer MuxerObserver) *Muxer {
 	uk := unique.GenUniqueKey("HLSMUXER")
-	nazalog.Infof("[%s] lifecycle new hls muxer. streamName=%s", uk, streamName)
+	nazalog.Infof("[%s] lifecycle new hls muxer. appName=%s, streamName=%s", uk, appName, streamName)
 
-	op := getMuxerOutPath(config.OutPath, streamName)
+	op := getMuxerOutPath(config.OutPath, appName, streamName)
 	playlistFilename := getM3U8Filename(op, streamName)
 	playlistFilenameBak := fmt.Sprintf("%s.bak", playlistFilename)
 	videoOut := make([]byte, 1024*1024)
@@ -82,6 +99,7 @@ func NewMuxer(streamName string, config *MuxerConfig) *Muxer {
 		playlistFilename:    playlistFilename,
 		playlistFilenameBak: playlistFilenameBak,
 		config:              config,
+		observer:            observer,
 		videoOut:            videoOut,
 		aaframe:             nil,
 		frags:               frags,
@@ -115,28 +133,40 @@ func (m *Muxer) feedVideo(msg rtmp.AVMsg) {
 		nazalog.Errorf("[%s] invalid video message length. len=%d", m.UniqueKey, len(msg.Payload))
 		return
 	}
-	if msg.Payload[0]&0xF != 7 {
-		// TODO chef: HLS视频现在只做了h264的支持
+	// 兼容Enhanced RTMP的扩展头，目前只支持AVC和HEVC，AV1和VP9等直接丢弃
+	vh, err := rtmp.ParseVideoHeader(msg.Payload)
+	if err != nil {
+		nazalog.Errorf("[%s] parse video header failed. err=%+v", m.UniqueKey, err)
+		return
+	}
+	if !vh.IsAVC() && !vh.IsHEVC() {
 		return
 	}
 
-	ftype := msg.Payload[0] & 0xF0 >> 4
-	htype := msg.Payload[1]
-
-	if ftype == 1 && htype == 0 {
-		if err := m.cacheSPSPPS(msg); err != nil {
+	if vh.IsSeqHeader() {
+		if err := m.cacheSPSPPS(msg, vh.IsHEVC()); err != nil {
 			nazalog.Errorf("[%s] cache spspps failed. err=%+v", m.UniqueKey, err)
 		}
 		return
 	}
+	if !vh.IsCodedFrames() {
+		return
+	}
 
-	cts := bele.BEUint24(msg.Payload[2:])
+	// 和seq header的编码类型不一致，丢弃
+	if vh.IsHEVC() != m.isHEVC {
+		nazalog.Warnf("[%s] video codec mismatch with seq header. header=%+v", m.UniqueKey, vh)
+		return
+	}
+
+	cts := vh.CTS
 
 	audSent := false
 	spsppsSent := false
+	key := false
 	// 优化这块buffer
 	out := m.videoOut[0:0]
-	for i := 5; i != len(msg.Payload); {
+	for i := vh.DataOffset; i != len(msg.Payload); {
 		if i+4 > len(msg.Payload) {
 			nazalog.Errorf("[%s] slice len not enough. i=%d, len=%d", m.UniqueKey, i, len(msg.Payload))
 			return
@@ -147,43 +177,15 @@ func (m *Muxer) feedVideo(msg rtmp.AVMsg) {
 			nazalog.Errorf("[%s] slice len not enough. i=%d, payload len=%d, nalBytes=%d", m.UniqueKey, i, len(msg.Payload), nalBytes)
 			return
 		}
-
-		nalType := avc.ParseNALUType(msg.Payload[i])
-
-		//nazalog.Debugf("hls: h264 NAL type=%d, len=%d(%d) cts=%d.", nalType, nalBytes, len(msg.Payload), cts)
-
-		if nalType == avc.NALUTypeSPS || nalType == avc.NALUTypePPS || nalType == avc.NALUTypeAUD {
-			i += nalBytes
+		if nalBytes == 0 {
 			continue
 		}
 
-		if !audSent {
-			switch nalType {
-			case avc.NALUTypeSlice, avc.NALUTypeIDRSlice, avc.NALUTypeSEI:
-				out = append(out, audNal...)
-				audSent = true
-			case avc.NALUTypeAUD:
-				audSent = true
-			}
-		}
-
-		switch nalType {
-		case avc.NALUTypeSlice:
-			spsppsSent = false
-		case avc.NALUTypeIDRSlice:
-			if !spsppsSent {
-				out = m.appendSPSPPS(out)
-			}
-			spsppsSent = true
-
-		}
-
-		if len(out) == 0 {
-			out = append(out, avc.NALUStartCode4...)
+		if m.isHEVC {
+			out, audSent, spsppsSent, key = m.appendHEVCNALU(out, msg.Payload[i:i+nalBytes], audSent, spsppsSent, key)
 		} else {
-			out = append(out, avc.NALUStartCode3...)
+			out, audSent, spsppsSent = m.appendAVCNALU(out, msg.Payload[i:i+nalBytes], audSent, spsppsSent)
 		}
-		out = append(out, msg.Payload[i:i+nalBytes]...)
 
 		i += nalBytes
 	}
@@ -194,7 +196,12 @@ func (m *Muxer) feedVideo(msg rtmp.AVMsg) {
 	frame.pts = frame.dts + uint64(cts)*90
 	frame.pid = PidVideo
 	frame.sid = streamIDVideo
-	frame.key = ftype == 1
+	// HEVC以IRAP帧作为fragment的边界
+	if m.isHEVC {
+		frame.key = key
+	} else {
+		frame.key = vh.IsKeyFrame()
+	}
 
 	boundary := frame.key && (!m.opened || !m.adts.HasInited() || m.aaframe != nil)
 
@@ -209,6 +216,71 @@ func (m *Muxer) feedVideo(msg rtmp.AVMsg) {
 	m.videoCC = frame.cc
 }
 
+func (m *Muxer) appendAVCNALU(out []byte, nalu []byte, audSent bool, spsppsSent bool) ([]byte, bool, bool) {
+	nalType := avc.ParseNALUType(nalu[0])
+
+	//nazalog.Debugf("hls: h264 NAL type=%d, len=%d(%d) cts=%d.", nalType, nalBytes, len(msg.Payload), cts)
+
+	if nalType == avc.NALUTypeSPS || nalType == avc.NALUTypePPS || nalType == avc.NALUTypeAUD {
+		return out, audSent, spsppsSent
+	}
+
+	if !audSent {
+		switch nalType {
+		case avc.NALUTypeSlice, avc.NALUTypeIDRSlice, avc.NALUTypeSEI:
+			out = append(out, audNal...)
+			audSent = true
+		case avc.NALUTypeAUD:
+			audSent = true
+		}
+	}
+
+	switch nalType {
+	case avc.NALUTypeSlice:
+		spsppsSent = false
+	case avc.NALUTypeIDRSlice:
+		if !spsppsSent {
+			out = m.appendSPSPPS(out)
+		}
+		spsppsSent = true
+
+	}
+
+	if len(out) == 0 {
+		out = append(out, avc.NALUStartCode4...)
+	} else {
+		out = append(out, avc.NALUStartCode3...)
+	}
+	out = append(out, nalu...)
+	return out, audSent, spsppsSent
+}
+
+// @param key 是否已经有IRAP帧
+func (m *Muxer) appendHEVCNALU(out []byte, nalu []byte, audSent bool, vpsspsppsSent bool, key bool) ([]byte, bool, bool, bool) {
+	nalType := hevc.ParseNALUType(nalu[0])
+
+	if nalType == hevc.NALUTypeVPS || nalType == hevc.NALUTypeSPS || nalType == hevc.NALUTypePPS || nalType == hevc.NALUTypeAUD {
+		return out, audSent, vpsspsppsSent, key
+	}
+
+	if !audSent {
+		out = append(out, audNalHEVC...)
+		audSent = true
+	}
+
+	if hevc.IsIRAP(nalType) {
+		if !vpsspsppsSent {
+			out = m.appendSPSPPS(out)
+		}
+		vpsspsppsSent = true
+		key = true
+	}
+
+	out = append(out, hevc.NALUStartCode3...)
+	out = append(out, nalu...)
+	return out, audSent, vpsspsppsSent, key
+}
+
 func (m *Muxer) feedAudio(msg rtmp.AVMsg) {
 	if len(msg.Payload) < 3 {
 		nazalog.Errorf("[%s] invalid audio message length. len=%d", m.UniqueKey, len(msg.Payload))
@@ -244,9 +316,15 @@ func (m *Muxer) cacheAACSeqHeader(msg rtmp.AVMsg) {
 	_ = m.adts.InitWithAACAudioSpecificConfig(msg.Payload[2:])
 }
 
-func (m *Muxer) cacheSPSPPS(msg rtmp.AVMsg) error {
+// 注意，传统方式和Enhanced RTMP扩展头方式的seq header，配置记录都是从第5个字节开始
+func (m *Muxer) cacheSPSPPS(msg rtmp.AVMsg, isHEVC bool) error {
 	var err error
-	m.spspps, err = avc.SPSPPSSeqHeader2AnnexB(msg.Payload)
+	m.isHEVC = isHEVC
+	if m.isHEVC {
+		m.spspps, err = hevc.VPSSPSPPSSeqHeader2AnnexB(msg.Payload)
+	} else {
+		m.spspps, err = avc.SPSPPSSeqHeader2AnnexB(msg.Payload)
+	}
 	return err
 }
 
@@ -305,7 +383,7 @@ func (m *Muxer) openFragment(ts uint64, discont bool) {
 	id := m.getFragmentID()
 
 	filename := getTSFilename(m.outPath, m.streamName, id)
-	_ = m.fragmentOP.OpenFile(filename)
+	_ = m.fragmentOP.OpenFile(filename, m.isHEVC)
 	m.opened = true
 
 	frag := m.getFrag(m.nfrags)
@@ -325,10 +403,20 @@ func (m *Muxer) closeFragment() {
 	m.fragmentOP.CloseFile()
 
 	m.opened = false
+	frag := *m.getFrag(m.nfrags)
 	//更新序号，为下个分片准备好
 	m.nextFrag()
 
 	m.writePlaylist()
+
+	if m.observer != nil {
+		m.observer.OnFragmentClose(FragmentEventInfo{
+			ID:           frag.id,
+			TSFile:       getTSFilename(m.outPath, m.streamName, frag.id),
+			LiveM3U8File: m.playlistFilename,
+			Duration:     frag.duration,
+		})
+	}
 }
 func (m *Muxer) writePlaylist() {
 	fp, err := os.Create(m.playlistFilenameBak)
@@ -21,28 +21,31 @@ import (
 // HTTP请求URI格式，已经文件路径的映射规则
 //
 // 假设
+// app名称="live"
 // 流名称="test110"
 // rootPath="/tmp/lal/hls/"
 //
 // 则
-// http://127.0.0.1:8081/hls/test110/playlist.m3u8 -> /tmp/lal/hls/test110/playlist.m3u8
-// http://127.0.0.1:8081/hls/test110/test110-0.ts  -> /tmp/lal/hls/test110/test110-0.ts
+// http://127.0.0.1:8081/hls/live/test110/playlist.m3u8 -> /tmp/lal/hls/live/test110/playlist.m3u8
+// http://127.0.0.1:8081/hls/live/test110/test110-0.ts  -> /tmp/lal/hls/live/test110/test110-0.ts
 
 type requestInfo struct {
 	fileName   string
+	appName    string
 	streamName string
 	fileType   string
 }
 
 // RequestURI example:
-// uri                                             -> fileName      streamName fileType
-// http://127.0.0.1:8081/hls/test110/playlist.m3u8 -> playlist.m3u8 test110    m3u8
-// http://127.0.0.1:8081/hls/test110/test110-0.ts  -> test110-0.ts  test110    ts
+// uri                                                  -> fileName      appName streamName fileType
+// http://127.0.0.1:8081/hls/live/test110/playlist.m3u8 -> playlist.m3u8 live    test110    m3u8
+// http://127.0.0.1:8081/hls/live/test110/test110-0.ts  -> test110-0.ts  live    test110    ts
 func parseRequestInfo(uri string) (ri requestInfo) {
 	ss := strings.Split(uri, "/")
-	if len(ss) < 2 {
+	if len(ss) < 3 {
 		return
 	}
+	ri.appName = ss[len(ss)-3]
 	ri.streamName = ss[len(ss)-2]
 	ri.fileName = ss[len(ss)-1]
 
@@ -56,12 +59,12 @@ func parseRequestInfo(uri string) (ri requestInfo) {
 }
 
 func readFileContent(rootOutPath string, ri requestInfo) ([]byte, error) {
-	filename := fmt.Sprintf("%s%s/%s", rootOutPath, ri.streamName, ri.fileName)
+	filename := fmt.Sprintf("%s%s/%s/%s", rootOutPath, ri.appName, ri.streamName, ri.fileName)
 	return ioutil.ReadFile(filename)
 }
 
-func getMuxerOutPath(rootOutPath string, streamName string) string {
-	return fmt.Sprintf("%s%s/", rootOutPath, streamName)
+func getMuxerOutPath(rootOutPath string, appName string, streamName string) string {
+	return fmt.Sprintf("%s%s/%s/", rootOutPath, appName, streamName)
 }
 
 func getM3U8Filename(outpath string, streamName string) string {
@@ -15,15 +15,24 @@ import (
 	"github.com/q191201771/naza/pkg/nazalog"
 )
 
+type ServerObserver interface {
+	// 成功响应了m3u8或ts文件的请求，上层可以用来判断流是否还有hls的观看者
+	// 注意，在HTTP服务的协程中回调
+	OnHLSRequest(appName string, streamName string)
+}
+
 type Server struct {
+	obs     ServerObserver
 	addr    string
 	outPath string
 	ln      net.Listener
 	httpSrv *http.Server
 }
 
-func NewServer(addr string, outPath string) *Server {
+// @param obs 可以为nil
+func NewServer(addr string, outPath string, obs ServerObserver) *Server {
 	return &Server{
+		obs:     obs,
 		addr:    addr,
 		outPath: outPath,
 	}
@@ -52,13 +61,13 @@ func (s *Server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	//nazalog.Debugf("%+v", req)
 
 	// TODO chef:
-	// - check appname in URI path
 	// - DIY 404 response body
 
 	ri := parseRequestInfo(req.RequestURI)
 	//nazalog.Debugf("%+v", ri)
 
-	if ri.fileName == "" || ri.streamName == "" || (ri.fileType != "m3u8" && ri.fileType != "ts") {
+	if ri.fileName == "" || ri.appName == "" || ri.streamName == "" || (ri.fileType != "m3u8" && ri.fileType != "ts") ||
+		ri.appName == ".." || ri.streamName == ".." {
 		nazalog.Warnf("%+v", ri)
 		resp.WriteHeader(404)
 		return
@@ -80,6 +89,9 @@ func (s *Server) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 	resp.Header().Add("Cache-Control", "no-cache")
 
 	_, _ = resp.Write(content)
+	if s.obs != nil {
+		s.obs.OnHLSRequest(ri.appName, ri.streamName)
+	}
 	return
 }
 
@@ -59,3 +59,16 @@ const (
 const (
 	SoundFormatAAC uint8 = 10
 )
+
+// Enhanced RTMP的扩展头，和rtmp中的类似
+const (
+	VideoExHeaderFlag uint8 = 0x80
+
+	PacketTypeExSequenceStart uint8 = 0
+	PacketTypeExCodedFrames   uint8 = 1
+	PacketTypeExCodedFramesX  uint8 = 3
+
+	FourCCHEVC = "hvc1"
+	FourCCAV1  = "av01"
+	FourCCVP9  = "vp09"
+)
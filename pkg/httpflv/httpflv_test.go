@@ -11,9 +11,44 @@ package httpflv_test
 import (
 	"testing"
 
+	"github.com/q191201771/lal/pkg/httpflv"
 	"github.com/q191201771/lal/pkg/innertest"
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 func TestRTMP(t *testing.T) {
 	innertest.InnerTestEntry(t)
 }
+
+func TestTagExHeader(t *testing.T) {
+	newTag := func(payload []byte) httpflv.Tag {
+		raw := httpflv.PackHTTPFLVTag(httpflv.TagTypeVideo, 0, payload)
+		return httpflv.Tag{
+			Header: httpflv.TagHeader{Type: httpflv.TagTypeVideo, DataSize: uint32(len(payload))},
+			Raw:    raw,
+		}
+	}
+
+	tag := newTag([]byte{0x90, 'h', 'v', 'c', '1', 1})
+	assert.Equal(t, true, tag.IsVideoExHeader())
+	assert.Equal(t, httpflv.FourCCHEVC, tag.VideoFourCC())
+	assert.Equal(t, true, tag.IsHEVC())
+	assert.Equal(t, false, tag.IsAVC())
+	assert.Equal(t, true, tag.IsVideoKeySeqHeader())
+	assert.Equal(t, false, tag.IsVideoKeyNALU())
+
+	tag = newTag([]byte{0x93, 'h', 'v', 'c', '1', 0, 0, 0, 1, 0x26})
+	assert.Equal(t, false, tag.IsVideoKeySeqHeader())
+	assert.Equal(t, true, tag.IsVideoKeyNALU())
+
+	tag = newTag([]byte{0x91, 'v', 'p', '0', '9', 0})
+	assert.Equal(t, false, tag.IsHEVC())
+	assert.Equal(t, httpflv.FourCCVP9, tag.VideoFourCC())
+	assert.Equal(t, true, tag.IsVideoKeyNALU())
+
+	tag = newTag([]byte{0x17, 1, 0, 0, 0, 0, 0, 0, 1, 0x65})
+	assert.Equal(t, false, tag.IsVideoExHeader())
+	assert.Equal(t, "", tag.VideoFourCC())
+	assert.Equal(t, true, tag.IsAVC())
+	assert.Equal(t, true, tag.IsVideoKeyNALU())
+}
@@ -16,7 +16,7 @@ import (
 
 type ServerObserver interface {
 	// 通知上层有新的拉流者
-	// 返回值： true则允许拉流，false则关闭连接
+	// 返回值： true则允许拉流，false则回复403并关闭连接
 	OnNewHTTPFLVSubSession(session *SubSession) bool
 
 	OnDelHTTPFLVSubSession(session *SubSession)
@@ -72,7 +72,10 @@ func (server *Server) handleConnect(conn net.Conn) {
 	log.Debugf("[%s] < read http request. uri=%s", session.UniqueKey, session.URI)
 
 	if !server.obs.OnNewHTTPFLVSubSession(session) {
+		log.Warnf("[%s] httpflv sub session rejected.", session.UniqueKey)
+		session.WriteHTTPResponseForbidden()
 		session.Dispose()
+		return
 	}
 
 	err := session.RunLoop()
@@ -14,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/nazahttp"
 
 	"github.com/q191201771/naza/pkg/connection"
@@ -32,6 +33,11 @@ var flvHTTPResponseHeaderStr = "HTTP/1.1 200 OK\r\n" +
 
 var flvHTTPResponseHeader = []byte(flvHTTPResponseHeaderStr)
 
+var flvHTTPResponseForbidden = []byte("HTTP/1.1 403 Forbidden\r\n" +
+	"Content-Length: 0\r\n" +
+	"Connection: close\r\n" +
+	"\r\n")
+
 type SubSession struct {
 	UniqueKey string
 
@@ -39,11 +45,14 @@ type SubSession struct {
 	StreamName string
 	AppName    string
 	URI        string
+	RawQuery   string // url中?后面的参数，不包含?
 	Headers    map[string]string
 
 	IsFresh bool
 
-	conn connection.Connection
+	conn      *base.StatConn
+	statAV    base.StatAV
+	startTime time.Time
 }
 
 func NewSubSession(conn net.Conn) *SubSession {
@@ -52,11 +61,12 @@ func NewSubSession(conn net.Conn) *SubSession {
 	return &SubSession{
 		UniqueKey: uk,
 		IsFresh:   true,
-		conn: connection.New(conn, func(option *connection.Option) {
+		conn: base.NewStatConn(connection.New(conn, func(option *connection.Option) {
 			option.ReadBufSize = readBufSize
 			option.WriteChanSize = wChanSize
 			option.WriteTimeoutMS = subSessionWriteTimeoutMS
-		}),
+		})),
+		startTime: time.Now(),
 	}
 }
 
@@ -106,6 +116,7 @@ func (session *SubSession) ReadRequest() (err error) {
 		return
 	}
 	session.StreamName = items[0]
+	session.RawQuery = urlObj.RawQuery
 
 	return nil
 }
@@ -121,19 +132,44 @@ func (session *SubSession) WriteHTTPResponseHeader() {
 	session.WriteRawPacket(flvHTTPResponseHeader)
 }
 
+// 上层拒绝拉流时使用，发送完成后才返回
+func (session *SubSession) WriteHTTPResponseForbidden() {
+	nazalog.Debugf("[%s] > W http response forbidden.", session.UniqueKey)
+	session.WriteRawPacket(flvHTTPResponseForbidden)
+	_ = session.conn.Flush()
+}
+
 func (session *SubSession) WriteFLVHeader() {
 	nazalog.Debugf("[%s] > W http flv header.", session.UniqueKey)
 	session.WriteRawPacket(FLVHeader)
 }
 
 func (session *SubSession) WriteTag(tag *Tag) {
-	session.WriteRawPacket(tag.Raw)
+	session.WriteRawTag(tag.Raw)
+}
+
+// 写入完整的flv tag，会统计写入的音视频帧
+func (session *SubSession) WriteRawTag(tag []byte) {
+	if _, err := session.conn.Write(tag); err != nil || len(tag) < TagHeaderSize {
+		return
+	}
+	h := parseTagHeader(tag)
+	switch h.Type {
+	case TagTypeAudio:
+		session.statAV.FeedAudio(h.Timestamp)
+	case TagTypeVideo:
+		session.statAV.FeedVideo(h.Timestamp)
+	}
 }
 
 func (session *SubSession) WriteRawPacket(pkt []byte) {
 	_, _ = session.conn.Write(pkt)
 }
 
+func (session *SubSession) GetStat() base.StatSession {
+	return base.NewStatSession(base.ProtocolHTTPFLV, session.UniqueKey, session.startTime, session.conn.RemoteAddr().String(), session.conn, &session.statAV)
+}
+
 func (session *SubSession) Dispose() {
 	_ = session.conn.Close()
 }
@@ -35,13 +35,42 @@ func (tag *Tag) IsMetadata() bool {
 }
 
 func (tag *Tag) IsAVC() bool {
-	return tag.Header.Type == TagTypeVideo && (tag.Raw[TagHeaderSize]&0xF == codecIDAVC)
+	return tag.Header.Type == TagTypeVideo && !tag.IsVideoExHeader() && (tag.Raw[TagHeaderSize]&0xF == codecIDAVC)
 }
 
+// 传统方式的HEVC（codec id为12），或者Enhanced RTMP的hvc1
 func (tag *Tag) IsHEVC() bool {
+	if tag.IsVideoExHeader() {
+		return tag.VideoFourCC() == FourCCHEVC
+	}
 	return tag.Header.Type == TagTypeVideo && (tag.Raw[TagHeaderSize]&0xF == codecIDHEVC)
 }
 
+// 使用Enhanced RTMP扩展头的视频tag
+func (tag *Tag) IsVideoExHeader() bool {
+	return tag.Header.Type == TagTypeVideo && tag.Header.DataSize >= 5 && tag.Raw[TagHeaderSize]&VideoExHeaderFlag != 0
+}
+
+// 扩展头中的FourCC，不是扩展头时返回空
+func (tag *Tag) VideoFourCC() string {
+	if !tag.IsVideoExHeader() {
+		return ""
+	}
+	return string(tag.Raw[TagHeaderSize+1 : TagHeaderSize+5])
+}
+
+func (tag *Tag) IsExSequenceStart() bool {
+	return tag.IsVideoExHeader() && tag.Raw[TagHeaderSize]&0xF == PacketTypeExSequenceStart
+}
+
+func (tag *Tag) IsExKeyCodedFrames() bool {
+	if !tag.IsVideoExHeader() || (tag.Raw[TagHeaderSize]>>4)&0x7 != frameTypeKey {
+		return false
+	}
+	pt := tag.Raw[TagHeaderSize] & 0xF
+	return pt == PacketTypeExCodedFrames || pt == PacketTypeExCodedFramesX
+}
+
 func (tag *Tag) IsAVCKeySeqHeader() bool {
 	return tag.Header.Type == TagTypeVideo && tag.Raw[TagHeaderSize] == AVCKeyFrame && tag.Raw[TagHeaderSize+1] == AVCPacketTypeSeqHeader
 }
@@ -50,9 +79,9 @@ func (tag *Tag) IsHEVCKeySeqHeader() bool {
 	return tag.Header.Type == TagTypeVideo && tag.Raw[TagHeaderSize] == HEVCKeyFrame && tag.Raw[TagHeaderSize+1] == HEVCPacketTypeSeqHeader
 }
 
-// AVC或HEVC的seq header
+// AVC，HEVC，或者Enhanced RTMP的seq header
 func (tag *Tag) IsVideoKeySeqHeader() bool {
-	return tag.IsAVCKeySeqHeader() || tag.IsHEVCKeySeqHeader()
+	return tag.IsAVCKeySeqHeader() || tag.IsHEVCKeySeqHeader() || tag.IsExSequenceStart()
 }
 
 func (tag *Tag) IsAVCKeyNALU() bool {
@@ -63,9 +92,9 @@ func (tag *Tag) IsHEVCKeyNALU() bool {
 	return tag.Header.Type == TagTypeVideo && tag.Raw[TagHeaderSize] == HEVCKeyFrame && tag.Raw[TagHeaderSize+1] == HEVCPacketTypeNALU
 }
 
-// AVC或HEVC的关键帧
+// AVC，HEVC，或者Enhanced RTMP的关键帧
 func (tag *Tag) IsVideoKeyNALU() bool {
-	return tag.IsAVCKeyNALU() || tag.IsHEVCKeyNALU()
+	return tag.IsAVCKeyNALU() || tag.IsHEVCKeyNALU() || tag.IsExKeyCodedFrames()
 }
 
 func (tag *Tag) IsAACSeqHeader() bool {
@@ -161,7 +161,7 @@ func InnerTestEntry(t *testing.T) {
 	var allContent []byte
 	var fileNum int
 	err = filebatch.Walk(
-		fmt.Sprintf("%sinnertest", config.HLSConfig.OutPath),
+		fmt.Sprintf("%slive/innertest", config.HLSConfig.OutPath),
 		false,
 		"",
 		func(path string, info os.FileInfo, content []byte, err error) []byte {
@@ -0,0 +1,103 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 准入回调
+//
+// 和notify.go中的HTTP回调通知不同，准入回调是同步的：rtmp和rtsp推流，rtmp，httpflv和rtsp拉流（rtsp拉流在DESCRIBE时）的session加入group前，
+// 以POST JSON的方式请求业务方配置的地址，请求内容同SessionEventInfo，根据返回的结果决定是否允许这个session，
+// 格式为 {"allow": true, "reason": "", "stream_name": "", "limit": {"max_duration_sec": 0, "max_kbitrate": 0}}
+//
+// - allow:       是否允许，没有这个字段时视为拒绝
+// - reason:      拒绝的原因，rtmp会在onStatus的description中回复给客户端
+// - stream_name: 不为空时，使用这个流名替换客户端请求的流名，rtsp拉流不支持
+// - limit:       这个session的限制，见SessionLimit
+//
+// HTTP状态码不是2xx时视为拒绝，网络错误，超时，或返回内容格式错误时，由配置的allow_on_error决定是否允许
+
+type SessionLimit struct {
+	MaxDurationSec int `json:"max_duration_sec"` // session的最长时长，单位秒，为0时不限制
+	MaxKBitrate    int `json:"max_kbitrate"`     // session的最大码率，pub为读取的码率，sub为写入的码率，单位kbit/s，为0时不限制
+}
+
+type AdmissionResult struct {
+	Allow      bool         `json:"allow"`
+	Reason     string       `json:"reason"`
+	StreamName string       `json:"stream_name"`
+	Limit      SessionLimit `json:"limit"`
+}
+
+type AdmissionHook struct {
+	config AdmissionConfig
+	client *http.Client
+}
+
+func NewAdmissionHook(config AdmissionConfig) *AdmissionHook {
+	return &AdmissionHook{
+		config: config,
+		client: &http.Client{
+			Timeout: time.Duration(config.TimeoutMS) * time.Millisecond,
+		},
+	}
+}
+
+// 注意，会阻塞直到收到回调的结果或超时
+func (a *AdmissionHook) OnPublish(info SessionEventInfo) AdmissionResult {
+	return a.request(a.config.OnPublish, info)
+}
+
+func (a *AdmissionHook) OnPlay(info SessionEventInfo) AdmissionResult {
+	return a.request(a.config.OnPlay, info)
+}
+
+func (a *AdmissionHook) request(url string, info SessionEventInfo) AdmissionResult {
+	if !a.config.Enable || url == "" {
+		return AdmissionResult{Allow: true}
+	}
+
+	result, err := a.requestOnce(url, info)
+	if err != nil {
+		nazalog.Errorf("[%s] admission hook failed. url=%s, allow on error=%v, err=%+v", info.SessionID, url, a.config.AllowOnError, err)
+		return AdmissionResult{Allow: a.config.AllowOnError, Reason: "Admission hook failed"}
+	}
+	nazalog.Infof("[%s] admission hook result. url=%s, result=%+v", info.SessionID, url, result)
+	return result
+}
+
+func (a *AdmissionHook) requestOnce(url string, info SessionEventInfo) (result AdmissionResult, err error) {
+	body, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	resp, err := a.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		nazalog.Warnf("[%s] admission hook status code not 2xx. url=%s, status code=%d", info.SessionID, url, resp.StatusCode)
+		return AdmissionResult{Allow: false, Reason: "Admission rejected"}, nil
+	}
+	err = json.Unmarshal(respBody, &result)
+	return
+}
@@ -0,0 +1,138 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+// 根据请求的流名返回不同的准入结果
+func newAdmissionStub() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var info SessionEventInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch info.StreamName {
+		case "reject":
+			_, _ = w.Write([]byte(`{"allow": false, "reason": "Stream forbidden"}`))
+		case "rewrite":
+			_, _ = w.Write([]byte(`{"allow": true, "stream_name": "rewritten", "limit": {"max_duration_sec": 1}}`))
+		case "error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "badjson":
+			_, _ = w.Write([]byte(`not json`))
+		default:
+			_, _ = w.Write([]byte(`{"allow": true}`))
+		}
+	}))
+}
+
+func TestAdmissionHook(t *testing.T) {
+	ts := newAdmissionStub()
+	defer ts.Close()
+
+	hook := NewAdmissionHook(AdmissionConfig{
+		Enable:    true,
+		TimeoutMS: 1000,
+		OnPublish: ts.URL + "/on_publish",
+	})
+	info := SessionEventInfo{Protocol: "rtmp", SessionID: "RTMPPUBSUB1", AppName: "live"}
+
+	info.StreamName = "test110"
+	assert.Equal(t, AdmissionResult{Allow: true}, hook.OnPublish(info))
+
+	info.StreamName = "reject"
+	assert.Equal(t, AdmissionResult{Allow: false, Reason: "Stream forbidden"}, hook.OnPublish(info))
+
+	info.StreamName = "rewrite"
+	assert.Equal(t, AdmissionResult{Allow: true, StreamName: "rewritten", Limit: SessionLimit{MaxDurationSec: 1}}, hook.OnPublish(info))
+
+	info.StreamName = "error"
+	assert.Equal(t, false, hook.OnPublish(info).Allow)
+
+	info.StreamName = "badjson"
+	assert.Equal(t, false, hook.OnPublish(info).Allow)
+
+	// 没有配置地址时不回调，直接允许
+	assert.Equal(t, AdmissionResult{Allow: true}, hook.OnPlay(info))
+
+	// 回调失败时由allow_on_error决定
+	hook = NewAdmissionHook(AdmissionConfig{
+		Enable:       true,
+		TimeoutMS:    1000,
+		AllowOnError: true,
+		OnPublish:    ts.URL + "/on_publish",
+	})
+	assert.Equal(t, true, hook.OnPublish(info).Allow)
+	info.StreamName = "error"
+	assert.Equal(t, false, hook.OnPublish(info).Allow)
+}
+
+func TestAdmissionHook_ServerManager(t *testing.T) {
+	ts := newAdmissionStub()
+	defer ts.Close()
+
+	oldConfig := config
+	config = &Config{}
+	config.RTMPConfig.Enable = true
+	config.RTMPConfig.Addr = "127.0.0.1:14950"
+	config.AdmissionConfig = AdmissionConfig{
+		Enable:    true,
+		TimeoutMS: 1000,
+		OnPublish: ts.URL + "/on_publish",
+		OnPlay:    ts.URL + "/on_play",
+	}
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager()
+	go sm.RunLoop()
+	defer sm.Dispose()
+	time.Sleep(100 * time.Millisecond)
+
+	pushSession := rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+		option.PushTimeoutMS = 5000
+	})
+	err := pushSession.Push("rtmp://127.0.0.1:14950/live/reject")
+	assert.IsNotNil(t, err)
+	pushSession.Dispose()
+
+	// 流名被修改，并且1秒后因超过时长限制被关闭
+	pushSession = rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+		option.PushTimeoutMS = 5000
+	})
+	err = pushSession.Push("rtmp://127.0.0.1:14950/live/rewrite")
+	assert.Equal(t, nil, err)
+	defer pushSession.Dispose()
+	assert.IsNotNil(t, sm.StatGroup("live", "rewritten"))
+	assert.Equal(t, true, sm.StatGroup("live", "rewrite") == nil)
+
+	pullSession := rtmp.NewPullSession(func(option *rtmp.PullSessionOption) {
+		option.PullTimeoutMS = 5000
+	})
+	err = pullSession.Pull("rtmp://127.0.0.1:14950/live/reject", func(msg rtmp.AVMsg) {})
+	assert.IsNotNil(t, err)
+	pullSession.Dispose()
+
+	select {
+	case <-pushSession.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not closed by max duration limit")
+	}
+}
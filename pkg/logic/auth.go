@@ -0,0 +1,135 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 推拉流鉴权
+//
+// 上层可以通过ModServerManagerOption传入自定义的Authenticator，
+// 否则配置文件中开启auth后，使用内置的签名URL方案：
+//
+// 推拉流URL中携带expire和sign两个参数，比如 rtmp://127.0.0.1:19350/live/test110?expire=1600000000&sign=xxx
+// - expire: 过期时间，Unix时间戳，单位秒
+// - sign:   hex(hmac_sha256(secret, "/{app_name}/{stream_name}-{expire}-{action}"))，secret为这个app配置的密钥，
+//           action推流时为"pub"，拉流时为"sub"，所以拉流的URL不能用来推流，见CalcAuthSign
+//
+// 没有配置的app，以及配置中没有开启推流或拉流鉴权的app，不做鉴权，app的配置见AppConfig
+
+const (
+	AuthProtocolRTMP    = "rtmp"
+	AuthProtocolHTTPFLV = "httpflv"
+	AuthProtocolRTSP    = "rtsp"
+)
+
+// 签名中的动作，区分推流和拉流
+const (
+	AuthActionPub = "pub"
+	AuthActionSub = "sub"
+)
+
+var (
+	ErrAuthParamMissing = errors.New("lal.logic: auth param missing")
+	ErrAuthExpired      = errors.New("lal.logic: auth expired")
+	ErrAuthSignMismatch = errors.New("lal.logic: auth sign mismatch")
+)
+
+type AuthInfo struct {
+	Protocol   string // AuthProtocolRTMP，AuthProtocolHTTPFLV 或 AuthProtocolRTSP
+	AppName    string
+	StreamName string
+	RawQuery   string // 推拉流url中?后面的参数，不包含?
+}
+
+type Authenticator interface {
+	// 返回nil则允许推流，否则拒绝
+	AuthPub(info AuthInfo) error
+
+	// 返回nil则允许拉流，否则拒绝
+	AuthSub(info AuthInfo) error
+}
+
+type SignedURLAuthenticator struct {
+	appMap map[string]AppAuthConfig
+}
+
+// @param appList 使用其中各app的Auth配置
+func NewSignedURLAuthenticator(appList []AppConfig) *SignedURLAuthenticator {
+	a := &SignedURLAuthenticator{
+		appMap: make(map[string]AppAuthConfig),
+	}
+	for _, app := range appList {
+		a.appMap[app.AppName] = app.Auth
+	}
+	return a
+}
+
+func (a *SignedURLAuthenticator) AuthPub(info AuthInfo) error {
+	app, ok := a.appMap[info.AppName]
+	if !ok || !app.PubEnable {
+		return nil
+	}
+	return checkAuthSign(app.Secret, AuthActionPub, info, time.Now())
+}
+
+func (a *SignedURLAuthenticator) AuthSub(info AuthInfo) error {
+	app, ok := a.appMap[info.AppName]
+	if !ok || !app.SubEnable {
+		return nil
+	}
+	return checkAuthSign(app.Secret, AuthActionSub, info, time.Now())
+}
+
+// 计算内置签名URL方案的签名
+//
+// @param action AuthActionPub 或 AuthActionSub
+// @param expire 过期时间，Unix时间戳，单位秒
+func CalcAuthSign(secret string, action string, appName string, streamName string, expire int64) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	_, _ = h.Write([]byte(fmt.Sprintf("/%s/%s-%d-%s", appName, streamName, expire, action)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 生成内置签名URL方案的参数，拼接在推拉流url的?后面，比如 "expire=1600000000&sign=xxx"
+func MakeAuthRawQuery(secret string, action string, appName string, streamName string, expire int64) string {
+	return fmt.Sprintf("expire=%d&sign=%s", expire, CalcAuthSign(secret, action, appName, streamName, expire))
+}
+
+func checkAuthSign(secret string, action string, info AuthInfo, now time.Time) error {
+	values, err := url.ParseQuery(info.RawQuery)
+	if err != nil {
+		return err
+	}
+	expireStr := values.Get("expire")
+	sign := values.Get("sign")
+	if expireStr == "" || sign == "" {
+		return ErrAuthParamMissing
+	}
+	expire, err := strconv.ParseInt(expireStr, 10, 64)
+	if err != nil {
+		return err
+	}
+	if now.Unix() > expire {
+		return ErrAuthExpired
+	}
+	expected := CalcAuthSign(secret, action, info.AppName, info.StreamName, expire)
+	if !hmac.Equal([]byte(sign), []byte(expected)) {
+		return ErrAuthSignMismatch
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/connection"
+)
+
+func TestCheckAuthSign(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	expire := now.Unix() + 60
+	info := AuthInfo{
+		Protocol:   AuthProtocolRTMP,
+		AppName:    "live",
+		StreamName: "test110",
+		RawQuery:   MakeAuthRawQuery("secret", AuthActionPub, "live", "test110", expire),
+	}
+	assert.Equal(t, nil, checkAuthSign("secret", AuthActionPub, info, now))
+
+	// 推流的签名不能用来拉流，反之亦然
+	assert.Equal(t, ErrAuthSignMismatch, checkAuthSign("secret", AuthActionSub, info, now))
+	info2 := info
+	info2.RawQuery = MakeAuthRawQuery("secret", AuthActionSub, "live", "test110", expire)
+	assert.Equal(t, nil, checkAuthSign("secret", AuthActionSub, info2, now))
+	assert.Equal(t, ErrAuthSignMismatch, checkAuthSign("secret", AuthActionPub, info2, now))
+
+	// 过期
+	assert.Equal(t, ErrAuthExpired, checkAuthSign("secret", AuthActionPub, info, now.Add(61*time.Second)))
+
+	// 密钥不匹配
+	assert.Equal(t, ErrAuthSignMismatch, checkAuthSign("secret2", AuthActionPub, info, now))
+
+	// 签名和流名不匹配
+	info2 = info
+	info2.StreamName = "test111"
+	assert.Equal(t, ErrAuthSignMismatch, checkAuthSign("secret", AuthActionPub, info2, now))
+
+	// 篡改过期时间
+	info2 = info
+	info2.RawQuery = "expire=1600003600&sign=" + CalcAuthSign("secret", AuthActionPub, "live", "test110", expire)
+	assert.Equal(t, ErrAuthSignMismatch, checkAuthSign("secret", AuthActionPub, info2, now))
+
+	// 缺少参数
+	info2 = info
+	info2.RawQuery = "sign=" + CalcAuthSign("secret", AuthActionPub, "live", "test110", expire)
+	assert.Equal(t, ErrAuthParamMissing, checkAuthSign("secret", AuthActionPub, info2, now))
+	info2.RawQuery = ""
+	assert.Equal(t, ErrAuthParamMissing, checkAuthSign("secret", AuthActionPub, info2, now))
+}
+
+func TestSignedURLAuthenticator(t *testing.T) {
+	a := NewSignedURLAuthenticator([]AppConfig{
+		{AppName: "live", Auth: AppAuthConfig{Secret: "secret", PubEnable: true, SubEnable: false}},
+	})
+	info := AuthInfo{Protocol: AuthProtocolRTMP, AppName: "live", StreamName: "test110"}
+	assert.IsNotNil(t, a.AuthPub(info))
+	assert.Equal(t, nil, a.AuthSub(info))
+
+	info.RawQuery = MakeAuthRawQuery("secret", AuthActionPub, "live", "test110", time.Now().Unix()+60)
+	assert.Equal(t, nil, a.AuthPub(info))
+
+	// 拉流的签名不能用来推流
+	info.RawQuery = MakeAuthRawQuery("secret", AuthActionSub, "live", "test110", time.Now().Unix()+60)
+	assert.Equal(t, ErrAuthSignMismatch, a.AuthPub(info))
+
+	// 没有配置的app不鉴权
+	info = AuthInfo{Protocol: AuthProtocolHTTPFLV, AppName: "other", StreamName: "test110"}
+	assert.Equal(t, nil, a.AuthPub(info))
+	assert.Equal(t, nil, a.AuthSub(info))
+}
+
+func TestServerManager_RTSPDescribeAuth(t *testing.T) {
+	oldConfig := config
+	config = &Config{}
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager(func(option *ServerManagerOption) {
+		option.Authenticator = NewSignedURLAuthenticator([]AppConfig{
+			{AppName: "live", Auth: AppAuthConfig{Secret: "secret", SubEnable: true}},
+		})
+	})
+	group := sm.getOrCreateGroup("live", "test110")
+	defer group.Dispose()
+	group.aacSeqHeader = []byte{0xaf, 0x00, 0x12, 0x10}
+
+	newSession := func(rawQuery string) *rtsp.SubSession {
+		c1, c2 := net.Pipe()
+		_ = c2.Close()
+		session := rtsp.NewSubSession("live", "test110", base.NewStatConn(connection.New(c1)))
+		session.RawQuery = rawQuery
+		return session
+	}
+
+	// 没有签名时，DESCRIBE就拒绝，不返回流的音视频头信息
+	session := newSession("")
+	assert.Equal(t, false, sm.OnNewRTSPSubSessionDescribe(session))
+	assert.Equal(t, true, session.SDP() == nil)
+	session.Dispose()
+
+	// 推流的签名不能用来拉流
+	session = newSession(MakeAuthRawQuery("secret", AuthActionPub, "live", "test110", time.Now().Unix()+60))
+	assert.Equal(t, false, sm.OnNewRTSPSubSessionDescribe(session))
+	session.Dispose()
+
+	session = newSession(MakeAuthRawQuery("secret", AuthActionSub, "live", "test110", time.Now().Unix()+60))
+	assert.Equal(t, true, sm.OnNewRTSPSubSessionDescribe(session))
+	assert.Equal(t, true, session.SDP() != nil)
+	// 只DESCRIBE没有PLAY的session也会回调删除
+	sm.OnDelRTSPSubSession(session)
+	session.Dispose()
+}
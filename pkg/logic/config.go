@@ -18,12 +18,28 @@ import (
 	"github.com/q191201771/naza/pkg/nazalog"
 )
 
+const (
+	RelayPullProtocolRTMP  = "rtmp"
+	RelayPullProtocolRTMPS = "rtmps"
+	RelayPullProtocolRTSP  = "rtsp"
+)
+
 type Config struct {
-	RTMPConfig      RTMPConfig      `json:"rtmp"`
-	HTTPFLVConfig   HTTPFLVConfig   `json:"httpflv"`
-	HLSConfig       HLSConfig       `json:"hls"`
-	RelayPushConfig RelayPushConfig `json:"relay_push"`
-	RelayPullConfig RelayPullConfig `json:"relay_pull"`
+	// 流已经存在推流时，新的推流（rtmp或rtsp）是否踢掉旧的推流，否则拒绝新的推流，可以按app配置，见AppConfig
+	PubPreempt bool `json:"pub_preempt"`
+
+	RTMPConfig       RTMPConfig       `json:"rtmp"`
+	HTTPFLVConfig    HTTPFLVConfig    `json:"httpflv"`
+	HLSConfig        HLSConfig        `json:"hls"`
+	RTSPConfig       RTSPConfig       `json:"rtsp"`
+	RelayPushConfig  RelayPushConfig  `json:"relay_push"`
+	RelayPullConfig  RelayPullConfig  `json:"relay_pull"`
+	StaticPullConfig StaticPullConfig `json:"static_pull"`
+	AuthConfig       AuthConfig       `json:"auth"`
+	AppList          []AppConfig      `json:"app_list"`
+	HTTPAPIConfig    HTTPAPIConfig    `json:"http_api"`
+	NotifyConfig     NotifyConfig     `json:"notify"`
+	AdmissionConfig  AdmissionConfig  `json:"admission"`
 
 	PProfConfig PProfConfig    `json:"pprof"`
 	LogConfig   nazalog.Option `json:"log"`
@@ -33,6 +49,15 @@ type RTMPConfig struct {
 	Enable bool   `json:"enable"`
 	Addr   string `json:"addr"`
 	GOPNum int    `json:"gop_num"`
+
+	// 已废弃，使用顶层的pub_preempt，没有配置顶层的pub_preempt时仍然兼容，见LoadConf
+	PubPreempt bool `json:"pub_preempt"`
+
+	// rtmps监听，可以和rtmp监听同时开启
+	RTMPSEnable   bool   `json:"rtmps_enable"`
+	RTMPSAddr     string `json:"rtmps_addr"`
+	RTMPSCertFile string `json:"rtmps_cert_file"` // PEM格式
+	RTMPSKeyFile  string `json:"rtmps_key_file"`  // PEM格式
 }
 
 type HTTPFLVConfig struct {
@@ -47,14 +72,106 @@ type HLSConfig struct {
 	hls.MuxerConfig
 }
 
+type RTSPConfig struct {
+	Enable bool   `json:"enable"`
+	Addr   string `json:"addr"`
+}
+
 type RelayPushConfig struct {
-	Enable   bool     `json:"enable"`
-	AddrList []string `json:"addr_list"`
+	Enable                  bool     `json:"enable"`
+	AddrList                []string `json:"addr_list"`                  // 例如 "127.0.0.1:19351"（rtmp），"rtmps://127.0.0.1:443" 或 "rtsp://127.0.0.1:554"
+	RTSPOverTCP             bool     `json:"rtsp_over_tcp"`              // rtsp转推时，是否使用RTP/AVP/TCP方式
+	RTMPSInsecureSkipVerify bool     `json:"rtmps_insecure_skip_verify"` // rtmps转推时，是否不校验对端的证书
 }
 
 type RelayPullConfig struct {
+	Enable                  bool   `json:"enable"`
+	Addr                    string `json:"addr"`
+	Protocol                string `json:"protocol"`                   // "rtmp"，"rtmps" 或 "rtsp"，为空时为"rtmp"
+	RTSPOverTCP             bool   `json:"rtsp_over_tcp"`              // rtsp回源时，是否使用RTP/AVP/TCP方式
+	RTMPSInsecureSkipVerify bool   `json:"rtmps_insecure_skip_verify"` // rtmps回源时，是否不校验对端的证书
+
+	// 回源拉流后，没有观看者（rtmp，httpflv，rtsp的sub，以及hls的请求）的时间超过这个值时，关闭回源拉流，单位秒
+	// 为0时不关闭；不影响static_pull以及通过HTTP API发起的拉流
+	IdleTimeoutSec int `json:"idle_timeout_sec"`
+}
+
+// 不依赖sub订阅者，启动后就主动拉取的流，比如IP摄像头的rtsp流
+type StaticPullConfig struct {
+	Enable                  bool             `json:"enable"`
+	RTSPOverTCP             bool             `json:"rtsp_over_tcp"`
+	RTMPSInsecureSkipVerify bool             `json:"rtmps_insecure_skip_verify"`
+	ItemList                []StaticPullItem `json:"item_list"`
+}
+
+type StaticPullItem struct {
+	URL        string `json:"url"` // 目前支持rtmp://，rtmps://和rtsp://
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+}
+
+// 推拉流鉴权，目前只支持内置的签名URL方案，见auth.go
+// 各app的密钥等在AppConfig中配置，旧的auth.app_list配置方式仍然兼容，见LoadConf
+type AuthConfig struct {
+	Enable bool `json:"enable"`
+}
+
+// 按app的配置，用于一个lalserver服务多个业务
+//
+// 配置中没有出现的字段，使用对应的全局配置，没有配置的app，全部使用全局配置，见Config.getAppConfig
+type AppConfig struct {
+	AppName           string        `json:"app_name"`
+	RTMPGOPNum        int           `json:"rtmp_gop_num"`         // 默认为rtmp.gop_num
+	HTTPFLVGOPNum     int           `json:"httpflv_gop_num"`      // 默认为httpflv.gop_num
+	HLSEnable         bool          `json:"hls_enable"`           // 默认为hls.enable，只控制是否生成HLS文件，HLS服务的监听由hls.enable控制
+	RelayPushEnable   bool          `json:"relay_push_enable"`    // 默认为relay_push.enable
+	RelayPushAddrList []string      `json:"relay_push_addr_list"` // 默认为relay_push.addr_list
+	RelayPullEnable   bool          `json:"relay_pull_enable"`    // 默认为relay_pull.enable
+	RelayPullAddr     string        `json:"relay_pull_addr"`      // 默认为relay_pull.addr
+	PubPreempt        bool          `json:"pub_preempt"`          // 默认为pub_preempt
+	Auth              AppAuthConfig `json:"auth"`
+}
+
+type AppAuthConfig struct {
+	Secret    string `json:"secret"`
+	PubEnable bool   `json:"pub_enable"` // 推流是否需要鉴权
+	SubEnable bool   `json:"sub_enable"` // 拉流是否需要鉴权
+}
+
+// HTTP管理接口，查询服务和流的信息，踢掉session，控制回源拉流和转推等，见http_api.go
+type HTTPAPIConfig struct {
 	Enable bool   `json:"enable"`
-	Addr   string `json:"addr"`
+	Addr   string `json:"addr"`  // 默认只监听127.0.0.1，需要对外开放时建议配置token
+	Token  string `json:"token"` // 不为空时，所有接口都需要携带token
+}
+
+// HTTP回调通知，地址为空的事件不通知，见notify.go
+type NotifyConfig struct {
+	Enable            bool `json:"enable"`
+	UpdateIntervalSec int  `json:"update_interval_sec"` // on_update的间隔，默认为5
+	TimeoutMS         int  `json:"timeout_ms"`          // 单次HTTP请求的超时，默认为3000
+	RetryNum          int  `json:"retry_num"`           // 失败后的重试次数，为0时不重试
+	QueueSize         int  `json:"queue_size"`          // 等待发送的事件队列的长度，队列满时丢弃新的事件，默认为1024
+
+	OnPubStart       string `json:"on_pub_start"`
+	OnPubStop        string `json:"on_pub_stop"`
+	OnSubStart       string `json:"on_sub_start"`
+	OnSubStop        string `json:"on_sub_stop"`
+	OnRelayPullStart string `json:"on_relay_pull_start"`
+	OnRelayPullStop  string `json:"on_relay_pull_stop"`
+	OnRelayPushStart string `json:"on_relay_push_start"`
+	OnRelayPushStop  string `json:"on_relay_push_stop"`
+	OnHLSMakeTS      string `json:"on_hls_make_ts"` // ts文件写完时
+	OnUpdate         string `json:"on_update"`      // 定时通知所有流的信息
+}
+
+// 准入回调，地址为空时不回调，见admission.go
+type AdmissionConfig struct {
+	Enable       bool   `json:"enable"`
+	TimeoutMS    int    `json:"timeout_ms"`     // HTTP请求的超时，默认为3000
+	AllowOnError bool   `json:"allow_on_error"` // 网络错误，超时，或返回内容格式错误时，是否允许
+	OnPublish    string `json:"on_publish"`     // rtmp和rtsp推流
+	OnPlay       string `json:"on_play"`        // rtmp，httpflv和rtsp拉流，rtsp拉流在DESCRIBE时回调，不支持修改流名
 }
 
 type PProfConfig struct {
@@ -103,6 +220,104 @@ func LoadConf(confFile string) (*Config, error) {
 	if !j.Exist("log.assert_behavior") {
 		config.LogConfig.AssertBehavior = nazalog.AssertError
 	}
+	if config.RelayPullConfig.Protocol == "" {
+		config.RelayPullConfig.Protocol = RelayPullProtocolRTMP
+	}
+	if config.NotifyConfig.UpdateIntervalSec == 0 {
+		config.NotifyConfig.UpdateIntervalSec = 5
+	}
+	if config.NotifyConfig.TimeoutMS == 0 {
+		config.NotifyConfig.TimeoutMS = 3000
+	}
+	if config.NotifyConfig.QueueSize == 0 {
+		config.NotifyConfig.QueueSize = 1024
+	}
+	if config.AdmissionConfig.TimeoutMS == 0 {
+		config.AdmissionConfig.TimeoutMS = 3000
+	}
+
+	// 兼容旧的配置方式，即rtmp.pub_preempt，顶层的pub_preempt存在时以顶层的为准
+	if j.Exist("rtmp.pub_preempt") {
+		nazalog.Warnf("rtmp.pub_preempt is deprecated, use pub_preempt instead.")
+		if !j.Exist("pub_preempt") {
+			config.PubPreempt = config.RTMPConfig.PubPreempt
+		}
+	}
+
+	// 按app的配置，在全局配置的基础上解析，配置中没有出现的字段保持全局配置的值
+	var rawAppList struct {
+		AppList []json.RawMessage `json:"app_list"`
+	}
+	if err = json.Unmarshal(rawContent, &rawAppList); err != nil {
+		return nil, err
+	}
+	config.AppList = nil
+	for _, item := range rawAppList.AppList {
+		appConfig := config.defaultAppConfig("")
+		if err = json.Unmarshal(item, &appConfig); err != nil {
+			return nil, err
+		}
+		config.AppList = append(config.AppList, appConfig)
+	}
+
+	// 兼容旧的配置方式，即在auth.app_list中配置各app的鉴权，将其合并到对应app的auth配置中
+	var rawAuth struct {
+		Auth struct {
+			AppList []struct {
+				AppName string `json:"app_name"`
+				AppAuthConfig
+			} `json:"app_list"`
+		} `json:"auth"`
+	}
+	if err = json.Unmarshal(rawContent, &rawAuth); err != nil {
+		return nil, err
+	}
+	for _, item := range rawAuth.Auth.AppList {
+		nazalog.Warnf("auth.app_list is deprecated, use app_list[].auth instead. app=%s", item.AppName)
+		config.mergeLegacyAppAuth(item.AppName, item.AppAuthConfig)
+	}
 
 	return &config, nil
 }
+
+// 旧配置auth.app_list中app的鉴权配置，app_list中已经配置了auth的app，以app_list中的为准
+func (c *Config) mergeLegacyAppAuth(appName string, auth AppAuthConfig) {
+	for i := range c.AppList {
+		if c.AppList[i].AppName != appName {
+			continue
+		}
+		if c.AppList[i].Auth != (AppAuthConfig{}) {
+			nazalog.Warnf("auth of app already exist in app_list, ignore auth.app_list. app=%s", appName)
+			return
+		}
+		c.AppList[i].Auth = auth
+		return
+	}
+	appConfig := c.defaultAppConfig(appName)
+	appConfig.Auth = auth
+	c.AppList = append(c.AppList, appConfig)
+}
+
+// 获取app的配置，没有配置时使用全局配置
+func (c *Config) getAppConfig(appName string) AppConfig {
+	for _, appConfig := range c.AppList {
+		if appConfig.AppName == appName {
+			return appConfig
+		}
+	}
+	return c.defaultAppConfig(appName)
+}
+
+func (c *Config) defaultAppConfig(appName string) AppConfig {
+	return AppConfig{
+		AppName:           appName,
+		RTMPGOPNum:        c.RTMPConfig.GOPNum,
+		HTTPFLVGOPNum:     c.HTTPFLVConfig.GOPNum,
+		HLSEnable:         c.HLSConfig.Enable,
+		RelayPushEnable:   c.RelayPushConfig.Enable,
+		RelayPushAddrList: append([]string(nil), c.RelayPushConfig.AddrList...), // 拷贝一份，避免解析app配置时覆盖全局配置
+		RelayPullEnable:   c.RelayPullConfig.Enable,
+		RelayPullAddr:     c.RelayPullConfig.Addr,
+		PubPreempt:        c.PubPreempt,
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+var testConfContent = `
+{
+  "pub_preempt": true,
+  "rtmp": {"enable": true, "addr": ":19350", "gop_num": 2},
+  "httpflv": {"enable": true, "sub_listen_addr": ":8080", "gop_num": 3},
+  "hls": {"enable": true, "sub_listen_addr": ":8081", "out_path": "/tmp/lal/hls/"},
+  "relay_push": {"enable": true, "addr_list": ["127.0.0.1:19351"]},
+  "relay_pull": {"enable": false, "addr": "127.0.0.1:19352"},
+  "app_list": [
+    {"app_name": "live", "rtmp_gop_num": 0, "hls_enable": false, "relay_push_addr_list": ["127.0.0.1:19353", "127.0.0.1:19354"]},
+    {"app_name": "test", "relay_pull_enable": true, "pub_preempt": false, "auth": {"secret": "xxx", "pub_enable": true}}
+  ],
+  "pprof": {"enable": false, "addr": ":10001"},
+  "log": {"level": 1}
+}
+`
+
+func TestLoadConf_AppList(t *testing.T) {
+	f, err := ioutil.TempFile("", "lalserver.conf.json")
+	assert.Equal(t, nil, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(testConfContent)
+	assert.Equal(t, nil, err)
+	_ = f.Close()
+
+	c, err := LoadConf(f.Name())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(c.AppList))
+
+	// 配置中出现的字段覆盖全局配置，没有出现的字段使用全局配置
+	live := c.getAppConfig("live")
+	assert.Equal(t, "live", live.AppName)
+	assert.Equal(t, 0, live.RTMPGOPNum)
+	assert.Equal(t, 3, live.HTTPFLVGOPNum)
+	assert.Equal(t, false, live.HLSEnable)
+	assert.Equal(t, true, live.RelayPushEnable)
+	assert.Equal(t, []string{"127.0.0.1:19353", "127.0.0.1:19354"}, live.RelayPushAddrList)
+	assert.Equal(t, false, live.Auth.PubEnable)
+	assert.Equal(t, true, live.PubPreempt)
+
+	test := c.getAppConfig("test")
+	assert.Equal(t, 2, test.RTMPGOPNum)
+	assert.Equal(t, true, test.HLSEnable)
+	assert.Equal(t, []string{"127.0.0.1:19351"}, test.RelayPushAddrList)
+	assert.Equal(t, true, test.RelayPullEnable)
+	assert.Equal(t, "127.0.0.1:19352", test.RelayPullAddr)
+	assert.Equal(t, false, test.PubPreempt)
+	assert.Equal(t, AppAuthConfig{Secret: "xxx", PubEnable: true, SubEnable: false}, test.Auth)
+
+	// 没有配置的app使用全局配置
+	other := c.getAppConfig("other")
+	assert.Equal(t, "other", other.AppName)
+	assert.Equal(t, 2, other.RTMPGOPNum)
+	assert.Equal(t, false, other.RelayPullEnable)
+	assert.Equal(t, true, other.PubPreempt)
+	assert.Equal(t, []string{"127.0.0.1:19351"}, other.RelayPushAddrList)
+	assert.Equal(t, []string{"127.0.0.1:19351"}, c.RelayPushConfig.AddrList)
+}
+
+func TestLoadConf_LegacyAuthAppList(t *testing.T) {
+	f, err := ioutil.TempFile("", "lalserver.conf.json")
+	assert.Equal(t, nil, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+{
+  "rtmp": {"enable": true, "addr": ":19350", "gop_num": 2},
+  "httpflv": {"enable": true, "sub_listen_addr": ":8080", "gop_num": 3},
+  "hls": {"enable": false},
+  "relay_push": {"enable": false},
+  "relay_pull": {"enable": false},
+  "app_list": [
+    {"app_name": "live", "rtmp_gop_num": 0},
+    {"app_name": "test", "auth": {"secret": "new", "sub_enable": true}}
+  ],
+  "auth": {
+    "enable": true,
+    "app_list": [
+      {"app_name": "live", "secret": "xxx", "pub_enable": true},
+      {"app_name": "test", "secret": "old", "pub_enable": true},
+      {"app_name": "other", "secret": "yyy", "sub_enable": true}
+    ]
+  },
+  "pprof": {"enable": false},
+  "log": {"level": 1}
+}
+`)
+	assert.Equal(t, nil, err)
+	_ = f.Close()
+
+	c, err := LoadConf(f.Name())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, c.AuthConfig.Enable)
+	assert.Equal(t, 3, len(c.AppList))
+
+	live := c.getAppConfig("live")
+	assert.Equal(t, 0, live.RTMPGOPNum)
+	assert.Equal(t, AppAuthConfig{Secret: "xxx", PubEnable: true}, live.Auth)
+
+	// app_list中已经配置了auth的，以app_list中的为准
+	test := c.getAppConfig("test")
+	assert.Equal(t, AppAuthConfig{Secret: "new", SubEnable: true}, test.Auth)
+
+	// 只在auth.app_list中出现的app，其他字段使用全局配置
+	other := c.getAppConfig("other")
+	assert.Equal(t, 2, other.RTMPGOPNum)
+	assert.Equal(t, AppAuthConfig{Secret: "yyy", SubEnable: true}, other.Auth)
+}
+
+func TestLoadConf_LegacyRTMPPubPreempt(t *testing.T) {
+	f, err := ioutil.TempFile("", "lalserver.conf.json")
+	assert.Equal(t, nil, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+{
+  "rtmp": {"enable": true, "addr": ":19350", "gop_num": 2, "pub_preempt": true},
+  "httpflv": {"enable": true, "sub_listen_addr": ":8080", "gop_num": 3},
+  "hls": {"enable": false},
+  "relay_push": {"enable": false},
+  "relay_pull": {"enable": false},
+  "app_list": [
+    {"app_name": "live", "pub_preempt": false}
+  ],
+  "pprof": {"enable": false},
+  "log": {"level": 1}
+}
+`)
+	assert.Equal(t, nil, err)
+	_ = f.Close()
+
+	c, err := LoadConf(f.Name())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, c.PubPreempt)
+	assert.Equal(t, false, c.getAppConfig("live").PubPreempt)
+	assert.Equal(t, true, c.getAppConfig("other").PubPreempt)
+}
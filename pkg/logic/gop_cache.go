@@ -118,6 +118,17 @@ func (gc *GOPCache) GetGOPDataAt(pos int) [][]byte {
 	return gc.gopRing[(pos+gc.gopRingFirst)%gc.gopSize].data
 }
 
+// 缓存的metadata，音视频头，以及gop数据占用的字节数
+func (gc *GOPCache) GetSize() int {
+	size := len(gc.Metadata) + len(gc.VideoSeqHeader) + len(gc.AACSeqHeader)
+	for i := 0; i < gc.GetGOPCount(); i++ {
+		for _, b := range gc.GetGOPDataAt(i) {
+			size += len(b)
+		}
+	}
+	return size
+}
+
 func (gc *GOPCache) Clear() {
 	gc.Metadata = nil
 	gc.VideoSeqHeader = nil
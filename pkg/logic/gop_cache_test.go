@@ -116,4 +116,8 @@ func TestGOPCache_Feed(t *testing.T) {
 	assert.Equal(t, [][]byte{{1, 3}, {0, 3}}, nc.GetGOPDataAt(1))
 	assert.Equal(t, [][]byte{{1, 4}, {0, 4}}, nc.GetGOPDataAt(2))
 	assert.Equal(t, nil, nc.GetGOPDataAt(3))
+	assert.Equal(t, 12, nc.GetSize())
+
+	nc.Clear()
+	assert.Equal(t, 0, nc.GetSize())
 }
@@ -10,19 +10,24 @@ package logic
 
 import (
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
 	"github.com/q191201771/lal/pkg/hls"
 
 	"github.com/q191201771/lal/pkg/httpflv"
 	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/lal/pkg/rtsp"
 	"github.com/q191201771/naza/pkg/nazalog"
 	"github.com/q191201771/naza/pkg/unique"
 )
 
 // TODO chef:
 //  - group可以考虑搞个协程
-//  - 多长没有sub订阅拉流，关闭pull回源
 //  - pull重试次数
 //  - sub无数据超时时间
 
@@ -33,32 +38,90 @@ type Group struct {
 	streamName string
 
 	exitChan chan struct{}
+	notify   *HTTPNotify
 
 	mutex                sync.Mutex
 	pubSession           *rtmp.ServerSession
+	rtspPubSession       *rtsp.PubSession
+	rtsp2RTMPRemuxer     *RTSP2RTMPRemuxer
 	rtmpSubSessionSet    map[*rtmp.ServerSession]struct{}
 	httpflvSubSessionSet map[*httpflv.SubSession]struct{}
+	rtspSubSessionSet    map[*rtsp.SubSession]struct{}
 	hlsMuxer             *hls.Muxer
 	url2PushProxy        map[string]*pushProxy
 	pullSession          *rtmp.PullSession
+	rtspPullSession      *rtsp.PullSession
 	isPulling            bool
+	staticPullURL        string                       // 不为空时，不依赖sub订阅者，一直从这个地址拉流
+	sessionLimitMap      map[string]*sessionLimitItem // key为session的UniqueKey，准入回调设置的限制
+	lastSubTime          time.Time                    // 最近一次有观看者的时间，用于关闭空闲的回源拉流
+	appConfig            AppConfig
 	gopCache             *GOPCache
 	httpflvGopCache      *GOPCache
+
+	// 已经从group中删除的session的字节数，用于统计流的总输入（pub，pull）和总输出（sub，push）
+	inBytesOfDone  uint64
+	outBytesOfDone uint64
+
+	// rtmp message格式的音视频头，用于生成rtsp的sdp
+	videoSeqHeader []byte // AVC或HEVC
+	aacSeqHeader   []byte
+}
+
+// group的统计信息，用于HTTP API
+//
+// 读取的字节数和码率为输入（pub，pull）的汇总，写入的为输出（sub，push）的汇总，字节数包含已经结束的session
+// 帧数和时间戳为当前输入session的
+// Pub和Pull不存在时为nil
+type StatGroup struct {
+	AppName       string             `json:"app_name"`
+	StreamName    string             `json:"stream_name"`
+	ReadBytesSum  uint64             `json:"read_bytes_sum"`
+	WroteBytesSum uint64             `json:"wrote_bytes_sum"`
+	ReadBitrate   int                `json:"read_bitrate"`  // 单位kbit/s
+	WroteBitrate  int                `json:"wrote_bitrate"` // 单位kbit/s
+	AudioCount    uint64             `json:"audio_count"`
+	VideoCount    uint64             `json:"video_count"`
+	LastTimestamp uint32             `json:"last_timestamp"` // 单位毫秒
+	Pub           *base.StatSession  `json:"pub"`
+	Pull          *base.StatSession  `json:"pull"`
+	Subs          []base.StatSession `json:"subs"`
+	Pushs         []StatPush         `json:"pushs"`
+}
+
+type StatPush struct {
+	URL     string            `json:"url"`
+	Session *base.StatSession `json:"session"` // 正在建立连接或没有在转推时为nil
+}
+
+type sessionLimitItem struct {
+	limit     SessionLimit
+	startTime time.Time
 }
 
 type pushProxy struct {
-	isPushing   bool
-	pushSession *rtmp.PushSession
+	isPushing       bool
+	pushSession     *rtmp.PushSession
+	rtspPushSession *rtsp.PushSession // 转推地址为rtsp://时使用
+	byAPI           bool              // 是否是通过HTTP API添加的转推地址，是的话即使group中没有任何session，也保留group
 }
 
-func NewGroup(appName string, streamName string) *Group {
+func NewGroup(appName string, streamName string, notify *HTTPNotify) *Group {
 	uk := unique.GenUniqueKey("GROUP")
 	nazalog.Infof("[%s] lifecycle new group. appName=%s, streamName=%s", uk, appName, streamName)
 
+	appConfig := config.getAppConfig(appName)
+
 	url2PushProxy := make(map[string]*pushProxy)
-	if config.RelayPushConfig.Enable {
-		for _, addr := range config.RelayPushConfig.AddrList {
-			url := fmt.Sprintf("rtmp://%s/%s/%s", addr, appName, streamName)
+	if appConfig.RelayPushEnable {
+		for _, addr := range appConfig.RelayPushAddrList {
+			// 地址中没有协议头时，默认为rtmp
+			var url string
+			if strings.Contains(addr, "://") {
+				url = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(addr, "/"), appName, streamName)
+			} else {
+				url = fmt.Sprintf("rtmp://%s/%s/%s", addr, appName, streamName)
+			}
 			url2PushProxy[url] = &pushProxy{
 				isPushing:   false,
 				pushSession: nil,
@@ -71,14 +134,26 @@ func NewGroup(appName string, streamName string) *Group {
 		appName:              appName,
 		streamName:           streamName,
 		exitChan:             make(chan struct{}, 1),
+		notify:               notify,
 		rtmpSubSessionSet:    make(map[*rtmp.ServerSession]struct{}),
 		httpflvSubSessionSet: make(map[*httpflv.SubSession]struct{}),
-		gopCache:             NewGOPCache("rtmp", uk, config.RTMPConfig.GOPNum),
-		httpflvGopCache:      NewGOPCache("httpflv", uk, config.HTTPFLVConfig.GOPNum),
+		rtspSubSessionSet:    make(map[*rtsp.SubSession]struct{}),
+		gopCache:             NewGOPCache("rtmp", uk, appConfig.RTMPGOPNum),
+		httpflvGopCache:      NewGOPCache("httpflv", uk, appConfig.HTTPFLVGOPNum),
 		url2PushProxy:        url2PushProxy,
+		sessionLimitMap:      make(map[string]*sessionLimitItem),
+		appConfig:            appConfig,
 	}
 }
 
+// 设置静态拉流地址，之后在Tick中发起拉流，断开后也会在Tick中重试
+func (group *Group) SetStaticPullURL(url string) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.staticPullURL = url
+	group.pullIfNeeded()
+}
+
 func (group *Group) RunLoop() {
 	<-group.exitChan
 }
@@ -88,8 +163,11 @@ func (group *Group) Tick() {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
+	now := time.Now()
 	group.pullIfNeeded()
 	group.pushIfNeeded()
+	group.checkSessionLimit(now)
+	group.stopPullIfIdle(now)
 }
 
 // 主动释放所有资源。保证所有资源的生命周期逻辑上都在我们的控制中。降低出bug的几率，降低心智负担。
@@ -97,6 +175,7 @@ func (group *Group) Tick() {
 // 值得一提，如果是从其他协程回调回来的消息，在使用Group中的资源前，要判断资源是否存在以及可用。
 //
 // TODO chef:
+//
 //  后续弄个协程来替换掉目前锁的方式，来做消息同步。这样有个好处，就是不用写很多的资源有效判断。统一写一个就好了。
 //  目前Dispose在IsTotalEmpty时调用，暂时没有这个问题。
 func (group *Group) Dispose() {
@@ -111,6 +190,11 @@ func (group *Group) Dispose() {
 		group.pubSession = nil
 	}
 
+	if group.rtspPubSession != nil {
+		group.rtspPubSession.Dispose()
+		group.rtspPubSession = nil
+	}
+
 	for session := range group.rtmpSubSessionSet {
 		session.Dispose()
 	}
@@ -121,16 +205,29 @@ func (group *Group) Dispose() {
 	}
 	group.httpflvSubSessionSet = nil
 
+	for session := range group.rtspSubSessionSet {
+		session.Dispose()
+	}
+	group.rtspSubSessionSet = nil
+
+	if group.rtspPullSession != nil {
+		group.rtspPullSession.Dispose()
+		group.rtspPullSession = nil
+	}
+
 	if group.hlsMuxer != nil {
 		group.hlsMuxer.Dispose()
 		group.hlsMuxer = nil
 	}
 
-	if config.RelayPushConfig.Enable {
+	if group.appConfig.RelayPushEnable {
 		for _, v := range group.url2PushProxy {
 			if v.pushSession != nil {
 				v.pushSession.Dispose()
 			}
+			if v.rtspPushSession != nil {
+				v.rtspPushSession.Dispose()
+			}
 		}
 		group.url2PushProxy = nil
 	}
@@ -142,20 +239,16 @@ func (group *Group) AddRTMPPubSession(session *rtmp.ServerSession) bool {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
-	if group.pubSession != nil {
-		nazalog.Errorf("[%s] PubSession already exist in group. old=%s, new=%s", group.UniqueKey, group.pubSession.UniqueKey, session.UniqueKey)
-		return false
+	if group.hasPubSession() {
+		if !group.appConfig.PubPreempt {
+			nazalog.Errorf("[%s] PubSession already exist in group. old=%s, new=%s", group.UniqueKey, group.pubSessionUniqueKey(), session.UniqueKey)
+			return false
+		}
+		nazalog.Warnf("[%s] PubSession already exist in group, kick it. old=%s, new=%s", group.UniqueKey, group.pubSessionUniqueKey(), session.UniqueKey)
+		group.kickPubSession()
 	}
 	group.pubSession = session
-
-	if config.HLSConfig.Enable {
-		group.hlsMuxer = hls.NewMuxer(group.streamName, &config.HLSConfig.MuxerConfig)
-		group.hlsMuxer.Start()
-	}
-
-	if config.RelayPushConfig.Enable {
-		group.pushIfNeeded()
-	}
+	group.addIn()
 
 	session.SetPubSessionObserver(group)
 
@@ -168,36 +261,73 @@ func (group *Group) DelRTMPPubSession(session *rtmp.ServerSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
-	group.pubSession = nil
+	delete(group.sessionLimitMap, session.UniqueKey)
+	group.inBytesOfDone += session.GetStat().ReadBytesSum
 
-	if config.HLSConfig.Enable && group.hlsMuxer != nil {
-		group.hlsMuxer.Dispose()
-		group.hlsMuxer = nil
+	if session != group.pubSession {
+		nazalog.Warnf("[%s] del PubSession but not match. curr=%s, del=%s", group.UniqueKey, group.pubSessionUniqueKey(), session.UniqueKey)
+		return
 	}
 
-	if config.RelayPushConfig.Enable {
-		for _, v := range group.url2PushProxy {
-			if v.pushSession != nil {
-				v.pushSession.Dispose()
-			}
-			v.pushSession = nil
+	group.pubSession = nil
+	group.delIn()
+}
+
+func (group *Group) AddRTSPPubSession(session *rtsp.PubSession) bool {
+	nazalog.Debugf("[%s] [%s] add rtsp PubSession into group.", group.UniqueKey, session.UniqueKey)
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.hasPubSession() {
+		if !group.appConfig.PubPreempt {
+			nazalog.Errorf("[%s] PubSession already exist in group. old=%s, new=%s", group.UniqueKey, group.pubSessionUniqueKey(), session.UniqueKey)
+			return false
 		}
+		nazalog.Warnf("[%s] PubSession already exist in group, kick it. old=%s, new=%s", group.UniqueKey, group.pubSessionUniqueKey(), session.UniqueKey)
+		group.kickPubSession()
 	}
+	group.rtspPubSession = session
+	group.addIn()
 
-	group.gopCache.Clear()
-	group.httpflvGopCache.Clear()
+	group.rtsp2RTMPRemuxer = NewRTSP2RTMPRemuxer(group.UniqueKey, group.onRemuxedRTMPAVMsg)
+	group.rtsp2RTMPRemuxer.InitWithAVConfig(session.GetAVConfig())
+
+	session.SetOnAVPacket(group.OnReadRTSPAVPacket)
+
+	return true
+}
+
+func (group *Group) DelRTSPPubSession(session *rtsp.PubSession) {
+	nazalog.Debugf("[%s] [%s] del rtsp PubSession from group.", group.UniqueKey, session.UniqueKey)
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	delete(group.sessionLimitMap, session.UniqueKey)
+	group.inBytesOfDone += session.GetStat().ReadBytesSum
+
+	if session != group.rtspPubSession {
+		nazalog.Warnf("[%s] del rtsp PubSession but not match. curr=%s, del=%s", group.UniqueKey, group.pubSessionUniqueKey(), session.UniqueKey)
+		return
+	}
+
+	group.rtspPubSession = nil
+	group.rtsp2RTMPRemuxer = nil
+	group.delIn()
 }
 
 func (group *Group) AddRTMPPullSession(session *rtmp.PullSession) {
 	nazalog.Debugf("[%s] [%s] add PullSession into group.", group.UniqueKey, session.UniqueKey())
 
 	group.mutex.Lock()
-	group.mutex.Unlock()
+	defer group.mutex.Unlock()
 
 	group.pullSession = session
+	group.lastSubTime = time.Now()
 
-	if config.HLSConfig.Enable {
-		group.hlsMuxer = hls.NewMuxer(group.streamName, &config.HLSConfig.MuxerConfig)
+	if group.appConfig.HLSEnable {
+		group.hlsMuxer = hls.NewMuxer(group.appName, group.streamName, &config.HLSConfig.MuxerConfig, group)
 		group.hlsMuxer.Start()
 	}
 }
@@ -206,18 +336,71 @@ func (group *Group) DelRTMPPullSession(session *rtmp.PullSession) {
 	nazalog.Debugf("[%s] [%s] del PullSession from group.", group.UniqueKey, session.UniqueKey())
 
 	group.mutex.Lock()
-	group.mutex.Unlock()
+	defer group.mutex.Unlock()
 
+	group.inBytesOfDone += session.GetStat().ReadBytesSum
+	// 旧的拉流的回调，此时可能已经有新的拉流正在进行，不能重置isPulling
+	if session != group.pullSession {
+		return
+	}
 	group.pullSession = nil
 	group.isPulling = false
 
-	if config.HLSConfig.Enable && group.hlsMuxer != nil {
+	if group.appConfig.HLSEnable && group.hlsMuxer != nil {
 		group.hlsMuxer.Dispose()
 		group.hlsMuxer = nil
 	}
 
 	group.gopCache.Clear()
 	group.httpflvGopCache.Clear()
+	group.videoSeqHeader = nil
+	group.aacSeqHeader = nil
+}
+
+func (group *Group) AddRTSPPullSession(session *rtsp.PullSession) {
+	nazalog.Debugf("[%s] [%s] add rtsp PullSession into group.", group.UniqueKey, session.UniqueKey)
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	group.rtspPullSession = session
+	group.lastSubTime = time.Now()
+
+	if group.appConfig.HLSEnable {
+		group.hlsMuxer = hls.NewMuxer(group.appName, group.streamName, &config.HLSConfig.MuxerConfig, group)
+		group.hlsMuxer.Start()
+	}
+
+	group.rtsp2RTMPRemuxer = NewRTSP2RTMPRemuxer(group.UniqueKey, group.onRemuxedRTMPAVMsg)
+	group.rtsp2RTMPRemuxer.InitWithAVConfig(session.GetAVConfig())
+
+	session.SetOnAVPacket(group.OnReadRTSPAVPacket)
+}
+
+func (group *Group) DelRTSPPullSession(session *rtsp.PullSession) {
+	nazalog.Debugf("[%s] [%s] del rtsp PullSession from group.", group.UniqueKey, session.UniqueKey)
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	group.inBytesOfDone += session.GetStat().ReadBytesSum
+	// 旧的拉流的回调，此时可能已经有新的拉流正在进行，不能重置isPulling
+	if session != group.rtspPullSession {
+		return
+	}
+	group.rtspPullSession = nil
+	group.isPulling = false
+	group.rtsp2RTMPRemuxer = nil
+
+	if group.appConfig.HLSEnable && group.hlsMuxer != nil {
+		group.hlsMuxer.Dispose()
+		group.hlsMuxer = nil
+	}
+
+	group.gopCache.Clear()
+	group.httpflvGopCache.Clear()
+	group.videoSeqHeader = nil
+	group.aacSeqHeader = nil
 }
 
 func (group *Group) AddRTMPSubSession(session *rtmp.ServerSession) {
@@ -234,6 +417,8 @@ func (group *Group) DelRTMPSubSession(session *rtmp.ServerSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 	delete(group.rtmpSubSessionSet, session)
+	delete(group.sessionLimitMap, session.UniqueKey)
+	group.outBytesOfDone += session.GetStat().WroteBytesSum
 }
 
 func (group *Group) AddHTTPFLVSubSession(session *httpflv.SubSession) {
@@ -253,24 +438,95 @@ func (group *Group) DelHTTPFLVSubSession(session *httpflv.SubSession) {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 	delete(group.httpflvSubSessionSet, session)
+	delete(group.sessionLimitMap, session.UniqueKey)
+	group.outBytesOfDone += session.GetStat().WroteBytesSum
+}
+
+// 返回false表示没有音视频头信息，无法生成sdp
+func (group *Group) HandleNewRTSPSubSessionDescribe(session *rtsp.SubSession) bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	vps, sps, pps, asc := group.getRTSPAVConfig()
+	if sps == nil && asc == nil {
+		nazalog.Warnf("[%s] [%s] no av config for rtsp describe.", group.UniqueKey, session.UniqueKey)
+		return false
+	}
+
+	if err := session.InitWithAVConfig(vps, sps, pps, asc); err != nil {
+		nazalog.Errorf("[%s] [%s] init rtsp SubSession failed. err=%+v", group.UniqueKey, session.UniqueKey, err)
+		return false
+	}
+	return true
+}
+
+func (group *Group) AddRTSPSubSession(session *rtsp.SubSession) {
+	nazalog.Debugf("[%s] [%s] add rtsp SubSession into group.", group.UniqueKey, session.UniqueKey)
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.rtspSubSessionSet[session] = struct{}{}
+}
+
+// @return 是否已经加入group，也即是否已经PLAY
+func (group *Group) DelRTSPSubSession(session *rtsp.SubSession) bool {
+	nazalog.Debugf("[%s] [%s] del rtsp SubSession from group.", group.UniqueKey, session.UniqueKey)
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	// DESCRIBE时设置的限制，没有PLAY时也需要删除
+	delete(group.sessionLimitMap, session.UniqueKey)
+	group.outBytesOfDone += session.GetStat().WroteBytesSum
+	if _, ok := group.rtspSubSessionSet[session]; !ok {
+		return false
+	}
+	delete(group.rtspSubSessionSet, session)
+	return true
 }
 
 func (group *Group) AddRTMPPushSession(url string, session *rtmp.PushSession) {
 	nazalog.Debugf("[%s] [%s] add rtmp PushSession into group.", group.UniqueKey, session.UniqueKey())
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
-	if group.url2PushProxy != nil {
-		group.url2PushProxy[url].pushSession = session
+	v, ok := group.url2PushProxy[url]
+	if !ok {
+		// 建立连接的过程中，转推地址已经通过StopRelayPush删除了
+		session.Dispose()
+		return
 	}
+	v.pushSession = session
 }
 
 func (group *Group) DelRTMPPushSession(url string, session *rtmp.PushSession) {
 	nazalog.Debugf("[%s] [%s] del rtmp PushSession into group.", group.UniqueKey, session.UniqueKey())
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
-	if group.url2PushProxy != nil {
-		group.url2PushProxy[url].pushSession = nil
-		group.url2PushProxy[url].isPushing = false
+	group.outBytesOfDone += session.GetStat().WroteBytesSum
+	// 转推地址可能已经通过StopRelayPush删除了
+	if v, ok := group.url2PushProxy[url]; ok {
+		v.pushSession = nil
+		v.isPushing = false
+	}
+}
+
+func (group *Group) AddRTSPPushSession(url string, session *rtsp.PushSession) {
+	nazalog.Debugf("[%s] [%s] add rtsp PushSession into group.", group.UniqueKey, session.UniqueKey)
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	v, ok := group.url2PushProxy[url]
+	if !ok {
+		session.Dispose()
+		return
+	}
+	v.rtspPushSession = session
+}
+
+func (group *Group) DelRTSPPushSession(url string, session *rtsp.PushSession) {
+	nazalog.Debugf("[%s] [%s] del rtsp PushSession from group.", group.UniqueKey, session.UniqueKey)
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.outBytesOfDone += session.GetStat().WroteBytesSum
+	if v, ok := group.url2PushProxy[url]; ok {
+		v.rtspPushSession = nil
+		v.isPushing = false
 	}
 }
 
@@ -278,19 +534,23 @@ func (group *Group) IsTotalEmpty() bool {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
 
+	// 通过HTTP API添加的转推地址和staticPullURL一样，在删除之前一直保留group，等待pub发布者
 	hasPushSession := false
 	for _, item := range group.url2PushProxy {
-		if item.isPushing || item.pushSession != nil {
+		if item.byAPI || item.isPushing || item.pushSession != nil || item.rtspPushSession != nil {
 			hasPushSession = true
 			break
 		}
 	}
 
-	return group.pubSession == nil && len(group.rtmpSubSessionSet) == 0 &&
+	return !group.hasPubSession() && len(group.rtmpSubSessionSet) == 0 &&
 		len(group.httpflvSubSessionSet) == 0 &&
+		len(group.rtspSubSessionSet) == 0 &&
 		group.hlsMuxer == nil &&
 		!hasPushSession &&
-		group.pullSession == nil
+		group.pullSession == nil &&
+		group.rtspPullSession == nil &&
+		group.staticPullURL == ""
 }
 
 // PubSession or PullSession
@@ -299,37 +559,429 @@ func (group *Group) OnReadRTMPAVMsg(msg rtmp.AVMsg) {
 	defer group.mutex.Unlock()
 
 	//nazalog.Debugf("%+v, %02x, %02x", msg.Header, msg.Payload[0], msg.Payload[1])
-	group.broadcastRTMP(msg)
+	group.onRemuxedRTMPAVMsg(msg)
+}
 
-	if config.HLSConfig.Enable && group.hlsMuxer != nil {
-		group.hlsMuxer.FeedRTMPMessage(msg)
+// rtsp.PubSession or rtsp.PullSession
+func (group *Group) OnReadRTSPAVPacket(pkt rtsp.AVPacket) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	// PubSession或PullSession可能已经从group中删除了
+	if group.rtsp2RTMPRemuxer == nil {
+		return
 	}
+	group.rtsp2RTMPRemuxer.FeedAVPacket(pkt)
 }
 
 func (group *Group) StringifyStats() string {
 	group.mutex.Lock()
 	defer group.mutex.Unlock()
-	var pub string
-	if group.pubSession == nil {
-		pub = "none"
-	} else {
-		pub = group.pubSession.UniqueKey
-	}
-	var pull string
-	if group.pullSession == nil {
-		pull = "none"
-	} else {
+	pub := group.pubSessionUniqueKey()
+	pull := "none"
+	if group.pullSession != nil {
 		pull = group.pullSession.UniqueKey()
+	} else if group.rtspPullSession != nil {
+		pull = group.rtspPullSession.UniqueKey
 	}
 	var pushSize int
 	for _, v := range group.url2PushProxy {
-		if v.pushSession != nil {
+		if v.pushSession != nil || v.rtspPushSession != nil {
 			pushSize++
 		}
 	}
 
-	return fmt.Sprintf("[%s] stream name=%s, rtmp pub=%s, relay rtmp pull=%s, rtmp sub size=%d, httpflv sub size=%d, relay rtmp push size=%d",
-		group.UniqueKey, group.streamName, pub, pull, len(group.rtmpSubSessionSet), len(group.httpflvSubSessionSet), pushSize)
+	stat := group.getStat()
+
+	return fmt.Sprintf("[%s] stream name=%s, pub=%s, relay pull=%s, rtmp sub size=%d, httpflv sub size=%d, rtsp sub size=%d, relay push size=%d, "+
+		"read=%dkbit/s(%d bytes), wrote=%dkbit/s(%d bytes), audio count=%d, video count=%d, last timestamp=%d",
+		group.UniqueKey, group.streamName, pub, pull, len(group.rtmpSubSessionSet), len(group.httpflvSubSessionSet), len(group.rtspSubSessionSet), pushSize,
+		stat.ReadBitrate, stat.ReadBytesSum, stat.WroteBitrate, stat.WroteBytesSum, stat.AudioCount, stat.VideoCount, stat.LastTimestamp)
+}
+
+func (group *Group) GetStat() StatGroup {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	return group.getStat()
+}
+
+func (group *Group) getMetrics() groupMetrics {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	stat := group.getStat()
+	m := groupMetrics{
+		appName:       group.appName,
+		streamName:    group.streamName,
+		subCount:      make(map[string]int),
+		inBytes:       stat.ReadBytesSum,
+		outBytes:      stat.WroteBytesSum,
+		gopCacheBytes: group.gopCache.GetSize() + group.httpflvGopCache.GetSize(),
+	}
+	if stat.Pub != nil {
+		m.pubProtocol = stat.Pub.Protocol
+	}
+	for _, sub := range stat.Subs {
+		m.subCount[sub.Protocol]++
+	}
+	return m
+}
+
+// 注意，调用方需持有group的锁
+func (group *Group) getStat() StatGroup {
+	stat := StatGroup{
+		AppName:       group.appName,
+		StreamName:    group.streamName,
+		ReadBytesSum:  group.inBytesOfDone,
+		WroteBytesSum: group.outBytesOfDone,
+		Subs:          make([]base.StatSession, 0),
+		Pushs:         make([]StatPush, 0),
+	}
+
+	if group.pubSession != nil {
+		s := group.pubSession.GetStat()
+		stat.Pub = &s
+	} else if group.rtspPubSession != nil {
+		s := group.rtspPubSession.GetStat()
+		stat.Pub = &s
+	}
+
+	if group.pullSession != nil {
+		s := group.pullSession.GetStat()
+		stat.Pull = &s
+	} else if group.rtspPullSession != nil {
+		s := group.rtspPullSession.GetStat()
+		stat.Pull = &s
+	}
+
+	for session := range group.rtmpSubSessionSet {
+		stat.Subs = append(stat.Subs, session.GetStat())
+	}
+	for session := range group.httpflvSubSessionSet {
+		stat.Subs = append(stat.Subs, session.GetStat())
+	}
+	for session := range group.rtspSubSessionSet {
+		stat.Subs = append(stat.Subs, session.GetStat())
+	}
+
+	for url, v := range group.url2PushProxy {
+		item := StatPush{URL: url}
+		if v.pushSession != nil {
+			s := v.pushSession.GetStat()
+			item.Session = &s
+		} else if v.rtspPushSession != nil {
+			s := v.rtspPushSession.GetStat()
+			item.Session = &s
+		}
+		stat.Pushs = append(stat.Pushs, item)
+	}
+
+	var in []*base.StatSession
+	if stat.Pub != nil {
+		in = append(in, stat.Pub)
+	}
+	if stat.Pull != nil {
+		in = append(in, stat.Pull)
+	}
+	for _, s := range in {
+		stat.ReadBytesSum += s.ReadBytesSum
+		stat.ReadBitrate += s.ReadBitrate
+		stat.AudioCount = s.AudioCount
+		stat.VideoCount = s.VideoCount
+		stat.LastTimestamp = s.LastTimestamp
+	}
+	for _, s := range stat.Subs {
+		stat.WroteBytesSum += s.WroteBytesSum
+		stat.WroteBitrate += s.WroteBitrate
+	}
+	for _, item := range stat.Pushs {
+		if item.Session != nil {
+			stat.WroteBytesSum += item.Session.WroteBytesSum
+			stat.WroteBitrate += item.Session.WroteBitrate
+		}
+	}
+
+	return stat
+}
+
+// 踢掉group中id为sessionID的session，可以是pub，sub，pull或push
+// session关闭后，由各自的Del回调从group中删除
+//
+// @return 没有找到对应的session时返回false
+func (group *Group) KickSession(sessionID string) bool {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	nazalog.Infof("[%s] kick session. session id=%s", group.UniqueKey, sessionID)
+
+	if group.pubSession != nil && group.pubSession.UniqueKey == sessionID {
+		group.pubSession.Dispose()
+		return true
+	}
+	if group.rtspPubSession != nil && group.rtspPubSession.UniqueKey == sessionID {
+		group.rtspPubSession.Dispose()
+		return true
+	}
+	if group.pullSession != nil && group.pullSession.UniqueKey() == sessionID {
+		group.pullSession.Dispose()
+		return true
+	}
+	if group.rtspPullSession != nil && group.rtspPullSession.UniqueKey == sessionID {
+		group.rtspPullSession.Dispose()
+		return true
+	}
+	for session := range group.rtmpSubSessionSet {
+		if session.UniqueKey == sessionID {
+			session.Dispose()
+			return true
+		}
+	}
+	for session := range group.httpflvSubSessionSet {
+		if session.UniqueKey == sessionID {
+			session.Dispose()
+			return true
+		}
+	}
+	for session := range group.rtspSubSessionSet {
+		if session.UniqueKey == sessionID {
+			session.Dispose()
+			return true
+		}
+	}
+	for _, v := range group.url2PushProxy {
+		if v.pushSession != nil && v.pushSession.UniqueKey() == sessionID {
+			v.pushSession.Dispose()
+			return true
+		}
+		if v.rtspPushSession != nil && v.rtspPushSession.UniqueKey == sessionID {
+			v.rtspPushSession.Dispose()
+			return true
+		}
+	}
+	return false
+}
+
+// 开始回源拉流，不依赖sub订阅者，效果和static_pull配置相同
+//
+// @param url 为空时，使用relay_pull的配置拼接回源地址
+func (group *Group) StartRelayPull(url string) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if group.hasPubSession() {
+		return ErrGroupHasPubSession
+	}
+	if url == "" {
+		if group.appConfig.RelayPullAddr == "" {
+			return ErrRelayPullAddrMissing
+		}
+		url = group.relayPullURL()
+	}
+
+	nazalog.Infof("[%s] start relay pull by api. url=%s", group.UniqueKey, url)
+	// 注意，如果已经在拉流，新的地址在下次重新拉流时生效
+	group.staticPullURL = url
+	group.pullIfNeeded()
+	return nil
+}
+
+// 停止回源拉流，并且之后不再根据relay_pull配置触发回源
+func (group *Group) StopRelayPull() {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	nazalog.Infof("[%s] stop relay pull by api.", group.UniqueKey)
+	group.staticPullURL = ""
+	group.appConfig.RelayPullEnable = false
+	if group.pullSession != nil {
+		group.pullSession.Dispose()
+	}
+	if group.rtspPullSession != nil {
+		group.rtspPullSession.Dispose()
+	}
+}
+
+// 增加一个转推地址，有pub发布者时开始转推
+func (group *Group) StartRelayPush(url string) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	if _, ok := group.url2PushProxy[url]; ok {
+		return ErrRelayPushAlreadyExist
+	}
+
+	nazalog.Infof("[%s] start relay push by api. url=%s", group.UniqueKey, url)
+	group.url2PushProxy[url] = &pushProxy{byAPI: true}
+	group.appConfig.RelayPushEnable = true
+	group.pushIfNeeded()
+	return nil
+}
+
+// 删除一个转推地址，并关闭对应的转推
+func (group *Group) StopRelayPush(url string) error {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+
+	v, ok := group.url2PushProxy[url]
+	if !ok {
+		return ErrRelayPushNotExist
+	}
+
+	nazalog.Infof("[%s] stop relay push by api. url=%s", group.UniqueKey, url)
+	if v.pushSession != nil {
+		v.pushSession.Dispose()
+	}
+	if v.rtspPushSession != nil {
+		v.rtspPushSession.Dispose()
+	}
+	delete(group.url2PushProxy, url)
+	return nil
+}
+
+// 设置准入回调返回的session的限制，在Tick中检查，超过限制时关闭session
+func (group *Group) SetSessionLimit(sessionID string, limit SessionLimit) {
+	if limit.MaxDurationSec == 0 && limit.MaxKBitrate == 0 {
+		return
+	}
+
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.sessionLimitMap[sessionID] = &sessionLimitItem{
+		limit:     limit,
+		startTime: time.Now(),
+	}
+}
+
+// 收到hls的请求时调用，hls的观看者没有session，以最近一次请求的时间判断是否还有观看者
+func (group *Group) UpdateLastSubTime() {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.lastSubTime = time.Now()
+}
+
+// hls.MuxerObserver
+// 注意，在持有group的锁时回调
+func (group *Group) OnFragmentClose(info hls.FragmentEventInfo) {
+	hlsFragmentsCounter.Incr()
+	group.notify.OnHLSMakeTS(HLSMakeTSInfo{
+		AppName:      group.appName,
+		StreamName:   group.streamName,
+		ID:           info.ID,
+		TSFile:       info.TSFile,
+		LiveM3U8File: info.LiveM3U8File,
+		Duration:     info.Duration,
+	})
+}
+
+// 输入流（rtmp pub，rtsp pub，relay pull）的数据，统一转换为rtmp.AVMsg后，从这里进入group
+// 注意，调用方需持有group的锁
+func (group *Group) onRemuxedRTMPAVMsg(msg rtmp.AVMsg) {
+	group.broadcastRTMP(msg)
+	group.broadcastRTSP(msg)
+
+	if group.appConfig.HLSEnable && group.hlsMuxer != nil {
+		group.hlsMuxer.FeedRTMPMessage(msg)
+	}
+}
+
+// 有输入流（rtmp pub或rtsp pub）加入
+func (group *Group) addIn() {
+	// 输入流重新推流时，已经存在的rtmp sub session也能收到流开始的通知
+	for session := range group.rtmpSubSessionSet {
+		_ = session.WriteStreamBegin()
+	}
+
+	if group.appConfig.HLSEnable {
+		group.hlsMuxer = hls.NewMuxer(group.appName, group.streamName, &config.HLSConfig.MuxerConfig, group)
+		group.hlsMuxer.Start()
+	}
+
+	if group.appConfig.RelayPushEnable {
+		group.pushIfNeeded()
+	}
+}
+
+// 输入流（rtmp pub或rtsp pub）离开
+func (group *Group) delIn() {
+	for session := range group.rtmpSubSessionSet {
+		_ = session.WriteStreamEOF()
+	}
+
+	if group.appConfig.HLSEnable && group.hlsMuxer != nil {
+		group.hlsMuxer.Dispose()
+		group.hlsMuxer = nil
+	}
+
+	if group.appConfig.RelayPushEnable {
+		for _, v := range group.url2PushProxy {
+			if v.pushSession != nil {
+				v.pushSession.Dispose()
+			}
+			v.pushSession = nil
+			if v.rtspPushSession != nil {
+				v.rtspPushSession.Dispose()
+			}
+			v.rtspPushSession = nil
+		}
+	}
+
+	group.gopCache.Clear()
+	group.httpflvGopCache.Clear()
+	group.videoSeqHeader = nil
+	group.aacSeqHeader = nil
+}
+
+// 从缓存的rtmp格式的音视频头中，获取生成rtsp sdp所需的信息
+// 视频为AVC时，vps为nil
+// 注意，调用方需持有group的锁
+func (group *Group) getRTSPAVConfig() (vps, sps, pps, asc []byte) {
+	if group.videoSeqHeader != nil {
+		// 注意，Enhanced RTMP的AV1和VP9等，rtsp暂时不支持，只转音频
+		vh, err := rtmp.ParseVideoHeader(group.videoSeqHeader)
+		if err == nil {
+			if vh.IsHEVC() {
+				vps, sps, pps, err = hevc.ParseVPSSPSPPSFromSeqHeader(group.videoSeqHeader)
+			} else if vh.IsAVC() {
+				sps, pps, err = avc.ParseSPSPPSFromSeqHeader(group.videoSeqHeader)
+			}
+		}
+		if err != nil {
+			nazalog.Errorf("[%s] parse video seq header failed. err=%+v", group.UniqueKey, err)
+		}
+	}
+	if group.aacSeqHeader != nil {
+		asc = group.aacSeqHeader[2:]
+	}
+	return
+}
+
+// 踢掉当前的输入流（rtmp pub或rtsp pub）
+// 被踢掉的session之后触发的Del回调，会因为和group中的session不匹配而被忽略
+func (group *Group) kickPubSession() {
+	if group.pubSession != nil {
+		group.pubSession.Dispose()
+		group.pubSession = nil
+	}
+	if group.rtspPubSession != nil {
+		group.rtspPubSession.Dispose()
+		group.rtspPubSession = nil
+		group.rtsp2RTMPRemuxer = nil
+	}
+	group.delIn()
+}
+
+func (group *Group) hasPubSession() bool {
+	return group.pubSession != nil || group.rtspPubSession != nil
+}
+
+func (group *Group) pubSessionUniqueKey() string {
+	if group.pubSession != nil {
+		return group.pubSession.UniqueKey
+	}
+	if group.rtspPubSession != nil {
+		return group.rtspPubSession.UniqueKey
+	}
+	return "none"
 }
 
 func (group *Group) broadcastRTMP(msg rtmp.AVMsg) {
@@ -375,7 +1027,7 @@ func (group *Group) broadcastRTMP(msg rtmp.AVMsg) {
 	}
 
 	// TODO chef: rtmp sub, rtmp push, httpflv sub 的发送逻辑都差不多，可以考虑封装一下
-	if config.RelayPushConfig.Enable {
+	if group.appConfig.RelayPushEnable {
 		for _, v := range group.url2PushProxy {
 			if v.pushSession == nil {
 				continue
@@ -408,24 +1060,24 @@ func (group *Group) broadcastRTMP(msg rtmp.AVMsg) {
 	for session := range group.httpflvSubSessionSet {
 		if session.IsFresh {
 			if group.httpflvGopCache.Metadata != nil {
-				session.WriteRawPacket(group.httpflvGopCache.Metadata)
+				session.WriteRawTag(group.httpflvGopCache.Metadata)
 			}
 			if group.httpflvGopCache.VideoSeqHeader != nil {
-				session.WriteRawPacket(group.httpflvGopCache.VideoSeqHeader)
+				session.WriteRawTag(group.httpflvGopCache.VideoSeqHeader)
 			}
 			if group.httpflvGopCache.AACSeqHeader != nil {
-				session.WriteRawPacket(group.httpflvGopCache.AACSeqHeader)
+				session.WriteRawTag(group.httpflvGopCache.AACSeqHeader)
 			}
 			for i := 0; i < group.httpflvGopCache.GetGOPCount(); i++ {
 				for _, item := range group.httpflvGopCache.GetGOPDataAt(i) {
-					session.WriteRawPacket(item)
+					session.WriteRawTag(item)
 				}
 			}
 
 			session.IsFresh = false
 		}
 
-		session.WriteRawPacket(lrm2ft.Get())
+		session.WriteRawTag(lrm2ft.Get())
 	}
 
 	// # 5. 缓存关键信息，以及gop
@@ -438,50 +1090,234 @@ func (group *Group) broadcastRTMP(msg rtmp.AVMsg) {
 	}
 }
 
-func (group *Group) pullIfNeeded() {
-	// pull回源功能没开
-	if !config.RelayPullConfig.Enable {
+func (group *Group) broadcastRTSP(msg rtmp.AVMsg) {
+	// 缓存音视频头，用于后续rtsp sub生成sdp
+	// 注意，msg.Payload的内存块在回调结束后可能被复用，所以需要拷贝
+	if msg.IsVideoKeySeqHeader() {
+		group.videoSeqHeader = append([]byte(nil), msg.Payload...)
+		return
+	}
+	if msg.IsAACSeqHeader() {
+		group.aacSeqHeader = append([]byte(nil), msg.Payload...)
 		return
 	}
-	// 没有sub订阅者
-	if len(group.rtmpSubSessionSet) == 0 && len(group.httpflvSubSessionSet) == 0 {
+
+	var rtspPushSessions []*rtsp.PushSession
+	if group.appConfig.RelayPushEnable {
+		for _, v := range group.url2PushProxy {
+			if v.rtspPushSession != nil {
+				rtspPushSessions = append(rtspPushSessions, v.rtspPushSession)
+			}
+		}
+	}
+
+	if len(group.rtspSubSessionSet) == 0 && len(rtspPushSessions) == 0 {
 		return
 	}
-	// 已有pull推流或pull回源
-	if group.pubSession != nil || group.pullSession != nil {
+	pkt, ok := RTMPAVMsg2RTSPAVPacket(msg)
+	if !ok {
+		return
+	}
+	for session := range group.rtspSubSessionSet {
+		session.WriteAVPacket(pkt)
+	}
+	for _, session := range rtspPushSessions {
+		session.WriteAVPacket(pkt)
+	}
+}
+
+func (group *Group) pullIfNeeded() {
+	// 已有pub推流或pull回源
+	if group.hasPubSession() || group.pullSession != nil || group.rtspPullSession != nil {
 		return
 	}
 	// 正在回源中
 	if group.isPulling {
 		return
 	}
+
+	var url string
+	var rtspOverTCP bool
+	var rtmpsInsecureSkipVerify bool
+	if group.staticPullURL != "" {
+		url = group.staticPullURL
+		rtspOverTCP = config.StaticPullConfig.RTSPOverTCP
+		rtmpsInsecureSkipVerify = config.StaticPullConfig.RTMPSInsecureSkipVerify
+	} else {
+		// pull回源功能没开
+		if !group.appConfig.RelayPullEnable {
+			return
+		}
+		// 没有sub订阅者
+		if len(group.rtmpSubSessionSet) == 0 && len(group.httpflvSubSessionSet) == 0 && len(group.rtspSubSessionSet) == 0 {
+			return
+		}
+		url = group.relayPullURL()
+		rtspOverTCP = config.RelayPullConfig.RTSPOverTCP
+		rtmpsInsecureSkipVerify = config.RelayPullConfig.RTMPSInsecureSkipVerify
+	}
 	group.isPulling = true
+	relayPullAttemptsCounter.Incr()
 
-	url := fmt.Sprintf("rtmp://%s/%s/%s", config.RelayPullConfig.Addr, group.appName, group.streamName)
 	nazalog.Infof("start relay pull. [%s] url=%s", group.UniqueKey, url)
 
+	if strings.HasPrefix(url, "rtsp://") {
+		go group.pullRTSP(url, rtspOverTCP)
+		return
+	}
+
 	go func() {
-		pullSesion := rtmp.NewPullSession()
+		pullSesion := rtmp.NewPullSession(func(option *rtmp.PullSessionOption) {
+			option.TLSInsecureSkipVerify = rtmpsInsecureSkipVerify
+		})
 		err := pullSesion.Pull(url, group.OnReadRTMPAVMsg)
 		if err != nil {
 			nazalog.Errorf("[%s] relay pull fail. err=%v", pullSesion.UniqueKey(), err)
-			group.DelRTMPPullSession(pullSesion)
+			relayPullFailuresCounter.Incr()
+			group.onRelayPullFail(pullSesion.GetStat().ReadBytesSum)
 			return
 		}
 		group.AddRTMPPullSession(pullSesion)
+		info := newRelayEventInfo(pullSesion.GetStat(), group.appName, group.streamName, url)
+		group.notify.OnRelayPullStart(info)
 		err = <-pullSesion.Done()
 		nazalog.Infof("[%s] relay pull done. err=%v", pullSesion.UniqueKey(), err)
 		group.DelRTMPPullSession(pullSesion)
+		group.notify.OnRelayPullStop(info)
 	}()
 }
 
+func (group *Group) checkSessionLimit(now time.Time) {
+	if len(group.sessionLimitMap) == 0 {
+		return
+	}
+
+	if group.pubSession != nil {
+		if item, ok := group.sessionLimitMap[group.pubSession.UniqueKey]; ok {
+			if group.isExceedSessionLimit(group.pubSession.UniqueKey, item, now, group.pubSession.GetStat().ReadBitrate) {
+				group.pubSession.Dispose()
+			}
+		}
+	}
+	if group.rtspPubSession != nil {
+		if item, ok := group.sessionLimitMap[group.rtspPubSession.UniqueKey]; ok {
+			if group.isExceedSessionLimit(group.rtspPubSession.UniqueKey, item, now, group.rtspPubSession.GetStat().ReadBitrate) {
+				group.rtspPubSession.Dispose()
+			}
+		}
+	}
+	for session := range group.rtmpSubSessionSet {
+		if item, ok := group.sessionLimitMap[session.UniqueKey]; ok {
+			if group.isExceedSessionLimit(session.UniqueKey, item, now, session.GetStat().WroteBitrate) {
+				session.Dispose()
+			}
+		}
+	}
+	for session := range group.httpflvSubSessionSet {
+		if item, ok := group.sessionLimitMap[session.UniqueKey]; ok {
+			if group.isExceedSessionLimit(session.UniqueKey, item, now, session.GetStat().WroteBitrate) {
+				session.Dispose()
+			}
+		}
+	}
+	for session := range group.rtspSubSessionSet {
+		if item, ok := group.sessionLimitMap[session.UniqueKey]; ok {
+			if group.isExceedSessionLimit(session.UniqueKey, item, now, session.GetStat().WroteBitrate) {
+				session.Dispose()
+			}
+		}
+	}
+}
+
+// 超过限制时，同时删除这个session的限制，避免在session从group中删除前重复关闭
+//
+// @param kbitrate session当前的码率
+func (group *Group) isExceedSessionLimit(sessionID string, item *sessionLimitItem, now time.Time, kbitrate int) bool {
+	elapsed := now.Sub(item.startTime)
+	if item.limit.MaxDurationSec != 0 && elapsed >= time.Duration(item.limit.MaxDurationSec)*time.Second {
+		nazalog.Warnf("[%s] [%s] exceed max duration, close it. max=%ds", group.UniqueKey, sessionID, item.limit.MaxDurationSec)
+		delete(group.sessionLimitMap, sessionID)
+		return true
+	}
+	if item.limit.MaxKBitrate != 0 && elapsed >= time.Duration(sessionLimitBitrateGraceMS)*time.Millisecond && kbitrate > item.limit.MaxKBitrate {
+		nazalog.Warnf("[%s] [%s] exceed max bitrate, close it. max=%dkbit/s, curr=%dkbit/s", group.UniqueKey, sessionID, item.limit.MaxKBitrate, kbitrate)
+		delete(group.sessionLimitMap, sessionID)
+		return true
+	}
+	return false
+}
+
+// 回源拉流后，没有观看者超过relay_pull.idle_timeout_sec时，关闭回源拉流
+// 关闭后，由拉流协程从group中删除，之后有新的sub时再由pullIfNeeded重新回源
+func (group *Group) stopPullIfIdle(now time.Time) {
+	if config.RelayPullConfig.IdleTimeoutSec == 0 || group.staticPullURL != "" {
+		return
+	}
+	if group.pullSession == nil && group.rtspPullSession == nil {
+		return
+	}
+	if len(group.rtmpSubSessionSet) != 0 || len(group.httpflvSubSessionSet) != 0 || len(group.rtspSubSessionSet) != 0 {
+		group.lastSubTime = now
+		return
+	}
+	if now.Sub(group.lastSubTime) < time.Duration(config.RelayPullConfig.IdleTimeoutSec)*time.Second {
+		return
+	}
+
+	nazalog.Infof("[%s] no sub for a while, stop relay pull. idle timeout=%ds", group.UniqueKey, config.RelayPullConfig.IdleTimeoutSec)
+	if group.pullSession != nil {
+		group.pullSession.Dispose()
+	}
+	if group.rtspPullSession != nil {
+		group.rtspPullSession.Dispose()
+	}
+}
+
+func (group *Group) relayPullURL() string {
+	return fmt.Sprintf("%s://%s/%s/%s", config.RelayPullConfig.Protocol, group.appConfig.RelayPullAddr, group.appName, group.streamName)
+}
+
+func (group *Group) pullRTSP(url string, overTCP bool) {
+	pullSession := rtsp.NewPullSession(func(option *rtsp.PullSessionOption) {
+		option.PullTimeoutMS = relayPullTimeoutMS
+		option.ReadAVTimeoutMS = relayPullReadAVTimeoutMS
+		option.OverTCP = overTCP
+	})
+	err := pullSession.Pull(url)
+	if err != nil {
+		nazalog.Errorf("[%s] relay pull fail. err=%v", pullSession.UniqueKey, err)
+		relayPullFailuresCounter.Incr()
+		pullSession.Dispose()
+		group.onRelayPullFail(pullSession.GetStat().ReadBytesSum)
+		return
+	}
+	group.AddRTSPPullSession(pullSession)
+	info := newRelayEventInfo(pullSession.GetStat(), group.appName, group.streamName, url)
+	group.notify.OnRelayPullStart(info)
+	err = <-pullSession.Done()
+	nazalog.Infof("[%s] relay pull done. err=%v", pullSession.UniqueKey, err)
+	pullSession.Dispose()
+	group.DelRTSPPullSession(pullSession)
+	group.notify.OnRelayPullStop(info)
+}
+
+// 回源拉流失败，此时session并没有加入group
+//
+// 同一时间只有一个正在进行的拉流，所以可以直接重置isPulling，之后由pullIfNeeded重新发起拉流
+func (group *Group) onRelayPullFail(readBytes uint64) {
+	group.mutex.Lock()
+	defer group.mutex.Unlock()
+	group.inBytesOfDone += readBytes
+	group.isPulling = false
+}
+
 func (group *Group) pushIfNeeded() {
 	// push转推功能没开
-	if !config.RelayPushConfig.Enable {
+	if !group.appConfig.RelayPushEnable {
 		return
 	}
 	// 没有pub发布者
-	if group.pubSession == nil {
+	if !group.hasPubSession() {
 		return
 	}
 	for url, v := range group.url2PushProxy {
@@ -489,7 +1325,22 @@ func (group *Group) pushIfNeeded() {
 		if v.isPushing {
 			continue
 		}
+
+		if strings.HasPrefix(url, "rtsp://") {
+			// rtsp推流需要使用音视频头生成sdp，还没有收到时，等下次Tick再尝试
+			vps, sps, pps, asc := group.getRTSPAVConfig()
+			if sps == nil && asc == nil {
+				continue
+			}
+			v.isPushing = true
+			relayPushAttemptsCounter.Incr()
+			nazalog.Infof("[%s] start relay push. url=%s", group.UniqueKey, url)
+			go group.pushRTSP(url, vps, sps, pps, asc)
+			continue
+		}
+
 		v.isPushing = true
+		relayPushAttemptsCounter.Incr()
 
 		nazalog.Infof("[%s] start relay push. url=%s", group.UniqueKey, url)
 
@@ -498,17 +1349,52 @@ func (group *Group) pushIfNeeded() {
 				option.ConnectTimeoutMS = relayPushConnectTimeoutMS
 				option.PushTimeoutMS = relayPushTimeoutMS
 				option.WriteAVTimeoutMS = relayPushWriteAVTimeoutMS
+				option.TLSInsecureSkipVerify = config.RelayPushConfig.RTMPSInsecureSkipVerify
 			})
 			err := pushSession.Push(url)
 			if err != nil {
 				nazalog.Errorf("[%s] relay push done. err=%v", pushSession.UniqueKey(), err)
+				relayPushFailuresCounter.Incr()
 				group.DelRTMPPushSession(url, pushSession)
 				return
 			}
 			group.AddRTMPPushSession(url, pushSession)
+			info := newRelayEventInfo(pushSession.GetStat(), group.appName, group.streamName, url)
+			group.notify.OnRelayPushStart(info)
 			err = <-pushSession.Done()
 			nazalog.Infof("[%s] relay push done. err=%v", pushSession.UniqueKey(), err)
 			group.DelRTMPPushSession(url, pushSession)
+			group.notify.OnRelayPushStop(info)
 		}(url)
 	}
 }
+
+func (group *Group) pushRTSP(url string, vps, sps, pps, asc []byte) {
+	pushSession := rtsp.NewPushSession(func(option *rtsp.PushSessionOption) {
+		option.PushTimeoutMS = relayPushTimeoutMS
+		option.OverTCP = config.RelayPushConfig.RTSPOverTCP
+	})
+	if err := pushSession.InitWithAVConfig(vps, sps, pps, asc); err != nil {
+		nazalog.Errorf("[%s] init rtsp PushSession failed. err=%+v", pushSession.UniqueKey, err)
+		relayPushFailuresCounter.Incr()
+		pushSession.Dispose()
+		group.DelRTSPPushSession(url, pushSession)
+		return
+	}
+	err := pushSession.Push(url)
+	if err != nil {
+		nazalog.Errorf("[%s] relay push done. err=%v", pushSession.UniqueKey, err)
+		relayPushFailuresCounter.Incr()
+		pushSession.Dispose()
+		group.DelRTSPPushSession(url, pushSession)
+		return
+	}
+	group.AddRTSPPushSession(url, pushSession)
+	info := newRelayEventInfo(pushSession.GetStat(), group.appName, group.streamName, url)
+	group.notify.OnRelayPushStart(info)
+	err = <-pushSession.Done()
+	nazalog.Infof("[%s] relay push done. err=%v", pushSession.UniqueKey, err)
+	pushSession.Dispose()
+	group.DelRTSPPushSession(url, pushSession)
+	group.notify.OnRelayPushStop(info)
+}
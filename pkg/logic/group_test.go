@@ -0,0 +1,158 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/connection"
+)
+
+// 作为回源的源站，允许所有推拉流，但不发送数据
+type testOriginObserver struct{}
+
+func (o *testOriginObserver) OnNewRTMPPubSession(session *rtmp.ServerSession) error { return nil }
+func (o *testOriginObserver) OnDelRTMPPubSession(session *rtmp.ServerSession)       {}
+func (o *testOriginObserver) OnNewRTMPSubSession(session *rtmp.ServerSession) error { return nil }
+func (o *testOriginObserver) OnDelRTMPSubSession(session *rtmp.ServerSession)       {}
+
+func TestGroup_RelayPullIdleTimeout(t *testing.T) {
+	origin := rtmp.NewServer(&testOriginObserver{}, "127.0.0.1:14960")
+	assert.Equal(t, nil, origin.Listen())
+	go origin.RunLoop()
+	defer origin.Dispose()
+
+	oldConfig := config
+	config = &Config{}
+	config.RTMPConfig.Enable = true
+	config.RTMPConfig.Addr = "127.0.0.1:14961"
+	config.RelayPullConfig.Enable = true
+	config.RelayPullConfig.Addr = "127.0.0.1:14960"
+	config.RelayPullConfig.Protocol = RelayPullProtocolRTMP
+	config.RelayPullConfig.IdleTimeoutSec = 1
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager()
+	go sm.RunLoop()
+	defer sm.Dispose()
+	time.Sleep(100 * time.Millisecond)
+
+	pullSession := rtmp.NewPullSession(func(option *rtmp.PullSessionOption) {
+		option.PullTimeoutMS = 5000
+	})
+	err := pullSession.Pull("rtmp://127.0.0.1:14961/live/test110", func(msg rtmp.AVMsg) {})
+	assert.Equal(t, nil, err)
+
+	// 有sub时一直回源
+	waitRelayPull := func(expected bool) bool {
+		for i := 0; i < 50; i++ {
+			stat := sm.StatGroup("live", "test110")
+			if (stat != nil && stat.Pull != nil) == expected {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+	assert.Equal(t, true, waitRelayPull(true))
+	time.Sleep(2 * time.Second)
+	assert.Equal(t, true, waitRelayPull(true))
+
+	// sub退出后，超过idle_timeout_sec时关闭回源
+	pullSession.Dispose()
+	assert.Equal(t, true, waitRelayPull(false))
+}
+
+func TestGroup_KeepAliveByRelayPush(t *testing.T) {
+	oldConfig := config
+	config = &Config{}
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager()
+
+	// 通过HTTP API添加转推地址后，即使没有任何session，group也不会被删除
+	err := sm.CtrlStartRelayPush("live", "test110", "rtmp://127.0.0.1:14962/live/test110")
+	assert.Equal(t, nil, err)
+	sm.iterateGroup()
+	sm.iterateGroup()
+	assert.IsNotNil(t, sm.StatGroup("live", "test110"))
+
+	// 删除转推地址后，group被删除
+	err = sm.CtrlStopRelayPush("live", "test110", "rtmp://127.0.0.1:14962/live/test110")
+	assert.Equal(t, nil, err)
+	sm.iterateGroup()
+	assert.Equal(t, true, sm.StatGroup("live", "test110") == nil)
+}
+
+func TestServerManager_GroupKey(t *testing.T) {
+	oldConfig := config
+	config = &Config{}
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager()
+
+	// app名或流名中带有'/'时，也不会混淆
+	g1 := sm.getOrCreateGroup("a/b", "c")
+	defer g1.Dispose()
+	g2 := sm.getOrCreateGroup("a", "b/c")
+	defer g2.Dispose()
+	assert.Equal(t, true, g1 != g2)
+	assert.Equal(t, true, sm.getGroup("a/b", "c") == g1)
+	assert.Equal(t, true, sm.getGroup("a", "b/c") == g2)
+
+	// app名为空的rtsp推流被拒绝
+	c1, c2 := net.Pipe()
+	_ = c2.Close()
+	session := rtsp.NewPubSession("", "test110", base.NewStatConn(connection.New(c1)))
+	assert.Equal(t, false, sm.OnNewRTSPPubSession(session))
+	assert.Equal(t, true, sm.getGroup("", "test110") == nil)
+	session.Dispose()
+}
+
+func TestGroup_DelStalePullSession(t *testing.T) {
+	oldConfig := config
+	config = &Config{}
+	defer func() {
+		config = oldConfig
+	}()
+
+	group := NewGroup("live", "test110", nil)
+	defer group.Dispose()
+
+	// 旧的拉流结束前，新的拉流已经在进行中
+	oldSession := rtmp.NewPullSession()
+	newSession := rtmp.NewPullSession()
+	group.AddRTMPPullSession(newSession)
+	group.isPulling = true
+
+	group.DelRTMPPullSession(oldSession)
+	assert.Equal(t, true, group.isPulling)
+	assert.Equal(t, true, group.pullSession == newSession)
+
+	group.DelRTMPPullSession(newSession)
+	assert.Equal(t, false, group.isPulling)
+	assert.Equal(t, true, group.pullSession == nil)
+
+	// 拉流失败时，session没有加入group
+	group.isPulling = true
+	group.onRelayPullFail(0)
+	assert.Equal(t, false, group.isPulling)
+}
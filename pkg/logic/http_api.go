@@ -0,0 +1,276 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bininfo"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// HTTP管理接口
+//
+// 所有接口返回JSON，格式为 {"error_code": 0, "desp": "succ", "data": ...}
+//
+// 配置了http_api.token时，所有接口都需要携带token，通过url query参数token=xxx，或者HTTP头Authorization: Bearer xxx传入
+//
+// 查询类：
+// - /api/stat/lal_info                                     服务的信息
+// - /api/stat/all_group                                    所有流的信息
+// - /api/stat/group?app_name=&stream_name=                 单个流的信息，包含pub，pull，sub，push的session信息
+//
+// 控制类（只支持POST，参数可以通过url query或者application/x-www-form-urlencoded的body传入）：
+// - /api/ctrl/kick_session?app_name=&stream_name=&session_id=  踢掉流中的一个session
+// - /api/ctrl/start_relay_pull?app_name=&stream_name=&url=     开始回源拉流，url为空时使用relay_pull的配置，url只支持rtmp://，rtmps://和rtsp://
+// - /api/ctrl/stop_relay_pull?app_name=&stream_name=           停止回源拉流
+// - /api/ctrl/start_relay_push?app_name=&stream_name=&url=     增加一个转推地址，url只支持rtmp://，rtmps://和rtsp://
+// - /api/ctrl/stop_relay_push?app_name=&stream_name=&url=      删除一个转推地址
+//
+// 监控类：
+// - /metrics  Prometheus格式的监控指标，见metrics.go
+
+const (
+	ErrorCodeSucc             = 0
+	ErrorCodeParamMissing     = 1001
+	ErrorCodeNotFound         = 1002
+	ErrorCodeCtrlFailed       = 1003
+	ErrorCodeParamInvalid     = 1004
+	ErrorCodeUnauthorized     = 1005
+	ErrorCodeMethodNotAllowed = 1006
+)
+
+const (
+	despSucc             = "succ"
+	despParamMissing     = "param missing"
+	despParamInvalid     = "param invalid"
+	despUnauthorized     = "unauthorized"
+	despMethodNotAllowed = "method not allowed"
+)
+
+type HTTPResponseBasic struct {
+	ErrorCode int         `json:"error_code"`
+	Desp      string      `json:"desp"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+type LALInfo struct {
+	BinInfo   string `json:"bin_info"`
+	StartTime string `json:"start_time"`
+}
+
+type HTTPAPIServer struct {
+	addr    string
+	token   string // 为空时不校验
+	sm      *ServerManager
+	ln      net.Listener
+	httpSrv *http.Server
+}
+
+func NewHTTPAPIServer(addr string, token string, sm *ServerManager) *HTTPAPIServer {
+	return &HTTPAPIServer{
+		addr:  addr,
+		token: token,
+		sm:    sm,
+	}
+}
+
+func (h *HTTPAPIServer) Listen() (err error) {
+	if h.ln, err = net.Listen("tcp", h.addr); err != nil {
+		return
+	}
+	h.httpSrv = &http.Server{Addr: h.addr, Handler: h.newServeMux()}
+	nazalog.Infof("start http api server listen. addr=%s", h.addr)
+	return
+}
+
+func (h *HTTPAPIServer) RunLoop() error {
+	return h.httpSrv.Serve(h.ln)
+}
+
+func (h *HTTPAPIServer) Dispose() {
+	if err := h.httpSrv.Close(); err != nil {
+		nazalog.Error(err)
+	}
+}
+
+func (h *HTTPAPIServer) newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/stat/lal_info", h.withAuth(h.statLALInfoHandler))
+	mux.HandleFunc("/api/stat/all_group", h.withAuth(h.statAllGroupHandler))
+	mux.HandleFunc("/api/stat/group", h.withAuth(h.statGroupHandler))
+	mux.HandleFunc("/api/ctrl/kick_session", h.withAuth(onlyPost(h.ctrlKickSessionHandler)))
+	mux.HandleFunc("/api/ctrl/start_relay_pull", h.withAuth(onlyPost(h.ctrlStartRelayPullHandler)))
+	mux.HandleFunc("/api/ctrl/stop_relay_pull", h.withAuth(onlyPost(h.ctrlStopRelayPullHandler)))
+	mux.HandleFunc("/api/ctrl/start_relay_push", h.withAuth(onlyPost(h.ctrlStartRelayPushHandler)))
+	mux.HandleFunc("/api/ctrl/stop_relay_push", h.withAuth(onlyPost(h.ctrlStopRelayPushHandler)))
+	mux.HandleFunc("/metrics", h.withAuth(h.metricsHandler))
+	return mux
+}
+
+// 配置了token时，校验请求携带的token
+func (h *HTTPAPIServer) withAuth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if h.token != "" {
+			token := req.URL.Query().Get("token")
+			if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+				token = strings.TrimPrefix(auth, "Bearer ")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+				nazalog.Warnf("http api unauthorized. remote=%s, uri=%s", req.RemoteAddr, req.URL.Path)
+				writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeUnauthorized, Desp: despUnauthorized})
+				return
+			}
+		}
+		handler(w, req)
+	}
+}
+
+// 控制类接口会改变服务的状态，只允许POST，避免被<img>等跨站的GET请求触发
+func onlyPost(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeMethodNotAllowed, Desp: despMethodNotAllowed})
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// 回源拉流和转推的地址只允许rtmp://，rtmps://和rtsp://，避免被用来访问其他协议的内部服务
+func isValidRelayURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "rtmp", "rtmps", "rtsp":
+		return true
+	}
+	return false
+}
+
+func (h *HTTPAPIServer) statLALInfoHandler(w http.ResponseWriter, req *http.Request) {
+	writeHTTPAPIResponse(w, HTTPResponseBasic{
+		ErrorCode: ErrorCodeSucc,
+		Desp:      despSucc,
+		Data: LALInfo{
+			BinInfo:   bininfo.StringifySingleLine(),
+			StartTime: base.FormatTime(h.sm.startTime),
+		},
+	})
+}
+
+func (h *HTTPAPIServer) statAllGroupHandler(w http.ResponseWriter, req *http.Request) {
+	writeHTTPAPIResponse(w, HTTPResponseBasic{
+		ErrorCode: ErrorCodeSucc,
+		Desp:      despSucc,
+		Data: struct {
+			Groups []StatGroup `json:"groups"`
+		}{h.sm.StatAllGroup()},
+	})
+}
+
+func (h *HTTPAPIServer) statGroupHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	appName, streamName := q.Get("app_name"), q.Get("stream_name")
+	if appName == "" || streamName == "" {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamMissing, Desp: despParamMissing})
+		return
+	}
+
+	stat := h.sm.StatGroup(appName, streamName)
+	if stat == nil {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeNotFound, Desp: ErrGroupNotFound.Error()})
+		return
+	}
+	writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeSucc, Desp: despSucc, Data: stat})
+}
+
+func (h *HTTPAPIServer) ctrlKickSessionHandler(w http.ResponseWriter, req *http.Request) {
+	appName, streamName, sessionID := req.FormValue("app_name"), req.FormValue("stream_name"), req.FormValue("session_id")
+	if appName == "" || streamName == "" || sessionID == "" {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamMissing, Desp: despParamMissing})
+		return
+	}
+	writeCtrlResult(w, h.sm.CtrlKickSession(appName, streamName, sessionID))
+}
+
+func (h *HTTPAPIServer) ctrlStartRelayPullHandler(w http.ResponseWriter, req *http.Request) {
+	appName, streamName, url := req.FormValue("app_name"), req.FormValue("stream_name"), req.FormValue("url")
+	if appName == "" || streamName == "" {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamMissing, Desp: despParamMissing})
+		return
+	}
+	if url != "" && !isValidRelayURL(url) {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamInvalid, Desp: despParamInvalid})
+		return
+	}
+	writeCtrlResult(w, h.sm.CtrlStartRelayPull(appName, streamName, url))
+}
+
+func (h *HTTPAPIServer) ctrlStopRelayPullHandler(w http.ResponseWriter, req *http.Request) {
+	appName, streamName := req.FormValue("app_name"), req.FormValue("stream_name")
+	if appName == "" || streamName == "" {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamMissing, Desp: despParamMissing})
+		return
+	}
+	writeCtrlResult(w, h.sm.CtrlStopRelayPull(appName, streamName))
+}
+
+func (h *HTTPAPIServer) ctrlStartRelayPushHandler(w http.ResponseWriter, req *http.Request) {
+	appName, streamName, url := req.FormValue("app_name"), req.FormValue("stream_name"), req.FormValue("url")
+	if appName == "" || streamName == "" || url == "" {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamMissing, Desp: despParamMissing})
+		return
+	}
+	if !isValidRelayURL(url) {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamInvalid, Desp: despParamInvalid})
+		return
+	}
+	writeCtrlResult(w, h.sm.CtrlStartRelayPush(appName, streamName, url))
+}
+
+func (h *HTTPAPIServer) ctrlStopRelayPushHandler(w http.ResponseWriter, req *http.Request) {
+	appName, streamName, url := req.FormValue("app_name"), req.FormValue("stream_name"), req.FormValue("url")
+	if appName == "" || streamName == "" || url == "" {
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeParamMissing, Desp: despParamMissing})
+		return
+	}
+	writeCtrlResult(w, h.sm.CtrlStopRelayPush(appName, streamName, url))
+}
+
+func writeCtrlResult(w http.ResponseWriter, err error) {
+	switch err {
+	case nil:
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeSucc, Desp: despSucc})
+	case ErrGroupNotFound, ErrSessionNotFound, ErrRelayPushNotExist:
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeNotFound, Desp: err.Error()})
+	default:
+		writeHTTPAPIResponse(w, HTTPResponseBasic{ErrorCode: ErrorCodeCtrlFailed, Desp: err.Error()})
+	}
+}
+
+func writeHTTPAPIResponse(w http.ResponseWriter, resp HTTPResponseBasic) {
+	body, err := json.Marshal(resp)
+	if err != nil {
+		nazalog.Errorf("marshal http api response failed. err=%+v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,136 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type testHTTPAPIResponse struct {
+	ErrorCode int             `json:"error_code"`
+	Desp      string          `json:"desp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func TestHTTPAPIServer(t *testing.T) {
+	oldConfig := config
+	config = &Config{}
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager()
+	defer func() {
+		for _, group := range sm.groupMap {
+			group.Dispose()
+		}
+	}()
+	ts := httptest.NewServer(NewHTTPAPIServer("", "", sm).newServeMux())
+	defer ts.Close()
+
+	get := func(uri string) testHTTPAPIResponse {
+		return doTestHTTPAPIRequest(t, http.MethodGet, ts.URL+uri, "")
+	}
+	post := func(uri string) testHTTPAPIResponse {
+		return doTestHTTPAPIRequest(t, http.MethodPost, ts.URL+uri, "")
+	}
+
+	var info LALInfo
+	ret := get("/api/stat/lal_info")
+	assert.Equal(t, ErrorCodeSucc, ret.ErrorCode)
+	assert.Equal(t, nil, json.Unmarshal(ret.Data, &info))
+	assert.Equal(t, true, info.StartTime != "")
+
+	assert.Equal(t, ErrorCodeParamMissing, get("/api/stat/group?app_name=live").ErrorCode)
+	assert.Equal(t, ErrorCodeNotFound, get("/api/stat/group?app_name=live&stream_name=test110").ErrorCode)
+
+	// 没有pub推流时，增加的转推地址不会发起转推
+	pushURI := "/api/ctrl/start_relay_push?app_name=live&stream_name=test110&url=rtmp://127.0.0.1:19399/live/test110"
+	assert.Equal(t, ErrorCodeMethodNotAllowed, get(pushURI).ErrorCode)
+	assert.Equal(t, ErrorCodeSucc, post(pushURI).ErrorCode)
+	assert.Equal(t, ErrorCodeCtrlFailed, post(pushURI).ErrorCode)
+
+	// 只允许rtmp://，rtmps://和rtsp://的地址
+	assert.Equal(t, ErrorCodeParamInvalid, post("/api/ctrl/start_relay_push?app_name=live&stream_name=test110&url=http://127.0.0.1:8080/").ErrorCode)
+	assert.Equal(t, ErrorCodeParamInvalid, post("/api/ctrl/start_relay_pull?app_name=live&stream_name=test110&url=file:///etc/passwd").ErrorCode)
+
+	var stat StatGroup
+	ret = get("/api/stat/group?app_name=live&stream_name=test110")
+	assert.Equal(t, ErrorCodeSucc, ret.ErrorCode)
+	assert.Equal(t, nil, json.Unmarshal(ret.Data, &stat))
+	assert.Equal(t, "live", stat.AppName)
+	assert.Equal(t, "test110", stat.StreamName)
+	assert.Equal(t, true, stat.Pub == nil)
+	assert.Equal(t, 0, len(stat.Subs))
+	assert.Equal(t, 1, len(stat.Pushs))
+	assert.Equal(t, "rtmp://127.0.0.1:19399/live/test110", stat.Pushs[0].URL)
+	assert.Equal(t, true, stat.Pushs[0].Session == nil)
+
+	var all struct {
+		Groups []StatGroup `json:"groups"`
+	}
+	ret = get("/api/stat/all_group")
+	assert.Equal(t, ErrorCodeSucc, ret.ErrorCode)
+	assert.Equal(t, nil, json.Unmarshal(ret.Data, &all))
+	assert.Equal(t, 1, len(all.Groups))
+
+	assert.Equal(t, ErrorCodeNotFound, post("/api/ctrl/kick_session?app_name=live&stream_name=test110&session_id=RTMPPUBSUB1").ErrorCode)
+	assert.Equal(t, ErrorCodeNotFound, post("/api/ctrl/kick_session?app_name=live&stream_name=test111&session_id=RTMPPUBSUB1").ErrorCode)
+
+	stopPushURI := "/api/ctrl/stop_relay_push?app_name=live&stream_name=test110&url=rtmp://127.0.0.1:19399/live/test110"
+	assert.Equal(t, ErrorCodeSucc, post(stopPushURI).ErrorCode)
+	assert.Equal(t, ErrorCodeNotFound, post(stopPushURI).ErrorCode)
+
+	// 没有配置relay_pull的地址，也没有传入url
+	ret = post("/api/ctrl/start_relay_pull?app_name=live&stream_name=test110")
+	assert.Equal(t, ErrorCodeCtrlFailed, ret.ErrorCode)
+	assert.Equal(t, ErrRelayPullAddrMissing.Error(), ret.Desp)
+	assert.Equal(t, ErrorCodeSucc, post("/api/ctrl/stop_relay_pull?app_name=live&stream_name=test110").ErrorCode)
+}
+
+func TestHTTPAPIServer_Token(t *testing.T) {
+	oldConfig := config
+	config = &Config{}
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager()
+	ts := httptest.NewServer(NewHTTPAPIServer("", "abc", sm).newServeMux())
+	defer ts.Close()
+
+	assert.Equal(t, ErrorCodeUnauthorized, doTestHTTPAPIRequest(t, http.MethodGet, ts.URL+"/api/stat/lal_info", "").ErrorCode)
+	assert.Equal(t, ErrorCodeUnauthorized, doTestHTTPAPIRequest(t, http.MethodGet, ts.URL+"/api/stat/lal_info?token=abd", "").ErrorCode)
+	assert.Equal(t, ErrorCodeUnauthorized, doTestHTTPAPIRequest(t, http.MethodPost, ts.URL+"/api/ctrl/stop_relay_pull?app_name=live&stream_name=test110", "").ErrorCode)
+	assert.Equal(t, ErrorCodeSucc, doTestHTTPAPIRequest(t, http.MethodGet, ts.URL+"/api/stat/lal_info?token=abc", "").ErrorCode)
+	assert.Equal(t, ErrorCodeSucc, doTestHTTPAPIRequest(t, http.MethodGet, ts.URL+"/api/stat/lal_info", "abc").ErrorCode)
+	assert.Equal(t, ErrorCodeNotFound, doTestHTTPAPIRequest(t, http.MethodPost, ts.URL+"/api/ctrl/stop_relay_pull?app_name=live&stream_name=test110", "abc").ErrorCode)
+}
+
+func doTestHTTPAPIRequest(t *testing.T, method string, url string, token string) testHTTPAPIResponse {
+	req, err := http.NewRequest(method, url, nil)
+	assert.Equal(t, nil, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, nil, err)
+	var ret testHTTPAPIResponse
+	assert.Equal(t, nil, json.Unmarshal(body, &ret))
+	return ret
+}
@@ -11,12 +11,28 @@ package logic
 import (
 	"errors"
 
+	"github.com/q191201771/lal/pkg/hls"
 	"github.com/q191201771/lal/pkg/httpflv"
 	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/lal/pkg/rtsp"
 )
 
 var ErrLogic = errors.New("lal.logic: fxxk")
 
+var (
+	ErrGroupNotFound   = errors.New("lal.logic: group not found")
+	ErrSessionNotFound = errors.New("lal.logic: session not found")
+
+	ErrGroupHasPubSession    = errors.New("lal.logic: group already has pub session")
+	ErrRelayPullAddrMissing  = errors.New("lal.logic: relay pull addr missing")
+	ErrRelayPushAlreadyExist = errors.New("lal.logic: relay push already exist")
+	ErrRelayPushNotExist     = errors.New("lal.logic: relay push not exist")
+	ErrHTTPNotifyStatusCode  = errors.New("lal.logic: http notify status code not 2xx")
+)
+
 var _ rtmp.ServerObserver = &ServerManager{}
 var _ httpflv.ServerObserver = &ServerManager{}
+var _ rtsp.ServerObserver = &ServerManager{}
+var _ hls.ServerObserver = &ServerManager{}
 var _ rtmp.PubSessionObserver = &Group{}
+var _ hls.MuxerObserver = &Group{}
@@ -0,0 +1,139 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/q191201771/lal/pkg/base"
+)
+
+// Prometheus监控指标
+//
+// 由HTTP API服务的/metrics接口，以Prometheus的text格式返回，包含：
+// - 流，pub，sub的数量
+// - 每个流输入（pub，pull）和输出（sub，push）的字节数
+// - 每个流gop缓存占用的内存
+// - 回源拉流和转推的次数，失败次数
+// - hls生成的ts切片个数
+// - 由于connection发送channel满而丢弃的写入次数
+// - rtmp握手失败次数
+
+var (
+	relayPullAttemptsCounter base.Counter
+	relayPullFailuresCounter base.Counter
+	relayPushAttemptsCounter base.Counter
+	relayPushFailuresCounter base.Counter
+	hlsFragmentsCounter      base.Counter
+)
+
+// 按固定顺序输出，没有session的协议也输出值为0的指标
+var metricsPubProtocols = []string{base.ProtocolRTMP, base.ProtocolRTSP}
+var metricsSubProtocols = []string{base.ProtocolRTMP, base.ProtocolHTTPFLV, base.ProtocolRTSP}
+
+// 单个group的监控指标
+type groupMetrics struct {
+	appName       string
+	streamName    string
+	pubProtocol   string         // 没有pub时为空
+	subCount      map[string]int // key为协议
+	inBytes       uint64
+	outBytes      uint64
+	gopCacheBytes int
+}
+
+func (h *HTTPAPIServer) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var buf bytes.Buffer
+	writeMetrics(&buf, h.sm.collectGroupMetrics())
+	_, _ = w.Write(buf.Bytes())
+}
+
+func writeMetrics(w io.Writer, groups []groupMetrics) {
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].appName != groups[j].appName {
+			return groups[i].appName < groups[j].appName
+		}
+		return groups[i].streamName < groups[j].streamName
+	})
+
+	writeMetricsHeader(w, "lal_groups", "gauge", "Number of active groups.")
+	fmt.Fprintf(w, "lal_groups %d\n", len(groups))
+
+	writeMetricsHeader(w, "lal_publishers", "gauge", "Number of publishers per protocol.")
+	for _, protocol := range metricsPubProtocols {
+		var n int
+		for _, g := range groups {
+			if g.pubProtocol == protocol {
+				n++
+			}
+		}
+		fmt.Fprintf(w, "lal_publishers{protocol=\"%s\"} %d\n", protocol, n)
+	}
+
+	writeMetricsHeader(w, "lal_subscribers", "gauge", "Number of subscribers per protocol.")
+	for _, protocol := range metricsSubProtocols {
+		var n int
+		for _, g := range groups {
+			n += g.subCount[protocol]
+		}
+		fmt.Fprintf(w, "lal_subscribers{protocol=\"%s\"} %d\n", protocol, n)
+	}
+
+	writeMetricsHeader(w, "lal_stream_in_bytes_total", "counter", "Bytes received by publishers and relay pulls per stream.")
+	for _, g := range groups {
+		fmt.Fprintf(w, "lal_stream_in_bytes_total{%s} %d\n", streamMetricsLabels(g), g.inBytes)
+	}
+
+	writeMetricsHeader(w, "lal_stream_out_bytes_total", "counter", "Bytes sent to subscribers and relay pushes per stream.")
+	for _, g := range groups {
+		fmt.Fprintf(w, "lal_stream_out_bytes_total{%s} %d\n", streamMetricsLabels(g), g.outBytes)
+	}
+
+	writeMetricsHeader(w, "lal_gop_cache_bytes", "gauge", "Memory used by GOP cache per stream.")
+	for _, g := range groups {
+		fmt.Fprintf(w, "lal_gop_cache_bytes{%s} %d\n", streamMetricsLabels(g), g.gopCacheBytes)
+	}
+
+	writeCounterMetrics(w, "lal_relay_pull_attempts_total", "Number of relay pull attempts.", relayPullAttemptsCounter.Get())
+	writeCounterMetrics(w, "lal_relay_pull_failures_total", "Number of failed relay pull attempts.", relayPullFailuresCounter.Get())
+	writeCounterMetrics(w, "lal_relay_push_attempts_total", "Number of relay push attempts.", relayPushAttemptsCounter.Get())
+	writeCounterMetrics(w, "lal_relay_push_failures_total", "Number of failed relay push attempts.", relayPushFailuresCounter.Get())
+	writeCounterMetrics(w, "lal_hls_fragments_total", "Number of HLS fragments written.", hlsFragmentsCounter.Get())
+	writeCounterMetrics(w, "lal_write_chan_full_dropped_total", "Number of writes dropped because the connection write channel was full.", base.WriteChanFullDroppedCounter.Get())
+
+	writeMetricsHeader(w, "lal_handshake_failures_total", "counter", "Number of handshake failures per protocol.")
+	fmt.Fprintf(w, "lal_handshake_failures_total{protocol=\"%s\"} %d\n", base.ProtocolRTMP, base.RTMPHandshakeFailedCounter.Get())
+}
+
+func writeMetricsHeader(w io.Writer, name string, t string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, t)
+}
+
+func writeCounterMetrics(w io.Writer, name string, help string, value uint64) {
+	writeMetricsHeader(w, name, "counter", help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+func streamMetricsLabels(g groupMetrics) string {
+	return fmt.Sprintf("app_name=\"%s\",stream_name=\"%s\"", escapeMetricsLabelValue(g.appName), escapeMetricsLabelValue(g.streamName))
+}
+
+// 流名来自客户端，需要按Prometheus的格式转义
+var metricsLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeMetricsLabelValue(v string) string {
+	return metricsLabelValueReplacer.Replace(v)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	oldConfig := config
+	config = &Config{}
+	defer func() {
+		config = oldConfig
+	}()
+
+	sm := NewServerManager()
+	defer func() {
+		for _, group := range sm.groupMap {
+			group.Dispose()
+		}
+	}()
+	assert.Equal(t, nil, sm.CtrlStartRelayPush("live", "test110", "rtmp://127.0.0.1:19399/live/test110"))
+
+	ts := httptest.NewServer(NewHTTPAPIServer("", "", sm).newServeMux())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain"))
+
+	lines := strings.Split(string(body), "\n")
+	for _, expected := range []string{
+		"# TYPE lal_groups gauge",
+		"lal_groups 1",
+		`lal_publishers{protocol="rtmp"} 0`,
+		`lal_subscribers{protocol="httpflv"} 0`,
+		`lal_stream_in_bytes_total{app_name="live",stream_name="test110"} 0`,
+		`lal_stream_out_bytes_total{app_name="live",stream_name="test110"} 0`,
+		`lal_gop_cache_bytes{app_name="live",stream_name="test110"} 0`,
+		"# TYPE lal_relay_push_attempts_total counter",
+	} {
+		var found bool
+		for _, line := range lines {
+			if line == expected {
+				found = true
+				break
+			}
+		}
+		assert.Equal(t, true, found, expected)
+	}
+}
+
+func TestWriteMetrics_Escape(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetrics(&buf, []groupMetrics{
+		{appName: "live", streamName: "a\"b\\c\nd", pubProtocol: "rtmp", subCount: map[string]int{"rtmp": 2}, inBytes: 100, outBytes: 200},
+	})
+	out := buf.String()
+	assert.Equal(t, true, strings.Contains(out, `lal_publishers{protocol="rtmp"} 1`+"\n"))
+	assert.Equal(t, true, strings.Contains(out, `lal_subscribers{protocol="rtmp"} 2`+"\n"))
+	assert.Equal(t, true, strings.Contains(out, `lal_stream_in_bytes_total{app_name="live",stream_name="a\"b\\c\nd"} 100`+"\n"))
+	assert.Equal(t, true, strings.Contains(out, `lal_stream_out_bytes_total{app_name="live",stream_name="a\"b\\c\nd"} 200`+"\n"))
+}
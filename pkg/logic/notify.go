@@ -0,0 +1,218 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// HTTP回调通知
+//
+// 流和session的事件发生时，以POST JSON的方式通知业务方配置的HTTP地址，各事件的地址见NotifyConfig
+//
+// 事件先放入队列，由单独的协程按顺序发送，所以不会阻塞音视频数据的处理
+// 队列满时，丢弃新的事件；发送失败（网络错误或HTTP状态码不是2xx）时，按配置重试
+
+type SessionEventInfo struct {
+	Protocol   string `json:"protocol"`
+	SessionID  string `json:"session_id"`
+	RemoteAddr string `json:"remote_addr"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	RawQuery   string `json:"raw_query"` // 推拉流url中?后面的参数，不包含?
+}
+
+type RelayEventInfo struct {
+	Protocol   string `json:"protocol"`
+	SessionID  string `json:"session_id"`
+	RemoteAddr string `json:"remote_addr"`
+	AppName    string `json:"app_name"`
+	StreamName string `json:"stream_name"`
+	URL        string `json:"url"` // 回源拉流或转推的地址
+}
+
+type HLSMakeTSInfo struct {
+	AppName      string  `json:"app_name"`
+	StreamName   string  `json:"stream_name"`
+	ID           int     `json:"id"`
+	TSFile       string  `json:"ts_file"`
+	LiveM3U8File string  `json:"live_m3u8_file"`
+	Duration     float64 `json:"duration"` // 单位秒
+}
+
+type UpdateInfo struct {
+	Groups []StatGroup `json:"groups"`
+}
+
+type HTTPNotify struct {
+	config      NotifyConfig
+	taskChan    chan notifyTask
+	client      *http.Client
+	exitChan    chan struct{}
+	disposeOnce sync.Once
+}
+
+type notifyTask struct {
+	url  string
+	info interface{}
+}
+
+func NewHTTPNotify(config NotifyConfig) *HTTPNotify {
+	return &HTTPNotify{
+		config:   config,
+		taskChan: make(chan notifyTask, config.QueueSize),
+		client: &http.Client{
+			Timeout: time.Duration(config.TimeoutMS) * time.Millisecond,
+		},
+		exitChan: make(chan struct{}),
+	}
+}
+
+func (h *HTTPNotify) RunLoop() {
+	for {
+		select {
+		case <-h.exitChan:
+			return
+		case task := <-h.taskChan:
+			h.send(task)
+		}
+	}
+}
+
+// 队列中还没有发送的事件会被丢弃，可以多次调用
+func (h *HTTPNotify) Dispose() {
+	h.disposeOnce.Do(func() {
+		close(h.exitChan)
+	})
+}
+
+func (h *HTTPNotify) OnPubStart(info SessionEventInfo) {
+	h.post(h.config.OnPubStart, info)
+}
+
+func (h *HTTPNotify) OnPubStop(info SessionEventInfo) {
+	h.post(h.config.OnPubStop, info)
+}
+
+func (h *HTTPNotify) OnSubStart(info SessionEventInfo) {
+	h.post(h.config.OnSubStart, info)
+}
+
+func (h *HTTPNotify) OnSubStop(info SessionEventInfo) {
+	h.post(h.config.OnSubStop, info)
+}
+
+func (h *HTTPNotify) OnRelayPullStart(info RelayEventInfo) {
+	h.post(h.config.OnRelayPullStart, info)
+}
+
+func (h *HTTPNotify) OnRelayPullStop(info RelayEventInfo) {
+	h.post(h.config.OnRelayPullStop, info)
+}
+
+func (h *HTTPNotify) OnRelayPushStart(info RelayEventInfo) {
+	h.post(h.config.OnRelayPushStart, info)
+}
+
+func (h *HTTPNotify) OnRelayPushStop(info RelayEventInfo) {
+	h.post(h.config.OnRelayPushStop, info)
+}
+
+func (h *HTTPNotify) OnHLSMakeTS(info HLSMakeTSInfo) {
+	h.post(h.config.OnHLSMakeTS, info)
+}
+
+func (h *HTTPNotify) OnUpdate(info UpdateInfo) {
+	h.post(h.config.OnUpdate, info)
+}
+
+// 没有开启或者没有配置地址的事件，不需要通知
+func (h *HTTPNotify) isEnabled(url string) bool {
+	return h.config.Enable && url != ""
+}
+
+func (h *HTTPNotify) post(url string, info interface{}) {
+	if !h.isEnabled(url) {
+		return
+	}
+	select {
+	case h.taskChan <- notifyTask{url: url, info: info}:
+	default:
+		nazalog.Warnf("http notify queue full, drop. url=%s, info=%+v", url, info)
+	}
+}
+
+func (h *HTTPNotify) send(task notifyTask) {
+	body, err := json.Marshal(task.info)
+	if err != nil {
+		nazalog.Errorf("marshal http notify info failed. url=%s, err=%+v", task.url, err)
+		return
+	}
+
+	for i := 0; i <= h.config.RetryNum; i++ {
+		if i != 0 {
+			select {
+			case <-h.exitChan:
+				return
+			case <-time.After(time.Duration(notifyRetryIntervalMS) * time.Millisecond):
+			}
+		}
+		statusCode, err := h.sendOnce(task.url, body)
+		if err == nil {
+			return
+		}
+		nazalog.Warnf("http notify failed. url=%s, retry=%d, status code=%d, err=%+v", task.url, i, statusCode, err)
+	}
+	nazalog.Errorf("http notify failed, give up. url=%s, body=%s", task.url, string(body))
+}
+
+// @return statusCode 网络错误时为0
+func (h *HTTPNotify) sendOnce(url string, body []byte) (statusCode int, err error) {
+	resp, err := h.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, ErrHTTPNotifyStatusCode
+	}
+	return resp.StatusCode, nil
+}
+
+func newSessionEventInfo(stat base.StatSession, appName string, streamName string, rawQuery string) SessionEventInfo {
+	return SessionEventInfo{
+		Protocol:   stat.Protocol,
+		SessionID:  stat.SessionID,
+		RemoteAddr: stat.RemoteAddr,
+		AppName:    appName,
+		StreamName: streamName,
+		RawQuery:   rawQuery,
+	}
+}
+
+func newRelayEventInfo(stat base.StatSession, appName string, streamName string, url string) RelayEventInfo {
+	return RelayEventInfo{
+		Protocol:   stat.Protocol,
+		SessionID:  stat.SessionID,
+		RemoteAddr: stat.RemoteAddr,
+		AppName:    appName,
+		StreamName: streamName,
+		URL:        url,
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestHTTPNotify(t *testing.T) {
+	oldInterval := notifyRetryIntervalMS
+	notifyRetryIntervalMS = 10
+	defer func() {
+		notifyRetryIntervalMS = oldInterval
+	}()
+
+	type request struct {
+		path string
+		body []byte
+	}
+	ch := make(chan request, 16)
+	var count int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		count++
+		// 第一次请求失败，触发重试
+		if count == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ch <- request{path: r.URL.Path, body: body}
+	}))
+	defer ts.Close()
+
+	notify := NewHTTPNotify(NotifyConfig{
+		Enable:     true,
+		TimeoutMS:  1000,
+		RetryNum:   1,
+		QueueSize:  16,
+		OnPubStart: ts.URL + "/on_pub_start",
+		OnSubStop:  ts.URL + "/on_sub_stop",
+	})
+	go notify.RunLoop()
+	defer notify.Dispose()
+
+	pubInfo := SessionEventInfo{
+		Protocol:   "rtmp",
+		SessionID:  "RTMPPUBSUB1",
+		RemoteAddr: "127.0.0.1:12345",
+		AppName:    "live",
+		StreamName: "test110",
+		RawQuery:   "token=abc",
+	}
+	notify.OnPubStart(pubInfo)
+	// 没有配置地址的事件不通知
+	notify.OnPubStop(pubInfo)
+	subInfo := pubInfo
+	subInfo.SessionID = "RTMPPUBSUB2"
+	notify.OnSubStop(subInfo)
+
+	expected := []struct {
+		path string
+		info SessionEventInfo
+	}{
+		{"/on_pub_start", pubInfo},
+		{"/on_sub_stop", subInfo},
+	}
+	for _, e := range expected {
+		select {
+		case req := <-ch:
+			assert.Equal(t, e.path, req.path)
+			var info SessionEventInfo
+			assert.Equal(t, nil, json.Unmarshal(req.body, &info))
+			assert.Equal(t, e.info, info)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("wait notify timeout. path=%s", e.path)
+		}
+	}
+	select {
+	case req := <-ch:
+		t.Fatalf("unexpected notify. path=%s", req.path)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHTTPNotify_QueueFull(t *testing.T) {
+	// 没有启动RunLoop，队列满后丢弃新的事件，不会阻塞
+	notify := NewHTTPNotify(NotifyConfig{
+		Enable:     true,
+		QueueSize:  1,
+		OnPubStart: "http://127.0.0.1:1/on_pub_start",
+	})
+	notify.OnPubStart(SessionEventInfo{SessionID: "1"})
+	notify.OnPubStart(SessionEventInfo{SessionID: "2"})
+	assert.Equal(t, 1, len(notify.taskChan))
+
+	// 没有开启时不通知
+	notify = NewHTTPNotify(NotifyConfig{
+		Enable:     false,
+		QueueSize:  1,
+		OnPubStart: "http://127.0.0.1:1/on_pub_start",
+	})
+	notify.OnPubStart(SessionEventInfo{SessionID: "1"})
+	assert.Equal(t, 0, len(notify.taskChan))
+}
+
+func TestHTTPNotify_Dispose(t *testing.T) {
+	notify := NewHTTPNotify(NotifyConfig{Enable: true, QueueSize: 1})
+	done := make(chan struct{})
+	go func() {
+		notify.RunLoop()
+		close(done)
+	}()
+
+	// 多次调用不会panic
+	notify.Dispose()
+	notify.Dispose()
+	<-done
+}
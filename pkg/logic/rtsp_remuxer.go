@@ -0,0 +1,278 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package logic
+
+import (
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/lal/pkg/rtsp"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 将rtsp.PubSession回调上来的rtsp.AVPacket转换为rtmp.AVMsg
+//
+// - 视频支持AVC和HEVC，音频支持AAC
+// - 音视频头信息优先使用sdp中的sprop-parameter-sets（sprop-vps/sps/pps）以及config，如果sdp中没有，则从视频数据中的VPS，SPS和PPS生成
+// - rtsp.AVPacket中的一个视频包只包含一个NALU，这里将时间戳相同的NALU合并为一个rtmp message
+// - 音频和视频的时间戳已经由rtsp包根据rtcp sr同步到了同一个从0开始的时间轴上，这里直接使用
+//
+// 注意，非协程安全，由调用方保证
+type RTSP2RTMPRemuxer struct {
+	uniqueKey   string
+	onRTMPAVMsg rtmp.OnReadRTMPAVMsg
+
+	isHEVC          bool
+	vps             []byte
+	sps             []byte
+	pps             []byte
+	videoHeaderSent bool
+
+	videoFrameTS  uint32   // 当前缓存的视频帧的时间戳
+	videoFrameKey bool     // 当前缓存的视频帧是否包含IDR（HEVC为IRAP）
+	videoNALUs    [][]byte // 当前缓存的视频帧的NALU
+}
+
+func NewRTSP2RTMPRemuxer(uniqueKey string, onRTMPAVMsg rtmp.OnReadRTMPAVMsg) *RTSP2RTMPRemuxer {
+	return &RTSP2RTMPRemuxer{
+		uniqueKey:   uniqueKey,
+		onRTMPAVMsg: onRTMPAVMsg,
+	}
+}
+
+// 使用sdp中的音视频头信息，生成rtmp的音视频seq header
+//
+// @param vps           不为nil时表示视频为HEVC
+// @param sps, pps, asc 任意一个都可以为nil
+func (r *RTSP2RTMPRemuxer) InitWithAVConfig(vps, sps, pps, asc []byte) {
+	if sps != nil && pps != nil {
+		r.isHEVC = vps != nil
+		r.vps = vps
+		r.sps = sps
+		r.pps = pps
+		r.emitVideoSeqHeader()
+	}
+
+	if asc != nil {
+		payload, err := aac.BuildAACSeqHeader(asc)
+		if err != nil {
+			nazalog.Errorf("[%s] build aac seq header failed. err=%+v", r.uniqueKey, err)
+			return
+		}
+		r.emit(rtmp.TypeidAudio, 0, payload)
+	}
+}
+
+func (r *RTSP2RTMPRemuxer) FeedAVPacket(pkt rtsp.AVPacket) {
+	if len(pkt.Payload) == 0 {
+		return
+	}
+
+	switch pkt.PayloadType {
+	case rtsp.RTPPacketTypeAVC:
+		r.feedAVC(pkt)
+	case rtsp.RTPPacketTypeHEVC:
+		r.feedHEVC(pkt)
+	case rtsp.RTPPacketTypeAAC:
+		r.feedAAC(pkt)
+	default:
+		nazalog.Errorf("[%s] unknown payload type. type=%d", r.uniqueKey, pkt.PayloadType)
+	}
+}
+
+func (r *RTSP2RTMPRemuxer) feedAAC(pkt rtsp.AVPacket) {
+	payload := make([]byte, 2+len(pkt.Payload))
+	payload[0] = 0xAF
+	payload[1] = rtmp.AACPacketTypeRaw
+	copy(payload[2:], pkt.Payload)
+	r.emit(rtmp.TypeidAudio, pkt.Timestamp, payload)
+}
+
+func (r *RTSP2RTMPRemuxer) feedAVC(pkt rtsp.AVPacket) {
+	ts := pkt.Timestamp
+
+	// 时间戳变化，说明上一帧已经完整了
+	if len(r.videoNALUs) != 0 && ts != r.videoFrameTS {
+		r.flushVideoFrame()
+	}
+
+	r.isHEVC = false
+	nalu := pkt.Payload
+	switch avc.ParseNALUType(nalu[0]) {
+	case avc.NALUTypeSPS:
+		r.sps = nalu
+		r.emitVideoSeqHeaderIfNeeded()
+		return
+	case avc.NALUTypePPS:
+		r.pps = nalu
+		r.emitVideoSeqHeaderIfNeeded()
+		return
+	case avc.NALUTypeAUD:
+		return
+	case avc.NALUTypeIDRSlice:
+		r.videoFrameKey = true
+	}
+
+	r.videoFrameTS = ts
+	r.videoNALUs = append(r.videoNALUs, nalu)
+}
+
+func (r *RTSP2RTMPRemuxer) feedHEVC(pkt rtsp.AVPacket) {
+	ts := pkt.Timestamp
+
+	// 时间戳变化，说明上一帧已经完整了
+	if len(r.videoNALUs) != 0 && ts != r.videoFrameTS {
+		r.flushVideoFrame()
+	}
+
+	r.isHEVC = true
+	nalu := pkt.Payload
+	if len(nalu) < 2 {
+		return
+	}
+	t := hevc.ParseNALUType(nalu[0])
+	switch {
+	case t == hevc.NALUTypeVPS:
+		r.vps = nalu
+		r.emitVideoSeqHeaderIfNeeded()
+		return
+	case t == hevc.NALUTypeSPS:
+		r.sps = nalu
+		r.emitVideoSeqHeaderIfNeeded()
+		return
+	case t == hevc.NALUTypePPS:
+		r.pps = nalu
+		r.emitVideoSeqHeaderIfNeeded()
+		return
+	case t == hevc.NALUTypeAUD:
+		return
+	case hevc.IsIRAP(t):
+		r.videoFrameKey = true
+	}
+
+	r.videoFrameTS = ts
+	r.videoNALUs = append(r.videoNALUs, nalu)
+}
+
+func (r *RTSP2RTMPRemuxer) flushVideoFrame() {
+	defer func() {
+		r.videoNALUs = r.videoNALUs[:0]
+		r.videoFrameKey = false
+	}()
+
+	// 没有seq header时，发送视频数据没有意义
+	if !r.videoHeaderSent {
+		return
+	}
+
+	size := 5
+	for _, nalu := range r.videoNALUs {
+		size += 4 + len(nalu)
+	}
+	payload := make([]byte, size)
+	switch {
+	case r.isHEVC && r.videoFrameKey:
+		payload[0] = rtmp.HEVCKeyFrame
+	case r.isHEVC:
+		payload[0] = rtmp.HEVCInterFrame
+	case r.videoFrameKey:
+		payload[0] = rtmp.AVCKeyFrame
+	default:
+		payload[0] = rtmp.AVCInterFrame
+	}
+	// 注意，AVCPacketTypeNALU和HEVCPacketTypeNALU的值相同
+	payload[1] = rtmp.AVCPacketTypeNALU
+	// cts为0，rtp中没有dts和pts的区分
+	index := 5
+	for _, nalu := range r.videoNALUs {
+		bele.BEPutUint32(payload[index:], uint32(len(nalu)))
+		index += 4
+		copy(payload[index:], nalu)
+		index += len(nalu)
+	}
+	r.emit(rtmp.TypeidVideo, r.videoFrameTS, payload)
+}
+
+// sdp中没有携带VPS，SPS和PPS时，使用视频数据中的VPS，SPS和PPS
+func (r *RTSP2RTMPRemuxer) emitVideoSeqHeaderIfNeeded() {
+	if r.videoHeaderSent || r.sps == nil || r.pps == nil || (r.isHEVC && r.vps == nil) {
+		return
+	}
+	r.emitVideoSeqHeader()
+}
+
+func (r *RTSP2RTMPRemuxer) emitVideoSeqHeader() {
+	var payload []byte
+	var err error
+	if r.isHEVC {
+		payload, err = hevc.BuildSeqHeaderFromVPSSPSPPS(r.vps, r.sps, r.pps)
+	} else {
+		payload, err = avc.BuildSeqHeaderFromSPSPPS(r.sps, r.pps)
+	}
+	if err != nil {
+		nazalog.Errorf("[%s] build video seq header failed. isHEVC=%t, err=%+v", r.uniqueKey, r.isHEVC, err)
+		return
+	}
+	r.emit(rtmp.TypeidVideo, 0, payload)
+	r.videoHeaderSent = true
+}
+
+func (r *RTSP2RTMPRemuxer) emit(typeID uint8, ts uint32, payload []byte) {
+	var msg rtmp.AVMsg
+	msg.Header.MsgTypeID = typeID
+	msg.Header.MsgLen = uint32(len(payload))
+	msg.Header.Timestamp = ts
+	msg.Header.TimestampAbs = ts
+	msg.Header.MsgStreamID = rtmp.MSID1
+	switch typeID {
+	case rtmp.TypeidAudio:
+		msg.Header.CSID = rtmp.CSIDAudio
+	case rtmp.TypeidVideo:
+		msg.Header.CSID = rtmp.CSIDVideo
+	}
+	msg.Payload = payload
+	r.onRTMPAVMsg(msg)
+}
+
+// 将rtmp.AVMsg转换为rtsp.SubSession发送所需要的rtsp.AVPacket
+//
+// 视频为AVCC（HVCC）格式的一帧数据，音频为一帧raw AAC数据，时间戳为pts
+// 音视频头以及其他格式的数据返回false
+func RTMPAVMsg2RTSPAVPacket(msg rtmp.AVMsg) (pkt rtsp.AVPacket, ok bool) {
+	switch msg.Header.MsgTypeID {
+	case rtmp.TypeidVideo:
+		// 兼容Enhanced RTMP的扩展头
+		vh, err := rtmp.ParseVideoHeader(msg.Payload)
+		if err != nil || !vh.IsCodedFrames() || len(msg.Payload) <= vh.DataOffset {
+			return
+		}
+		switch {
+		case vh.IsAVC():
+			pkt.PayloadType = rtsp.RTPPacketTypeAVC
+		case vh.IsHEVC():
+			pkt.PayloadType = rtsp.RTPPacketTypeHEVC
+		default:
+			return
+		}
+		pkt.Timestamp = msg.Header.TimestampAbs + vh.CTS
+		pkt.Payload = msg.Payload[vh.DataOffset:]
+		return pkt, true
+	case rtmp.TypeidAudio:
+		if len(msg.Payload) <= 2 || msg.Payload[0]>>4 != rtmp.SoundFormatAAC || msg.Payload[1] != rtmp.AACPacketTypeRaw {
+			return
+		}
+		pkt.Timestamp = msg.Header.TimestampAbs
+		pkt.Payload = msg.Payload[2:]
+		pkt.PayloadType = rtsp.RTPPacketTypeAAC
+		return pkt, true
+	}
+	return
+}
@@ -17,32 +17,89 @@ import (
 
 	"github.com/q191201771/lal/pkg/httpflv"
 	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/lal/pkg/rtsp"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
 
+// appName和streamName中都可能出现'/'，所以不使用字符串拼接作为key
+type groupKey struct {
+	appName    string
+	streamName string
+}
+
 type ServerManager struct {
 	rtmpServer    *rtmp.Server
+	rtmpsServer   *rtmp.Server
 	httpflvServer *httpflv.Server
 	hlsServer     *hls.Server
+	rtspServer    *rtsp.Server
+	httpAPIServer *HTTPAPIServer
+	notify        *HTTPNotify
+	admission     *AdmissionHook
 	exitChan      chan struct{}
+	startTime     time.Time
+
+	option ServerManagerOption
 
 	mutex    sync.Mutex
-	groupMap map[string]*Group // TODO chef: with appName
+	groupMap map[groupKey]*Group
+}
+
+type ServerManagerOption struct {
+	// 推拉流鉴权，为nil时，根据配置文件决定是否使用内置的签名URL方案，见auth.go
+	Authenticator Authenticator
+}
+
+var defaultServerManagerOption = ServerManagerOption{
+	Authenticator: nil,
 }
 
-func NewServerManager() *ServerManager {
+type ModServerManagerOption func(option *ServerManagerOption)
+
+func NewServerManager(modOptions ...ModServerManagerOption) *ServerManager {
 	m := &ServerManager{
-		groupMap: make(map[string]*Group),
-		exitChan: make(chan struct{}),
+		groupMap:  make(map[groupKey]*Group),
+		exitChan:  make(chan struct{}),
+		startTime: time.Now(),
+		notify:    NewHTTPNotify(config.NotifyConfig),
+		admission: NewAdmissionHook(config.AdmissionConfig),
+		option:    defaultServerManagerOption,
+	}
+	for _, fn := range modOptions {
+		fn(&m.option)
+	}
+	if m.option.Authenticator == nil && config.AuthConfig.Enable {
+		m.option.Authenticator = NewSignedURLAuthenticator(config.AppList)
 	}
 	if config.RTMPConfig.Enable {
 		m.rtmpServer = rtmp.NewServer(m, config.RTMPConfig.Addr)
 	}
+	if config.RTMPConfig.RTMPSEnable {
+		m.rtmpsServer = rtmp.NewTLSServer(m, config.RTMPConfig.RTMPSAddr, config.RTMPConfig.RTMPSCertFile, config.RTMPConfig.RTMPSKeyFile)
+	}
 	if config.HTTPFLVConfig.Enable {
 		m.httpflvServer = httpflv.NewServer(m, config.HTTPFLVConfig.SubListenAddr)
 	}
 	if config.HLSConfig.Enable {
-		m.hlsServer = hls.NewServer(config.HLSConfig.SubListenAddr, config.HLSConfig.OutPath)
+		m.hlsServer = hls.NewServer(config.HLSConfig.SubListenAddr, config.HLSConfig.OutPath, m)
+	}
+	if config.RTSPConfig.Enable {
+		m.rtspServer = rtsp.NewServer(config.RTSPConfig.Addr, m)
+	}
+	if config.HTTPAPIConfig.Enable {
+		if config.HTTPAPIConfig.Token == "" {
+			nazalog.Warnf("http api enabled without token, anyone who can access it can kick sessions and control relay. addr=%s", config.HTTPAPIConfig.Addr)
+		}
+		m.httpAPIServer = NewHTTPAPIServer(config.HTTPAPIConfig.Addr, config.HTTPAPIConfig.Token, m)
+	}
+	if config.StaticPullConfig.Enable {
+		for _, item := range config.StaticPullConfig.ItemList {
+			if !checkAppName("static_pull", item.AppName) {
+				continue
+			}
+			group := m.getOrCreateGroup(item.AppName, item.StreamName)
+			group.SetStaticPullURL(item.URL)
+		}
 	}
 	return m
 }
@@ -60,6 +117,18 @@ func (sm *ServerManager) RunLoop() {
 		}()
 	}
 
+	if sm.rtmpsServer != nil {
+		if err := sm.rtmpsServer.Listen(); err != nil {
+			nazalog.Error(err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := sm.rtmpsServer.RunLoop(); err != nil {
+				nazalog.Error(err)
+			}
+		}()
+	}
+
 	if sm.httpflvServer != nil {
 		if err := sm.httpflvServer.Listen(); err != nil {
 			nazalog.Error(err)
@@ -84,6 +153,32 @@ func (sm *ServerManager) RunLoop() {
 		}()
 	}
 
+	if sm.rtspServer != nil {
+		if err := sm.rtspServer.Listen(); err != nil {
+			nazalog.Error(err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := sm.rtspServer.RunLoop(); err != nil {
+				nazalog.Error(err)
+			}
+		}()
+	}
+
+	if sm.httpAPIServer != nil {
+		if err := sm.httpAPIServer.Listen(); err != nil {
+			nazalog.Error(err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := sm.httpAPIServer.RunLoop(); err != nil {
+				nazalog.Error(err)
+			}
+		}()
+	}
+
+	go sm.notify.RunLoop()
+
 	t := time.NewTicker(1 * time.Second)
 	defer t.Stop()
 	var count uint32
@@ -94,6 +189,9 @@ func (sm *ServerManager) RunLoop() {
 		case <-t.C:
 			sm.iterateGroup()
 			count++
+			if sm.notify.isEnabled(config.NotifyConfig.OnUpdate) && (count%uint32(config.NotifyConfig.UpdateIntervalSec)) == 0 {
+				sm.notify.OnUpdate(UpdateInfo{Groups: sm.StatAllGroup()})
+			}
 			if (count % 10) == 0 {
 				sm.mutex.Lock()
 				nazalog.Debugf("group size=%d", len(sm.groupMap))
@@ -111,12 +209,22 @@ func (sm *ServerManager) Dispose() {
 	if sm.rtmpServer != nil {
 		sm.rtmpServer.Dispose()
 	}
+	if sm.rtmpsServer != nil {
+		sm.rtmpsServer.Dispose()
+	}
 	if sm.httpflvServer != nil {
 		sm.httpflvServer.Dispose()
 	}
 	if sm.hlsServer != nil {
 		sm.hlsServer.Dispose()
 	}
+	if sm.rtspServer != nil {
+		sm.rtspServer.Dispose()
+	}
+	if sm.httpAPIServer != nil {
+		sm.httpAPIServer.Dispose()
+	}
+	sm.notify.Dispose()
 
 	sm.mutex.Lock()
 	for _, group := range sm.groupMap {
@@ -128,15 +236,40 @@ func (sm *ServerManager) Dispose() {
 }
 
 // ServerObserver of rtmp.Server
-func (sm *ServerManager) OnNewRTMPPubSession(session *rtmp.ServerSession) bool {
+func (sm *ServerManager) OnNewRTMPPubSession(session *rtmp.ServerSession) error {
+	if !checkAppName(session.UniqueKey, session.AppName) {
+		return &rtmp.StatusError{Code: rtmp.StatusCodePublishBadName, Description: "Empty app name"}
+	}
+	info := AuthInfo{
+		Protocol:   AuthProtocolRTMP,
+		AppName:    session.AppName,
+		StreamName: session.StreamName,
+		RawQuery:   session.RawQuery,
+	}
+	if err := sm.authPub(session.UniqueKey, info); err != nil {
+		return &rtmp.StatusError{Code: rtmp.StatusCodePublishBadName, Description: "Auth failed"}
+	}
+	result := sm.admission.OnPublish(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	if !result.Allow {
+		return &rtmp.StatusError{Code: rtmp.StatusCodePublishBadName, Description: admissionRejectReason(result)}
+	}
+	session.StreamName = rewriteStreamName(session.UniqueKey, session.StreamName, result)
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	group := sm.getOrCreateGroup(session.AppName, session.StreamName)
-	return group.AddRTMPPubSession(session)
+	if !group.AddRTMPPubSession(session) {
+		return &rtmp.StatusError{Code: rtmp.StatusCodePublishBadName, Description: "Stream already publishing"}
+	}
+	group.SetSessionLimit(session.UniqueKey, result.Limit)
+	sm.notify.OnPubStart(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	return nil
 }
 
 // ServerObserver of rtmp.Server
 func (sm *ServerManager) OnDelRTMPPubSession(session *rtmp.ServerSession) {
+	sm.notify.OnPubStop(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	group := sm.getGroup(session.AppName, session.StreamName)
@@ -146,16 +279,38 @@ func (sm *ServerManager) OnDelRTMPPubSession(session *rtmp.ServerSession) {
 }
 
 // ServerObserver of rtmp.Server
-func (sm *ServerManager) OnNewRTMPSubSession(session *rtmp.ServerSession) bool {
+func (sm *ServerManager) OnNewRTMPSubSession(session *rtmp.ServerSession) error {
+	if !checkAppName(session.UniqueKey, session.AppName) {
+		return &rtmp.StatusError{Code: rtmp.StatusCodePlayFailed, Description: "Empty app name"}
+	}
+	info := AuthInfo{
+		Protocol:   AuthProtocolRTMP,
+		AppName:    session.AppName,
+		StreamName: session.StreamName,
+		RawQuery:   session.RawQuery,
+	}
+	if err := sm.authSub(session.UniqueKey, info); err != nil {
+		return &rtmp.StatusError{Code: rtmp.StatusCodePlayFailed, Description: "Auth failed"}
+	}
+	result := sm.admission.OnPlay(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	if !result.Allow {
+		return &rtmp.StatusError{Code: rtmp.StatusCodePlayFailed, Description: admissionRejectReason(result)}
+	}
+	session.StreamName = rewriteStreamName(session.UniqueKey, session.StreamName, result)
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	group := sm.getOrCreateGroup(session.AppName, session.StreamName)
 	group.AddRTMPSubSession(session)
-	return true
+	group.SetSessionLimit(session.UniqueKey, result.Limit)
+	sm.notify.OnSubStart(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	return nil
 }
 
 // ServerObserver of rtmp.Server
 func (sm *ServerManager) OnDelRTMPSubSession(session *rtmp.ServerSession) {
+	sm.notify.OnSubStop(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	group := sm.getGroup(session.AppName, session.StreamName)
@@ -166,15 +321,38 @@ func (sm *ServerManager) OnDelRTMPSubSession(session *rtmp.ServerSession) {
 
 // ServerObserver of httpflv.Server
 func (sm *ServerManager) OnNewHTTPFLVSubSession(session *httpflv.SubSession) bool {
+	if !checkAppName(session.UniqueKey, session.AppName) {
+		return false
+	}
+	info := AuthInfo{
+		Protocol:   AuthProtocolHTTPFLV,
+		AppName:    session.AppName,
+		StreamName: session.StreamName,
+		RawQuery:   session.RawQuery,
+	}
+	if err := sm.authSub(session.UniqueKey, info); err != nil {
+		return false
+	}
+	result := sm.admission.OnPlay(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	if !result.Allow {
+		nazalog.Warnf("[%s] rejected by admission hook. reason=%s", session.UniqueKey, admissionRejectReason(result))
+		return false
+	}
+	session.StreamName = rewriteStreamName(session.UniqueKey, session.StreamName, result)
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	group := sm.getOrCreateGroup(session.AppName, session.StreamName)
 	group.AddHTTPFLVSubSession(session)
+	group.SetSessionLimit(session.UniqueKey, result.Limit)
+	sm.notify.OnSubStart(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
 	return true
 }
 
 // ServerObserver of httpflv.Server
 func (sm *ServerManager) OnDelHTTPFLVSubSession(session *httpflv.SubSession) {
+	sm.notify.OnSubStop(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
 	group := sm.getGroup(session.AppName, session.StreamName)
@@ -183,6 +361,207 @@ func (sm *ServerManager) OnDelHTTPFLVSubSession(session *httpflv.SubSession) {
 	}
 }
 
+// ServerObserver of rtsp.Server
+func (sm *ServerManager) OnNewRTSPPubSession(session *rtsp.PubSession) bool {
+	if !checkAppName(session.UniqueKey, session.AppName) {
+		return false
+	}
+	info := AuthInfo{
+		Protocol:   AuthProtocolRTSP,
+		AppName:    session.AppName,
+		StreamName: session.StreamName,
+		RawQuery:   session.RawQuery,
+	}
+	if err := sm.authPub(session.UniqueKey, info); err != nil {
+		return false
+	}
+	result := sm.admission.OnPublish(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	if !result.Allow {
+		nazalog.Warnf("[%s] rtsp publish refused by admission hook. reason=%s", session.UniqueKey, admissionRejectReason(result))
+		return false
+	}
+	session.StreamName = rewriteStreamName(session.UniqueKey, session.StreamName, result)
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getOrCreateGroup(session.AppName, session.StreamName)
+	if !group.AddRTSPPubSession(session) {
+		return false
+	}
+	group.SetSessionLimit(session.UniqueKey, result.Limit)
+	sm.notify.OnPubStart(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	return true
+}
+
+// ServerObserver of rtsp.Server
+func (sm *ServerManager) OnDelRTSPPubSession(session *rtsp.PubSession) {
+	sm.notify.OnPubStop(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(session.AppName, session.StreamName)
+	if group != nil {
+		group.DelRTSPPubSession(session)
+	}
+}
+
+// ServerObserver of rtsp.Server
+//
+// rtsp拉流在DESCRIBE时就会返回流的音视频头信息，所以在这里做鉴权和准入回调，准入回调返回的限制也在这里设置
+func (sm *ServerManager) OnNewRTSPSubSessionDescribe(session *rtsp.SubSession) bool {
+	if !checkAppName(session.UniqueKey, session.AppName) {
+		return false
+	}
+	if err := sm.authSub(session.UniqueKey, newRTSPSubAuthInfo(session)); err != nil {
+		return false
+	}
+	result := sm.admission.OnPlay(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	if !result.Allow {
+		nazalog.Warnf("[%s] rtsp play refused by admission hook. reason=%s", session.UniqueKey, admissionRejectReason(result))
+		return false
+	}
+	// PLAY时会使用url中的流名再次鉴权，所以rtsp拉流不支持改写流名
+	if result.StreamName != "" && result.StreamName != session.StreamName {
+		nazalog.Warnf("[%s] ignore stream name rewrite of rtsp play. %s -> %s", session.UniqueKey, session.StreamName, result.StreamName)
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(session.AppName, session.StreamName)
+	if group == nil {
+		return false
+	}
+	if !group.HandleNewRTSPSubSessionDescribe(session) {
+		return false
+	}
+	group.SetSessionLimit(session.UniqueKey, result.Limit)
+	return true
+}
+
+// ServerObserver of rtsp.Server
+//
+// 鉴权已经在DESCRIBE时做过，这里再检查一次，比如DESCRIBE和PLAY之间签名过期了
+func (sm *ServerManager) OnNewRTSPSubSessionPlay(session *rtsp.SubSession) bool {
+	if err := sm.authSub(session.UniqueKey, newRTSPSubAuthInfo(session)); err != nil {
+		return false
+	}
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(session.AppName, session.StreamName)
+	if group == nil {
+		return false
+	}
+	group.AddRTSPSubSession(session)
+	sm.notify.OnSubStart(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	return true
+}
+
+// ServerObserver of rtsp.Server
+func (sm *ServerManager) OnDelRTSPSubSession(session *rtsp.SubSession) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(session.AppName, session.StreamName)
+	if group == nil {
+		return
+	}
+	// 只DESCRIBE没有PLAY的session，没有通知过sub开始，也不通知sub结束
+	if group.DelRTSPSubSession(session) {
+		sm.notify.OnSubStop(newSessionEventInfo(session.GetStat(), session.AppName, session.StreamName, session.RawQuery))
+	}
+}
+
+// hls.ServerObserver
+func (sm *ServerManager) OnHLSRequest(appName string, streamName string) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(appName, streamName)
+	if group == nil {
+		return
+	}
+	group.UpdateLastSubTime()
+}
+
+func (sm *ServerManager) StatAllGroup() []StatGroup {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	ret := make([]StatGroup, 0, len(sm.groupMap))
+	for _, group := range sm.groupMap {
+		ret = append(ret, group.GetStat())
+	}
+	return ret
+}
+
+// @return 流不存在时返回nil
+func (sm *ServerManager) StatGroup(appName string, streamName string) *StatGroup {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(appName, streamName)
+	if group == nil {
+		return nil
+	}
+	stat := group.GetStat()
+	return &stat
+}
+
+func (sm *ServerManager) CtrlKickSession(appName string, streamName string, sessionID string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(appName, streamName)
+	if group == nil {
+		return ErrGroupNotFound
+	}
+	if !group.KickSession(sessionID) {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (sm *ServerManager) collectGroupMetrics() []groupMetrics {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	ret := make([]groupMetrics, 0, len(sm.groupMap))
+	for _, group := range sm.groupMap {
+		ret = append(ret, group.getMetrics())
+	}
+	return ret
+}
+
+// 流不存在时会创建
+func (sm *ServerManager) CtrlStartRelayPull(appName string, streamName string, url string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.getOrCreateGroup(appName, streamName).StartRelayPull(url)
+}
+
+func (sm *ServerManager) CtrlStopRelayPull(appName string, streamName string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(appName, streamName)
+	if group == nil {
+		return ErrGroupNotFound
+	}
+	group.StopRelayPull()
+	return nil
+}
+
+// 流不存在时会创建
+func (sm *ServerManager) CtrlStartRelayPush(appName string, streamName string, url string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	return sm.getOrCreateGroup(appName, streamName).StartRelayPush(url)
+}
+
+func (sm *ServerManager) CtrlStopRelayPush(appName string, streamName string, url string) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+	group := sm.getGroup(appName, streamName)
+	if group == nil {
+		return ErrGroupNotFound
+	}
+	return group.StopRelayPush(url)
+}
+
 func (sm *ServerManager) iterateGroup() {
 	sm.mutex.Lock()
 	defer sm.mutex.Unlock()
@@ -198,11 +577,35 @@ func (sm *ServerManager) iterateGroup() {
 	}
 }
 
+// 注意，鉴权可能比较耗时（比如自定义的Authenticator请求外部服务），所以不在锁内调用
+func (sm *ServerManager) authPub(uk string, info AuthInfo) error {
+	if sm.option.Authenticator == nil {
+		return nil
+	}
+	err := sm.option.Authenticator.AuthPub(info)
+	if err != nil {
+		nazalog.Warnf("[%s] auth pub failed. info=%+v, err=%v", uk, info, err)
+	}
+	return err
+}
+
+func (sm *ServerManager) authSub(uk string, info AuthInfo) error {
+	if sm.option.Authenticator == nil {
+		return nil
+	}
+	err := sm.option.Authenticator.AuthSub(info)
+	if err != nil {
+		nazalog.Warnf("[%s] auth sub failed. info=%+v, err=%v", uk, info, err)
+	}
+	return err
+}
+
 func (sm *ServerManager) getOrCreateGroup(appName string, streamName string) *Group {
-	group, exist := sm.groupMap[streamName]
+	key := groupKey{appName: appName, streamName: streamName}
+	group, exist := sm.groupMap[key]
 	if !exist {
-		group = NewGroup(appName, streamName)
-		sm.groupMap[streamName] = group
+		group = NewGroup(appName, streamName, sm.notify)
+		sm.groupMap[key] = group
 
 		go group.RunLoop()
 	}
@@ -210,9 +613,43 @@ func (sm *ServerManager) getOrCreateGroup(appName string, streamName string) *Gr
 }
 
 func (sm *ServerManager) getGroup(appName string, streamName string) *Group {
-	group, exist := sm.groupMap[streamName]
+	group, exist := sm.groupMap[groupKey{appName: appName, streamName: streamName}]
 	if !exist {
 		return nil
 	}
 	return group
 }
+
+func admissionRejectReason(result AdmissionResult) string {
+	if result.Reason == "" {
+		return "Admission rejected"
+	}
+	return result.Reason
+}
+
+func newRTSPSubAuthInfo(session *rtsp.SubSession) AuthInfo {
+	return AuthInfo{
+		Protocol:   AuthProtocolRTSP,
+		AppName:    session.AppName,
+		StreamName: session.StreamName,
+		RawQuery:   session.RawQuery,
+	}
+}
+
+// 准入回调返回了新的流名时，使用新的流名
+func rewriteStreamName(uk string, streamName string, result AdmissionResult) string {
+	if result.StreamName == "" || result.StreamName == streamName {
+		return streamName
+	}
+	nazalog.Infof("[%s] rewrite stream name by admission hook. %s -> %s", uk, streamName, result.StreamName)
+	return result.StreamName
+}
+
+// app名为空的流，HLS无法按/hls/{app}/{stream}/访问，所以不允许推拉
+func checkAppName(uk string, appName string) bool {
+	if appName == "" {
+		nazalog.Warnf("[%s] app name is empty.", uk)
+		return false
+	}
+	return true
+}
@@ -12,3 +12,11 @@ var relayPushCheckIntervalMS = 1000
 var relayPushConnectTimeoutMS = 5000
 var relayPushTimeoutMS = 5000
 var relayPushWriteAVTimeoutMS = 5000
+
+var relayPullTimeoutMS = 5000
+var relayPullReadAVTimeoutMS = 10000
+
+var notifyRetryIntervalMS = 1000
+
+// session加入后的这段时间内，不检查准入回调设置的码率限制，比如sub加入时会一次性发送gop缓存
+var sessionLimitBitrateGraceMS = 10000
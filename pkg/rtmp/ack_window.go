@@ -0,0 +1,112 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+// ack_window.go
+// @pure
+// rtmp acknowledgement窗口
+//
+// - 作为接收方，对端通知了窗口大小（Window Acknowledgement Size）后，每收到窗口大小的字节数，回复一个Acknowledgement
+// - 作为发送方，记录对端Acknowledgement中的sequence number，用于计算ack lag，也即已发送但对端还没有确认的字节数
+// - 收到对端的Set Peer Bandwidth后，根据限制类型更新本端的窗口大小，窗口大小发生变化时需要通知对端
+//
+// 注意，sequence number不包含握手阶段的字节数
+//
+// 除了peerAckSeq，其他字段都只在读协程中访问
+
+import "sync/atomic"
+
+type ackWindow struct {
+	baseReadBytes  uint64 // 握手结束时已读取的字节数
+	baseWroteBytes uint64 // 握手结束时已写入的字节数
+
+	peerWinAckSize   uint32 // 对端通知的窗口大小，为0时表示对端没有通知，不回复Acknowledgement
+	lastAckReadBytes uint64 // 上一次回复Acknowledgement时已读取的字节数，不包含握手阶段
+
+	winAckSize    uint32 // 本端通知对端的窗口大小，为0时表示还没有通知过
+	hasLimitType  bool
+	lastLimitType uint8
+
+	hasPeerAck uint32 // 原子操作，对端是否回复过Acknowledgement
+	peerAckSeq uint32 // 原子操作，对端最近一次Acknowledgement中的sequence number
+}
+
+func (a *ackWindow) onHandshakeDone(readBytes uint64, wroteBytes uint64) {
+	a.baseReadBytes = readBytes
+	a.baseWroteBytes = wroteBytes
+}
+
+func (a *ackWindow) onPeerWinAckSize(size uint32) {
+	a.peerWinAckSize = size
+}
+
+// 本端发送Window Acknowledgement Size后调用
+func (a *ackWindow) onWinAckSizeSent(size uint32) {
+	a.winAckSize = size
+}
+
+// @param readBytes 连接上已读取的字节数，包含握手阶段
+//
+// @return 是否需要回复Acknowledgement，需要时，第1个参数为Acknowledgement中的sequence number
+func (a *ackWindow) checkAck(readBytes uint64) (uint32, bool) {
+	if a.peerWinAckSize == 0 {
+		return 0, false
+	}
+	n := readBytes - a.baseReadBytes
+	if n-a.lastAckReadBytes < uint64(a.peerWinAckSize) {
+		return 0, false
+	}
+	a.lastAckReadBytes = n
+	return uint32(n), true
+}
+
+func (a *ackWindow) onPeerAck(seq uint32) {
+	atomic.StoreUint32(&a.peerAckSeq, seq)
+	atomic.StoreUint32(&a.hasPeerAck, 1)
+}
+
+// 已发送但对端还没有确认的字节数，对端没有回复过Acknowledgement时，为已发送的字节数
+//
+// @param wroteBytes 连接上已写入的字节数，包含握手阶段
+func (a *ackWindow) ackLag(wroteBytes uint64) uint32 {
+	n := uint32(wroteBytes - a.baseWroteBytes)
+	if atomic.LoadUint32(&a.hasPeerAck) == 0 {
+		return n
+	}
+	// 注意，sequence number是32位的，可能发生翻转，这里使用无符号数的减法
+	return n - atomic.LoadUint32(&a.peerAckSeq)
+}
+
+// 见rtmp协议5.4.5
+//
+// @return 本端的窗口大小是否发生变化，发生变化时需要向对端发送Window Acknowledgement Size，第1个参数为新的窗口大小
+func (a *ackWindow) onPeerBandwidth(size uint32, limitType uint8) (uint32, bool) {
+	switch limitType {
+	case peerBandwidthLimitTypeHard:
+		// noop
+	case peerBandwidthLimitTypeSoft:
+		if a.winAckSize != 0 && a.winAckSize < size {
+			size = a.winAckSize
+		}
+	case peerBandwidthLimitTypeDynamic:
+		// 上一次为hard时，当作hard处理，否则忽略
+		if !a.hasLimitType || a.lastLimitType != peerBandwidthLimitTypeHard {
+			return 0, false
+		}
+		limitType = peerBandwidthLimitTypeHard
+	default:
+		return 0, false
+	}
+	a.hasLimitType = true
+	a.lastLimitType = limitType
+	if size == a.winAckSize {
+		return 0, false
+	}
+	return size, true
+}
@@ -0,0 +1,87 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAckWindow_CheckAck(t *testing.T) {
+	var a ackWindow
+	a.onHandshakeDone(3073, 3073)
+
+	// 对端没有通知窗口大小，不回复
+	_, ok := a.checkAck(100000)
+	assert.Equal(t, false, ok)
+
+	a.onPeerWinAckSize(1000)
+	_, ok = a.checkAck(3073 + 999)
+	assert.Equal(t, false, ok)
+	seq, ok := a.checkAck(3073 + 1500)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint32(1500), seq)
+	_, ok = a.checkAck(3073 + 2000)
+	assert.Equal(t, false, ok)
+	seq, ok = a.checkAck(3073 + 2500)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, uint32(2500), seq)
+}
+
+func TestAckWindow_AckLag(t *testing.T) {
+	var a ackWindow
+	a.onHandshakeDone(3073, 3073)
+	assert.Equal(t, uint32(100), a.ackLag(3073+100))
+	a.onPeerAck(60)
+	assert.Equal(t, uint32(40), a.ackLag(3073+100))
+
+	// sequence number翻转
+	a.onHandshakeDone(0, 0)
+	a.onPeerAck(0xFFFFFFF0)
+	assert.Equal(t, uint32(0x20), a.ackLag(0x100000010))
+}
+
+func TestAckWindow_OnPeerBandwidth(t *testing.T) {
+	var a ackWindow
+
+	// 之前不是hard，忽略dynamic
+	_, changed := a.onPeerBandwidth(1000, peerBandwidthLimitTypeDynamic)
+	assert.Equal(t, false, changed)
+
+	size, changed := a.onPeerBandwidth(1000, peerBandwidthLimitTypeHard)
+	assert.Equal(t, true, changed)
+	assert.Equal(t, uint32(1000), size)
+	a.onWinAckSizeSent(size)
+
+	// 之前是hard，dynamic当作hard处理
+	size, changed = a.onPeerBandwidth(2000, peerBandwidthLimitTypeDynamic)
+	assert.Equal(t, true, changed)
+	assert.Equal(t, uint32(2000), size)
+	a.onWinAckSizeSent(size)
+
+	// soft取较小值
+	_, changed = a.onPeerBandwidth(3000, peerBandwidthLimitTypeSoft)
+	assert.Equal(t, false, changed)
+	size, changed = a.onPeerBandwidth(500, peerBandwidthLimitTypeSoft)
+	assert.Equal(t, true, changed)
+	assert.Equal(t, uint32(500), size)
+	a.onWinAckSizeSent(size)
+
+	// 之前是soft，忽略dynamic
+	_, changed = a.onPeerBandwidth(4000, peerBandwidthLimitTypeDynamic)
+	assert.Equal(t, false, changed)
+
+	// 大小没有变化
+	_, changed = a.onPeerBandwidth(500, peerBandwidthLimitTypeHard)
+	assert.Equal(t, false, changed)
+
+	_, changed = a.onPeerBandwidth(500, 3)
+	assert.Equal(t, false, changed)
+}
@@ -28,20 +28,23 @@ var (
 )
 
 const (
-	AMF0TypeMarkerNumber     = uint8(0x00)
-	AMF0TypeMarkerBoolean    = uint8(0x01)
-	AMF0TypeMarkerString     = uint8(0x02)
-	AMF0TypeMarkerObject     = uint8(0x03)
-	AMF0TypeMarkerNull       = uint8(0x05)
-	AMF0TypeMarkerEcmaArray  = uint8(0x08)
-	AMF0TypeMarkerObjectEnd  = uint8(0x09)
-	AMF0TypeMarkerLongString = uint8(0x0c)
+	AMF0TypeMarkerNumber      = uint8(0x00)
+	AMF0TypeMarkerBoolean     = uint8(0x01)
+	AMF0TypeMarkerString      = uint8(0x02)
+	AMF0TypeMarkerObject      = uint8(0x03)
+	AMF0TypeMarkerNull        = uint8(0x05)
+	AMF0TypeMarkerEcmaArray   = uint8(0x08)
+	AMF0TypeMarkerObjectEnd   = uint8(0x09)
+	AMF0TypeMarkerStrictArray = uint8(0x0a)
+	AMF0TypeMarkerLongString  = uint8(0x0c)
+
+	// 之后的一个值使用AMF3编码
+	AMF0TypeMarkerAVMPlusObject = uint8(0x11)
 
 	// 还没用到的类型
 	//AMF0TypeMarkerMovieclip   = uint8(0x04)
 	//AMF0TypeMarkerUndefined   = uint8(0x06)
 	//AMF0TypeMarkerReference   = uint8(0x07)
-	//AMF0TypeMarkerStrictArray = uint8(0x0a)
 	//AMF0TypeMarkerData        = uint8(0x0b)
 	//AMF0TypeMarkerUnsupported = uint8(0x0d)
 	//AMF0TypeMarkerRecordset   = uint8(0x0e)
@@ -152,6 +155,22 @@ func (amf0) WriteObject(writer io.Writer, opa ObjectPairArray) error {
 			if err := AMF0.WriteBoolean(writer, opa[i].Value.(bool)); err != nil {
 				return err
 			}
+		case float64:
+			if err := AMF0.WriteNumber(writer, opa[i].Value.(float64)); err != nil {
+				return err
+			}
+		case nil:
+			if err := AMF0.WriteNull(writer); err != nil {
+				return err
+			}
+		case ObjectPairArray:
+			if err := AMF0.WriteObject(writer, opa[i].Value.(ObjectPairArray)); err != nil {
+				return err
+			}
+		case []string:
+			if err := AMF0.WriteStringStrictArray(writer, opa[i].Value.([]string)); err != nil {
+				return err
+			}
 		default:
 			nazalog.Panicf("unknown value type. i=%d, v=%+v", i, opa[i].Value)
 		}
@@ -160,6 +179,22 @@ func (amf0) WriteObject(writer io.Writer, opa ObjectPairArray) error {
 	return err
 }
 
+// 元素都是string类型的strict array，比如Enhanced RTMP中的fourCcList
+func (amf0) WriteStringStrictArray(writer io.Writer, val []string) error {
+	if _, err := writer.Write([]byte{AMF0TypeMarkerStrictArray}); err != nil {
+		return err
+	}
+	if err := bele.WriteBE(writer, uint32(len(val))); err != nil {
+		return err
+	}
+	for _, v := range val {
+		if err := AMF0.WriteString(writer, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ----------------------------------------------------------------------------
 // read类型的方法集合
 //
@@ -293,12 +328,68 @@ func (amf0) ReadObject(b []byte) (ObjectPairArray, int, error) {
 			}
 			ops = append(ops, ObjectPair{k, v})
 			index += l
+		case AMF0TypeMarkerStrictArray:
+			v, l, err := AMF0.ReadStrictArray(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l
+		case AMF0TypeMarkerAVMPlusObject:
+			v, l, err := AMF0.ReadAVMPlusObject(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l
 		default:
 			nazalog.Panicf("unknown type. vt=%d", vt)
 		}
 	}
 }
 
+// 目前只支持元素为string，number，boolean类型的strict array
+//
+// @return 第1个参数中的元素类型为string，float64或bool
+func (amf0) ReadStrictArray(b []byte) ([]interface{}, int, error) {
+	if len(b) < 5 {
+		return nil, 0, ErrAMFTooShort
+	}
+	if b[0] != AMF0TypeMarkerStrictArray {
+		return nil, 0, ErrAMFInvalidType
+	}
+	count := int(bele.BEUint32(b[1:]))
+
+	index := 5
+	var vals []interface{}
+	for i := 0; i < count; i++ {
+		if len(b)-index < 1 {
+			return nil, 0, ErrAMFTooShort
+		}
+		var (
+			v   interface{}
+			l   int
+			err error
+		)
+		switch b[index] {
+		case AMF0TypeMarkerString, AMF0TypeMarkerLongString:
+			v, l, err = AMF0.ReadString(b[index:])
+		case AMF0TypeMarkerBoolean:
+			v, l, err = AMF0.ReadBoolean(b[index:])
+		case AMF0TypeMarkerNumber:
+			v, l, err = AMF0.ReadNumber(b[index:])
+		default:
+			err = ErrAMFInvalidType
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		vals = append(vals, v)
+		index += l
+	}
+	return vals, index, nil
+}
+
 // TODO chef:
 // - 实现WriteArray
 // - ReadArray和ReadObject有些代码重复
@@ -346,6 +437,13 @@ func (amf0) ReadArray(b []byte) (ObjectPairArray, int, error) {
 			}
 			ops = append(ops, ObjectPair{k, v})
 			index += l
+		case AMF0TypeMarkerAVMPlusObject:
+			v, l, err := AMF0.ReadAVMPlusObject(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			ops = append(ops, ObjectPair{k, v})
+			index += l
 		default:
 			nazalog.Panicf("unknown type. vt=%d", vt)
 		}
@@ -360,6 +458,25 @@ func (amf0) ReadArray(b []byte) (ObjectPairArray, int, error) {
 	return ops, index, nil
 }
 
+// 读取avmplus-object-marker以及之后的一个AMF3编码的值
+//
+// 注意，每个avmplus-object-marker使用独立的AMF3引用表
+//
+// @return 第1个参数的类型见AMF3Reader.ReadValue
+func (amf0) ReadAVMPlusObject(b []byte) (interface{}, int, error) {
+	if len(b) < 1 {
+		return nil, 0, ErrAMFTooShort
+	}
+	if b[0] != AMF0TypeMarkerAVMPlusObject {
+		return nil, 0, ErrAMFInvalidType
+	}
+	v, l, err := NewAMF3Reader().ReadValue(b[1:])
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, l + 1, nil
+}
+
 func (amf0) ReadObjectOrArray(b []byte) (ObjectPairArray, int, error) {
 	if len(b) < 1 {
 		return nil, 0, ErrAMFTooShort
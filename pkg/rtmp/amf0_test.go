@@ -83,6 +83,44 @@ func TestAmf0_WriteObject_ReadObject(t *testing.T) {
 	assert.Equal(t, true, v.Find("dog"))
 }
 
+func TestAmf0_WriteStringStrictArray_ReadStrictArray(t *testing.T) {
+	out := &bytes.Buffer{}
+	objs := []ObjectPair{
+		{Key: "app", Value: "live"},
+		{Key: "fourCcList", Value: []string{"hvc1", "av01"}},
+		{Key: "dog", Value: true},
+	}
+	err := AMF0.WriteObject(out, objs)
+	assert.Equal(t, nil, err)
+	v, l, err := AMF0.ReadObject(out.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, out.Len(), l)
+	assert.Equal(t, 3, len(v))
+	assert.Equal(t, []interface{}{"hvc1", "av01"}, v.Find("fourCcList"))
+	assert.Equal(t, true, v.Find("dog"))
+
+	// 元素类型混合
+	b := []byte{AMF0TypeMarkerStrictArray, 0, 0, 0, 3}
+	out.Reset()
+	_ = AMF0.WriteString(out, "vp09")
+	_ = AMF0.WriteNumber(out, 1)
+	_ = AMF0.WriteBoolean(out, false)
+	b = append(b, out.Bytes()...)
+	vals, l, err := AMF0.ReadStrictArray(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(b), l)
+	assert.Equal(t, []interface{}{"vp09", float64(1), false}, vals)
+
+	_, _, err = AMF0.ReadStrictArray(b[:len(b)-1])
+	assert.Equal(t, ErrAMFTooShort, err)
+	_, _, err = AMF0.ReadStrictArray([]byte{AMF0TypeMarkerStrictArray, 0, 0, 0, 1, AMF0TypeMarkerNull})
+	assert.Equal(t, ErrAMFInvalidType, err)
+	_, _, err = AMF0.ReadStrictArray([]byte{AMF0TypeMarkerStrictArray, 0, 0, 0})
+	assert.Equal(t, ErrAMFTooShort, err)
+	_, _, err = AMF0.ReadStrictArray([]byte{AMF0TypeMarkerEcmaArray, 0, 0, 0, 0})
+	assert.Equal(t, ErrAMFInvalidType, err)
+}
+
 func TestAmf0_WriteNull_readNull(t *testing.T) {
 	out := &bytes.Buffer{}
 	err := AMF0.WriteNull(out)
@@ -0,0 +1,519 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+// amf3.go
+// @pure
+// AMF3的序列化和反序列化，见 amf3_spec_121207.pdf
+//
+// AMF3中的字符串，对象等可以通过索引引用之前出现过的值，所以读写时需要维护引用表，
+// 引用表的作用范围为一个AMF3上下文，比如AMF0中的一个avmplus-object-marker，
+// 所以AMF3Reader和AMF3Writer都是有状态的，不同的上下文需要使用不同的对象
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+const (
+	AMF3TypeMarkerUndefined    = uint8(0x00)
+	AMF3TypeMarkerNull         = uint8(0x01)
+	AMF3TypeMarkerFalse        = uint8(0x02)
+	AMF3TypeMarkerTrue         = uint8(0x03)
+	AMF3TypeMarkerInteger      = uint8(0x04)
+	AMF3TypeMarkerDouble       = uint8(0x05)
+	AMF3TypeMarkerString       = uint8(0x06)
+	AMF3TypeMarkerXMLDocument  = uint8(0x07)
+	AMF3TypeMarkerDate         = uint8(0x08)
+	AMF3TypeMarkerArray        = uint8(0x09)
+	AMF3TypeMarkerObject       = uint8(0x0a)
+	AMF3TypeMarkerXML          = uint8(0x0b)
+	AMF3TypeMarkerByteArray    = uint8(0x0c)
+	AMF3TypeMarkerVectorInt    = uint8(0x0d)
+	AMF3TypeMarkerVectorUint   = uint8(0x0e)
+	AMF3TypeMarkerVectorDouble = uint8(0x0f)
+	AMF3TypeMarkerVectorObject = uint8(0x10)
+	AMF3TypeMarkerDictionary   = uint8(0x11)
+)
+
+// integer类型可以表示的范围，29位有符号整数
+const (
+	amf3IntegerMax = 1<<28 - 1
+	amf3IntegerMin = -(1 << 28)
+)
+
+type amf3Traits struct {
+	className      string
+	externalizable bool
+	dynamic        bool
+	members        []string
+}
+
+// ----------------------------------------------------------------------------
+
+type AMF3Reader struct {
+	strRefs    []string
+	objRefs    []interface{}
+	traitsRefs []amf3Traits
+}
+
+func NewAMF3Reader() *AMF3Reader {
+	return &AMF3Reader{}
+}
+
+// 从<b>中读取一个AMF3类型的值
+//
+// 返回的值的类型如下：
+// - undefined和null为nil
+// - false和true为bool
+// - integer和double为float64，和AMF0保持一致
+// - string，xml document和xml为string
+// - date为time.Time
+// - 只有dense部分的array为[]interface{}，有associative部分时为ObjectPairArray，dense部分的key为下标
+// - object为ObjectPairArray，不包含类名
+// - byte array为[]byte
+//
+// 注意，vector，dictionary以及externalizable的object不支持，返回ErrAMFInvalidType
+//
+// @return 第2个参数为读取时从<b>消耗的字节大小
+func (r *AMF3Reader) ReadValue(b []byte) (interface{}, int, error) {
+	if len(b) < 1 {
+		return nil, 0, ErrAMFTooShort
+	}
+
+	var (
+		v   interface{}
+		l   int
+		err error
+	)
+	switch b[0] {
+	case AMF3TypeMarkerUndefined, AMF3TypeMarkerNull:
+		return nil, 1, nil
+	case AMF3TypeMarkerFalse:
+		return false, 1, nil
+	case AMF3TypeMarkerTrue:
+		return true, 1, nil
+	case AMF3TypeMarkerInteger:
+		var u uint32
+		u, l, err = readU29(b[1:])
+		// 29位有符号整数
+		if u&0x10000000 != 0 {
+			v = float64(int32(u | 0xE0000000))
+		} else {
+			v = float64(u)
+		}
+	case AMF3TypeMarkerDouble:
+		if len(b) < 9 {
+			return nil, 0, ErrAMFTooShort
+		}
+		return bele.BEFloat64(b[1:]), 9, nil
+	case AMF3TypeMarkerString:
+		v, l, err = r.readString(b[1:])
+	case AMF3TypeMarkerXMLDocument, AMF3TypeMarkerXML:
+		v, l, err = r.readXML(b[1:])
+	case AMF3TypeMarkerDate:
+		v, l, err = r.readDate(b[1:])
+	case AMF3TypeMarkerArray:
+		v, l, err = r.readArray(b[1:])
+	case AMF3TypeMarkerObject:
+		v, l, err = r.readObject(b[1:])
+	case AMF3TypeMarkerByteArray:
+		v, l, err = r.readByteArray(b[1:])
+	default:
+		nazalog.Warnf("unsupported amf3 type. marker=%d", b[0])
+		return nil, 0, ErrAMFInvalidType
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, l + 1, nil
+}
+
+// 读取不包含type marker的string，也即UTF-8-vr
+func (r *AMF3Reader) readString(b []byte) (string, int, error) {
+	u, l, err := readU29(b)
+	if err != nil {
+		return "", 0, err
+	}
+	if u&1 == 0 {
+		index := int(u >> 1)
+		if index >= len(r.strRefs) {
+			return "", 0, ErrAMFInvalidType
+		}
+		return r.strRefs[index], l, nil
+	}
+
+	n := int(u >> 1)
+	if len(b)-l < n {
+		return "", 0, ErrAMFTooShort
+	}
+	str := string(b[l : l+n])
+	// 空字符串不放入引用表
+	if n > 0 {
+		r.strRefs = append(r.strRefs, str)
+	}
+	return str, l + n, nil
+}
+
+// 读取object类型（array，date，xml，byte array等）开头的U29，它可能是一个引用，也可能是内联值的长度
+//
+// @return 第1个参数为引用的对象，第2个参数为内联值的长度，第3个参数为是否是引用，第4个参数为消耗的字节大小
+func (r *AMF3Reader) readObjectRefOrLength(b []byte) (interface{}, int, bool, int, error) {
+	u, l, err := readU29(b)
+	if err != nil {
+		return nil, 0, false, 0, err
+	}
+	if u&1 == 0 {
+		index := int(u >> 1)
+		if index >= len(r.objRefs) {
+			return nil, 0, false, 0, ErrAMFInvalidType
+		}
+		return r.objRefs[index], 0, true, l, nil
+	}
+	return nil, int(u >> 1), false, l, nil
+}
+
+func (r *AMF3Reader) readXML(b []byte) (interface{}, int, error) {
+	ref, n, isRef, l, err := r.readObjectRefOrLength(b)
+	if err != nil || isRef {
+		return ref, l, err
+	}
+	if len(b)-l < n {
+		return nil, 0, ErrAMFTooShort
+	}
+	str := string(b[l : l+n])
+	r.objRefs = append(r.objRefs, str)
+	return str, l + n, nil
+}
+
+func (r *AMF3Reader) readDate(b []byte) (interface{}, int, error) {
+	ref, _, isRef, l, err := r.readObjectRefOrLength(b)
+	if err != nil || isRef {
+		return ref, l, err
+	}
+	if len(b)-l < 8 {
+		return nil, 0, ErrAMFTooShort
+	}
+	ms := bele.BEFloat64(b[l:])
+	t := time.Unix(0, int64(ms)*int64(time.Millisecond))
+	r.objRefs = append(r.objRefs, t)
+	return t, l + 8, nil
+}
+
+func (r *AMF3Reader) readByteArray(b []byte) (interface{}, int, error) {
+	ref, n, isRef, l, err := r.readObjectRefOrLength(b)
+	if err != nil || isRef {
+		return ref, l, err
+	}
+	if len(b)-l < n {
+		return nil, 0, ErrAMFTooShort
+	}
+	ba := append([]byte(nil), b[l:l+n]...)
+	r.objRefs = append(r.objRefs, ba)
+	return ba, l + n, nil
+}
+
+func (r *AMF3Reader) readArray(b []byte) (interface{}, int, error) {
+	ref, count, isRef, index, err := r.readObjectRefOrLength(b)
+	if err != nil || isRef {
+		return ref, index, err
+	}
+
+	// 先占位，数组中的元素可能引用这个数组
+	refIndex := len(r.objRefs)
+	r.objRefs = append(r.objRefs, nil)
+
+	// associative部分，以空字符串结束
+	var ops ObjectPairArray
+	for {
+		k, l, err := r.readString(b[index:])
+		if err != nil {
+			return nil, 0, err
+		}
+		index += l
+		if k == "" {
+			break
+		}
+		v, l, err := r.ReadValue(b[index:])
+		if err != nil {
+			return nil, 0, err
+		}
+		index += l
+		ops = append(ops, ObjectPair{k, v})
+	}
+
+	// dense部分
+	var vals []interface{}
+	for i := 0; i < count; i++ {
+		v, l, err := r.ReadValue(b[index:])
+		if err != nil {
+			return nil, 0, err
+		}
+		index += l
+		vals = append(vals, v)
+	}
+
+	if ops == nil {
+		r.objRefs[refIndex] = vals
+		return vals, index, nil
+	}
+	for i, v := range vals {
+		ops = append(ops, ObjectPair{strconv.Itoa(i), v})
+	}
+	r.objRefs[refIndex] = ops
+	return ops, index, nil
+}
+
+func (r *AMF3Reader) readObject(b []byte) (interface{}, int, error) {
+	u, index, err := readU29(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	if u&1 == 0 {
+		i := int(u >> 1)
+		if i >= len(r.objRefs) {
+			return nil, 0, ErrAMFInvalidType
+		}
+		return r.objRefs[i], index, nil
+	}
+
+	var traits amf3Traits
+	if u&2 == 0 {
+		i := int(u >> 2)
+		if i >= len(r.traitsRefs) {
+			return nil, 0, ErrAMFInvalidType
+		}
+		traits = r.traitsRefs[i]
+	} else {
+		traits.externalizable = u&4 != 0
+		traits.dynamic = u&8 != 0
+		memberCount := int(u >> 4)
+
+		var l int
+		if traits.className, l, err = r.readString(b[index:]); err != nil {
+			return nil, 0, err
+		}
+		index += l
+		for i := 0; i < memberCount; i++ {
+			name, l, err := r.readString(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			index += l
+			traits.members = append(traits.members, name)
+		}
+		r.traitsRefs = append(r.traitsRefs, traits)
+	}
+
+	if traits.externalizable {
+		nazalog.Warnf("unsupported amf3 externalizable object. className=%s", traits.className)
+		return nil, 0, ErrAMFInvalidType
+	}
+
+	refIndex := len(r.objRefs)
+	r.objRefs = append(r.objRefs, nil)
+
+	var ops ObjectPairArray
+	for _, name := range traits.members {
+		v, l, err := r.ReadValue(b[index:])
+		if err != nil {
+			return nil, 0, err
+		}
+		index += l
+		ops = append(ops, ObjectPair{name, v})
+	}
+	if traits.dynamic {
+		for {
+			k, l, err := r.readString(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			index += l
+			if k == "" {
+				break
+			}
+			v, l, err := r.ReadValue(b[index:])
+			if err != nil {
+				return nil, 0, err
+			}
+			index += l
+			ops = append(ops, ObjectPair{k, v})
+		}
+	}
+
+	r.objRefs[refIndex] = ops
+	return ops, index, nil
+}
+
+// ----------------------------------------------------------------------------
+
+// 注意，写入时只对字符串做引用，对象总是内联写入
+type AMF3Writer struct {
+	strRefs map[string]int
+}
+
+func NewAMF3Writer() *AMF3Writer {
+	return &AMF3Writer{
+		strRefs: make(map[string]int),
+	}
+}
+
+// 支持的类型：
+// - nil
+// - bool
+// - int，超出29位有符号整数范围时写入为double
+// - float64
+// - string
+// - []byte
+// - []string，[]interface{}，写入为只有dense部分的array
+// - ObjectPairArray，[]ObjectPair，写入为匿名的dynamic object
+func (w *AMF3Writer) WriteValue(writer io.Writer, val interface{}) error {
+	switch v := val.(type) {
+	case nil:
+		_, err := writer.Write([]byte{AMF3TypeMarkerNull})
+		return err
+	case bool:
+		marker := AMF3TypeMarkerFalse
+		if v {
+			marker = AMF3TypeMarkerTrue
+		}
+		_, err := writer.Write([]byte{marker})
+		return err
+	case int:
+		if v < amf3IntegerMin || v > amf3IntegerMax {
+			return w.WriteValue(writer, float64(v))
+		}
+		if _, err := writer.Write([]byte{AMF3TypeMarkerInteger}); err != nil {
+			return err
+		}
+		return writeU29(writer, uint32(v)&0x1FFFFFFF)
+	case float64:
+		if _, err := writer.Write([]byte{AMF3TypeMarkerDouble}); err != nil {
+			return err
+		}
+		return bele.WriteBE(writer, v)
+	case string:
+		if _, err := writer.Write([]byte{AMF3TypeMarkerString}); err != nil {
+			return err
+		}
+		return w.writeString(writer, v)
+	case []byte:
+		if _, err := writer.Write([]byte{AMF3TypeMarkerByteArray}); err != nil {
+			return err
+		}
+		if err := writeU29(writer, uint32(len(v))<<1|1); err != nil {
+			return err
+		}
+		_, err := writer.Write(v)
+		return err
+	case []string:
+		vals := make([]interface{}, len(v))
+		for i := range v {
+			vals[i] = v[i]
+		}
+		return w.WriteValue(writer, vals)
+	case []interface{}:
+		if _, err := writer.Write([]byte{AMF3TypeMarkerArray}); err != nil {
+			return err
+		}
+		if err := writeU29(writer, uint32(len(v))<<1|1); err != nil {
+			return err
+		}
+		// associative部分为空
+		if err := w.writeString(writer, ""); err != nil {
+			return err
+		}
+		for i := range v {
+			if err := w.WriteValue(writer, v[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []ObjectPair:
+		return w.WriteValue(writer, ObjectPairArray(v))
+	case ObjectPairArray:
+		// 内联对象，内联traits，dynamic，没有sealed成员
+		if _, err := writer.Write([]byte{AMF3TypeMarkerObject, 0x0b}); err != nil {
+			return err
+		}
+		// 类名为空，也即匿名对象
+		if err := w.writeString(writer, ""); err != nil {
+			return err
+		}
+		for i := range v {
+			if err := w.writeString(writer, v[i].Key); err != nil {
+				return err
+			}
+			if err := w.WriteValue(writer, v[i].Value); err != nil {
+				return err
+			}
+		}
+		return w.writeString(writer, "")
+	}
+	nazalog.Warnf("unsupported amf3 value type. v=%+v", val)
+	return ErrAMFInvalidType
+}
+
+// 写入不包含type marker的string，也即UTF-8-vr
+func (w *AMF3Writer) writeString(writer io.Writer, val string) error {
+	if val != "" {
+		if index, ok := w.strRefs[val]; ok {
+			return writeU29(writer, uint32(index)<<1)
+		}
+		w.strRefs[val] = len(w.strRefs)
+	}
+	if err := writeU29(writer, uint32(len(val))<<1|1); err != nil {
+		return err
+	}
+	_, err := writer.Write([]byte(val))
+	return err
+}
+
+// ----------------------------------------------------------------------------
+
+// U29，变长的29位无符号整数
+// 前3个字节，每个字节的最高位表示后面是否还有字节，低7位为数据，第4个字节的8位都是数据
+func readU29(b []byte) (uint32, int, error) {
+	var u uint32
+	for i := 0; i < 4; i++ {
+		if i >= len(b) {
+			return 0, 0, ErrAMFTooShort
+		}
+		if i == 3 {
+			u = u<<8 | uint32(b[i])
+			return u, 4, nil
+		}
+		u = u<<7 | uint32(b[i]&0x7F)
+		if b[i]&0x80 == 0 {
+			return u, i + 1, nil
+		}
+	}
+	return u, 4, nil
+}
+
+func writeU29(writer io.Writer, u uint32) error {
+	var b []byte
+	u &= 0x1FFFFFFF
+	switch {
+	case u < 0x80:
+		b = []byte{byte(u)}
+	case u < 0x4000:
+		b = []byte{byte(u>>7) | 0x80, byte(u & 0x7F)}
+	case u < 0x200000:
+		b = []byte{byte(u>>14) | 0x80, byte(u>>7) | 0x80, byte(u & 0x7F)}
+	default:
+		b = []byte{byte(u>>22) | 0x80, byte(u>>15) | 0x80, byte(u>>8) | 0x80, byte(u)}
+	}
+	_, err := writer.Write(b)
+	return err
+}
@@ -0,0 +1,131 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp_test
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAMF3_WriteValue_ReadValue(t *testing.T) {
+	cases := []struct {
+		in  interface{}
+		out interface{}
+	}{
+		{nil, nil},
+		{true, true},
+		{false, false},
+		{0, float64(0)},
+		{127, float64(127)},
+		{0x3FFF, float64(0x3FFF)},
+		{0x0FFFFFFF, float64(0x0FFFFFFF)},
+		{-1, float64(-1)},
+		{0x10000000, float64(0x10000000)}, // 超出29位，使用double
+		{1.5, 1.5},
+		{"", ""},
+		{"aaa", "aaa"},
+		{[]byte{1, 2, 3}, []byte{1, 2, 3}},
+		{[]string{"a", "b"}, []interface{}{"a", "b"}},
+		{[]interface{}{1, "a", nil}, []interface{}{float64(1), "a", nil}},
+		{
+			ObjectPairArray{{Key: "a", Value: 1}, {Key: "b", Value: "bbb"}, {Key: "c", Value: ObjectPairArray{{Key: "d", Value: true}}}},
+			ObjectPairArray{{Key: "a", Value: float64(1)}, {Key: "b", Value: "bbb"}, {Key: "c", Value: ObjectPairArray{{Key: "d", Value: true}}}},
+		},
+	}
+	for _, c := range cases {
+		out := &bytes.Buffer{}
+		err := NewAMF3Writer().WriteValue(out, c.in)
+		assert.Equal(t, nil, err)
+		v, l, err := NewAMF3Reader().ReadValue(out.Bytes())
+		assert.Equal(t, nil, err)
+		assert.Equal(t, out.Len(), l)
+		assert.Equal(t, c.out, v)
+	}
+}
+
+func TestAMF3_StringRef(t *testing.T) {
+	out := &bytes.Buffer{}
+	err := NewAMF3Writer().WriteValue(out, []string{"abc", "abc", ""})
+	assert.Equal(t, nil, err)
+	// 第二个abc使用引用，空字符串不进入引用表
+	assert.Equal(t, []byte{0x09, 0x07, 0x01, 0x06, 0x07, 'a', 'b', 'c', 0x06, 0x00, 0x06, 0x01}, out.Bytes())
+
+	v, l, err := NewAMF3Reader().ReadValue(out.Bytes())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, out.Len(), l)
+	assert.Equal(t, []interface{}{"abc", "abc", ""}, v)
+}
+
+func TestAMF3_ObjectRef(t *testing.T) {
+	// [obj1, obj1, obj2]，第二个元素引用第一个元素，第三个元素引用第一个元素的traits
+	b := []byte{
+		0x09, 0x07, 0x01,
+		0x0a, 0x0b, 0x01, 0x03, 'a', 0x04, 0x01, 0x01, // 动态object {a: 1}，类名为空字符串
+		0x0a, 0x02, // 引用下标为1的object，下标0为数组自身
+		0x0a, 0x01, 0x03, 'b', 0x04, 0x02, 0x01, // 引用下标为0的traits，{b: 2}，key b使用新字符串
+	}
+	v, l, err := NewAMF3Reader().ReadValue(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(b), l)
+	obj1 := ObjectPairArray{{Key: "a", Value: float64(1)}}
+	obj2 := ObjectPairArray{{Key: "b", Value: float64(2)}}
+	assert.Equal(t, []interface{}{obj1, obj1, obj2}, v)
+
+	// 引用不存在
+	_, _, err = NewAMF3Reader().ReadValue([]byte{0x0a, 0x00})
+	assert.Equal(t, ErrAMFInvalidType, err)
+	_, _, err = NewAMF3Reader().ReadValue([]byte{0x06, 0x00})
+	assert.Equal(t, ErrAMFInvalidType, err)
+}
+
+func TestAMF3_Corner(t *testing.T) {
+	_, _, err := NewAMF3Reader().ReadValue(nil)
+	assert.Equal(t, ErrAMFTooShort, err)
+	_, _, err = NewAMF3Reader().ReadValue([]byte{0x04, 0x81})
+	assert.Equal(t, ErrAMFTooShort, err)
+	_, _, err = NewAMF3Reader().ReadValue([]byte{0x06, 0x07, 'a'})
+	assert.Equal(t, ErrAMFTooShort, err)
+	// vector
+	_, _, err = NewAMF3Reader().ReadValue([]byte{0x0d, 0x01})
+	assert.Equal(t, ErrAMFInvalidType, err)
+
+	// U29的4字节形式
+	v, l, err := NewAMF3Reader().ReadValue([]byte{0x04, 0xbf, 0xff, 0xff, 0xff})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 5, l)
+	assert.Equal(t, float64(0x0FFFFFFF), v)
+	v, _, err = NewAMF3Reader().ReadValue([]byte{0x04, 0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, float64(-1), v)
+}
+
+func TestAMF0_ReadAVMPlusObject(t *testing.T) {
+	// AMF0 object {a: 1, b: AMF3 "abc"}
+	out := &bytes.Buffer{}
+	_ = AMF0.WriteObject(out, ObjectPairArray{{Key: "a", Value: 1}})
+	b := out.Bytes()
+	b = b[:len(b)-3]
+	b = append(b, 0x00, 0x01, 'b', 0x11, 0x06, 0x07, 'a', 'b', 'c')
+	b = append(b, AMF0TypeMarkerObjectEndBytes...)
+
+	opa, l, err := AMF0.ReadObject(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(b), l)
+	assert.Equal(t, ObjectPairArray{{Key: "a", Value: float64(1)}, {Key: "b", Value: "abc"}}, opa)
+
+	v, l, err := AMF0.ReadAVMPlusObject([]byte{0x11, 0x03})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, l)
+	assert.Equal(t, true, v)
+	_, _, err = AMF0.ReadAVMPlusObject([]byte{0x02, 0x03})
+	assert.Equal(t, ErrAMFInvalidType, err)
+}
@@ -153,3 +153,31 @@ func message2Chunks(message []byte, header *Header, prevHeader *Header, chunkSiz
 
 	return out[:index]
 }
+
+// 从Message2Chunks切片后的数据中，解析出message的类型和时间戳，用于统计写入的音视频帧
+//
+// 注意，只支持第一个chunk为fmt0格式的数据，其他情况返回false
+func peekChunksMessageInfo(chunks []byte) (msgTypeID uint8, timestamp uint32, ok bool) {
+	if len(chunks) == 0 || chunks[0]>>6 != 0 {
+		return
+	}
+	index := 1
+	switch chunks[0] & 0x3f {
+	case 0:
+		index = 2
+	case 1:
+		index = 3
+	}
+	if len(chunks) < index+11 {
+		return
+	}
+	timestamp = bele.BEUint24(chunks[index:])
+	msgTypeID = chunks[index+6]
+	if timestamp == maxTimestampInMessageHeader {
+		if len(chunks) < index+15 {
+			return 0, 0, false
+		}
+		timestamp = bele.BEUint32(chunks[index+11:])
+	}
+	return msgTypeID, timestamp, true
+}
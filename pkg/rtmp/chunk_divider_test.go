@@ -0,0 +1,55 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestPeekChunksMessageInfo(t *testing.T) {
+	golden := []struct {
+		csid      int
+		typeID    uint8
+		timestamp uint32
+	}{
+		{CSIDVideo, TypeidVideo, 40},
+		{CSIDAudio, TypeidAudio, 0},
+		{100, TypeidVideo, 1000},
+		{400, TypeidAudio, 2000},
+		{CSIDVideo, TypeidVideo, maxTimestampInMessageHeader + 1},
+	}
+	for _, g := range golden {
+		payload := make([]byte, LocalChunkSize+1)
+		h := Header{CSID: g.csid, MsgLen: uint32(len(payload)), MsgTypeID: g.typeID, MsgStreamID: 1, TimestampAbs: g.timestamp}
+		typeID, timestamp, ok := peekChunksMessageInfo(Message2Chunks(payload, &h))
+		assert.Equal(t, true, ok)
+		assert.Equal(t, g.typeID, typeID)
+		assert.Equal(t, g.timestamp, timestamp)
+	}
+
+	// 不是fmt0，或者长度不够
+	_, _, ok := peekChunksMessageInfo([]byte{0x47, 0, 0, 0})
+	assert.Equal(t, false, ok)
+	_, _, ok = peekChunksMessageInfo([]byte{0x07, 0, 0})
+	assert.Equal(t, false, ok)
+	_, _, ok = peekChunksMessageInfo(nil)
+	assert.Equal(t, false, ok)
+
+	var stat base.StatAV
+	h := Header{CSID: CSIDVideo, MsgLen: 2, MsgTypeID: TypeidVideo, MsgStreamID: 1, TimestampAbs: 80}
+	feedStatAVByChunks(&stat, Message2Chunks([]byte{0x17, 1}, &h))
+	h = Header{CSID: CSIDAMF, MsgLen: 2, MsgTypeID: TypeidDataMessageAMF0, MsgStreamID: 1, TimestampAbs: 0}
+	feedStatAVByChunks(&stat, Message2Chunks([]byte{2, 0}, &h))
+	assert.Equal(t, uint64(0), stat.AudioCount())
+	assert.Equal(t, uint64(1), stat.VideoCount())
+	assert.Equal(t, uint32(80), stat.LastTimestamp())
+}
@@ -8,6 +8,8 @@
 
 package rtmp
 
+import "github.com/q191201771/lal/pkg/base"
+
 type PullSession struct {
 	core *ClientSession
 }
@@ -16,12 +18,18 @@ type PullSessionOption struct {
 	ConnectTimeoutMS int
 	PullTimeoutMS    int
 	ReadAVTimeoutMS  int
+
+	// 以下只对rtmps生效，含义见ClientSessionOption
+	TLSInsecureSkipVerify bool
+	TLSRootCAFile         string
 }
 
 var defaultPullSessionOption = PullSessionOption{
-	ConnectTimeoutMS: 0,
-	PullTimeoutMS:    0,
-	ReadAVTimeoutMS:  0,
+	ConnectTimeoutMS:      0,
+	PullTimeoutMS:         0,
+	ReadAVTimeoutMS:       0,
+	TLSInsecureSkipVerify: false,
+	TLSRootCAFile:         "",
 }
 
 type ModPullSessionOption func(option *PullSessionOption)
@@ -37,6 +45,8 @@ func NewPullSession(modOptions ...ModPullSessionOption) *PullSession {
 			option.ConnectTimeoutMS = opt.ConnectTimeoutMS
 			option.DoTimeoutMS = opt.PullTimeoutMS
 			option.ReadAVTimeoutMS = opt.ReadAVTimeoutMS
+			option.TLSInsecureSkipVerify = opt.TLSInsecureSkipVerify
+			option.TLSRootCAFile = opt.TLSRootCAFile
 		}),
 	}
 }
@@ -44,6 +54,8 @@ func NewPullSession(modOptions ...ModPullSessionOption) *PullSession {
 // 建立rtmp play连接
 // 阻塞直到收到服务端返回的rtmp publish对应结果的信令，或发生错误
 //
+// @param rawURL: 例如 rtmp://127.0.0.1:1935/live/test110，也支持rtmps://
+//
 // @param onReadRTMPAVMsg: 注意，回调结束后，内存块会被PullSession重复使用
 func (s *PullSession) Pull(rawURL string, onReadRTMPAVMsg OnReadRTMPAVMsg) error {
 	s.core.onReadRTMPAVMsg = onReadRTMPAVMsg
@@ -61,3 +73,12 @@ func (s *PullSession) Dispose() {
 func (s *PullSession) UniqueKey() string {
 	return s.core.UniqueKey
 }
+
+// 见ClientSession.AckLag
+func (s *PullSession) GetStat() base.StatSession {
+	return s.core.GetStat()
+}
+
+func (s *PullSession) AckLag() uint32 {
+	return s.core.AckLag()
+}
@@ -8,6 +8,8 @@
 
 package rtmp
 
+import "github.com/q191201771/lal/pkg/base"
+
 type PushSession struct {
 	IsFresh bool
 
@@ -19,12 +21,18 @@ type PushSessionOption struct {
 	ConnectTimeoutMS int
 	PushTimeoutMS    int
 	WriteAVTimeoutMS int
+
+	// 以下只对rtmps生效，含义见ClientSessionOption
+	TLSInsecureSkipVerify bool
+	TLSRootCAFile         string
 }
 
 var defaultPushSessionOption = PushSessionOption{
-	ConnectTimeoutMS: 0,
-	PushTimeoutMS:    0,
-	WriteAVTimeoutMS: 0,
+	ConnectTimeoutMS:      0,
+	PushTimeoutMS:         0,
+	WriteAVTimeoutMS:      0,
+	TLSInsecureSkipVerify: false,
+	TLSRootCAFile:         "",
 }
 
 type ModPushSessionOption func(option *PushSessionOption)
@@ -40,12 +48,16 @@ func NewPushSession(modOptions ...ModPushSessionOption) *PushSession {
 			option.ConnectTimeoutMS = opt.ConnectTimeoutMS
 			option.DoTimeoutMS = opt.PushTimeoutMS
 			option.WriteAVTimeoutMS = opt.WriteAVTimeoutMS
+			option.TLSInsecureSkipVerify = opt.TLSInsecureSkipVerify
+			option.TLSRootCAFile = opt.TLSRootCAFile
 		}),
 	}
 }
 
 // 建立rtmp publish连接
 // 阻塞直到收到服务端返回的rtmp publish对应结果的信令，或发生错误
+//
+// @param rawURL: 例如 rtmp://127.0.0.1:1935/live/test110，也支持rtmps://
 func (s *PushSession) Push(rawURL string) error {
 	return s.core.doWithTimeout(rawURL)
 }
@@ -69,3 +81,12 @@ func (s *PushSession) Done() <-chan error {
 func (s *PushSession) UniqueKey() string {
 	return s.core.UniqueKey
 }
+
+// 见ClientSession.AckLag
+func (s *PushSession) GetStat() base.StatSession {
+	return s.core.GetStat()
+}
+
+func (s *PushSession) AckLag() uint32 {
+	return s.core.AckLag()
+}
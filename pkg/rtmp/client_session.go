@@ -9,13 +9,19 @@
 package rtmp
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
+	"io"
+	"io/ioutil"
 	"net"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/bele"
 	"github.com/q191201771/naza/pkg/connection"
 	log "github.com/q191201771/naza/pkg/nazalog"
@@ -42,10 +48,12 @@ type ClientSession struct {
 	streamName             string
 	streamNameWithRawQuery string
 	hc                     HandshakeClientSimple
-	peerWinAckSize         int
 
-	conn         connection.Connection
+	conn         *base.StatConn
+	statAV       base.StatAV
+	ack          ackWindow
 	doResultChan chan struct{}
+	startTime    time.Time
 }
 
 type ClientSessionType int
@@ -61,13 +69,19 @@ type ClientSessionOption struct {
 	DoTimeoutMS      int // 从发起连接（包含了建立连接的时间）到收到publish或play信令结果的超时
 	ReadAVTimeoutMS  int // 读取音视频数据的超时
 	WriteAVTimeoutMS int // 发送音视频数据的超时
+
+	// 以下只对rtmps生效
+	TLSInsecureSkipVerify bool   // 为true时，不校验服务端的证书，比如服务端使用的是自签名证书
+	TLSRootCAFile         string // 校验服务端证书使用的根证书文件，PEM格式，为空时使用系统的根证书
 }
 
 var defaultClientSessOption = ClientSessionOption{
-	ConnectTimeoutMS: 0,
-	DoTimeoutMS:      0,
-	ReadAVTimeoutMS:  0,
-	WriteAVTimeoutMS: 0,
+	ConnectTimeoutMS:      0,
+	DoTimeoutMS:           0,
+	ReadAVTimeoutMS:       0,
+	WriteAVTimeoutMS:      0,
+	TLSInsecureSkipVerify: false,
+	TLSRootCAFile:         "",
 }
 
 type ModClientSessionOption func(option *ClientSessionOption)
@@ -95,6 +109,7 @@ func NewClientSession(t ClientSessionType, modOptions ...ModClientSessionOption)
 		doResultChan:  make(chan struct{}, 1),
 		packer:        NewMessagePacker(),
 		chunkComposer: NewChunkComposer(),
+		startTime:     time.Now(),
 	}
 }
 
@@ -127,6 +142,7 @@ func (s *ClientSession) do(rawURL string) <-chan error {
 	}
 
 	if err := s.handshake(); err != nil {
+		base.RTMPHandshakeFailedCounter.Incr()
 		ch <- err
 		return ch
 	}
@@ -162,6 +178,9 @@ func (s *ClientSession) Done() <-chan error {
 
 func (s *ClientSession) AsyncWrite(msg []byte) error {
 	_, err := s.conn.Write(msg)
+	if err == nil {
+		feedStatAVByChunks(&s.statAV, msg)
+	}
 	return err
 }
 
@@ -179,6 +198,10 @@ func (s *ClientSession) runReadLoop() {
 }
 
 func (s *ClientSession) doMsg(stream *Stream) error {
+	if err := s.checkAck(); err != nil {
+		return err
+	}
+
 	switch stream.header.MsgTypeID {
 	case typeidWinAckSize:
 		fallthrough
@@ -188,15 +211,32 @@ func (s *ClientSession) doMsg(stream *Stream) error {
 		return s.doProtocolControlMessage(stream)
 	case typeidCommandMessageAMF0:
 		return s.doCommandMessage(stream)
+	case typeidCommandMessageAMF3:
+		// 跳过第一个字节，之后的内容和AMF0信令相同
+		if stream.msg.len() < 1 {
+			return ErrRTMP
+		}
+		stream.msg.consumed(1)
+		return s.doCommandMessage(stream)
 	case TypeidDataMessageAMF0:
 		return s.doDataMessageAMF0(stream)
+	case TypeidDataMessageAMF3:
+		amf0Stream, err := stream.toAMF0DataStream()
+		if err != nil {
+			return err
+		}
+		return s.doDataMessageAMF0(amf0Stream)
 	case typeidAck:
 		return s.doAck(stream)
 	case typeidUserControl:
-		log.Warnf("[%s] read user control message, ignore.", s.UniqueKey)
+		return s.doUserControl(stream)
+	case typeidAggregateMessage:
+		// 拆分为子消息，逐个按普通消息处理
+		return stream.forEachAggregateSubMsg(s.doMsg)
 	case TypeidAudio:
 		fallthrough
 	case TypeidVideo:
+		feedStatAVByHeader(&s.statAV, stream.header)
 		s.onReadRTMPAVMsg(stream.toAVMsg())
 	default:
 		log.Errorf("[%s] read unknown message. typeid=%d, %s", s.UniqueKey, stream.header.MsgTypeID, stream.toDebugString())
@@ -206,11 +246,59 @@ func (s *ClientSession) doMsg(stream *Stream) error {
 }
 
 func (s *ClientSession) doAck(stream *Stream) error {
+	if stream.msg.len() < 4 {
+		return ErrRTMP
+	}
 	seqNum := bele.BEUint32(stream.msg.buf[stream.msg.b:stream.msg.e])
-	log.Infof("[%s] < R Acknowledgement. ignore. sequence number=%d.", s.UniqueKey, seqNum)
+	s.ack.onPeerAck(seqNum)
+	log.Debugf("[%s] < R Acknowledgement. sequence number=%d, ack lag=%d", s.UniqueKey, seqNum, s.AckLag())
 	return nil
 }
 
+// 收到的字节数达到对端通知的窗口大小时，回复Acknowledgement
+func (s *ClientSession) checkAck() error {
+	seqNum, ok := s.ack.checkAck(s.conn.ReadBytes())
+	if !ok {
+		return nil
+	}
+	log.Debugf("[%s] > W Acknowledgement. sequence number=%d", s.UniqueKey, seqNum)
+	return s.asyncWriteWithPacker(func(writer io.Writer) error {
+		return s.packer.writeAcknowledgement(writer, seqNum)
+	})
+}
+
+// 注意，连接建立后，conn使用channel异步发送并且有写缓冲，而packer内部的缓冲是复用的，
+// 所以先将信令写入一块新的内存，再交给conn发送，并且需要flush
+//
+// 只在读协程中调用
+func (s *ClientSession) asyncWriteWithPacker(fn func(writer io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		return err
+	}
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return s.conn.Flush()
+}
+
+// 已发送但对端还没有确认（Acknowledgement）的字节数，用于诊断流控问题
+// 对端没有回复过Acknowledgement时，为已发送的字节数
+func (s *ClientSession) AckLag() uint32 {
+	if s.conn == nil {
+		return 0
+	}
+	return s.ack.ackLag(s.conn.WroteBytes())
+}
+
+// 还没有建立连接时，只有会话的基本信息
+func (s *ClientSession) GetStat() base.StatSession {
+	if s.conn == nil {
+		return base.NewStatSession(base.ProtocolRTMP, s.UniqueKey, s.startTime, "", nil, nil)
+	}
+	return base.NewStatSession(base.ProtocolRTMP, s.UniqueKey, s.startTime, s.conn.RemoteAddr().String(), s.conn, &s.statAV)
+}
+
 func (s *ClientSession) doDataMessageAMF0(stream *Stream) error {
 	val, err := stream.msg.peekStringWithType()
 	if err != nil {
@@ -266,6 +354,7 @@ func (s *ClientSession) doOnStatusMessage(stream *Stream, tid int) error {
 	if err != nil {
 		return err
 	}
+	description, _ := infos.FindString("description")
 	switch s.t {
 	case CSTPushSession:
 		switch code {
@@ -273,7 +362,7 @@ func (s *ClientSession) doOnStatusMessage(stream *Stream, tid int) error {
 			log.Infof("[%s] < R onStatus('NetStream.Publish.Start').", s.UniqueKey)
 			s.notifyDoResultSucc()
 		default:
-			log.Errorf("[%s] read on status message but code field unknown. code=%s", s.UniqueKey, code)
+			log.Errorf("[%s] read on status message but code field unknown. code=%s, description=%s", s.UniqueKey, code, description)
 		}
 	case CSTPullSession:
 		switch code {
@@ -281,7 +370,7 @@ func (s *ClientSession) doOnStatusMessage(stream *Stream, tid int) error {
 			log.Infof("[%s] < R onStatus('NetStream.Play.Start').", s.UniqueKey)
 			s.notifyDoResultSucc()
 		default:
-			log.Errorf("[%s] read on status message but code field unknown. code=%s", s.UniqueKey, code)
+			log.Errorf("[%s] read on status message but code field unknown. code=%s, description=%s", s.UniqueKey, code, description)
 		}
 	}
 
@@ -341,6 +430,28 @@ func (s *ClientSession) doResultMessage(stream *Stream, tid int) error {
 	return nil
 }
 
+func (s *ClientSession) doUserControl(stream *Stream) error {
+	if stream.msg.len() < 6 {
+		return ErrRTMP
+	}
+	eventType := bele.BEUint16(stream.msg.buf[stream.msg.b:])
+	val := bele.BEUint32(stream.msg.buf[stream.msg.b+2:])
+	switch eventType {
+	case userControlEventStreamBegin:
+		log.Infof("[%s] < R Stream Begin. stream id=%d", s.UniqueKey, val)
+	case userControlEventStreamEOF:
+		log.Infof("[%s] < R Stream EOF. stream id=%d", s.UniqueKey, val)
+	case userControlEventPingRequest:
+		log.Debugf("[%s] < R Ping Request. timestamp=%d", s.UniqueKey, val)
+		return s.asyncWriteWithPacker(func(writer io.Writer) error {
+			return s.packer.writePingResponse(writer, val)
+		})
+	default:
+		log.Debugf("[%s] < R user control message, ignore. event type=%d, val=%d", s.UniqueKey, eventType, val)
+	}
+	return nil
+}
+
 func (s *ClientSession) doProtocolControlMessage(stream *Stream) error {
 	if stream.msg.len() < 4 {
 		return ErrRTMP
@@ -349,11 +460,21 @@ func (s *ClientSession) doProtocolControlMessage(stream *Stream) error {
 
 	switch stream.header.MsgTypeID {
 	case typeidWinAckSize:
-		s.peerWinAckSize = val
-		log.Infof("[%s] < R Window Acknowledgement Size: %d", s.UniqueKey, s.peerWinAckSize)
+		log.Infof("[%s] < R Window Acknowledgement Size: %d", s.UniqueKey, val)
+		s.ack.onPeerWinAckSize(uint32(val))
 	case typeidBandwidth:
-		// TODO chef: 是否需要关注这个信令
-		log.Debugf("[%s] < R Set Peer Bandwidth. ignore.", s.UniqueKey)
+		if stream.msg.len() < 5 {
+			return ErrRTMP
+		}
+		limitType := stream.msg.buf[stream.msg.b+4]
+		log.Infof("[%s] < R Set Peer Bandwidth. val=%d, limit type=%d", s.UniqueKey, val, limitType)
+		if size, changed := s.ack.onPeerBandwidth(uint32(val), limitType); changed {
+			log.Infof("[%s] > W Window Acknowledgement Size %d.", s.UniqueKey, size)
+			s.ack.onWinAckSizeSent(size)
+			return s.asyncWriteWithPacker(func(writer io.Writer) error {
+				return s.packer.writeWinAckSize(writer, int(size))
+			})
+		}
 	case typeidSetChunkSize:
 		// composer内部会自动更新peer chunk size.
 		log.Infof("[%s] < R Set Chunk Size %d.", s.UniqueKey, val)
@@ -369,7 +490,7 @@ func (s *ClientSession) parseURL(rawURL string) error {
 	if err != nil {
 		return err
 	}
-	if s.url.Scheme != "rtmp" || len(s.url.Host) == 0 || len(s.url.Path) == 0 || s.url.Path[0] != '/' {
+	if (s.url.Scheme != "rtmp" && s.url.Scheme != "rtmps") || len(s.url.Host) == 0 || len(s.url.Path) == 0 || s.url.Path[0] != '/' {
 		return ErrRTMP
 	}
 	index := strings.LastIndexByte(rawURL, '/')
@@ -409,6 +530,7 @@ func (s *ClientSession) handshake() error {
 	if err := s.hc.WriteC2(s.conn); err != nil {
 		return err
 	}
+	s.ack.onHandshakeDone(s.conn.ReadBytes(), s.conn.WroteBytes())
 	return nil
 }
 
@@ -417,22 +539,55 @@ func (s *ClientSession) tcpConnect() error {
 	var addr string
 	if strings.Contains(s.url.Host, ":") {
 		addr = s.url.Host
+	} else if s.url.Scheme == "rtmps" {
+		addr = s.url.Host + ":443"
 	} else {
 		addr = s.url.Host + ":1935"
 	}
 
 	var conn net.Conn
-	if conn, err = net.DialTimeout("tcp", addr, time.Duration(s.option.ConnectTimeoutMS)*time.Millisecond); err != nil {
-		return err
+	if s.url.Scheme == "rtmps" {
+		var tlsConfig *tls.Config
+		if tlsConfig, err = s.makeTLSConfig(); err != nil {
+			return err
+		}
+		dialer := &net.Dialer{Timeout: time.Duration(s.option.ConnectTimeoutMS) * time.Millisecond}
+		if conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig); err != nil {
+			return err
+		}
+	} else {
+		if conn, err = net.DialTimeout("tcp", addr, time.Duration(s.option.ConnectTimeoutMS)*time.Millisecond); err != nil {
+			return err
+		}
 	}
 
-	s.conn = connection.New(conn, func(option *connection.Option) {
+	s.conn = base.NewStatConn(connection.New(conn, func(option *connection.Option) {
 		option.ReadBufSize = readBufSize
 		option.WriteChanFullBehavior = connection.WriteChanFullBehaviorBlock
-	})
+	}))
 	return nil
 }
 
+func (s *ClientSession) makeTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         s.url.Hostname(),
+		InsecureSkipVerify: s.option.TLSInsecureSkipVerify,
+	}
+	if s.option.TLSRootCAFile != "" {
+		pem, err := ioutil.ReadFile(s.option.TLSRootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Errorf("[%s] invalid root ca file. file=%s", s.UniqueKey, s.option.TLSRootCAFile)
+			return nil, ErrRTMP
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
 func (s *ClientSession) notifyDoResultSucc() {
 	s.conn.ModWriteChanSize(wChanSize)
 	s.conn.ModWriteBufSize(writeBufSize)
@@ -29,6 +29,10 @@ type MessagePacker struct {
 	// 1. 增加一层缓冲，避免 write 一个信令时发生多次系统调用
 	// 2. 因为 bytes.Buffer.Write 返回的 error 永远为 nil，所以本文件中所有对 b 的写操作都不判断返回值
 	b *bytes.Buffer
+
+	// 对端在connect信令中指定了objectEncoding为AMF3时，发送的信令使用AMF3 command message（type 17）
+	// 消息体的第一个字节为0，之后的内容依然使用AMF0编码
+	commandAMF3 bool
 }
 
 func NewMessagePacker() *MessagePacker {
@@ -43,12 +47,22 @@ func (packer *MessagePacker) writeMessageHeader(csid int, bodyLen int, typeID ui
 		panic(csid)
 	}
 
+	// 注意，bodyLen为0的信令会在写完消息体之后重新计算长度，所以这里统一加1也没有问题
+	isAMF3 := typeID == typeidCommandMessageAMF0 && packer.commandAMF3
+	if isAMF3 {
+		typeID = typeidCommandMessageAMF3
+		bodyLen++
+	}
+
 	fmt := 0
 	// 0 0 0 是时间戳
 	_, _ = packer.b.Write([]byte{uint8(fmt<<6 | csid), 0, 0, 0})
 	_ = bele.WriteBEUint24(packer.b, uint32(bodyLen))
 	_ = packer.b.WriteByte(typeID)
 	_ = bele.WriteLE(packer.b, uint32(streamID))
+	if isAMF3 {
+		_ = packer.b.WriteByte(0)
+	}
 }
 
 func (packer *MessagePacker) writeProtocolControlMessage(writer io.Writer, typeID uint8, val int) error {
@@ -66,6 +80,10 @@ func (packer *MessagePacker) writeWinAckSize(writer io.Writer, val int) error {
 	return packer.writeProtocolControlMessage(writer, typeidWinAckSize, val)
 }
 
+func (packer *MessagePacker) writeAcknowledgement(writer io.Writer, seqNum uint32) error {
+	return packer.writeProtocolControlMessage(writer, typeidAck, int(seqNum))
+}
+
 func (packer *MessagePacker) writePeerBandwidth(writer io.Writer, val int, limitType uint8) error {
 	packer.writeMessageHeader(csidProtocolControl, 5, typeidBandwidth, 0)
 	_ = bele.WriteBE(packer.b, uint32(val))
@@ -74,6 +92,32 @@ func (packer *MessagePacker) writePeerBandwidth(writer io.Writer, val int, limit
 	return err
 }
 
+// 注意，目前发送的user control message的事件数据都是4字节
+func (packer *MessagePacker) writeUserControlMessage(writer io.Writer, eventType uint16, val uint32) error {
+	packer.writeMessageHeader(csidProtocolControl, 6, typeidUserControl, 0)
+	_ = bele.WriteBE(packer.b, eventType)
+	_ = bele.WriteBE(packer.b, val)
+	_, err := packer.b.WriteTo(writer)
+	return err
+}
+
+func (packer *MessagePacker) writeStreamBegin(writer io.Writer, streamID int) error {
+	return packer.writeUserControlMessage(writer, userControlEventStreamBegin, uint32(streamID))
+}
+
+func (packer *MessagePacker) writeStreamEOF(writer io.Writer, streamID int) error {
+	return packer.writeUserControlMessage(writer, userControlEventStreamEOF, uint32(streamID))
+}
+
+// @param timestamp 发送方的本地时间，对端在ping response中原样返回
+func (packer *MessagePacker) writePingRequest(writer io.Writer, timestamp uint32) error {
+	return packer.writeUserControlMessage(writer, userControlEventPingRequest, timestamp)
+}
+
+func (packer *MessagePacker) writePingResponse(writer io.Writer, timestamp uint32) error {
+	return packer.writeUserControlMessage(writer, userControlEventPingResponse, timestamp)
+}
+
 func (packer *MessagePacker) writeConnect(writer io.Writer, appName, tcURL string) error {
 	packer.writeMessageHeader(csidOverConnection, 0, typeidCommandMessageAMF0, 0)
 	_ = AMF0.WriteString(packer.b, "connect")
@@ -92,22 +136,26 @@ func (packer *MessagePacker) writeConnect(writer io.Writer, appName, tcURL strin
 	return err
 }
 
-func (packer *MessagePacker) writeConnectResult(writer io.Writer, tid int) error {
-	packer.writeMessageHeader(csidOverConnection, 190, typeidCommandMessageAMF0, 0)
+// @param objectEncoding 对端在connect信令中指定的objectEncoding，0表示AMF0，3表示AMF3
+func (packer *MessagePacker) writeConnectResult(writer io.Writer, tid int, objectEncoding int) error {
+	packer.writeMessageHeader(csidOverConnection, 0, typeidCommandMessageAMF0, 0)
 	_ = AMF0.WriteString(packer.b, "_result")
 	_ = AMF0.WriteNumber(packer.b, float64(tid))
 	objs := []ObjectPair{
 		{Key: "fmsVer", Value: "FMS/3,0,1,123"},
 		{Key: "capabilities", Value: 31},
+		{Key: "fourCcList", Value: FourCCList}, // Enhanced RTMP
 	}
 	_ = AMF0.WriteObject(packer.b, objs)
 	objs = []ObjectPair{
 		{Key: "level", Value: "status"},
 		{Key: "code", Value: "NetConnection.Connect.Success"},
 		{Key: "description", Value: "Connection succeeded."},
-		{Key: "objectEncoding", Value: 0},
+		{Key: "objectEncoding", Value: objectEncoding},
 	}
 	_ = AMF0.WriteObject(packer.b, objs)
+	raw := packer.b.Bytes()
+	bele.BEPutUint24(raw[4:], uint32(len(raw)-12))
 	_, err := packer.b.WriteTo(writer)
 	return err
 }
@@ -174,6 +222,27 @@ func (packer *MessagePacker) writeOnStatusPublish(writer io.Writer, streamID int
 	return err
 }
 
+// 写入任意level、code的onStatus信令，比如拒绝publish或play时回复错误
+func (packer *MessagePacker) writeOnStatus(writer io.Writer, streamID int, level string, code string, description string) error {
+	objs := []ObjectPair{
+		{Key: "level", Value: level},
+		{Key: "code", Value: code},
+		{Key: "description", Value: description},
+	}
+	// "onStatus"(11) + transaction id(9) + null(1) + object开始和结束标记(1+3)
+	bodyLen := 11 + 9 + 1 + 1 + 3
+	for _, obj := range objs {
+		bodyLen += 2 + len(obj.Key) + 3 + len(obj.Value.(string))
+	}
+	packer.writeMessageHeader(csidOverStream, bodyLen, typeidCommandMessageAMF0, streamID)
+	_ = AMF0.WriteString(packer.b, "onStatus")
+	_ = AMF0.WriteNumber(packer.b, 0)
+	_ = AMF0.WriteNull(packer.b)
+	_ = AMF0.WriteObject(packer.b, objs)
+	_, err := packer.b.WriteTo(writer)
+	return err
+}
+
 func (packer *MessagePacker) writeOnStatusPlay(writer io.Writer, streamID int) error {
 	packer.writeMessageHeader(csidOverStream, 96, typeidCommandMessageAMF0, streamID)
 	_ = AMF0.WriteString(packer.b, "onStatus")
@@ -23,6 +23,81 @@ func TestWriteMessageHandler(t *testing.T) {
 	assert.Equal(t, []byte{1, 0, 0, 0, 0, 0, 2, 3, 4, 0, 0, 0}, packer.b.Bytes())
 }
 
+func TestWriteUserControl(t *testing.T) {
+	buf := &bytes.Buffer{}
+	packer := NewMessagePacker()
+
+	err := packer.writeStreamBegin(buf, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{2, 0, 0, 0, 0, 0, 6, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, buf.Bytes())
+	buf.Reset()
+
+	err = packer.writeStreamEOF(buf, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{2, 0, 0, 0, 0, 0, 6, 4, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}, buf.Bytes())
+	buf.Reset()
+
+	err = packer.writePingRequest(buf, 0x01020304)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{2, 0, 0, 0, 0, 0, 6, 4, 0, 0, 0, 0, 0, 6, 1, 2, 3, 4}, buf.Bytes())
+	buf.Reset()
+
+	err = packer.writePingResponse(buf, 0x01020304)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{2, 0, 0, 0, 0, 0, 6, 4, 0, 0, 0, 0, 0, 7, 1, 2, 3, 4}, buf.Bytes())
+}
+
+func TestWriteCommandAMF3(t *testing.T) {
+	buf := &bytes.Buffer{}
+	packer := NewMessagePacker()
+	packer.commandAMF3 = true
+
+	// 固定长度的信令
+	err := packer.writeCreateStreamResult(buf, 2)
+	assert.Equal(t, nil, err)
+	result := buf.Bytes()
+	assert.Equal(t, []byte{0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1e, 0x11, 0x0, 0x0, 0x0, 0x0, 0x0}, result[:13])
+	assert.Equal(t, 12+30, len(result))
+	buf.Reset()
+
+	// 写完消息体之后再计算长度的信令
+	err = packer.writeConnectResult(buf, 1, 3)
+	assert.Equal(t, nil, err)
+	result = buf.Bytes()
+	assert.Equal(t, uint8(0x11), result[7])
+	assert.Equal(t, uint8(0), result[12])
+	assert.Equal(t, len(result)-12, int(result[4])<<16|int(result[5])<<8|int(result[6]))
+	buf.Reset()
+
+	// 非信令不受影响
+	err = packer.writeChunkSize(buf, 1)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{2, 0, 0, 0, 0, 0, 4, 1, 0, 0, 0, 0, 0, 0, 0, 1}, buf.Bytes())
+}
+
+func TestWriteOnStatus(t *testing.T) {
+	buf := &bytes.Buffer{}
+	packer := NewMessagePacker()
+
+	// 和固定长度的onStatus信令结果一致
+	err := packer.writeOnStatusPublish(buf, 1)
+	assert.Equal(t, nil, err)
+	expected := append([]byte{}, buf.Bytes()...)
+	buf.Reset()
+	err = packer.writeOnStatus(buf, 1, "status", "NetStream.Publish.Start", "Start publishing")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, expected, buf.Bytes())
+	buf.Reset()
+
+	err = packer.writeOnStatus(buf, 1, "error", "NetStream.Publish.BadName", "")
+	assert.Equal(t, nil, err)
+	result := buf.Bytes()
+	assert.Equal(t, len(result)-12, int(result[4])<<16|int(result[5])<<8|int(result[6]))
+	cmd, _, err := AMF0.ReadString(result[12:])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "onStatus", cmd)
+}
+
 func TestWrite(t *testing.T) {
 	var (
 		err    error
@@ -67,9 +142,9 @@ func TestWrite(t *testing.T) {
 	assert.Equal(t, result, buf.Bytes())
 	buf.Reset()
 
-	err = packer.writeConnectResult(buf, 1)
+	err = packer.writeConnectResult(buf, 1, 0)
 	assert.Equal(t, nil, err)
-	result = []byte{0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0xbe, 0x14, 0x0, 0x0, 0x0, 0x0, 0x2, 0x0, 0x7, 0x5f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x0, 0x3f, 0xf0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x0, 0x6, 0x66, 0x6d, 0x73, 0x56, 0x65, 0x72, 0x2, 0x0, 0xd, 0x46, 0x4d, 0x53, 0x2f, 0x33, 0x2c, 0x30, 0x2c, 0x31, 0x2c, 0x31, 0x32, 0x33, 0x0, 0xc, 0x63, 0x61, 0x70, 0x61, 0x62, 0x69, 0x6c, 0x69, 0x74, 0x69, 0x65, 0x73, 0x0, 0x40, 0x3f, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x9, 0x3, 0x0, 0x5, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x2, 0x0, 0x6, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x0, 0x4, 0x63, 0x6f, 0x64, 0x65, 0x2, 0x0, 0x1d, 0x4e, 0x65, 0x74, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x0, 0xb, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x2, 0x0, 0x15, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x20, 0x73, 0x75, 0x63, 0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x2e, 0x0, 0xe, 0x6f, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x9}
+	result = []byte{0x3, 0x0, 0x0, 0x0, 0x0, 0x0, 0xe4, 0x14, 0x0, 0x0, 0x0, 0x0, 0x2, 0x0, 0x7, 0x5f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x0, 0x3f, 0xf0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x0, 0x6, 0x66, 0x6d, 0x73, 0x56, 0x65, 0x72, 0x2, 0x0, 0xd, 0x46, 0x4d, 0x53, 0x2f, 0x33, 0x2c, 0x30, 0x2c, 0x31, 0x2c, 0x31, 0x32, 0x33, 0x0, 0xc, 0x63, 0x61, 0x70, 0x61, 0x62, 0x69, 0x6c, 0x69, 0x74, 0x69, 0x65, 0x73, 0x0, 0x40, 0x3f, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0xa, 0x66, 0x6f, 0x75, 0x72, 0x43, 0x63, 0x4c, 0x69, 0x73, 0x74, 0xa, 0x0, 0x0, 0x0, 0x3, 0x2, 0x0, 0x4, 0x68, 0x76, 0x63, 0x31, 0x2, 0x0, 0x4, 0x61, 0x76, 0x30, 0x31, 0x2, 0x0, 0x4, 0x76, 0x70, 0x30, 0x39, 0x0, 0x0, 0x9, 0x3, 0x0, 0x5, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x2, 0x0, 0x6, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x0, 0x4, 0x63, 0x6f, 0x64, 0x65, 0x2, 0x0, 0x1d, 0x4e, 0x65, 0x74, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x0, 0xb, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x2, 0x0, 0x15, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x20, 0x73, 0x75, 0x63, 0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x2e, 0x0, 0xe, 0x6f, 0x62, 0x6a, 0x65, 0x63, 0x74, 0x45, 0x6e, 0x63, 0x6f, 0x64, 0x69, 0x6e, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x9}
 	assert.Equal(t, result, buf.Bytes())
 	buf.Reset()
 
@@ -141,7 +216,7 @@ func TestPackCorner(t *testing.T) {
 	assert.IsNotNil(t, err)
 	err = packer.writeConnect(mw, "live", "rtmp://127.0.0.1/live")
 	assert.IsNotNil(t, err)
-	err = packer.writeConnectResult(mw, 1)
+	err = packer.writeConnectResult(mw, 1, 0)
 	assert.IsNotNil(t, err)
 	err = packer.writeCreateStream(mw)
 	assert.IsNotNil(t, err)
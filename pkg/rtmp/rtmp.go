@@ -10,10 +10,35 @@ package rtmp
 
 import (
 	"errors"
+	"fmt"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/bele"
 )
 
 var ErrRTMP = errors.New("lal.rtmp: fxxk")
 
+// 上层拒绝了publish或play
+var ErrRTMPRejected = errors.New("lal.rtmp: rejected")
+
+// 上层拒绝publish或play时使用的onStatus code
+const (
+	StatusCodePublishBadName     = "NetStream.Publish.BadName"
+	StatusCodePlayStreamNotFound = "NetStream.Play.StreamNotFound"
+	StatusCodePlayFailed         = "NetStream.Play.Failed"
+)
+
+// 上层拒绝publish或play时，可以返回这个类型的错误，Code和Description会通过onStatus回复给对端
+// 返回其他类型的错误时，publish使用StatusCodePublishBadName，play使用StatusCodePlayStreamNotFound，Description为错误信息
+type StatusError struct {
+	Code        string
+	Description string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("lal.rtmp: %s %s", e.Code, e.Description)
+}
+
 const (
 	CSIDAMF   = 5
 	CSIDAudio = 6
@@ -31,6 +56,7 @@ const (
 	TypeidAudio           = uint8(8)
 	TypeidVideo           = uint8(9)
 	TypeidDataMessageAMF0 = uint8(18) // meta
+	TypeidDataMessageAMF3 = uint8(15)
 
 	typeidSetChunkSize       = uint8(1)
 	typeidAck                = uint8(3)
@@ -38,6 +64,25 @@ const (
 	typeidWinAckSize         = uint8(5)
 	typeidBandwidth          = uint8(6)
 	typeidCommandMessageAMF0 = uint8(20)
+	typeidCommandMessageAMF3 = uint8(17)
+	typeidAggregateMessage   = uint8(22)
+)
+
+// user control message的事件类型
+const (
+	userControlEventStreamBegin      = uint16(0)
+	userControlEventStreamEOF        = uint16(1)
+	userControlEventStreamDry        = uint16(2)
+	userControlEventSetBufferLength  = uint16(3)
+	userControlEventStreamIsRecorded = uint16(4)
+	userControlEventPingRequest      = uint16(6)
+	userControlEventPingResponse     = uint16(7)
+)
+
+// connect信令中的objectEncoding字段
+const (
+	objectEncodingAMF0 = 0
+	objectEncodingAMF3 = 3
 )
 
 const (
@@ -90,8 +135,103 @@ const (
 	AACPacketTypeRaw       uint8 = 1
 )
 
+// Enhanced RTMP，见 https://github.com/veovera/enhanced-rtmp
+//
+// 视频消息第一个字节的最高位为1时，表示使用扩展头：
+// IsExHeader 1b | FrameType 3b | PacketType 4b | FourCC 4B | ...
+// 之后的数据格式由PacketType决定
+const (
+	VideoExHeaderFlag uint8 = 0x80
+
+	PacketTypeExSequenceStart        uint8 = 0 // 之后是解码器配置记录，比如HEVC的hvcC
+	PacketTypeExCodedFrames          uint8 = 1 // 之后是3字节的cts，再之后是帧数据
+	PacketTypeExSequenceEnd          uint8 = 2
+	PacketTypeExCodedFramesX         uint8 = 3 // 和CodedFrames的区别是没有cts，也即cts为0
+	PacketTypeExMetadata             uint8 = 4
+	PacketTypeExMPEG2TSSequenceStart uint8 = 5
+
+	FourCCHEVC = "hvc1"
+	FourCCAV1  = "av01"
+	FourCCVP9  = "vp09"
+)
+
+// 在connect的结果中通知对端，自身支持的Enhanced RTMP编码类型
+var FourCCList = []string{FourCCHEVC, FourCCAV1, FourCCVP9}
+
 type OnReadRTMPAVMsg func(msg AVMsg)
 
+// 视频消息的头部信息，兼容传统的codec id方式以及Enhanced RTMP的扩展头方式
+type VideoHeader struct {
+	IsExHeader bool
+	FrameType  uint8  // 1 关键帧 2 非关键帧
+	CodecID    uint8  // 传统方式时有效
+	FourCC     string // 扩展头方式时有效
+	PacketType uint8  // 传统方式时为AVCPacketType，扩展头方式时为PacketTypeExXXX
+	CTS        uint32
+	DataOffset int // seq header中配置记录的起始位置，或帧数据中第一个NALU的起始位置
+}
+
+func ParseVideoHeader(payload []byte) (h VideoHeader, err error) {
+	if len(payload) < 5 {
+		return h, ErrRTMP
+	}
+
+	h.IsExHeader = payload[0]&VideoExHeaderFlag != 0
+	if !h.IsExHeader {
+		h.FrameType = payload[0] >> 4
+		h.CodecID = payload[0] & 0xF
+		h.PacketType = payload[1]
+		h.CTS = bele.BEUint24(payload[2:])
+		h.DataOffset = 5
+		return
+	}
+
+	h.FrameType = (payload[0] >> 4) & 0x7
+	h.PacketType = payload[0] & 0xF
+	h.FourCC = string(payload[1:5])
+	h.DataOffset = 5
+	if h.PacketType == PacketTypeExCodedFrames {
+		// 注意，HEVC才有cts字段，AV1和VP9没有
+		if h.FourCC == FourCCHEVC {
+			if len(payload) < 8 {
+				return h, ErrRTMP
+			}
+			h.CTS = bele.BEUint24(payload[5:])
+			h.DataOffset = 8
+		}
+	}
+	return
+}
+
+func (h VideoHeader) IsAVC() bool {
+	return !h.IsExHeader && h.CodecID == codecIDAVC
+}
+
+func (h VideoHeader) IsHEVC() bool {
+	if h.IsExHeader {
+		return h.FourCC == FourCCHEVC
+	}
+	return h.CodecID == codecIDHEVC
+}
+
+func (h VideoHeader) IsSeqHeader() bool {
+	if h.IsExHeader {
+		return h.PacketType == PacketTypeExSequenceStart
+	}
+	return h.PacketType == AVCPacketTypeSeqHeader
+}
+
+func (h VideoHeader) IsCodedFrames() bool {
+	if h.IsExHeader {
+		return h.PacketType == PacketTypeExCodedFrames || h.PacketType == PacketTypeExCodedFramesX
+	}
+	return h.PacketType == AVCPacketTypeNALU
+}
+
+func (h VideoHeader) IsKeyFrame() bool {
+	return h.FrameType == frameTypeKey
+}
+
 func (msg AVMsg) IsAVCKeySeqHeader() bool {
 	return msg.Header.MsgTypeID == TypeidVideo && msg.Payload[0] == AVCKeyFrame && msg.Payload[1] == AVCPacketTypeSeqHeader
 }
@@ -100,8 +240,26 @@ func (msg AVMsg) IsHEVCKeySeqHeader() bool {
 	return msg.Header.MsgTypeID == TypeidVideo && msg.Payload[0] == HEVCKeyFrame && msg.Payload[1] == HEVCPacketTypeSeqHeader
 }
 
+// 使用Enhanced RTMP扩展头的视频消息
+func (msg AVMsg) IsVideoExHeader() bool {
+	return msg.Header.MsgTypeID == TypeidVideo && len(msg.Payload) >= 5 && msg.Payload[0]&VideoExHeaderFlag != 0
+}
+
+func (msg AVMsg) IsExSequenceStart() bool {
+	return msg.IsVideoExHeader() && msg.Payload[0]&0xF == PacketTypeExSequenceStart
+}
+
+func (msg AVMsg) IsExKeyCodedFrames() bool {
+	if !msg.IsVideoExHeader() || (msg.Payload[0]>>4)&0x7 != frameTypeKey {
+		return false
+	}
+	pt := msg.Payload[0] & 0xF
+	return pt == PacketTypeExCodedFrames || pt == PacketTypeExCodedFramesX
+}
+
+// AVC，HEVC，或者Enhanced RTMP的seq header
 func (msg AVMsg) IsVideoKeySeqHeader() bool {
-	return msg.IsAVCKeySeqHeader() || msg.IsHEVCKeySeqHeader()
+	return msg.IsAVCKeySeqHeader() || msg.IsHEVCKeySeqHeader() || msg.IsExSequenceStart()
 }
 
 func (msg AVMsg) IsAVCKeyNALU() bool {
@@ -112,8 +270,9 @@ func (msg AVMsg) IsHEVCKeyNALU() bool {
 	return msg.Header.MsgTypeID == TypeidVideo && msg.Payload[0] == HEVCKeyFrame && msg.Payload[1] == HEVCPacketTypeNALU
 }
 
+// AVC，HEVC，或者Enhanced RTMP的关键帧
 func (msg AVMsg) IsVideoKeyNALU() bool {
-	return msg.IsAVCKeyNALU() || msg.IsHEVCKeyNALU()
+	return msg.IsAVCKeyNALU() || msg.IsHEVCKeyNALU() || msg.IsExKeyCodedFrames()
 }
 
 func (msg AVMsg) IsAACSeqHeader() bool {
@@ -128,3 +287,27 @@ func ParseMetadata(b []byte) (ObjectPairArray, error) {
 	opa, _, err := AMF0.ReadObjectOrArray(b[l:])
 	return opa, err
 }
+
+// 输出类型的会话，统计写入的音视频帧
+func feedStatAVByChunks(stat *base.StatAV, chunks []byte) {
+	msgTypeID, timestamp, ok := peekChunksMessageInfo(chunks)
+	if !ok {
+		return
+	}
+	switch msgTypeID {
+	case TypeidAudio:
+		stat.FeedAudio(timestamp)
+	case TypeidVideo:
+		stat.FeedVideo(timestamp)
+	}
+}
+
+// 输入类型的会话，统计读取的音视频帧
+func feedStatAVByHeader(stat *base.StatAV, header Header) {
+	switch header.MsgTypeID {
+	case TypeidAudio:
+		stat.FeedAudio(header.TimestampAbs)
+	case TypeidVideo:
+		stat.FeedVideo(header.TimestampAbs)
+	}
+}
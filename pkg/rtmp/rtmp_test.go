@@ -12,8 +12,89 @@ import (
 	"testing"
 
 	"github.com/q191201771/lal/pkg/innertest"
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
 )
 
 func TestRTMP(t *testing.T) {
 	innertest.InnerTestEntry(t)
 }
+
+func TestParseVideoHeader(t *testing.T) {
+	// 传统方式的AVC帧
+	vh, err := rtmp.ParseVideoHeader([]byte{0x27, 1, 0, 0, 0x28, 0, 0, 0, 1, 0x41})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, vh.IsExHeader)
+	assert.Equal(t, true, vh.IsAVC())
+	assert.Equal(t, false, vh.IsKeyFrame())
+	assert.Equal(t, true, vh.IsCodedFrames())
+	assert.Equal(t, uint32(40), vh.CTS)
+	assert.Equal(t, 5, vh.DataOffset)
+
+	// 扩展头方式的HEVC seq header
+	vh, err = rtmp.ParseVideoHeader([]byte{0x90, 'h', 'v', 'c', '1', 1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, vh.IsExHeader)
+	assert.Equal(t, true, vh.IsHEVC())
+	assert.Equal(t, true, vh.IsSeqHeader())
+	assert.Equal(t, true, vh.IsKeyFrame())
+	assert.Equal(t, 5, vh.DataOffset)
+
+	// 扩展头方式的HEVC关键帧，带cts
+	vh, err = rtmp.ParseVideoHeader([]byte{0x91, 'h', 'v', 'c', '1', 0, 0, 0x50, 0, 0, 0, 1, 0x26})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, vh.IsCodedFrames())
+	assert.Equal(t, uint32(80), vh.CTS)
+	assert.Equal(t, 8, vh.DataOffset)
+
+	// 扩展头方式的HEVC非关键帧，不带cts
+	vh, err = rtmp.ParseVideoHeader([]byte{0xa3, 'h', 'v', 'c', '1', 0, 0, 0, 1, 0x02})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, vh.IsCodedFrames())
+	assert.Equal(t, false, vh.IsKeyFrame())
+	assert.Equal(t, uint32(0), vh.CTS)
+	assert.Equal(t, 5, vh.DataOffset)
+
+	// 扩展头方式的AV1
+	vh, err = rtmp.ParseVideoHeader([]byte{0x91, 'a', 'v', '0', '1', 0x12, 0})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, vh.IsHEVC())
+	assert.Equal(t, false, vh.IsAVC())
+	assert.Equal(t, rtmp.FourCCAV1, vh.FourCC)
+	assert.Equal(t, 5, vh.DataOffset)
+
+	_, err = rtmp.ParseVideoHeader([]byte{0x17, 0, 0, 0})
+	assert.Equal(t, rtmp.ErrRTMP, err)
+	_, err = rtmp.ParseVideoHeader([]byte{0x91, 'h', 'v', 'c', '1', 0})
+	assert.Equal(t, rtmp.ErrRTMP, err)
+}
+
+func TestAVMsgExHeader(t *testing.T) {
+	newMsg := func(payload []byte) rtmp.AVMsg {
+		var msg rtmp.AVMsg
+		msg.Header.MsgTypeID = rtmp.TypeidVideo
+		msg.Payload = payload
+		return msg
+	}
+
+	msg := newMsg([]byte{0x90, 'h', 'v', 'c', '1', 1})
+	assert.Equal(t, true, msg.IsVideoExHeader())
+	assert.Equal(t, true, msg.IsVideoKeySeqHeader())
+	assert.Equal(t, false, msg.IsHEVCKeySeqHeader())
+	assert.Equal(t, false, msg.IsVideoKeyNALU())
+
+	msg = newMsg([]byte{0x91, 'h', 'v', 'c', '1', 0, 0, 0, 0, 0, 0, 1, 0x26})
+	assert.Equal(t, false, msg.IsVideoKeySeqHeader())
+	assert.Equal(t, true, msg.IsVideoKeyNALU())
+
+	msg = newMsg([]byte{0x93, 'a', 'v', '0', '1', 0x12, 0})
+	assert.Equal(t, true, msg.IsVideoKeyNALU())
+
+	msg = newMsg([]byte{0xa1, 'v', 'p', '0', '9', 0})
+	assert.Equal(t, false, msg.IsVideoKeyNALU())
+
+	// 传统方式
+	msg = newMsg([]byte{0x17, 0, 0, 0, 0, 1})
+	assert.Equal(t, false, msg.IsVideoExHeader())
+	assert.Equal(t, true, msg.IsVideoKeySeqHeader())
+}
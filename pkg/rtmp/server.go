@@ -9,15 +9,16 @@
 package rtmp
 
 import (
+	"crypto/tls"
 	"net"
 
 	log "github.com/q191201771/naza/pkg/nazalog"
 )
 
 type ServerObserver interface {
-	OnNewRTMPPubSession(session *ServerSession) bool // 返回true则允许推流，返回false则强制关闭这个连接
+	OnNewRTMPPubSession(session *ServerSession) error // 返回nil则允许推流，否则回复错误信令并关闭这个连接，见StatusError
 	OnDelRTMPPubSession(session *ServerSession)
-	OnNewRTMPSubSession(session *ServerSession) bool // 返回true则允许拉流，返回false则强制关闭这个连接
+	OnNewRTMPSubSession(session *ServerSession) error // 返回nil则允许拉流，否则回复错误信令并关闭这个连接，见StatusError
 	OnDelRTMPSubSession(session *ServerSession)
 }
 
@@ -25,6 +26,10 @@ type Server struct {
 	obs  ServerObserver
 	addr string
 	ln   net.Listener
+
+	// 不为空时，使用TLS监听，也即rtmps
+	certFile string
+	keyFile  string
 }
 
 func NewServer(obs ServerObserver, addr string) *Server {
@@ -34,11 +39,39 @@ func NewServer(obs ServerObserver, addr string) *Server {
 	}
 }
 
+// rtmps服务端，TLS握手完成后，其余流程和rtmp完全一致
+//
+// @param certFile 证书文件路径，PEM格式
+// @param keyFile  私钥文件路径，PEM格式
+func NewTLSServer(obs ServerObserver, addr string, certFile string, keyFile string) *Server {
+	return &Server{
+		obs:      obs,
+		addr:     addr,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+}
+
 func (server *Server) Listen() (err error) {
-	if server.ln, err = net.Listen("tcp", server.addr); err != nil {
+	if server.certFile == "" {
+		if server.ln, err = net.Listen("tcp", server.addr); err != nil {
+			return
+		}
+		log.Infof("start rtmp server listen. addr=%s", server.addr)
 		return
 	}
-	log.Infof("start rtmp server listen. addr=%s", server.addr)
+
+	var cert tls.Certificate
+	if cert, err = tls.LoadX509KeyPair(server.certFile, server.keyFile); err != nil {
+		return
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if server.ln, err = tls.Listen("tcp", server.addr, tlsConfig); err != nil {
+		return
+	}
+	log.Infof("start rtmps server listen. addr=%s", server.addr)
 	return
 }
 
@@ -77,18 +110,19 @@ func (server *Server) handleTCPConnect(conn net.Conn) {
 }
 
 // ServerSessionObserver
-func (server *Server) OnNewRTMPPubSession(session *ServerSession) {
-	if !server.obs.OnNewRTMPPubSession(session) {
-		log.Warnf("dispose PubSession since pub exist.")
-		session.Dispose()
-		return
+func (server *Server) OnNewRTMPPubSession(session *ServerSession) error {
+	if err := server.obs.OnNewRTMPPubSession(session); err != nil {
+		log.Warnf("[%s] pub session rejected. err=%v", session.UniqueKey, err)
+		return err
 	}
+	return nil
 }
 
 // ServerSessionObserver
-func (server *Server) OnNewRTMPSubSession(session *ServerSession) {
-	if !server.obs.OnNewRTMPSubSession(session) {
-		session.Dispose()
-		return
+func (server *Server) OnNewRTMPSubSession(session *ServerSession) error {
+	if err := server.obs.OnNewRTMPSubSession(session); err != nil {
+		log.Warnf("[%s] sub session rejected. err=%v", session.UniqueKey, err)
+		return err
 	}
+	return nil
 }
@@ -9,9 +9,14 @@
 package rtmp
 
 import (
+	"bytes"
+	"io"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/bele"
 	"github.com/q191201771/naza/pkg/connection"
 	"github.com/q191201771/naza/pkg/nazalog"
@@ -21,8 +26,12 @@ import (
 // TODO chef: 没有进化成Pub Sub时的超时释放
 
 type ServerSessionObserver interface {
-	OnNewRTMPPubSession(session *ServerSession) // 上层代码应该在这个事件回调中注册音视频数据的监听
-	OnNewRTMPSubSession(session *ServerSession)
+	// 上层代码应该在这个事件回调中注册音视频数据的监听
+	// 返回nil则回复onStatus('NetStream.Publish.Start')，否则回复错误的onStatus并关闭连接，见StatusError
+	OnNewRTMPPubSession(session *ServerSession) error
+
+	// 返回nil则回复onStatus('NetStream.Play.Start')，否则回复错误的onStatus并关闭连接，见StatusError
+	OnNewRTMPSubSession(session *ServerSession) error
 }
 
 var _ ServerSessionObserver = &Server{}
@@ -49,6 +58,7 @@ type ServerSession struct {
 	AppName                string
 	StreamName             string
 	StreamNameWithRawQuery string
+	RawQuery               string // StreamNameWithRawQuery中?后面的参数，不包含?
 
 	obs           ServerSessionObserver
 	t             ServerSessionType
@@ -56,7 +66,25 @@ type ServerSession struct {
 	chunkComposer *ChunkComposer
 	packer        *MessagePacker
 
-	conn connection.Connection
+	conn      *base.StatConn
+	statAV    base.StatAV
+	ack       ackWindow
+	startTime time.Time
+
+	// publish或play之后，packer在读协程，ping协程以及上层调用WriteStreamXXX的协程中都会使用，所以需要加锁，见asyncWriteWithPacker
+	// publish或play之前，只在读协程中使用，不加锁
+	packerMutex sync.Mutex
+
+	pingMutex       sync.Mutex
+	hasPingResponse bool      // 对端是否回复过ping response
+	pingRequestTS   uint32    // 等待回复的ping request的时间戳，为0时表示没有等待回复的ping request
+	pingRequestTime time.Time // 等待回复的ping request的发送时间
+	rtt             time.Duration
+
+	// 上层接受publish或play之前，上层调用AsyncWrite写入的数据先缓存起来，回复完onStatus后再发送，保证对端先收到onStatus
+	startMutex  sync.Mutex
+	isStarted   bool
+	pendingMsgs [][]byte
 
 	// only for PubSession
 	avObs PubSessionObserver
@@ -69,9 +97,10 @@ func NewServerSession(obs ServerSessionObserver, conn net.Conn) *ServerSession {
 	uk := unique.GenUniqueKey("RTMPPUBSUB")
 	nazalog.Infof("[%s] lifecycle new rtmp server session. addr=%s", uk, conn.RemoteAddr().String())
 	return &ServerSession{
-		conn: connection.New(conn, func(option *connection.Option) {
+		conn: base.NewStatConn(connection.New(conn, func(option *connection.Option) {
 			option.ReadBufSize = readBufSize
-		}),
+		})),
+		startTime:     time.Now(),
 		UniqueKey:     uk,
 		obs:           obs,
 		t:             ServerSessionTypeUnknown,
@@ -83,6 +112,7 @@ func NewServerSession(obs ServerSessionObserver, conn net.Conn) *ServerSession {
 
 func (s *ServerSession) RunLoop() (err error) {
 	if err = s.handshake(); err != nil {
+		base.RTMPHandshakeFailedCounter.Incr()
 		return err
 	}
 
@@ -90,14 +120,70 @@ func (s *ServerSession) RunLoop() (err error) {
 }
 
 func (s *ServerSession) AsyncWrite(msg []byte) error {
+	s.startMutex.Lock()
+	defer s.startMutex.Unlock()
+	if !s.isStarted {
+		s.pendingMsgs = append(s.pendingMsgs, msg)
+		feedStatAVByChunks(&s.statAV, msg)
+		return nil
+	}
 	_, err := s.conn.Write(msg)
+	if err == nil {
+		feedStatAVByChunks(&s.statAV, msg)
+	}
 	return err
 }
 
 func (s *ServerSession) Flush() error {
+	s.startMutex.Lock()
+	defer s.startMutex.Unlock()
+	if !s.isStarted {
+		return nil
+	}
 	return s.conn.Flush()
 }
 
+// 通知对端流开始，比如输入流重新推流时发送给SubSession
+func (s *ServerSession) WriteStreamBegin() error {
+	nazalog.Infof("[%s] > W Stream Begin.", s.UniqueKey)
+	msg, err := s.packWithPacker(func(writer io.Writer) error {
+		return s.packer.writeStreamBegin(writer, MSID1)
+	})
+	if err != nil {
+		return err
+	}
+	return s.AsyncWrite(msg)
+}
+
+// 通知对端流结束，比如输入流断开时发送给SubSession
+func (s *ServerSession) WriteStreamEOF() error {
+	nazalog.Infof("[%s] > W Stream EOF.", s.UniqueKey)
+	msg, err := s.packWithPacker(func(writer io.Writer) error {
+		return s.packer.writeStreamEOF(writer, MSID1)
+	})
+	if err != nil {
+		return err
+	}
+	return s.AsyncWrite(msg)
+}
+
+// 已发送但对端还没有确认（Acknowledgement）的字节数，用于诊断流控问题
+// 对端没有回复过Acknowledgement时，为已发送的字节数
+func (s *ServerSession) AckLag() uint32 {
+	return s.ack.ackLag(s.conn.WroteBytes())
+}
+
+// 最近一次ping request和ping response的往返时间，对端没有回复过ping response时为0
+func (s *ServerSession) RTT() time.Duration {
+	s.pingMutex.Lock()
+	defer s.pingMutex.Unlock()
+	return s.rtt
+}
+
+func (s *ServerSession) GetStat() base.StatSession {
+	return base.NewStatSession(base.ProtocolRTMP, s.UniqueKey, s.startTime, s.conn.RemoteAddr().String(), s.conn, &s.statAV)
+}
+
 func (s *ServerSession) Dispose() {
 	nazalog.Infof("[%s] lifecycle dispose rtmp server session.", s.UniqueKey)
 	_ = s.conn.Close()
@@ -107,6 +193,122 @@ func (s *ServerSession) runReadLoop() error {
 	return s.chunkComposer.RunLoop(s.conn, s.doMsg)
 }
 
+// 注意，publish或play之后，conn使用channel异步发送，写入的内存块在真正发送前不能被复用，
+// 而packer内部的缓冲是复用的，所以先将信令写入一块新的内存，再交给conn发送
+func (s *ServerSession) asyncWriteWithPacker(fn func(writer io.Writer) error) error {
+	msg, err := s.packWithPacker(fn)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.Write(msg)
+	return err
+}
+
+func (s *ServerSession) packWithPacker(fn func(writer io.Writer) error) ([]byte, error) {
+	s.packerMutex.Lock()
+	defer s.packerMutex.Unlock()
+	var buf bytes.Buffer
+	if err := fn(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 上层接受publish或play后调用
+// 回复信令，修改connection的属性，然后按顺序发送缓存的数据，之后AsyncWrite直接发送
+func (s *ServerSession) start(writeStatus func() error) error {
+	s.startMutex.Lock()
+	defer s.startMutex.Unlock()
+	if err := writeStatus(); err != nil {
+		return err
+	}
+
+	// 回复完信令后修改 connection 的属性
+	s.ModConnProps()
+
+	for _, msg := range s.pendingMsgs {
+		if _, err := s.conn.Write(msg); err != nil {
+			return err
+		}
+	}
+	s.pendingMsgs = nil
+	s.isStarted = true
+
+	go s.runPingLoop()
+	return nil
+}
+
+// 上层拒绝publish或play后调用，回复错误信令并关闭连接
+//
+// @param defaultCode reason不是StatusError类型时使用的onStatus code
+func (s *ServerSession) reject(reason error, defaultCode string) error {
+	code, description := defaultCode, reason.Error()
+	if se, ok := reason.(*StatusError); ok {
+		code, description = se.Code, se.Description
+	}
+	nazalog.Warnf("[%s] > W onStatus('%s'). description=%s", s.UniqueKey, code, description)
+	s.t = ServerSessionTypeUnknown
+	_ = s.asyncWriteWithPacker(func(writer io.Writer) error {
+		return s.packer.writeOnStatus(writer, MSID1, "error", code, description)
+	})
+	s.Dispose()
+	return ErrRTMPRejected
+}
+
+func (s *ServerSession) runPingLoop() {
+	t := time.NewTicker(time.Duration(serverSessionPingIntervalMS) * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.conn.Done():
+			return
+		case now := <-t.C:
+			if !s.ping(now) {
+				// 关闭连接后，读协程会退出，由上层走正常的session释放流程
+				nazalog.Warnf("[%s] ping response timeout, close conn.", s.UniqueKey)
+				_ = s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// @return 对端回复过ping response，但是之后的ping request超时没有回复时，返回false
+func (s *ServerSession) ping(now time.Time) bool {
+	s.pingMutex.Lock()
+	if s.pingRequestTS != 0 {
+		// 上一个ping request还没有回复
+		timeout := s.hasPingResponse && now.Sub(s.pingRequestTime) > time.Duration(serverSessionPingTimeoutMS)*time.Millisecond
+		s.pingMutex.Unlock()
+		return !timeout
+	}
+	s.pingRequestTime = now
+	s.pingRequestTS = uint32(now.UnixNano() / int64(time.Millisecond))
+	if s.pingRequestTS == 0 {
+		s.pingRequestTS = 1
+	}
+	ts := s.pingRequestTS
+	s.pingMutex.Unlock()
+
+	_ = s.asyncWriteWithPacker(func(writer io.Writer) error {
+		return s.packer.writePingRequest(writer, ts)
+	})
+	return true
+}
+
+func (s *ServerSession) onPingResponse(ts uint32) {
+	s.pingMutex.Lock()
+	defer s.pingMutex.Unlock()
+	if s.pingRequestTS == 0 || ts != s.pingRequestTS {
+		nazalog.Warnf("[%s] < R Ping Response, but timestamp not match. timestamp=%d, expected=%d", s.UniqueKey, ts, s.pingRequestTS)
+		return
+	}
+	s.rtt = time.Since(s.pingRequestTime)
+	s.hasPingResponse = true
+	s.pingRequestTS = 0
+	nazalog.Debugf("[%s] < R Ping Response. rtt=%v", s.UniqueKey, s.rtt)
+}
+
 func (s *ServerSession) handshake() error {
 	if err := s.hs.ReadC0C1(s.conn); err != nil {
 		return err
@@ -122,21 +324,39 @@ func (s *ServerSession) handshake() error {
 		return err
 	}
 	nazalog.Infof("[%s] < R Handshake C2.", s.UniqueKey)
+	s.ack.onHandshakeDone(s.conn.ReadBytes(), s.conn.WroteBytes())
 	return nil
 }
 
 func (s *ServerSession) doMsg(stream *Stream) error {
+	if err := s.checkAck(); err != nil {
+		return err
+	}
+
 	//log.Debugf("%d %d %v", stream.header.msgTypeID, stream.msgLen, stream.header)
 	switch stream.header.MsgTypeID {
 	case typeidSetChunkSize:
 		// noop
 		// 因为底层的 chunk composer 已经处理过了，这里就不用处理
+	case typeidWinAckSize:
+		fallthrough
+	case typeidBandwidth:
+		return s.doProtocolControlMessage(stream)
 	case typeidCommandMessageAMF0:
 		return s.doCommandMessage(stream)
+	case typeidCommandMessageAMF3:
+		return s.doCommandMessageAMF3(stream)
 	case TypeidDataMessageAMF0:
 		return s.doDataMessageAMF0(stream)
+	case TypeidDataMessageAMF3:
+		return s.doDataMessageAMF3(stream)
 	case typeidAck:
 		return s.doACK(stream)
+	case typeidUserControl:
+		return s.doUserControl(stream)
+	case typeidAggregateMessage:
+		// 拆分为子消息，逐个按普通消息处理
+		return stream.forEachAggregateSubMsg(s.doMsg)
 	case TypeidAudio:
 		fallthrough
 	case TypeidVideo:
@@ -144,6 +364,7 @@ func (s *ServerSession) doMsg(stream *Stream) error {
 			nazalog.Errorf("[%s] read audio/video message but server session not pub type.", s.UniqueKey)
 			return ErrRTMP
 		}
+		feedStatAVByHeader(&s.statAV, stream.header)
 		s.avObs.OnReadRTMPAVMsg(stream.toAVMsg())
 	default:
 		nazalog.Warnf("[%s] read unknown message. typeid=%d, %s", s.UniqueKey, stream.header.MsgTypeID, stream.toDebugString())
@@ -153,8 +374,73 @@ func (s *ServerSession) doMsg(stream *Stream) error {
 }
 
 func (s *ServerSession) doACK(stream *Stream) error {
+	if stream.msg.len() < 4 {
+		return ErrRTMP
+	}
 	seqNum := bele.BEUint32(stream.msg.buf[stream.msg.b:stream.msg.e])
-	nazalog.Infof("[%s] < R Acknowledgement. ignore. sequence number=%d.", s.UniqueKey, seqNum)
+	s.ack.onPeerAck(seqNum)
+	nazalog.Debugf("[%s] < R Acknowledgement. sequence number=%d, ack lag=%d", s.UniqueKey, seqNum, s.AckLag())
+	return nil
+}
+
+func (s *ServerSession) doProtocolControlMessage(stream *Stream) error {
+	if stream.msg.len() < 4 {
+		return ErrRTMP
+	}
+	val := bele.BEUint32(stream.msg.buf[stream.msg.b:])
+
+	switch stream.header.MsgTypeID {
+	case typeidWinAckSize:
+		nazalog.Infof("[%s] < R Window Acknowledgement Size: %d", s.UniqueKey, val)
+		s.ack.onPeerWinAckSize(val)
+	case typeidBandwidth:
+		if stream.msg.len() < 5 {
+			return ErrRTMP
+		}
+		limitType := stream.msg.buf[stream.msg.b+4]
+		nazalog.Infof("[%s] < R Set Peer Bandwidth. val=%d, limit type=%d", s.UniqueKey, val, limitType)
+		if size, changed := s.ack.onPeerBandwidth(val, limitType); changed {
+			nazalog.Infof("[%s] > W Window Acknowledgement Size %d.", s.UniqueKey, size)
+			s.ack.onWinAckSizeSent(size)
+			return s.asyncWriteWithPacker(func(writer io.Writer) error {
+				return s.packer.writeWinAckSize(writer, int(size))
+			})
+		}
+	}
+	return nil
+}
+
+// 收到的字节数达到对端通知的窗口大小时，回复Acknowledgement
+func (s *ServerSession) checkAck() error {
+	seqNum, ok := s.ack.checkAck(s.conn.ReadBytes())
+	if !ok {
+		return nil
+	}
+	nazalog.Debugf("[%s] > W Acknowledgement. sequence number=%d", s.UniqueKey, seqNum)
+	return s.asyncWriteWithPacker(func(writer io.Writer) error {
+		return s.packer.writeAcknowledgement(writer, seqNum)
+	})
+}
+
+func (s *ServerSession) doUserControl(stream *Stream) error {
+	if stream.msg.len() < 6 {
+		return ErrRTMP
+	}
+	eventType := bele.BEUint16(stream.msg.buf[stream.msg.b:])
+	val := bele.BEUint32(stream.msg.buf[stream.msg.b+2:])
+	switch eventType {
+	case userControlEventPingRequest:
+		nazalog.Debugf("[%s] < R Ping Request. timestamp=%d", s.UniqueKey, val)
+		return s.asyncWriteWithPacker(func(writer io.Writer) error {
+			return s.packer.writePingResponse(writer, val)
+		})
+	case userControlEventPingResponse:
+		s.onPingResponse(val)
+	case userControlEventSetBufferLength:
+		nazalog.Debugf("[%s] < R Set Buffer Length. stream id=%d", s.UniqueKey, val)
+	default:
+		nazalog.Debugf("[%s] < R user control message, ignore. event type=%d, val=%d", s.UniqueKey, eventType, val)
+	}
 	return nil
 }
 
@@ -196,6 +482,23 @@ func (s *ServerSession) doDataMessageAMF0(stream *Stream) error {
 	return nil
 }
 
+func (s *ServerSession) doDataMessageAMF3(stream *Stream) error {
+	amf0Stream, err := stream.toAMF0DataStream()
+	if err != nil {
+		return err
+	}
+	return s.doDataMessageAMF0(amf0Stream)
+}
+
+func (s *ServerSession) doCommandMessageAMF3(stream *Stream) error {
+	// 跳过第一个字节，之后的内容和AMF0信令相同
+	if stream.msg.len() < 1 {
+		return ErrRTMP
+	}
+	stream.msg.consumed(1)
+	return s.doCommandMessage(stream)
+}
+
 func (s *ServerSession) doCommandMessage(stream *Stream) error {
 	cmd, err := stream.msg.readStringWithType()
 	if err != nil {
@@ -241,11 +544,23 @@ func (s *ServerSession) doConnect(tid int, stream *Stream) error {
 		return err
 	}
 	nazalog.Infof("[%s] < R connect('%s').", s.UniqueKey, s.AppName)
+	if fourCCList := val.Find("fourCcList"); fourCCList != nil {
+		nazalog.Infof("[%s] client support enhanced rtmp. fourCcList=%v", s.UniqueKey, fourCCList)
+	}
+	objectEncoding := objectEncodingAMF0
+	if v, ok := val.Find("objectEncoding").(float64); ok {
+		objectEncoding = int(v)
+	}
+	if objectEncoding == objectEncodingAMF3 {
+		nazalog.Infof("[%s] client use amf3 object encoding.", s.UniqueKey)
+		s.packer.commandAMF3 = true
+	}
 
 	nazalog.Infof("[%s] > W Window Acknowledgement Size %d.", s.UniqueKey, windowAcknowledgementSize)
 	if err := s.packer.writeWinAckSize(s.conn, windowAcknowledgementSize); err != nil {
 		return err
 	}
+	s.ack.onWinAckSizeSent(uint32(windowAcknowledgementSize))
 
 	nazalog.Infof("[%s] > W Set Peer Bandwidth.", s.UniqueKey)
 	if err := s.packer.writePeerBandwidth(s.conn, peerBandwidth, peerBandwidthLimitTypeDynamic); err != nil {
@@ -258,7 +573,7 @@ func (s *ServerSession) doConnect(tid int, stream *Stream) error {
 	}
 
 	nazalog.Infof("[%s] > W _result('NetConnection.Connect.Success').", s.UniqueKey)
-	if err := s.packer.writeConnectResult(s.conn, tid); err != nil {
+	if err := s.packer.writeConnectResult(s.conn, tid, objectEncoding); err != nil {
 		return err
 	}
 	return nil
@@ -281,8 +596,7 @@ func (s *ServerSession) doPublish(tid int, stream *Stream) (err error) {
 	if err != nil {
 		return err
 	}
-	ss := strings.Split(s.StreamNameWithRawQuery, "?")
-	s.StreamName = ss[0]
+	s.parseStreamNameWithRawQuery()
 
 	pubType, err := stream.msg.readStringWithType()
 	if err != nil {
@@ -291,18 +605,17 @@ func (s *ServerSession) doPublish(tid int, stream *Stream) (err error) {
 	nazalog.Debugf("[%s] pubType=%s", s.UniqueKey, pubType)
 	nazalog.Infof("[%s] < R publish('%s')", s.UniqueKey, s.StreamName)
 
-	nazalog.Infof("[%s] > W onStatus('NetStream.Publish.Start').", s.UniqueKey)
-	if err := s.packer.writeOnStatusPublish(s.conn, MSID1); err != nil {
-		return err
-	}
-
-	// 回复完信令后修改 connection 的属性
-	s.ModConnProps()
-
 	s.t = ServerSessionTypePub
-	s.obs.OnNewRTMPPubSession(s)
+	if err := s.obs.OnNewRTMPPubSession(s); err != nil {
+		return s.reject(err, StatusCodePublishBadName)
+	}
 
-	return nil
+	return s.start(func() error {
+		nazalog.Infof("[%s] > W onStatus('NetStream.Publish.Start').", s.UniqueKey)
+		return s.asyncWriteWithPacker(func(writer io.Writer) error {
+			return s.packer.writeOnStatusPublish(writer, MSID1)
+		})
+	})
 }
 
 func (s *ServerSession) doPlay(tid int, stream *Stream) (err error) {
@@ -313,24 +626,37 @@ func (s *ServerSession) doPlay(tid int, stream *Stream) (err error) {
 	if err != nil {
 		return err
 	}
-	ss := strings.Split(s.StreamNameWithRawQuery, "?")
-	s.StreamName = ss[0]
+	s.parseStreamNameWithRawQuery()
 
 	nazalog.Infof("[%s] < R play('%s').", s.StreamName, s.UniqueKey)
 	// TODO chef: start duration reset
 
-	nazalog.Infof("[%s] > W onStatus('NetStream.Play.Start').", s.UniqueKey)
-	if err := s.packer.writeOnStatusPlay(s.conn, MSID1); err != nil {
-		return err
+	s.t = ServerSessionTypeSub
+	if err := s.obs.OnNewRTMPSubSession(s); err != nil {
+		return s.reject(err, StatusCodePlayStreamNotFound)
 	}
 
-	// 回复完信令后修改 connection 的属性
-	s.ModConnProps()
+	return s.start(func() error {
+		nazalog.Infof("[%s] > W Stream Begin.", s.UniqueKey)
+		if err := s.asyncWriteWithPacker(func(writer io.Writer) error {
+			return s.packer.writeStreamBegin(writer, MSID1)
+		}); err != nil {
+			return err
+		}
 
-	s.t = ServerSessionTypeSub
-	s.obs.OnNewRTMPSubSession(s)
+		nazalog.Infof("[%s] > W onStatus('NetStream.Play.Start').", s.UniqueKey)
+		return s.asyncWriteWithPacker(func(writer io.Writer) error {
+			return s.packer.writeOnStatusPlay(writer, MSID1)
+		})
+	})
+}
 
-	return nil
+func (s *ServerSession) parseStreamNameWithRawQuery() {
+	ss := strings.SplitN(s.StreamNameWithRawQuery, "?", 2)
+	s.StreamName = ss[0]
+	if len(ss) == 2 {
+		s.RawQuery = ss[1]
+	}
 }
 
 func (s *ServerSession) ModConnProps() {
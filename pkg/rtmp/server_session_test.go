@@ -0,0 +1,254 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type pingServerObserver struct {
+	subChan chan *ServerSession
+}
+
+func (o *pingServerObserver) OnNewRTMPPubSession(session *ServerSession) error {
+	return nil
+}
+
+func (o *pingServerObserver) OnDelRTMPPubSession(session *ServerSession) {}
+
+func (o *pingServerObserver) OnNewRTMPSubSession(session *ServerSession) error {
+	o.subChan <- session
+	return nil
+}
+
+func (o *pingServerObserver) OnDelRTMPSubSession(session *ServerSession) {}
+
+func TestServerSessionPing(t *testing.T) {
+	oldIntervalMS := serverSessionPingIntervalMS
+	serverSessionPingIntervalMS = 50
+	defer func() {
+		serverSessionPingIntervalMS = oldIntervalMS
+	}()
+
+	obs := &pingServerObserver{subChan: make(chan *ServerSession, 1)}
+	addr := "127.0.0.1:14937"
+	server := NewServer(obs, addr)
+	assert.Equal(t, nil, server.Listen())
+	defer server.Dispose()
+	go server.RunLoop()
+
+	ps := NewPullSession(func(option *PullSessionOption) {
+		option.PullTimeoutMS = 5000
+	})
+	err := ps.Pull("rtmp://"+addr+"/live/test110", func(msg AVMsg) {})
+	assert.Equal(t, nil, err)
+	defer ps.Dispose()
+
+	session := <-obs.subChan
+	assert.Equal(t, time.Duration(0), session.RTT())
+
+	// PullSession会回复ping response
+	var rtt time.Duration
+	for i := 0; i < 100; i++ {
+		if rtt = session.RTT(); rtt != 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, true, rtt > 0)
+
+	assert.Equal(t, nil, session.WriteStreamEOF())
+	assert.Equal(t, nil, session.WriteStreamBegin())
+}
+
+func TestServerSessionPingTimeout(t *testing.T) {
+	now := time.Now()
+	timeout := time.Duration(serverSessionPingTimeoutMS) * time.Millisecond
+
+	// 对端没有回复过ping response，不做超时判断
+	s := &ServerSession{pingRequestTS: 1, pingRequestTime: now}
+	assert.Equal(t, true, s.ping(now.Add(timeout*2)))
+
+	s = &ServerSession{hasPingResponse: true, pingRequestTS: 1, pingRequestTime: now}
+	assert.Equal(t, true, s.ping(now.Add(timeout/2)))
+	assert.Equal(t, false, s.ping(now.Add(timeout*2)))
+
+	// 时间戳不匹配的ping response被忽略
+	s.onPingResponse(2)
+	assert.Equal(t, false, s.ping(now.Add(timeout*2)))
+	s.onPingResponse(1)
+	assert.Equal(t, true, s.RTT() > 0)
+}
+
+func TestServerSessionAck(t *testing.T) {
+	oldWinAckSize := windowAcknowledgementSize
+	windowAcknowledgementSize = 1000
+	defer func() {
+		windowAcknowledgementSize = oldWinAckSize
+	}()
+
+	obs := &pingServerObserver{subChan: make(chan *ServerSession, 1)}
+	addr := "127.0.0.1:14938"
+	server := NewServer(obs, addr)
+	assert.Equal(t, nil, server.Listen())
+	defer server.Dispose()
+	go server.RunLoop()
+
+	ps := NewPullSession(func(option *PullSessionOption) {
+		option.PullTimeoutMS = 5000
+	})
+	msgChan := make(chan struct{}, 16)
+	err := ps.Pull("rtmp://"+addr+"/live/test110", func(msg AVMsg) {
+		msgChan <- struct{}{}
+	})
+	assert.Equal(t, nil, err)
+	defer ps.Dispose()
+
+	session := <-obs.subChan
+	payload := make([]byte, 500)
+	payload[0] = SoundFormatAAC << 4
+	payload[1] = AACPacketTypeRaw
+	h := Header{CSID: CSIDAudio, MsgLen: uint32(len(payload)), MsgTypeID: TypeidAudio, MsgStreamID: MSID1}
+	for i := 0; i < 10; i++ {
+		h.TimestampAbs = uint32(i)
+		assert.Equal(t, nil, session.AsyncWrite(Message2Chunks(payload, &h)))
+		<-msgChan
+	}
+
+	// PullSession按服务端通知的窗口大小回复ack
+	var lag uint32
+	for i := 0; i < 100; i++ {
+		if lag = session.AckLag(); lag < 1000 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, true, lag < 1000)
+}
+
+func TestClientSessionAggregateMessage(t *testing.T) {
+	obs := &pingServerObserver{subChan: make(chan *ServerSession, 1)}
+	addr := "127.0.0.1:14939"
+	server := NewServer(obs, addr)
+	assert.Equal(t, nil, server.Listen())
+	defer server.Dispose()
+	go server.RunLoop()
+
+	ps := NewPullSession(func(option *PullSessionOption) {
+		option.PullTimeoutMS = 5000
+	})
+	msgChan := make(chan AVMsg, 16)
+	err := ps.Pull("rtmp://"+addr+"/live/test110", func(msg AVMsg) {
+		msg.Payload = append([]byte(nil), msg.Payload...)
+		msgChan <- msg
+	})
+	assert.Equal(t, nil, err)
+	defer ps.Dispose()
+
+	session := <-obs.subChan
+	var payload []byte
+	payload = append(payload, makeAggregateSubMsg(TypeidVideo, 100, []byte{0x17, 0x01, 0x02}, true)...)
+	payload = append(payload, makeAggregateSubMsg(TypeidAudio, 120, []byte{0xaf, 0x01}, true)...)
+	h := Header{CSID: CSIDVideo, MsgLen: uint32(len(payload)), MsgTypeID: typeidAggregateMessage, MsgStreamID: MSID1, TimestampAbs: 5000}
+	assert.Equal(t, nil, session.AsyncWrite(Message2Chunks(payload, &h)))
+
+	msg := <-msgChan
+	assert.Equal(t, TypeidVideo, msg.Header.MsgTypeID)
+	assert.Equal(t, uint32(5000), msg.Header.TimestampAbs)
+	assert.Equal(t, []byte{0x17, 0x01, 0x02}, msg.Payload)
+	msg = <-msgChan
+	assert.Equal(t, TypeidAudio, msg.Header.MsgTypeID)
+	assert.Equal(t, uint32(5020), msg.Header.TimestampAbs)
+	assert.Equal(t, []byte{0xaf, 0x01}, msg.Payload)
+}
+
+type admissionServerObserver struct {
+	rejectErr error  // 不为nil时，拒绝publish和play
+	subMsg    []byte // 不为空时，在接受play的回调中写入
+	delChan   chan struct{}
+}
+
+func (o *admissionServerObserver) OnNewRTMPPubSession(session *ServerSession) error {
+	return o.rejectErr
+}
+
+func (o *admissionServerObserver) OnDelRTMPPubSession(session *ServerSession) {
+	o.delChan <- struct{}{}
+}
+
+func (o *admissionServerObserver) OnNewRTMPSubSession(session *ServerSession) error {
+	if o.rejectErr == nil && o.subMsg != nil {
+		_ = session.AsyncWrite(o.subMsg)
+	}
+	return o.rejectErr
+}
+
+func (o *admissionServerObserver) OnDelRTMPSubSession(session *ServerSession) {
+	o.delChan <- struct{}{}
+}
+
+func TestServerSessionReject(t *testing.T) {
+	obs := &admissionServerObserver{rejectErr: &StatusError{Code: StatusCodePlayFailed, Description: "test"}, delChan: make(chan struct{}, 2)}
+	addr := "127.0.0.1:14940"
+	server := NewServer(obs, addr)
+	assert.Equal(t, nil, server.Listen())
+	defer server.Dispose()
+	go server.RunLoop()
+
+	pushSession := NewPushSession(func(option *PushSessionOption) {
+		option.PushTimeoutMS = 5000
+	})
+	err := pushSession.Push("rtmp://" + addr + "/live/test110")
+	assert.IsNotNil(t, err)
+	pushSession.Dispose()
+
+	pullSession := NewPullSession(func(option *PullSessionOption) {
+		option.PullTimeoutMS = 5000
+	})
+	err = pullSession.Pull("rtmp://"+addr+"/live/test110", func(msg AVMsg) {})
+	assert.IsNotNil(t, err)
+	pullSession.Dispose()
+
+	// 被拒绝的session不会触发OnDel回调
+	select {
+	case <-obs.delChan:
+		t.Fatal("unexpected del callback")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServerSessionWriteBeforeStart(t *testing.T) {
+	payload := []byte{0x17, 0x00, 0x00, 0x00, 0x00}
+	h := Header{CSID: CSIDVideo, MsgLen: uint32(len(payload)), MsgTypeID: TypeidVideo, MsgStreamID: MSID1, TimestampAbs: 100}
+	obs := &admissionServerObserver{subMsg: Message2Chunks(payload, &h), delChan: make(chan struct{}, 2)}
+	addr := "127.0.0.1:14941"
+	server := NewServer(obs, addr)
+	assert.Equal(t, nil, server.Listen())
+	defer server.Dispose()
+	go server.RunLoop()
+
+	// 接受play的回调中写入的数据，在onStatus之后才发送给对端
+	pullSession := NewPullSession(func(option *PullSessionOption) {
+		option.PullTimeoutMS = 5000
+	})
+	msgChan := make(chan AVMsg, 1)
+	err := pullSession.Pull("rtmp://"+addr+"/live/test110", func(msg AVMsg) {
+		msg.Payload = append([]byte(nil), msg.Payload...)
+		msgChan <- msg
+	})
+	assert.Equal(t, nil, err)
+	defer pullSession.Dispose()
+
+	msg := <-msgChan
+	assert.Equal(t, TypeidVideo, msg.Header.MsgTypeID)
+	assert.Equal(t, payload, msg.Payload)
+}
@@ -0,0 +1,117 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/q191201771/lal/pkg/rtmp"
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+type tlsServerObserver struct {
+	pubChan chan string
+}
+
+func (o *tlsServerObserver) OnNewRTMPPubSession(session *rtmp.ServerSession) error {
+	o.pubChan <- session.StreamName
+	return nil
+}
+
+func (o *tlsServerObserver) OnDelRTMPPubSession(session *rtmp.ServerSession) {}
+
+func (o *tlsServerObserver) OnNewRTMPSubSession(session *rtmp.ServerSession) error {
+	return nil
+}
+
+func (o *tlsServerObserver) OnDelRTMPSubSession(session *rtmp.ServerSession) {}
+
+func TestTLSServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lal_rtmps")
+	assert.Equal(t, nil, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	genSelfSignedCert(t, certFile, keyFile)
+
+	obs := &tlsServerObserver{pubChan: make(chan string, 1)}
+	addr := "127.0.0.1:14935"
+	server := rtmp.NewTLSServer(obs, addr, certFile, keyFile)
+	assert.Equal(t, nil, server.Listen())
+	defer server.Dispose()
+	go server.RunLoop()
+
+	// 自签名证书，默认会校验失败
+	ps := rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+		option.PushTimeoutMS = 5000
+	})
+	err = ps.Push("rtmps://" + addr + "/live/test110")
+	assert.IsNotNil(t, err)
+
+	// 使用自签名证书作为根证书
+	ps = rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+		option.PushTimeoutMS = 5000
+		option.TLSRootCAFile = certFile
+	})
+	err = ps.Push("rtmps://" + addr + "/live/test110")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test110", <-obs.pubChan)
+	ps.Dispose()
+
+	// 不校验证书
+	ps = rtmp.NewPushSession(func(option *rtmp.PushSessionOption) {
+		option.PushTimeoutMS = 5000
+		option.TLSInsecureSkipVerify = true
+	})
+	err = ps.Push("rtmps://" + addr + "/live/test111")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "test111", <-obs.pubChan)
+	ps.Dispose()
+
+	// 证书和私钥不存在
+	server2 := rtmp.NewTLSServer(obs, "127.0.0.1:14936", filepath.Join(dir, "notexist.pem"), keyFile)
+	assert.IsNotNil(t, server2.Listen())
+}
+
+func genSelfSignedCert(t *testing.T, certFile string, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Equal(t, nil, err)
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "lal"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	assert.Equal(t, nil, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Equal(t, nil, err)
+
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	assert.Equal(t, nil, err)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	assert.Equal(t, nil, err)
+}
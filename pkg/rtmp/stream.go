@@ -9,9 +9,11 @@
 package rtmp
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 
+	"github.com/q191201771/naza/pkg/bele"
 	log "github.com/q191201771/naza/pkg/nazalog"
 )
 
@@ -62,6 +64,113 @@ func (stream *Stream) toAVMsg() AVMsg {
 	}
 }
 
+// 将AMF3数据消息（type 15）转换为AMF0数据消息（type 18），之后就可以和AMF0数据消息一样处理了
+//
+// 注意，AMF3特有的类型（比如date，byte array，dense array）在AMF0中没有对应，转换时会被丢弃（或者转换为null）
+func (stream *Stream) toAMF0DataStream() (*Stream, error) {
+	// 跳过第一个字节
+	if stream.msg.len() < 1 {
+		return nil, ErrAMFTooShort
+	}
+	stream.msg.consumed(1)
+
+	var buf bytes.Buffer
+	for stream.msg.len() > 0 {
+		v, err := stream.msg.readValue()
+		if err != nil {
+			return nil, err
+		}
+		v, _ = amf3ToAMF0Value(v)
+		switch val := v.(type) {
+		case string:
+			_ = AMF0.WriteString(&buf, val)
+		case float64:
+			_ = AMF0.WriteNumber(&buf, val)
+		case bool:
+			_ = AMF0.WriteBoolean(&buf, val)
+		case ObjectPairArray:
+			_ = AMF0.WriteObject(&buf, val)
+		default:
+			_ = AMF0.WriteNull(&buf)
+		}
+	}
+
+	out := NewStream()
+	out.header = stream.header
+	out.header.MsgTypeID = TypeidDataMessageAMF0
+	out.header.MsgLen = uint32(buf.Len())
+	out.msg.reserve(uint32(buf.Len()))
+	copy(out.msg.buf[out.msg.e:], buf.Bytes())
+	out.msg.produced(uint32(buf.Len()))
+	return out, nil
+}
+
+// 聚合消息（type 22）中每个子消息的头部大小，以及子消息之后back pointer的大小
+const (
+	aggregateSubHeaderSize   = 11
+	aggregateBackPointerSize = 4
+)
+
+// 遍历聚合消息（type 22）中的子消息
+//
+// 子消息的格式和flv tag相同：type(1) size(3) timestamp(3) timestamp extended(1) stream id(3) body(size) back pointer(4)
+// 子消息中的时间戳是相对于第一个子消息的，需要加上聚合消息自身的时间戳
+//
+// @param cb 子消息的内存块引用自聚合消息，回调结束后不应再使用
+func (stream *Stream) forEachAggregateSubMsg(cb func(sub *Stream) error) error {
+	b := stream.msg.buf[stream.msg.b:stream.msg.e]
+	var firstTimestamp uint32
+	for i := 0; len(b) > 0; i++ {
+		if len(b) < aggregateSubHeaderSize {
+			return ErrRTMP
+		}
+		size := int(bele.BEUint24(b[1:]))
+		timestamp := bele.BEUint24(b[4:]) | uint32(b[7])<<24
+		if len(b) < aggregateSubHeaderSize+size {
+			return ErrRTMP
+		}
+		if i == 0 {
+			firstTimestamp = timestamp
+		}
+
+		var sub Stream
+		sub.header = stream.header
+		sub.header.MsgTypeID = b[0]
+		sub.header.MsgLen = uint32(size)
+		sub.header.TimestampAbs = stream.header.TimestampAbs + timestamp - firstTimestamp
+		sub.header.Timestamp = sub.header.TimestampAbs
+		sub.msg.buf = b[aggregateSubHeaderSize : aggregateSubHeaderSize+size]
+		sub.msg.e = uint32(size)
+		if err := cb(&sub); err != nil {
+			return err
+		}
+
+		b = b[aggregateSubHeaderSize+size:]
+		// 兼容最后一个子消息没有back pointer的情况
+		if len(b) < aggregateBackPointerSize {
+			break
+		}
+		b = b[aggregateBackPointerSize:]
+	}
+	return nil
+}
+
+func amf3ToAMF0Value(v interface{}) (interface{}, bool) {
+	switch val := v.(type) {
+	case nil, string, float64, bool:
+		return v, true
+	case ObjectPairArray:
+		opa := make(ObjectPairArray, 0, len(val))
+		for _, op := range val {
+			if nv, ok := amf3ToAMF0Value(op.Value); ok {
+				opa = append(opa, ObjectPair{Key: op.Key, Value: nv})
+			}
+		}
+		return opa, true
+	}
+	return nil, false
+}
+
 func (msg *StreamMsg) reserve(n uint32) {
 	bufCap := uint32(cap(msg.buf))
 	nn := bufCap - msg.e
@@ -98,12 +207,37 @@ func (msg *StreamMsg) clear() {
 //	return msg.buf[msg.b: msg.e]
 //}
 
+// 注意，AMF3信令（以及数据）中的值可能通过avmplus-object-marker切换为AMF3编码，以下read系列的函数都做了兼容
+
 func (msg *StreamMsg) peekStringWithType() (string, error) {
-	str, _, err := AMF0.ReadString(msg.buf[msg.b:msg.e])
+	b := msg.buf[msg.b:msg.e]
+	if msg.isAVMPlusObject() {
+		v, _, err := AMF0.ReadAVMPlusObject(b)
+		if err != nil {
+			return "", err
+		}
+		str, ok := v.(string)
+		if !ok {
+			return "", ErrAMFInvalidType
+		}
+		return str, nil
+	}
+	str, _, err := AMF0.ReadString(b)
 	return str, err
 }
 
 func (msg *StreamMsg) readStringWithType() (string, error) {
+	if msg.isAVMPlusObject() {
+		v, err := msg.readAVMPlusObject()
+		if err != nil {
+			return "", err
+		}
+		str, ok := v.(string)
+		if !ok {
+			return "", ErrAMFInvalidType
+		}
+		return str, nil
+	}
 	str, l, err := AMF0.ReadString(msg.buf[msg.b:msg.e])
 	if err == nil {
 		msg.consumed(uint32(l))
@@ -112,6 +246,17 @@ func (msg *StreamMsg) readStringWithType() (string, error) {
 }
 
 func (msg *StreamMsg) readNumberWithType() (int, error) {
+	if msg.isAVMPlusObject() {
+		v, err := msg.readAVMPlusObject()
+		if err != nil {
+			return 0, err
+		}
+		val, ok := v.(float64)
+		if !ok {
+			return 0, ErrAMFInvalidType
+		}
+		return int(val), nil
+	}
 	val, l, err := AMF0.ReadNumber(msg.buf[msg.b:msg.e])
 	if err == nil {
 		msg.consumed(uint32(l))
@@ -120,6 +265,17 @@ func (msg *StreamMsg) readNumberWithType() (int, error) {
 }
 
 func (msg *StreamMsg) readObjectWithType() (ObjectPairArray, error) {
+	if msg.isAVMPlusObject() {
+		v, err := msg.readAVMPlusObject()
+		if err != nil {
+			return nil, err
+		}
+		opa, ok := v.(ObjectPairArray)
+		if !ok {
+			return nil, ErrAMFInvalidType
+		}
+		return opa, nil
+	}
 	opa, l, err := AMF0.ReadObject(msg.buf[msg.b:msg.e])
 	if err == nil {
 		msg.consumed(uint32(l))
@@ -128,9 +284,67 @@ func (msg *StreamMsg) readObjectWithType() (ObjectPairArray, error) {
 }
 
 func (msg *StreamMsg) readNull() error {
+	if msg.isAVMPlusObject() {
+		v, err := msg.readAVMPlusObject()
+		if err != nil {
+			return err
+		}
+		if v != nil {
+			return ErrAMFInvalidType
+		}
+		return nil
+	}
 	l, err := AMF0.ReadNull(msg.buf[msg.b:msg.e])
 	if err == nil {
 		msg.consumed(uint32(l))
 	}
 	return err
 }
+
+// 读取一个任意类型的值，AMF0编码或者AMF3编码都可以，用于将AMF3数据转换为AMF0数据
+//
+// 注意，AMF0编码时只支持string，number，boolean，null，object和ecma array
+func (msg *StreamMsg) readValue() (interface{}, error) {
+	if msg.isAVMPlusObject() {
+		return msg.readAVMPlusObject()
+	}
+	b := msg.buf[msg.b:msg.e]
+	if len(b) < 1 {
+		return nil, ErrAMFTooShort
+	}
+	var (
+		v   interface{}
+		l   int
+		err error
+	)
+	switch b[0] {
+	case AMF0TypeMarkerString, AMF0TypeMarkerLongString:
+		v, l, err = AMF0.ReadString(b)
+	case AMF0TypeMarkerNumber:
+		v, l, err = AMF0.ReadNumber(b)
+	case AMF0TypeMarkerBoolean:
+		v, l, err = AMF0.ReadBoolean(b)
+	case AMF0TypeMarkerNull:
+		l, err = AMF0.ReadNull(b)
+	case AMF0TypeMarkerObject, AMF0TypeMarkerEcmaArray:
+		v, l, err = AMF0.ReadObjectOrArray(b)
+	default:
+		err = ErrAMFInvalidType
+	}
+	if err == nil {
+		msg.consumed(uint32(l))
+	}
+	return v, err
+}
+
+func (msg *StreamMsg) isAVMPlusObject() bool {
+	return msg.len() > 0 && msg.buf[msg.b] == AMF0TypeMarkerAVMPlusObject
+}
+
+func (msg *StreamMsg) readAVMPlusObject() (interface{}, error) {
+	v, l, err := AMF0.ReadAVMPlusObject(msg.buf[msg.b:msg.e])
+	if err == nil {
+		msg.consumed(uint32(l))
+	}
+	return v, err
+}
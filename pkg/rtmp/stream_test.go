@@ -0,0 +1,89 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtmp
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+// 生成聚合消息中的一个子消息
+func makeAggregateSubMsg(typeID uint8, timestamp uint32, body []byte, withBackPointer bool) []byte {
+	size := len(body)
+	b := []byte{
+		typeID, uint8(size >> 16), uint8(size >> 8), uint8(size),
+		uint8(timestamp >> 16), uint8(timestamp >> 8), uint8(timestamp), uint8(timestamp >> 24),
+		0, 0, 0,
+	}
+	b = append(b, body...)
+	if withBackPointer {
+		n := len(b)
+		b = append(b, uint8(n>>24), uint8(n>>16), uint8(n>>8), uint8(n))
+	}
+	return b
+}
+
+func makeAggregateStream(timestampAbs uint32, payload []byte) *Stream {
+	stream := NewStream()
+	stream.header.CSID = CSIDVideo
+	stream.header.MsgTypeID = typeidAggregateMessage
+	stream.header.MsgStreamID = MSID1
+	stream.header.MsgLen = uint32(len(payload))
+	stream.header.TimestampAbs = timestampAbs
+	stream.msg.reserve(uint32(len(payload)))
+	copy(stream.msg.buf, payload)
+	stream.msg.produced(uint32(len(payload)))
+	return stream
+}
+
+func TestStream_ForEachAggregateSubMsg(t *testing.T) {
+	var payload []byte
+	payload = append(payload, makeAggregateSubMsg(TypeidVideo, 0x01000010, []byte{0x17, 0x01, 0x02}, true)...)
+	payload = append(payload, makeAggregateSubMsg(TypeidAudio, 0x01000030, []byte{0xaf, 0x01}, true)...)
+	// 最后一个子消息没有back pointer
+	payload = append(payload, makeAggregateSubMsg(TypeidDataMessageAMF0, 0x01000040, []byte{0x05}, false)...)
+
+	stream := makeAggregateStream(1000, payload)
+	var subs []AVMsg
+	err := stream.forEachAggregateSubMsg(func(sub *Stream) error {
+		msg := sub.toAVMsg()
+		msg.Payload = append([]byte(nil), msg.Payload...)
+		subs = append(subs, msg)
+		return nil
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, len(subs))
+
+	assert.Equal(t, TypeidVideo, subs[0].Header.MsgTypeID)
+	assert.Equal(t, uint32(1000), subs[0].Header.TimestampAbs)
+	assert.Equal(t, uint32(3), subs[0].Header.MsgLen)
+	assert.Equal(t, []byte{0x17, 0x01, 0x02}, subs[0].Payload)
+	assert.Equal(t, MSID1, subs[0].Header.MsgStreamID)
+
+	assert.Equal(t, TypeidAudio, subs[1].Header.MsgTypeID)
+	assert.Equal(t, uint32(1032), subs[1].Header.TimestampAbs)
+	assert.Equal(t, []byte{0xaf, 0x01}, subs[1].Payload)
+
+	assert.Equal(t, TypeidDataMessageAMF0, subs[2].Header.MsgTypeID)
+	assert.Equal(t, uint32(1048), subs[2].Header.TimestampAbs)
+	assert.Equal(t, []byte{0x05}, subs[2].Payload)
+
+	// 子消息长度超出聚合消息
+	stream = makeAggregateStream(1000, payload[:20])
+	err = stream.forEachAggregateSubMsg(func(sub *Stream) error {
+		return nil
+	})
+	assert.Equal(t, ErrRTMP, err)
+	stream = makeAggregateStream(1000, payload[:5])
+	err = stream.forEachAggregateSubMsg(func(sub *Stream) error {
+		return nil
+	})
+	assert.Equal(t, ErrRTMP, err)
+}
@@ -18,4 +18,10 @@ var (
 	LocalChunkSize                = 4096 // 本端设置的 chunk size
 	windowAcknowledgementSize     = 5000000
 	peerBandwidth                 = 5000000
+
+	// publish或play之后，ServerSession周期性的发送ping request，用于测量rtt
+	// 对端回复过ping response之后又超过serverSessionPingTimeoutMS没有回复，则认为对端已经不可用，主动关闭连接
+	// 注意，不回复ping的对端不受超时的影响
+	serverSessionPingIntervalMS = 2000
+	serverSessionPingTimeoutMS  = 5000
 )
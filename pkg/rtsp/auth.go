@@ -0,0 +1,102 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// rfc2617 HTTP Authentication: Basic and Digest Access Authentication
+//
+// 客户端收到服务端回复的401后，使用回复中的WWW-Authenticate字段，生成后续请求中的Authorization字段
+
+const (
+	AuthTypeBasic  = "Basic"
+	AuthTypeDigest = "Digest"
+)
+
+type Auth struct {
+	Username string
+	Password string
+
+	Typ   string
+	Realm string
+	Nonce string
+}
+
+// @param s 服务端回复的WWW-Authenticate字段的值
+//
+// 例子:
+// Digest realm="IP Camera(12345)", nonce="a2b3c4d5e6f7", stale="FALSE"
+// Basic realm="IP Camera(12345)"
+func (a *Auth) FeedWWWAuthenticate(s string, username string, password string) {
+	a.Username = username
+	a.Password = password
+
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, AuthTypeDigest):
+		a.Typ = AuthTypeDigest
+		s = strings.TrimPrefix(s, AuthTypeDigest)
+	case strings.HasPrefix(s, AuthTypeBasic):
+		a.Typ = AuthTypeBasic
+		s = strings.TrimPrefix(s, AuthTypeBasic)
+	default:
+		a.Typ = ""
+		return
+	}
+
+	for _, item := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.Trim(kv[1], "\"")
+		switch kv[0] {
+		case "realm":
+			a.Realm = v
+		case "nonce":
+			a.Nonce = v
+		}
+	}
+}
+
+// 是否已经从WWW-Authenticate中获取到了鉴权方式
+func (a *Auth) HasInited() bool {
+	return a.Typ != ""
+}
+
+// 生成请求中的Authorization字段的值
+//
+// @param method 请求的方法，比如DESCRIBE
+// @param uri    请求的uri
+func (a *Auth) MakeAuthorization(method string, uri string) string {
+	switch a.Typ {
+	case AuthTypeBasic:
+		return AuthTypeBasic + " " + base64.StdEncoding.EncodeToString([]byte(a.Username+":"+a.Password))
+	case AuthTypeDigest:
+		// rfc2617 3.2.2.1 Request-Digest
+		// response = MD5(MD5(username:realm:password):nonce:MD5(method:uri))
+		ha1 := md5Hex(fmt.Sprintf("%s:%s:%s", a.Username, a.Realm, a.Password))
+		ha2 := md5Hex(fmt.Sprintf("%s:%s", method, uri))
+		response := md5Hex(fmt.Sprintf("%s:%s:%s", ha1, a.Nonce, ha2))
+		return fmt.Sprintf(`%s username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+			AuthTypeDigest, a.Username, a.Realm, a.Nonce, uri, response)
+	}
+	return ""
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,42 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"testing"
+
+	"github.com/q191201771/naza/pkg/assert"
+)
+
+func TestAuth(t *testing.T) {
+	var a Auth
+	assert.Equal(t, false, a.HasInited())
+
+	a.FeedWWWAuthenticate(`Digest realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", stale="FALSE"`, "Mufasa", "Circle Of Life")
+	assert.Equal(t, true, a.HasInited())
+	assert.Equal(t, AuthTypeDigest, a.Typ)
+	assert.Equal(t, "testrealm@host.com", a.Realm)
+	assert.Equal(t, "dcd98b7102dd2f0e8b11d0f600bfb0c093", a.Nonce)
+	assert.Equal(t, `Digest username="Mufasa", realm="testrealm@host.com", nonce="dcd98b7102dd2f0e8b11d0f600bfb0c093", uri="rtsp://127.0.0.1/live/test", response="c5fbc3e174694bb916feae6604f3a58d"`,
+		a.MakeAuthorization(MethodDescribe, "rtsp://127.0.0.1/live/test"))
+
+	var b Auth
+	b.FeedWWWAuthenticate(`Basic realm="IP Camera(12345)"`, "Mufasa", "Circle Of Life")
+	assert.Equal(t, AuthTypeBasic, b.Typ)
+	assert.Equal(t, "Basic TXVmYXNhOkNpcmNsZSBPZiBMaWZl", b.MakeAuthorization(MethodDescribe, "rtsp://127.0.0.1/live/test"))
+}
+
+func TestMakeSetupURI(t *testing.T) {
+	s := newClientCommandSession("test")
+	s.uri = "rtsp://127.0.0.1:554/live/test"
+	assert.Equal(t, "rtsp://127.0.0.1:554/live/test/trackID=0", s.makeSetupURI("", "trackID=0"))
+	assert.Equal(t, "rtsp://127.0.0.1:554/base/trackID=0", s.makeSetupURI("rtsp://127.0.0.1:554/base/", "trackID=0"))
+	assert.Equal(t, "rtsp://127.0.0.1:554/abs", s.makeSetupURI("rtsp://127.0.0.1:554/base/", "rtsp://127.0.0.1:554/abs"))
+	assert.Equal(t, "rtsp://127.0.0.1:554/live/test", s.makeSetupURI("", "*"))
+}
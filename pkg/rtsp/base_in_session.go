@@ -0,0 +1,333 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 接收音视频数据的session（PubSession和PullSession）的公共部分
+//
+// 根据sdp初始化，接收RTP/RTCP包（UDP或interleaved），合成帧后回调给上层
+// 并定时向对端发送rtcp rr
+
+// 发送rtcp rr的间隔
+var receiverReportIntervalMS = 5000
+
+type baseInSession struct {
+	uniqueKey    string
+	onAVPacketFn OnAVPacket
+	ssrc         uint32 // 发送rtcp rr时使用
+
+	// 使用RTP/AVP/TCP方式时，rtcp rr通过信令连接发送
+	// 另外，UDP方式收发的字节数也统计在这个连接上
+	cmdConn *base.StatConn
+	statAV  base.StatAV
+
+	// 注意，sdp中的payload type是动态的，不一定是RTPPacketTypeAVC和RTPPacketTypeAAC
+	audioPayloadType int
+	videoPayloadType int
+	isHEVC           bool // 视频是否为HEVC
+	vps              []byte
+	sps              []byte
+	pps              []byte
+	asc              []byte
+
+	m           sync.Mutex
+	servers     []*UDPServer
+	audioStream *Stream
+	videoStream *Stream
+
+	// interleaved方式时，用于传输RTCP的channel
+	rtcpChannels map[int]struct{}
+	// UDP方式时，对端接收RTCP的地址
+	rtcpPeers  []rtcpPeer
+	lastRRTime time.Time
+}
+
+type rtcpPeer struct {
+	conn *net.UDPConn
+	addr *net.UDPAddr
+}
+
+// @param cmdConn 信令连接，可以为nil，之后再设置
+func newBaseInSession(uniqueKey string, cmdConn *base.StatConn) *baseInSession {
+	return &baseInSession{
+		uniqueKey:        uniqueKey,
+		ssrc:             rand.Uint32(),
+		cmdConn:          cmdConn,
+		audioPayloadType: -1,
+		videoPayloadType: -1,
+		rtcpChannels:     make(map[int]struct{}),
+	}
+}
+
+// 注意，回调可能来自不同的协程（音频和视频各自的udp端口），回调中的Payload内存块在回调结束后不会被复用
+func (b *baseInSession) SetOnAVPacket(onAVPacket OnAVPacket) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.onAVPacketFn = onAVPacket
+}
+
+func (b *baseInSession) InitWithSDP(sdp SDP) {
+	var audioClockRate int
+	var videoClockRate int
+
+	for _, item := range sdp.ARTPMapList {
+		switch strings.ToUpper(item.EncodingName) {
+		case EncodingNameH264:
+			b.videoPayloadType = item.PayloadType
+			videoClockRate = item.ClockRate
+		case EncodingNameH265:
+			b.videoPayloadType = item.PayloadType
+			videoClockRate = item.ClockRate
+			b.isHEVC = true
+		case EncodingNameAAC:
+			b.audioPayloadType = item.PayloadType
+			audioClockRate = item.ClockRate
+		default:
+			nazalog.Errorf("[%s] unknown encoding name. payloadType=%d, name=%s", b.uniqueKey, item.PayloadType, item.EncodingName)
+		}
+	}
+
+	for _, item := range sdp.AFmtPBaseList {
+		var err error
+		switch item.Format {
+		case b.videoPayloadType:
+			if b.isHEVC {
+				b.vps, b.sps, b.pps, err = ParseVPSSPSPPS(item)
+			} else {
+				b.sps, b.pps, err = ParseSPSPPS(item)
+			}
+		case b.audioPayloadType:
+			b.asc, err = ParseASC(item)
+		default:
+			nazalog.Errorf("[%s] unknown format. fmt=%d", b.uniqueKey, item.Format)
+		}
+		if err != nil {
+			// 有的流在sdp中不携带这些信息，而是放在媒体数据中
+			nazalog.Warnf("[%s] parse fmtp failed. fmt=%d, err=%+v", b.uniqueKey, item.Format, err)
+		}
+	}
+
+	avSync := NewAVSync(videoClockRate, audioClockRate)
+	if b.videoPayloadType != -1 {
+		if b.isHEVC {
+			b.videoStream = NewStream(RTPPacketTypeHEVC, videoClockRate, avSync, b.onAVPacket)
+		} else {
+			b.videoStream = NewStream(RTPPacketTypeAVC, videoClockRate, avSync, b.onAVPacket)
+		}
+	}
+	if b.audioPayloadType != -1 {
+		b.audioStream = NewStream(RTPPacketTypeAAC, audioClockRate, avSync, b.onAVPacket)
+	}
+}
+
+// 获取sdp中携带的音视频头信息，不存在的字段为nil
+//
+// @return vps      不包含start code，只有视频为HEVC时才有
+//         sps, pps 不包含start code
+//         asc      AAC的AudioSpecificConfig
+func (b *baseInSession) GetAVConfig() (vps, sps, pps, asc []byte) {
+	return b.vps, b.sps, b.pps, b.asc
+}
+
+// 视频是否为HEVC，sdp中携带的视频头信息为空时，上层可以用来判断视频格式
+func (b *baseInSession) IsHEVC() bool {
+	return b.isHEVC
+}
+
+// 判断sdp中的某个payload type是否是支持的音视频格式
+func (b *baseInSession) isSupportedPayloadType(payloadType int) bool {
+	return payloadType != -1 && (payloadType == b.videoPayloadType || payloadType == b.audioPayloadType)
+}
+
+func (b *baseInSession) AddConn(conn *net.UDPConn) {
+	server := NewUDPServerWithConn(conn, b.onReadUDPPacket)
+	go server.RunLoop()
+
+	b.m.Lock()
+	b.servers = append(b.servers, server)
+	b.m.Unlock()
+}
+
+// 设置对端接收RTCP的地址，用于发送rtcp rr
+//
+// @param conn 本地的UDP连接，需已经通过AddConn添加
+func (b *baseInSession) SetupRTCPPeer(conn *net.UDPConn, peerIP string, peerRTCPPort int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.rtcpPeers = append(b.rtcpPeers, rtcpPeer{
+		conn: conn,
+		addr: &net.UDPAddr{IP: net.ParseIP(peerIP), Port: peerRTCPPort},
+	})
+}
+
+// SETUP信令使用RTP/AVP/TCP方式时调用，之后RTP和RTCP包通过FeedInterleavedPacket传入
+func (b *baseInSession) SetupWithChannel(rtpChannel int, rtcpChannel int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.rtcpChannels[rtcpChannel] = struct{}{}
+}
+
+// 传入从rtsp信令连接上读取到的interleaved的RTP或RTCP包
+//
+// @param pkt 不包含4字节的interleaved头
+func (b *baseInSession) FeedInterleavedPacket(pkt []byte, channel int) {
+	b.m.Lock()
+	_, isRTCP := b.rtcpChannels[channel]
+	b.m.Unlock()
+
+	if isRTCP {
+		b.feedRTCPPacket(pkt)
+		return
+	}
+	b.feedRTPPacket(pkt)
+}
+
+func (b *baseInSession) dispose() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for _, s := range b.servers {
+		_ = s.Dispose()
+	}
+	b.servers = nil
+}
+
+func (b *baseInSession) onReadUDPPacket(pkt []byte, addr string, err error) {
+	if err != nil || len(pkt) < 2 {
+		return
+	}
+	if b.cmdConn != nil {
+		b.cmdConn.AddReadBytes(len(pkt))
+	}
+
+	// try RTCP
+	switch pkt[1] {
+	case RTCPPacketTypeSR, RTCPPacketTypeRR:
+		b.feedRTCPPacket(pkt)
+		return
+	}
+
+	// try RTP
+	b.feedRTPPacket(pkt)
+}
+
+func (b *baseInSession) feedRTPPacket(raw []byte) {
+	h, err := parseRTPPacket(raw)
+	if err != nil {
+		nazalog.Errorf("[%s] read invalid rtp packet. err=%+v", b.uniqueKey, err)
+		return
+	}
+	var pkt RTPPacket
+	pkt.header = h
+	pkt.raw = raw
+
+	switch int(h.packetType) {
+	case b.videoPayloadType:
+		if b.isHEVC {
+			b.videoStream.FeedHEVCPacket(pkt)
+		} else {
+			b.videoStream.FeedAVCPacket(pkt)
+		}
+	case b.audioPayloadType:
+		b.audioStream.FeedAACPacket(pkt)
+	default:
+		nazalog.Errorf("[%s] unknown PT. pt=%d", b.uniqueKey, h.packetType)
+		parseRTCPPacket(raw)
+		return
+	}
+
+	b.sendRRIfNeeded()
+}
+
+func (b *baseInSession) feedRTCPPacket(raw []byte) {
+	if len(raw) < 2 || raw[1] != RTCPPacketTypeSR {
+		parseRTCPPacket(raw)
+		return
+	}
+	sr, err := parseSR(raw)
+	if err != nil {
+		return
+	}
+	for _, stream := range []*Stream{b.videoStream, b.audioStream} {
+		if stream != nil {
+			stream.FeedSR(sr)
+		}
+	}
+}
+
+// 间隔receiverReportIntervalMS，向对端发送一次rtcp rr，包含音频和视频的report block
+func (b *baseInSession) sendRRIfNeeded() {
+	now := time.Now()
+
+	b.m.Lock()
+	if now.Sub(b.lastRRTime) < time.Duration(receiverReportIntervalMS)*time.Millisecond {
+		b.m.Unlock()
+		return
+	}
+	b.lastRRTime = now
+	peers := b.rtcpPeers
+	var channels []int
+	for ch := range b.rtcpChannels {
+		channels = append(channels, ch)
+	}
+	b.m.Unlock()
+
+	var blocks []ReportBlock
+	for _, stream := range []*Stream{b.videoStream, b.audioStream} {
+		if stream == nil {
+			continue
+		}
+		if rb, ok := stream.MakeReportBlock(); ok {
+			blocks = append(blocks, rb)
+		}
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	nazalog.Debugf("[%s] send rtcp rr. blocks=%+v", b.uniqueKey, blocks)
+	rr := PackRR(b.ssrc, blocks)
+
+	for _, peer := range peers {
+		n, err := peer.conn.WriteToUDP(rr, peer.addr)
+		if err != nil {
+			nazalog.Warnf("[%s] write rtcp rr failed. err=%+v", b.uniqueKey, err)
+		}
+		if b.cmdConn != nil {
+			b.cmdConn.AddWroteBytes(n)
+		}
+	}
+	if b.cmdConn != nil {
+		for _, ch := range channels {
+			if _, err := b.cmdConn.Write(packInterleaved(ch, rr)); err != nil {
+				nazalog.Warnf("[%s] write rtcp rr failed. err=%+v", b.uniqueKey, err)
+			}
+		}
+	}
+}
+
+func (b *baseInSession) onAVPacket(pkt AVPacket) {
+	feedStatAV(&b.statAV, pkt)
+
+	b.m.Lock()
+	fn := b.onAVPacketFn
+	b.m.Unlock()
+
+	if fn != nil {
+		fn(pkt)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/q191201771/lal/pkg/aac"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 发送音视频数据的session（SubSession和PushSession）的公共部分
+//
+// 根据音视频头信息生成sdp，将帧数据打包成RTP包，通过UDP或interleaved的方式发送给对端，并定时发送rtcp sr
+
+// 发送rtcp sr的间隔
+var senderReportIntervalMS = 5000
+
+type baseOutSession struct {
+	uniqueKey string
+
+	// 使用RTP/AVP/TCP方式时，音视频数据通过信令连接发送
+	// 另外，UDP方式收发的字节数也统计在这个连接上
+	cmdConn *base.StatConn
+	statAV  base.StatAV
+
+	m          sync.Mutex
+	sdp        []byte
+	videoTrack *outTrack
+	audioTrack *outTrack
+}
+
+// 一路音频或一路视频
+type outTrack struct {
+	packer *RTPPacker
+
+	rtpServer    *UDPServer
+	rtcpServer   *UDPServer
+	rtpConn      *net.UDPConn
+	rtcpConn     *net.UDPConn
+	rtpPeerAddr  *net.UDPAddr
+	rtcpPeerAddr *net.UDPAddr
+
+	// RTP/AVP/TCP方式，RTP和RTCP包复用信令连接发送
+	isInterleaved bool
+	rtpChannel    int
+	rtcpChannel   int
+
+	lastSRTime time.Time
+}
+
+func newBaseOutSession(uniqueKey string, cmdConn *base.StatConn) *baseOutSession {
+	return &baseOutSession{
+		uniqueKey: uniqueKey,
+		cmdConn:   cmdConn,
+	}
+}
+
+// 使用音视频头信息生成sdp，并初始化RTP打包
+//
+// @param vps      不包含start code，不为nil时表示视频为HEVC，否则为AVC
+// @param sps, pps 不包含start code，为nil时表示没有视频
+// @param asc      AAC的AudioSpecificConfig，为nil时表示没有音频
+func (b *baseOutSession) InitWithAVConfig(vps, sps, pps, asc []byte) error {
+	sdp, err := PackSDP(vps, sps, pps, asc)
+	if err != nil {
+		return err
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.sdp = sdp
+	if sps != nil && pps != nil {
+		payloadType := RTPPacketTypeAVC
+		if vps != nil {
+			payloadType = RTPPacketTypeHEVC
+		}
+		b.videoTrack = &outTrack{
+			packer: NewRTPPacker(payloadType, uint8(payloadType), 90000, rand.Uint32()),
+		}
+	}
+	if asc != nil {
+		samplingFrequency, _, err := aac.ParseSamplingFrequencyAndChannels(asc)
+		if err != nil {
+			return err
+		}
+		b.audioTrack = &outTrack{
+			packer: NewRTPPacker(RTPPacketTypeAAC, RTPPacketTypeAAC, samplingFrequency, rand.Uint32()),
+		}
+	}
+	return nil
+}
+
+func (b *baseOutSession) SDP() []byte {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.sdp
+}
+
+// 传入从rtsp信令连接上读取到的interleaved的RTP或RTCP包，比如对端发送过来的rtcp rr
+func (b *baseOutSession) FeedInterleavedPacket(pkt []byte, channel int) {
+	b.onReadPeerPacket(pkt, b.cmdConn.RemoteAddr().String())
+}
+
+// @param pkt 音频时，Payload为一帧raw AAC数据
+//            视频时，Payload为一帧AVCC（HVCC）格式的数据，也即每个NALU前有4字节的长度
+//            注意，视频的PayloadType需和InitWithAVConfig时的视频格式一致
+func (b *baseOutSession) WriteAVPacket(pkt AVPacket) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var track *outTrack
+	switch pkt.PayloadType {
+	case RTPPacketTypeAVC, RTPPacketTypeHEVC:
+		track = b.videoTrack
+	case RTPPacketTypeAAC:
+		track = b.audioTrack
+	}
+	// 对端没有SETUP这路流
+	if track == nil || (track.rtpConn == nil && !track.isInterleaved) {
+		return
+	}
+	if track.packer.payloadType != pkt.PayloadType {
+		return
+	}
+
+	for _, rtpPkt := range track.packer.Pack(pkt) {
+		if err := b.writeRTP(track, rtpPkt); err != nil {
+			nazalog.Errorf("[%s] write rtp packet failed. err=%+v", b.uniqueKey, err)
+			return
+		}
+	}
+	feedStatAV(&b.statAV, pkt)
+
+	now := time.Now()
+	if now.Sub(track.lastSRTime) >= time.Duration(senderReportIntervalMS)*time.Millisecond {
+		track.lastSRTime = now
+		ts, packetCount, octetCount := track.packer.Stat()
+		sr := PackSR(track.packer.SSRC(), now, ts, packetCount, octetCount)
+		if err := b.writeRTCP(track, sr); err != nil {
+			nazalog.Errorf("[%s] write rtcp sr failed. err=%+v", b.uniqueKey, err)
+		}
+	}
+}
+
+// 注意，调用方需持有锁
+func (b *baseOutSession) setupTrackWithConn(track *outTrack, rtpConn, rtcpConn *net.UDPConn, peerIP string, peerRTPPort, peerRTCPPort int) {
+	ip := net.ParseIP(peerIP)
+	track.rtpConn = rtpConn
+	track.rtcpConn = rtcpConn
+	track.rtpPeerAddr = &net.UDPAddr{IP: ip, Port: peerRTPPort}
+	track.rtcpPeerAddr = &net.UDPAddr{IP: ip, Port: peerRTCPPort}
+
+	// 读取对端发送过来的数据，比如rtcp rr
+	track.rtpServer = NewUDPServerWithConn(rtpConn, b.onReadUDPPacket)
+	track.rtcpServer = NewUDPServerWithConn(rtcpConn, b.onReadUDPPacket)
+	go track.rtpServer.RunLoop()
+	go track.rtcpServer.RunLoop()
+}
+
+// 注意，调用方需持有锁
+func (b *baseOutSession) setupTrackWithChannel(track *outTrack, rtpChannel int, rtcpChannel int) {
+	track.isInterleaved = true
+	track.rtpChannel = rtpChannel
+	track.rtcpChannel = rtcpChannel
+}
+
+// 注意，调用方需持有锁
+func (b *baseOutSession) isTrackSetup(track *outTrack) bool {
+	return track.rtpConn != nil || track.isInterleaved
+}
+
+func (b *baseOutSession) dispose() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	for _, track := range []*outTrack{b.videoTrack, b.audioTrack} {
+		if track == nil {
+			continue
+		}
+		if track.rtpServer != nil {
+			_ = track.rtpServer.Dispose()
+		}
+		if track.rtcpServer != nil {
+			_ = track.rtcpServer.Dispose()
+		}
+	}
+}
+
+func (b *baseOutSession) writeRTP(track *outTrack, pkt []byte) (err error) {
+	if track.isInterleaved {
+		_, err = b.cmdConn.Write(packInterleaved(track.rtpChannel, pkt))
+		return
+	}
+	n, err := track.rtpConn.WriteToUDP(pkt, track.rtpPeerAddr)
+	b.cmdConn.AddWroteBytes(n)
+	return
+}
+
+func (b *baseOutSession) writeRTCP(track *outTrack, pkt []byte) (err error) {
+	if track.isInterleaved {
+		_, err = b.cmdConn.Write(packInterleaved(track.rtcpChannel, pkt))
+		return
+	}
+	n, err := track.rtcpConn.WriteToUDP(pkt, track.rtcpPeerAddr)
+	b.cmdConn.AddWroteBytes(n)
+	return
+}
+
+func (b *baseOutSession) onReadUDPPacket(pkt []byte, addr string, err error) {
+	if err != nil {
+		return
+	}
+	b.cmdConn.AddReadBytes(len(pkt))
+	b.onReadPeerPacket(pkt, addr)
+}
+
+func (b *baseOutSession) onReadPeerPacket(pkt []byte, addr string) {
+	if len(pkt) < 2 {
+		return
+	}
+	// TODO chef: 处理rtcp rr
+	nazalog.Debugf("[%s] read udp packet. addr=%s, len=%d", b.uniqueKey, addr, len(pkt))
+}
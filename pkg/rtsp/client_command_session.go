@@ -0,0 +1,221 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/connection"
+	"github.com/q191201771/naza/pkg/nazahttp"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// rtsp客户端类型连接（PullSession，PushSession）的信令部分
+//
+// 负责建立tcp连接，发送请求并读取回复，需要鉴权时自动使用用户名密码重新发送请求
+
+const defaultRTSPPort = 554
+
+type clientCommandSession struct {
+	uniqueKey string
+
+	uri      string // 不包含用户名密码
+	username string
+	password string
+
+	conn      *base.StatConn
+	r         *bufio.Reader
+	cseq      int
+	auth      Auth
+	sessionID string
+}
+
+type clientResponse struct {
+	statusCode string
+	headers    map[string]string
+	body       []byte
+}
+
+func newClientCommandSession(uniqueKey string) *clientCommandSession {
+	return &clientCommandSession{
+		uniqueKey: uniqueKey,
+	}
+}
+
+// @param timeoutMS 建立连接的超时，为0则没有超时
+func (s *clientCommandSession) connect(rawURL string, timeoutMS int) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "rtsp" {
+		return ErrRTSP
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+		u.User = nil
+	}
+	s.uri = u.String()
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), strconv.Itoa(defaultRTSPPort))
+	}
+
+	nazalog.Debugf("[%s] > tcp connect. addr=%s", s.uniqueKey, addr)
+	rawConn, err := net.DialTimeout("tcp", addr, time.Duration(timeoutMS)*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	nazalog.Debugf("[%s] < tcp connect. laddr=%s", s.uniqueKey, rawConn.LocalAddr().String())
+
+	s.conn = base.NewStatConn(connection.New(rawConn))
+	s.r = bufio.NewReader(s.conn)
+	return nil
+}
+
+// 发送请求，并阻塞等待回复
+// 如果服务端回复401，则使用url中的用户名密码鉴权，再发送一次
+//
+// @param headers 除CSeq、Session、Authorization外的其他字段，可以为nil
+func (s *clientCommandSession) request(method string, uri string, headers map[string]string, body []byte) (resp clientResponse, err error) {
+	for i := 0; i < 2; i++ {
+		if err = s.writeRequest(method, uri, headers, body); err != nil {
+			return
+		}
+		if resp, err = s.readResponse(); err != nil {
+			return
+		}
+
+		switch resp.statusCode {
+		case "200":
+			if v := getHeader(resp.headers, HeaderFieldSession); v != "" {
+				// 例子: Session: 12345678;timeout=60
+				s.sessionID = strings.TrimSpace(strings.Split(v, ";")[0])
+			}
+			return
+		case "401":
+			if s.auth.HasInited() || s.username == "" {
+				nazalog.Errorf("[%s] rtsp auth failed. method=%s, uri=%s", s.uniqueKey, method, uri)
+				err = ErrRTSP
+				return
+			}
+			s.auth.FeedWWWAuthenticate(getHeader(resp.headers, HeaderFieldWWWAuthenticate), s.username, s.password)
+			if !s.auth.HasInited() {
+				err = ErrRTSP
+				return
+			}
+			continue
+		default:
+			nazalog.Errorf("[%s] rtsp response status not ok. method=%s, status=%s", s.uniqueKey, method, resp.statusCode)
+			err = ErrRTSP
+			return
+		}
+	}
+	err = ErrRTSP
+	return
+}
+
+// 只发送请求，不等待回复，比如心跳
+func (s *clientCommandSession) writeRequest(method string, uri string, headers map[string]string, body []byte) error {
+	h := make(map[string]string)
+	for k, v := range headers {
+		h[k] = v
+	}
+	if s.sessionID != "" {
+		h[HeaderFieldSession] = s.sessionID
+	}
+	if s.auth.HasInited() {
+		h[HeaderFieldAuthorization] = s.auth.MakeAuthorization(method, uri)
+	}
+	s.cseq++
+	req := PackRequest(method, uri, s.cseq, h, body)
+	nazalog.Debugf("[%s] > W %s %s", s.uniqueKey, method, uri)
+	_, err := s.conn.Write([]byte(req))
+	return err
+}
+
+// 读取一个回复。注意，调用方需保证接下来读取的不是interleaved的数据
+func (s *clientCommandSession) readResponse() (resp clientResponse, err error) {
+	statusLine, headers, err := nazahttp.ReadHTTPHeader(s.r)
+	if err != nil {
+		return
+	}
+	_, resp.statusCode, _, err = nazahttp.ParseHTTPStatusLine(statusLine)
+	if err != nil {
+		return
+	}
+	resp.headers = headers
+
+	if v := getHeader(headers, HeaderFieldContentLength); v != "" {
+		var cl int
+		if cl, err = strconv.Atoi(v); err != nil {
+			return
+		}
+		resp.body = make([]byte, cl)
+		if _, err = io.ReadFull(s.r, resp.body); err != nil {
+			return
+		}
+	}
+	nazalog.Debugf("[%s] < R %s. headers=%+v", s.uniqueKey, statusLine, headers)
+	return
+}
+
+// 根据DESCRIBE回复中的Content-Base以及sdp中的a=control，生成SETUP使用的uri
+func (s *clientCommandSession) makeSetupURI(contentBase string, control string) string {
+	if strings.HasPrefix(control, "rtsp://") {
+		return control
+	}
+	base := contentBase
+	if base == "" {
+		base = s.uri
+	}
+	if control == "" || control == "*" {
+		return base
+	}
+	if strings.HasSuffix(base, "/") {
+		return base + control
+	}
+	return base + "/" + control
+}
+
+// 还没有建立连接时返回空字符串
+func (s *clientCommandSession) remoteAddr() string {
+	if s.conn == nil {
+		return ""
+	}
+	return s.conn.RemoteAddr().String()
+}
+
+func (s *clientCommandSession) dispose() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+	}
+}
+
+// 不区分大小写获取header中的字段
+func getHeader(headers map[string]string, key string) string {
+	if v, ok := headers[key]; ok {
+		return v
+	}
+	for k, v := range headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// rfc2326 10.12 Embedded (Interleaved) Binary Data
+//
+// RTP和RTCP包复用rtsp信令的tcp连接传输，每个包前面加上4字节的头
+//
+// +---------+---------+-------------------+
+// | '$' (1) | chn (1) | length (2, BE)    |
+// +---------+---------+-------------------+
+// |           RTP or RTCP packet          |
+// +---------------------------------------+
+
+const interleavedMagic uint8 = '$'
+
+const interleavedHeaderLength = 4
+
+// 判断tcp连接上的下一个数据，是interleaved的RTP/RTCP包，还是rtsp信令
+func isInterleaved(r *bufio.Reader) (bool, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return false, err
+	}
+	return b[0] == interleavedMagic, nil
+}
+
+// 从tcp连接上读取一个interleaved的RTP/RTCP包
+//
+// @return b 不包含4字节的头
+func readInterleaved(r *bufio.Reader) (channel int, b []byte, err error) {
+	var h [interleavedHeaderLength]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	if h[0] != interleavedMagic {
+		err = ErrRTSP
+		return
+	}
+	channel = int(h[1])
+	b = make([]byte, bele.BEUint16(h[2:]))
+	_, err = io.ReadFull(r, b)
+	return
+}
+
+// @param b RTP或RTCP包
+//
+// @return 加上4字节头后的数据
+func packInterleaved(channel int, b []byte) []byte {
+	ret := make([]byte, interleavedHeaderLength+len(b))
+	ret[0] = interleavedMagic
+	ret[1] = uint8(channel)
+	ret[2] = uint8(len(b) >> 8)
+	ret[3] = uint8(len(b))
+	copy(ret[interleavedHeaderLength:], b)
+	return ret
+}
@@ -9,102 +9,49 @@
 package rtsp
 
 import (
-	"net"
+	"time"
 
+	"github.com/q191201771/lal/pkg/base"
 	"github.com/q191201771/naza/pkg/nazalog"
+	"github.com/q191201771/naza/pkg/unique"
 )
 
 type PubSession struct {
-	StreamName   string // presentation
-	onAVPacketFn OnAVPacket
+	UniqueKey  string
+	AppName    string
+	StreamName string // presentation
+	RawQuery   string // ANNOUNCE信令的uri中?后面的参数，不包含?
 
-	servers     []*UDPServer
-	audioStream *Stream
-	videoStream *Stream
+	*baseInSession
+
+	cmdConn   *base.StatConn
+	startTime time.Time
 }
 
-func NewPubSession(streamName string) *PubSession {
+// @param cmdConn 接收rtsp信令的tcp连接，Dispose时会被关闭
+func NewPubSession(appName string, streamName string, cmdConn *base.StatConn) *PubSession {
+	uk := unique.GenUniqueKey("RTSPPUB")
+	nazalog.Infof("[%s] lifecycle new rtsp PubSession. appName=%s, streamName=%s", uk, appName, streamName)
 	return &PubSession{
-		StreamName: streamName,
+		UniqueKey:     uk,
+		AppName:       appName,
+		StreamName:    streamName,
+		baseInSession: newBaseInSession(uk, cmdConn),
+		cmdConn:       cmdConn,
+		startTime:     time.Now(),
 	}
 }
 
-func (p *PubSession) SetOnAVPacket(onAVPacket OnAVPacket) {
-	p.onAVPacketFn = onAVPacket
+func (p *PubSession) GetStat() base.StatSession {
+	return base.NewStatSession(base.ProtocolRTSP, p.UniqueKey, p.startTime, p.cmdConn.RemoteAddr().String(), p.cmdConn, &p.statAV)
 }
 
-func (p *PubSession) InitWithSDP(sdp SDP) {
-	var audioPayloadType int
-	var videoPayloadType int
-	var audioClockRate int
-	var videoClockRate int
-	for _, item := range sdp.AFmtPBaseList {
-		switch item.Format {
-		case RTPPacketTypeAVC:
-			videoPayloadType = item.Format
-		case RTPPacketTypeAAC:
-			audioPayloadType = item.Format
-		default:
-			nazalog.Errorf("unknown format. fmt=%d", item.Format)
-		}
-	}
+func (p *PubSession) Dispose() {
+	nazalog.Infof("[%s] lifecycle dispose rtsp PubSession.", p.UniqueKey)
 
-	for _, item := range sdp.ARTPMapList {
-		switch item.PayloadType {
-		case RTPPacketTypeAVC:
-			videoClockRate = item.ClockRate
-		case RTPPacketTypeAAC:
-			audioClockRate = item.ClockRate
-		default:
-			nazalog.Errorf("unknown payloadType. type=%d", item.PayloadType)
-		}
-	}
-	p.audioStream = NewStream(audioPayloadType, audioClockRate, p.onAVPacket)
-	p.videoStream = NewStream(videoPayloadType, videoClockRate, p.onAVPacket)
-}
+	p.baseInSession.dispose()
 
-func (p *PubSession) AddConn(conn *net.UDPConn) {
-	server := NewUDPServerWithConn(conn, p.onReadUDPPacket)
-	go server.RunLoop()
-	p.servers = append(p.servers, server)
-}
-func (p *PubSession) onReadUDPPacket(b []byte, addr string, err error) {
-	// try RTCP
-	switch b[1] {
-	case RTCPPacketTypeSR:
-		parseRTCPPacket(b)
-		return
+	if p.cmdConn != nil {
+		_ = p.cmdConn.Close()
 	}
-
-	// try RTP
-	packetType := b[1] & 0x7F
-	switch packetType {
-	case RTPPacketTypeAVC:
-		h, err := parseRTPPacket(b)
-		if err != nil {
-			nazalog.Errorf("read invalid rtp packet. err=%+v", err)
-		}
-		nazalog.Debugf("%+v", h)
-		var pkt RTPPacket
-		pkt.header = h
-		pkt.raw = b
-		p.videoStream.FeedAVCPacket(pkt)
-	case RTPPacketTypeAAC:
-		h, err := parseRTPPacket(b)
-		if err != nil {
-			nazalog.Errorf("read invalid rtp packet. err=%+v", err)
-		}
-		nazalog.Debugf("%+v", h)
-		var pkt RTPPacket
-		pkt.header = h
-		pkt.raw = b
-		p.audioStream.FeedAACPacket(pkt)
-	default:
-		nazalog.Errorf("unknown PT. pt=%d", packetType)
-		parseRTCPPacket(b)
-	}
-}
-
-func (p *PubSession) onAVPacket(pkt AVPacket) {
-	p.onAVPacketFn(pkt)
 }
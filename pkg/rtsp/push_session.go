@@ -0,0 +1,261 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+	"github.com/q191201771/naza/pkg/unique"
+)
+
+var ErrPushSessionTimeout = errors.New("lal.rtsp: push session timeout")
+
+// 作为rtsp客户端，向rtsp服务端推流
+type PushSession struct {
+	UniqueKey string
+
+	*baseOutSession
+
+	option PushSessionOption
+	cmd    *clientCommandSession
+
+	doneChan  chan error
+	exitChan  chan struct{}
+	startTime time.Time
+}
+
+type PushSessionOption struct {
+	// 单位毫秒，如果为0，则没有超时
+	PushTimeoutMS int // 从发起连接到收到RECORD信令结果的超时
+
+	OverTCP bool // 为true时使用RTP/AVP/TCP方式，否则使用UDP
+}
+
+var defaultPushSessionOption = PushSessionOption{
+	PushTimeoutMS: 0,
+	OverTCP:       false,
+}
+
+type ModPushSessionOption func(option *PushSessionOption)
+
+func NewPushSession(modOptions ...ModPushSessionOption) *PushSession {
+	option := defaultPushSessionOption
+	for _, fn := range modOptions {
+		fn(&option)
+	}
+
+	uk := unique.GenUniqueKey("RTSPPUSH")
+	nazalog.Infof("[%s] lifecycle new rtsp PushSession.", uk)
+	return &PushSession{
+		UniqueKey:      uk,
+		baseOutSession: newBaseOutSession(uk, nil),
+		option:         option,
+		cmd:            newClientCommandSession(uk),
+		doneChan:       make(chan error, 1),
+		exitChan:       make(chan struct{}, 1),
+		startTime:      time.Now(),
+	}
+}
+
+// 建立rtsp推流连接
+// 阻塞直到收到服务端返回的RECORD信令的结果，或发生错误
+//
+// 注意，调用Push前，需先调用InitWithAVConfig设置音视频头信息，用于生成ANNOUNCE中的sdp
+// 成功后，调用WriteAVPacket发送音视频数据
+//
+// @param rawURL 例如 rtsp://127.0.0.1:554/live/test110
+func (s *PushSession) Push(rawURL string) error {
+	if s.option.PushTimeoutMS == 0 {
+		return s.push(rawURL)
+	}
+
+	ch := make(chan error, 1)
+	go func() {
+		ch <- s.push(rawURL)
+	}()
+	t := time.NewTimer(time.Duration(s.option.PushTimeoutMS) * time.Millisecond)
+	defer t.Stop()
+	select {
+	case err := <-ch:
+		return err
+	case <-t.C:
+		// 关闭连接，使得push协程退出
+		s.Dispose()
+		return ErrPushSessionTimeout
+	}
+}
+
+// 连接断开或发生错误时返回
+func (s *PushSession) Done() <-chan error {
+	return s.doneChan
+}
+
+func (s *PushSession) GetStat() base.StatSession {
+	return base.NewStatSession(base.ProtocolRTSP, s.UniqueKey, s.startTime, s.cmd.remoteAddr(), s.cmd.conn, &s.statAV)
+}
+
+func (s *PushSession) Dispose() {
+	nazalog.Infof("[%s] lifecycle dispose rtsp PushSession.", s.UniqueKey)
+	select {
+	case s.exitChan <- struct{}{}:
+	default:
+	}
+	s.baseOutSession.dispose()
+	s.cmd.dispose()
+}
+
+func (s *PushSession) push(rawURL string) error {
+	nazalog.Infof("[%s] push. url=%s", s.UniqueKey, rawURL)
+
+	sdp := s.SDP()
+	if sdp == nil {
+		nazalog.Errorf("[%s] push but av config not set.", s.UniqueKey)
+		return ErrRTSP
+	}
+
+	if err := s.cmd.connect(rawURL, s.option.PushTimeoutMS); err != nil {
+		return err
+	}
+	s.cmdConn = s.cmd.conn
+
+	if _, err := s.cmd.request(MethodOptions, s.cmd.uri, nil, nil); err != nil {
+		return err
+	}
+
+	if _, err := s.cmd.request(MethodAnnounce, s.cmd.uri, map[string]string{HeaderFieldContentType: "application/sdp"}, sdp); err != nil {
+		return err
+	}
+
+	channel := 0
+	for _, item := range []struct {
+		track   *outTrack
+		control string
+	}{
+		{s.videoTrack, ControlVideo},
+		{s.audioTrack, ControlAudio},
+	} {
+		if item.track == nil {
+			continue
+		}
+		uri := s.cmd.makeSetupURI("", item.control)
+		if err := s.setup(uri, item.track, channel); err != nil {
+			return err
+		}
+		channel += 2
+	}
+
+	// 开始推流后，音视频数据可能通过信令连接发送，使用异步发送，避免阻塞上层
+	if s.option.OverTCP {
+		s.cmd.conn.ModWriteChanSize(wChanSize)
+	}
+
+	if _, err := s.cmd.request(MethodRecord, s.cmd.uri, map[string]string{HeaderFieldRange: "npt=0.000-"}, nil); err != nil {
+		return err
+	}
+
+	go s.runCmdReadLoop()
+	go s.runKeepaliveLoop()
+	return nil
+}
+
+// @param channel RTP/AVP/TCP方式时使用的RTP channel，RTCP channel为channel+1
+func (s *PushSession) setup(uri string, track *outTrack, channel int) error {
+	if s.option.OverTCP {
+		transport := fmt.Sprintf("%s;unicast;interleaved=%d-%d;mode=record", TransportTCP, channel, channel+1)
+		if _, err := s.cmd.request(MethodSetup, uri, map[string]string{HeaderFieldTransport: transport}, nil); err != nil {
+			return err
+		}
+		s.m.Lock()
+		s.setupTrackWithChannel(track, channel, channel+1)
+		s.m.Unlock()
+		return nil
+	}
+
+	rtpConn, rtcpConn, rtpPort, rtcpPort, err := clientUDPServerPool.AcquirePair()
+	if err != nil {
+		return err
+	}
+
+	transport := fmt.Sprintf("RTP/AVP/UDP;unicast;client_port=%d-%d;mode=record", rtpPort, rtcpPort)
+	resp, err := s.cmd.request(MethodSetup, uri, map[string]string{HeaderFieldTransport: transport}, nil)
+	if err != nil {
+		_ = rtpConn.Close()
+		_ = rtcpConn.Close()
+		return err
+	}
+
+	// 例子: Transport: RTP/AVP/UDP;unicast;client_port=30000-30001;server_port=8000-8001
+	peerRTPPort, peerRTCPPort, err := parseTransportPair(getHeader(resp.headers, HeaderFieldTransport), "server_port")
+	if err != nil {
+		nazalog.Errorf("[%s] parse server port failed. transport=%s", s.UniqueKey, getHeader(resp.headers, HeaderFieldTransport))
+		_ = rtpConn.Close()
+		_ = rtcpConn.Close()
+		return err
+	}
+	peerIP, _, err := net.SplitHostPort(s.cmd.conn.RemoteAddr().String())
+	if err != nil {
+		_ = rtpConn.Close()
+		_ = rtcpConn.Close()
+		return err
+	}
+
+	s.m.Lock()
+	s.setupTrackWithConn(track, rtpConn, rtcpConn, peerIP, peerRTPPort, peerRTCPPort)
+	s.m.Unlock()
+	return nil
+}
+
+// 读取信令连接上的数据，直到连接断开
+// 比如心跳的回复，以及RTP/AVP/TCP方式时对端发送的rtcp rr
+func (s *PushSession) runCmdReadLoop() {
+	var err error
+	for {
+		var interleaved bool
+		if interleaved, err = isInterleaved(s.cmd.r); err != nil {
+			break
+		}
+		if interleaved {
+			var channel int
+			var b []byte
+			if channel, b, err = readInterleaved(s.cmd.r); err != nil {
+				break
+			}
+			s.FeedInterleavedPacket(b, channel)
+			continue
+		}
+
+		// 心跳的回复，忽略
+		if _, err = s.cmd.readResponse(); err != nil {
+			break
+		}
+	}
+	nazalog.Infof("[%s] cmd read loop done. err=%+v", s.UniqueKey, err)
+	s.doneChan <- err
+}
+
+func (s *PushSession) runKeepaliveLoop() {
+	t := time.NewTicker(time.Duration(keepaliveIntervalMS) * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.exitChan:
+			return
+		case <-t.C:
+			if err := s.cmd.writeRequest(MethodOptions, s.cmd.uri, nil, nil); err != nil {
+				nazalog.Errorf("[%s] write keepalive failed. err=%+v", s.UniqueKey, err)
+				return
+			}
+		}
+	}
+}
@@ -106,6 +106,10 @@ type SR struct {
 }
 
 func parseRTCPPacket(b []byte) {
+	if len(b) < 4 {
+		return
+	}
+
 	var h RTCPHeader
 	h.version = b[0] >> 6
 	h.padding = (b[0] >> 5) & 0x1
@@ -116,15 +120,21 @@ func parseRTCPPacket(b []byte) {
 
 	switch h.packetType {
 	case RTCPPacketTypeSR:
-		parseSR(b)
+		_, _ = parseSR(b)
+	case RTCPPacketTypeRR:
+		// noop
 	default:
 		nazalog.Warnf("unknown packet type. type=%d", h.packetType)
 	}
 }
 
 // rfc3550 6.4.1
-func parseSR(b []byte) {
-	var s SR
+func parseSR(b []byte) (s SR, err error) {
+	if len(b) < 28 {
+		err = ErrRTP
+		return
+	}
+
 	s.senderSSRC = bele.BEUint32(b[4:])
 	s.msw = bele.BEUint32(b[8:])
 	s.lsw = bele.BEUint32(b[12:])
@@ -132,4 +142,5 @@ func parseSR(b []byte) {
 	s.pktCnt = bele.BEUint32(b[20:])
 	s.octetCnt = bele.BEUint32(b[24:])
 	nazalog.Debugf("%+v", s)
+	return
 }
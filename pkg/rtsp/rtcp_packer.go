@@ -8,7 +8,73 @@
 
 package rtsp
 
-func PackRR() []byte {
-	// TODO chef: impl me
-	return nil
+import (
+	"time"
+
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+// NTP时间戳的起点1900-01-01和unix时间戳的起点1970-01-01之间相差的秒数
+const ntpUnixOffset = 2208988800
+
+// rfc3550 6.4.2 RR: Receiver Report RTCP Packet
+//
+// @param senderSSRC 自身的SSRC
+// @param blocks     每个被统计的SSRC对应一个report block，最多31个
+func PackRR(senderSSRC uint32, blocks []ReportBlock) []byte {
+	if len(blocks) > 31 {
+		blocks = blocks[:31]
+	}
+
+	b := make([]byte, 8+24*len(blocks))
+	b[0] = 0x80 | uint8(len(blocks)) // version=2, padding=0, rc
+	b[1] = RTCPPacketTypeRR
+	length := len(b)/4 - 1
+	b[2] = uint8(length >> 8)
+	b[3] = uint8(length)
+	bele.BEPutUint32(b[4:], senderSSRC)
+	for i, rb := range blocks {
+		p := b[8+24*i:]
+		bele.BEPutUint32(p, rb.SSRC)
+		p[4] = rb.FractionLost
+		bele.BEPutUint24(p[5:], rb.CumulativeLost)
+		bele.BEPutUint32(p[8:], rb.ExtendedHighestSeq)
+		bele.BEPutUint32(p[12:], rb.Jitter)
+		bele.BEPutUint32(p[16:], rb.LSR)
+		bele.BEPutUint32(p[20:], rb.DLSR)
+	}
+	return b
+}
+
+// rfc3550 6.4.1 SR: Sender Report RTCP Packet
+//
+// 不包含report block
+//
+// @param ntp 与timestamp对应的墙上时间
+func PackSR(senderSSRC uint32, ntp time.Time, timestamp uint32, packetCount uint32, octetCount uint32) []byte {
+	msw, lsw := time2NTP(ntp)
+
+	b := make([]byte, 28)
+	b[0] = 0x80 // version=2, padding=0, rc=0
+	b[1] = RTCPPacketTypeSR
+	b[2] = 0
+	b[3] = 6 // byte length = (length+1) * 4
+	bele.BEPutUint32(b[4:], senderSSRC)
+	bele.BEPutUint32(b[8:], msw)
+	bele.BEPutUint32(b[12:], lsw)
+	bele.BEPutUint32(b[16:], timestamp)
+	bele.BEPutUint32(b[20:], packetCount)
+	bele.BEPutUint32(b[24:], octetCount)
+	return b
+}
+
+func time2NTP(t time.Time) (msw uint32, lsw uint32) {
+	msw = uint32(t.Unix() + ntpUnixOffset)
+	lsw = uint32((uint64(t.Nanosecond()) << 32) / uint64(time.Second))
+	return
+}
+
+func ntp2Time(msw uint32, lsw uint32) time.Time {
+	nsec := (uint64(lsw) * uint64(time.Second)) >> 32
+	return time.Unix(int64(msw)-ntpUnixOffset, int64(nsec))
 }
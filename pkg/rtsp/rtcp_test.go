@@ -0,0 +1,89 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestRTPReceiverStat(t *testing.T) {
+	stat := NewRTPReceiverStat(90000)
+	assert.Equal(t, false, stat.HasData())
+
+	// 65534 ~ 3 跨越序号翻转，其中丢失了0和1
+	now := time.Now()
+	for _, seq := range []uint16{65534, 65535, 2, 3} {
+		stat.FeedRTPHeader(RTPHeader{seq: seq, timestamp: 3600, ssrc: 1}, now)
+	}
+	assert.Equal(t, true, stat.HasData())
+
+	stat.FeedSR(SR{senderSSRC: 2, msw: 0x11112222, lsw: 0x33334444}, now)
+	stat.FeedSR(SR{senderSSRC: 1, msw: 0x11112222, lsw: 0x33334444}, now)
+
+	rb := stat.MakeReportBlock(now.Add(time.Second))
+	assert.Equal(t, uint32(1), rb.SSRC)
+	assert.Equal(t, uint32(65536+3), rb.ExtendedHighestSeq)
+	assert.Equal(t, uint32(2), rb.CumulativeLost)
+	assert.Equal(t, uint8(2*256/6), rb.FractionLost)
+	assert.Equal(t, uint32(0x22223333), rb.LSR)
+	assert.Equal(t, uint32(65536), rb.DLSR)
+
+	// 下一个统计区间没有丢包
+	stat.FeedRTPHeader(RTPHeader{seq: 4, timestamp: 3600, ssrc: 1}, now)
+	rb = stat.MakeReportBlock(now)
+	assert.Equal(t, uint8(0), rb.FractionLost)
+	assert.Equal(t, uint32(2), rb.CumulativeLost)
+}
+
+func TestPackRR(t *testing.T) {
+	rr := PackRR(1, []ReportBlock{{SSRC: 2, FractionLost: 3, CumulativeLost: 4, ExtendedHighestSeq: 5, Jitter: 6, LSR: 7, DLSR: 8}})
+	assert.Equal(t, 32, len(rr))
+	assert.Equal(t, uint8(0x81), rr[0])
+	assert.Equal(t, uint8(RTCPPacketTypeRR), rr[1])
+	assert.Equal(t, uint16(7), bele.BEUint16(rr[2:]))
+	assert.Equal(t, uint32(1), bele.BEUint32(rr[4:]))
+	assert.Equal(t, uint32(2), bele.BEUint32(rr[8:]))
+	assert.Equal(t, uint8(3), rr[12])
+	assert.Equal(t, uint32(4), bele.BEUint24(rr[13:]))
+	assert.Equal(t, uint32(5), bele.BEUint32(rr[16:]))
+	assert.Equal(t, uint32(6), bele.BEUint32(rr[20:]))
+	assert.Equal(t, uint32(7), bele.BEUint32(rr[24:]))
+	assert.Equal(t, uint32(8), bele.BEUint32(rr[28:]))
+}
+
+func TestAVSync(t *testing.T) {
+	now := time.Now()
+	s := NewAVSync(90000, 44100)
+
+	// 没有sr时，使用到达时间作为起始偏移
+	assert.Equal(t, uint32(0), s.ToMS(RTPPacketTypeAVC, 4294967000, now))
+	assert.Equal(t, uint32(10), s.ToMS(RTPPacketTypeAAC, 5000, now.Add(10*time.Millisecond)))
+	// 视频RTP时间戳翻转
+	assert.Equal(t, uint32(1000), s.ToMS(RTPPacketTypeAVC, 4294967000+90000-4294967296, now.Add(time.Second)))
+	assert.Equal(t, uint32(1010), s.ToMS(RTPPacketTypeAAC, 5000+44100, now.Add(time.Second)))
+
+	// 根据sr，音频的第一个采样比视频的第一帧晚200毫秒
+	wall := time.Unix(1600000000, 0)
+	msw, lsw := time2NTP(wall)
+	s.FeedSR(RTPPacketTypeAVC, SR{msw: msw, lsw: lsw, ts: 4294967000})
+	msw, lsw = time2NTP(wall.Add(200 * time.Millisecond))
+	s.FeedSR(RTPPacketTypeAAC, SR{msw: msw, lsw: lsw, ts: 5000})
+	assert.Equal(t, uint32(1200), s.ToMS(RTPPacketTypeAAC, 5000+44100, now.Add(time.Second)))
+	assert.Equal(t, uint32(2000), s.ToMS(RTPPacketTypeAVC, 4294967000+180000-4294967296, now.Add(2*time.Second)))
+}
+
+func TestNTP(t *testing.T) {
+	wall := time.Unix(1600000000, 500000000)
+	msw, lsw := time2NTP(wall)
+	assert.Equal(t, wall.UnixNano()/int64(time.Millisecond), ntp2Time(msw, lsw).UnixNano()/int64(time.Millisecond))
+}
@@ -38,8 +38,9 @@ const (
 )
 
 const (
-	RTPPacketTypeAVC = 96
-	RTPPacketTypeAAC = 97
+	RTPPacketTypeAVC  = 96
+	RTPPacketTypeAAC  = 97
+	RTPPacketTypeHEVC = 98
 )
 
 // rfc3984 5.2.  Common Structure of the RTP Payload Format
@@ -62,6 +63,21 @@ const (
 	NALUTypeFUA       = 28
 )
 
+// rfc7798 4.4.  Payload Structures
+//
+// Type   Packet
+// ----------------------------------------
+// 0-47   Single NAL unit packet
+// 48     Aggregation Packet (AP)
+// 49     Fragmentation Unit (FU)
+// 50     PACI
+// 51-63  undefined
+
+const (
+	NALUTypeHEVCAP = 48
+	NALUTypeHEVCFU = 49
+)
+
 const (
 	//PositionUnknown uint8 = 0
 	PositionTypeSingle      uint8 = 1
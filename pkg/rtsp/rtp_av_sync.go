@@ -0,0 +1,125 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"sync"
+	"time"
+)
+
+// 将音频和视频各自的RTP时间戳，转换到同一个从0开始的毫秒时间轴上
+//
+// 音频和视频的RTP时间戳起始值是随机的，单位也不同，只能通过rtcp sr中NTP时间戳和RTP时间戳的对应关系来同步
+//
+// - 每路流的RTP时间戳先展开（处理翻转）并换算为毫秒，再加上这路流在时间轴上的起始偏移
+// - 还没有收到sr时，使用这路流第一个包的到达时间作为起始偏移
+// - 音频和视频都收到sr后，以视频为基准，使用sr计算音频的起始偏移，之后每次收到sr都会重新校准，避免漂移
+//
+// 一个PubSession或PullSession对应一个对象，内部加锁
+type AVSync struct {
+	m        sync.Mutex
+	hasBase  bool
+	baseTime time.Time // 时间轴的起点，也即第一个合成好的帧的到达时间
+
+	video syncTrack
+	audio syncTrack
+}
+
+type syncTrack struct {
+	clockRate int
+
+	inited   bool
+	lastRTP  uint32
+	ext      int64 // lastRTP相对于第一个RTP时间戳的展开值，单位为clockRate
+	offsetMS int64 // 第一个RTP时间戳在时间轴上的位置
+
+	// 根据最近一次的sr，计算出的第一个RTP时间戳对应的墙上时间
+	hasSR     bool
+	firstWall time.Time
+}
+
+func NewAVSync(videoClockRate int, audioClockRate int) *AVSync {
+	return &AVSync{
+		video: syncTrack{clockRate: videoClockRate},
+		audio: syncTrack{clockRate: audioClockRate},
+	}
+}
+
+// @param payloadType RTPPacketTypeAVC，RTPPacketTypeHEVC或RTPPacketTypeAAC
+// @param rtpTS       RTP时间戳，单位为clockRate
+// @param now         帧的到达时间
+//
+// @return 时间轴上的毫秒时间戳
+func (a *AVSync) ToMS(payloadType int, rtpTS uint32, now time.Time) uint32 {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	t := a.getTrack(payloadType)
+	if t == nil || t.clockRate == 0 {
+		return 0
+	}
+
+	if !a.hasBase {
+		a.baseTime = now
+		a.hasBase = true
+	}
+
+	if !t.inited {
+		t.inited = true
+		t.lastRTP = rtpTS
+		t.ext = 0
+		t.offsetMS = int64(now.Sub(a.baseTime) / time.Millisecond)
+		a.adjust()
+	} else {
+		t.ext += int64(int32(rtpTS - t.lastRTP))
+		t.lastRTP = rtpTS
+	}
+
+	ms := t.offsetMS + t.ext*1000/int64(t.clockRate)
+	if ms < 0 {
+		ms = 0
+	}
+	return uint32(ms)
+}
+
+// @param payloadType 发送这个sr的流的类型，RTPPacketTypeAVC，RTPPacketTypeHEVC或RTPPacketTypeAAC
+func (a *AVSync) FeedSR(payloadType int, sr SR) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	t := a.getTrack(payloadType)
+	// 还没有收到RTP包时，无法确定sr中的RTP时间戳相对于第一个RTP时间戳的位置
+	if t == nil || !t.inited || t.clockRate == 0 {
+		return
+	}
+
+	srExt := t.ext + int64(int32(sr.ts-t.lastRTP))
+	srWall := ntp2Time(sr.msw, sr.lsw)
+	t.firstWall = srWall.Add(-time.Duration(srExt*1000/int64(t.clockRate)) * time.Millisecond)
+	t.hasSR = true
+	a.adjust()
+}
+
+// 音频和视频都收到sr后，以视频为基准，调整音频在时间轴上的起始偏移
+func (a *AVSync) adjust() {
+	if !a.video.inited || !a.audio.inited || !a.video.hasSR || !a.audio.hasSR {
+		return
+	}
+	a.audio.offsetMS = a.video.offsetMS + int64(a.audio.firstWall.Sub(a.video.firstWall).Round(time.Millisecond)/time.Millisecond)
+}
+
+func (a *AVSync) getTrack(payloadType int) *syncTrack {
+	switch payloadType {
+	case RTPPacketTypeAVC, RTPPacketTypeHEVC:
+		return &a.video
+	case RTPPacketTypeAAC:
+		return &a.audio
+	}
+	return nil
+}
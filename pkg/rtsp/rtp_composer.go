@@ -8,27 +8,52 @@
 
 package rtsp
 
-import "github.com/q191201771/naza/pkg/nazalog"
+import (
+	"time"
+
+	"github.com/q191201771/lal/pkg/avc"
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/lal/pkg/hevc"
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
 
 // 传入RTP包，合成帧数据，并回调
 // 一路音频或一路视频对应一个对象
-// 目前支持AVC和AAC
-
-// to be continued
-// 把composer改成unpacker。把stream去掉。解决时间戳问题。
+// 目前支持AVC，HEVC和AAC
+//
+// 内部同时作为jitter buffer使用，对乱序的RTP包重新排序
+// 当出现丢包时，最多等待timeoutMS，之后跳过丢失的包继续合成
+// 视频跳过丢失的包后，丢弃后续的帧，直到下一个关键帧
+//
+// 注意，回调的AVPacket中的Timestamp为RTP时间戳，单位为clockRate，由Stream转换为毫秒
 
 // TODO chef: 由于音频数据，存在多个帧放一个RTP包的情况，叫composer不一定合适了，可以改名为unpacker
 
 // TODO chef: move to package base
 type AVPacket struct {
+	// RTPComposer回调时为RTP时间戳，单位为clockRate
+	// Stream转换为毫秒后，PubSession和PullSession回调的音频和视频处于同一个时间轴上
+	// 传入RTPPacker（SubSession和PushSession的WriteAVPacket）时为毫秒
 	Timestamp   uint32
 	Payload     []byte
 	PayloadType int
 }
 
+// 统计帧数和时间戳，输入类型的session统计合成的帧，输出类型的session统计打包发送的帧
+func feedStatAV(stat *base.StatAV, pkt AVPacket) {
+	switch pkt.PayloadType {
+	case RTPPacketTypeAAC:
+		stat.FeedAudio(pkt.Timestamp)
+	case RTPPacketTypeAVC, RTPPacketTypeHEVC:
+		stat.FeedVideo(pkt.Timestamp)
+	}
+}
+
 type RTPPacketListItem struct {
-	packet RTPPacket
-	next   *RTPPacketListItem
+	packet   RTPPacket
+	recvTime time.Time // 插入队列的时间，用于判断等待丢失的包是否超时
+	next     *RTPPacketListItem
 }
 
 type RTPPacketList struct {
@@ -40,30 +65,46 @@ type RTPComposer struct {
 	payloadType        int
 	clockRate          int
 	maxSize            int
+	timeoutMS          int
 	onAVPacketComposed OnAVPacketComposed
 
 	list         RTPPacketList
 	composedFlag bool
 	composedSeq  uint16
+
+	waitKeyFrame bool // 发生过丢包，视频需要等待下一个关键帧
+	skipCount    int  // 累计因为丢包而跳过的次数
 }
 
 type OnAVPacketComposed func(pkt AVPacket)
 
-func NewRTPComposer(payloadType int, clockRate int, maxSize int, onAVPacketComposed OnAVPacketComposed) *RTPComposer {
+// @param maxSize   缓存的RTP包的最大个数
+// @param timeoutMS 出现丢包时，等待丢失的包的最大时长，单位毫秒，为0则只在缓存满了时才跳过
+func NewRTPComposer(payloadType int, clockRate int, maxSize int, timeoutMS int, onAVPacketComposed OnAVPacketComposed) *RTPComposer {
 	return &RTPComposer{
 		payloadType:        payloadType,
 		clockRate:          clockRate,
 		maxSize:            maxSize,
+		timeoutMS:          timeoutMS,
 		onAVPacketComposed: onAVPacketComposed,
 	}
 }
 
 func (r *RTPComposer) Feed(pkt RTPPacket) {
+	r.feed(pkt, time.Now())
+}
+
+// 累计因为丢包而跳过的次数
+func (r *RTPComposer) SkipCount() int {
+	return r.skipCount
+}
+
+func (r *RTPComposer) feed(pkt RTPPacket, now time.Time) {
 	if r.isStale(pkt.header.seq) {
 		return
 	}
-	calcPositionIfNeeded(&pkt)
-	r.insert(pkt)
+	calcPositionIfNeeded(&pkt, r.payloadType)
+	r.insert(pkt, now)
 
 	// 尽可能多的合成顺序的帧
 	count := 0
@@ -79,8 +120,13 @@ func (r *RTPComposer) Feed(pkt RTPPacket) {
 		return
 	}
 
-	// 缓存达到最大值
-	if r.list.size > r.maxSize {
+	// 缓存达到最大值，或者等待丢失的包超时了
+	for r.list.size > 0 && (r.list.size > r.maxSize || r.isWaitTimeout(now)) {
+		r.skipCount++
+		if r.payloadType == RTPPacketTypeAVC || r.payloadType == RTPPacketTypeHEVC {
+			r.waitKeyFrame = true
+		}
+
 		// 尝试合成一帧发生跳跃的帧
 		if !r.composeOne() {
 
@@ -99,6 +145,47 @@ func (r *RTPComposer) Feed(pkt RTPPacket) {
 	}
 }
 
+// 队列头部的包等待的时长是否超时
+// 注意，只在顺序合成失败时调用，也即队列头部的包和上次合成的包之间有缺失，或者头部的帧不完整
+func (r *RTPComposer) isWaitTimeout(now time.Time) bool {
+	if r.timeoutMS == 0 {
+		return false
+	}
+	first := r.list.head.next
+	if first == nil {
+		return false
+	}
+	return now.Sub(first.recvTime) >= time.Duration(r.timeoutMS)*time.Millisecond
+}
+
+// 回调合成好的帧
+// 视频发生丢包后，丢弃后续的帧，直到下一个关键帧。vps，sps和pps不丢弃
+func (r *RTPComposer) output(pkt AVPacket) {
+	if r.waitKeyFrame && len(pkt.Payload) > 0 {
+		if r.payloadType == RTPPacketTypeHEVC {
+			t := hevc.ParseNALUType(pkt.Payload[0])
+			switch {
+			case hevc.IsIRAP(t):
+				r.waitKeyFrame = false
+			case t == hevc.NALUTypeVPS || t == hevc.NALUTypeSPS || t == hevc.NALUTypePPS:
+				// noop
+			default:
+				return
+			}
+		} else {
+			switch avc.ParseNALUType(pkt.Payload[0]) {
+			case avc.NALUTypeIDRSlice:
+				r.waitKeyFrame = false
+			case avc.NALUTypeSPS, avc.NALUTypePPS:
+				// noop
+			default:
+				return
+			}
+		}
+	}
+	r.onAVPacketComposed(pkt)
+}
+
 // 检查rtp包是否已经过期
 //
 // @return true  表示过期
@@ -112,12 +199,24 @@ func (r *RTPComposer) isStale(seq uint16) bool {
 }
 
 // 计算rtp包处于帧中的位置
-func calcPositionIfNeeded(pkt *RTPPacket) {
-	if pkt.header.packetType != RTPPacketTypeAVC {
-		return
+//
+// @param payloadType 注意，使用的是composer内部的类型，而不是rtp包头中的动态类型
+//
+func calcPositionIfNeeded(pkt *RTPPacket, payloadType int) {
+	switch payloadType {
+	case RTPPacketTypeAVC:
+		calcPositionAVC(pkt)
+	case RTPPacketTypeHEVC:
+		calcPositionHEVC(pkt)
 	}
+}
 
+func calcPositionAVC(pkt *RTPPacket) {
 	b := pkt.raw[pkt.header.payloadOffset:]
+	if len(b) < 2 {
+		pkt.positionType = PositionTypeSingle
+		return
+	}
 
 	// rfc3984 5.3.  NAL Unit Octet Usage
 	//
@@ -182,11 +281,60 @@ func calcPositionIfNeeded(pkt *RTPPacket) {
 	}
 }
 
+func calcPositionHEVC(pkt *RTPPacket) {
+	b := pkt.raw[pkt.header.payloadOffset:]
+
+	// rfc7798 1.1.4.  NAL Unit Header
+	//
+	// +---------------+---------------+
+	// |0|1|2|3|4|5|6|7|0|1|2|3|4|5|6|7|
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |F|   Type    |  LayerId  | TID |
+	// +-------------+-----------------+
+
+	if len(b) < 3 || hevc.ParseNALUType(b[0]) != NALUTypeHEVCFU {
+		// single NAL unit packet或者aggregation packet，都是完整的
+		pkt.positionType = PositionTypeSingle
+		return
+	}
+
+	// rfc7798 4.4.3.  Fragmentation Units
+	//
+	// 0                   1                   2                   3
+	// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |    PayloadHdr (Type=49)       |   FU header   | DONL (cond)   |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-|
+	// | DONL (cond)   |                                               |
+	// |-+-+-+-+-+-+-+-+                                               |
+	// |                         FU payload                            |
+	// |                                                               |
+	// |                               +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	// |                               :...OPTIONAL RTP padding        |
+	// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+	//
+	// FU header:
+	// +---------------+
+	// |0|1|2|3|4|5|6|7|
+	// +-+-+-+-+-+-+-+-+
+	// |S|E|  FuType   |
+	// +---------------+
+
+	fuHeader := b[2]
+	if (fuHeader & 0x80) != 0 {
+		pkt.positionType = PositionTypeMultiStart
+		return
+	}
+	if (fuHeader & 0x40) != 0 {
+		pkt.positionType = PositionTypeMultiEnd
+		return
+	}
+	pkt.positionType = PositionTypeMultiMiddle
+}
+
 // 将rtp包插入队列中的合适位置
-func (r *RTPComposer) insert(pkt RTPPacket) {
+func (r *RTPComposer) insert(pkt RTPPacket, now time.Time) {
 	//l := r.list
-	r.list.size++
-
 	p := &r.list.head
 	for ; p.next != nil; p = p.next {
 		res := CompareSeq(pkt.header.seq, p.next.packet.header.seq)
@@ -197,19 +345,23 @@ func (r *RTPComposer) insert(pkt RTPPacket) {
 			// noop
 		case -1:
 			item := &RTPPacketListItem{
-				packet: pkt,
-				next:   p.next,
+				packet:   pkt,
+				recvTime: now,
+				next:     p.next,
 			}
 			p.next = item
+			r.list.size++
 			return
 		}
 	}
 
 	item := &RTPPacketListItem{
-		packet: pkt,
-		next:   p.next,
+		packet:   pkt,
+		recvTime: now,
+		next:     p.next,
 	}
 	p.next = item
+	r.list.size++
 }
 
 // 从头部检查，是否可以合成一个完成的帧。并且，需保证这次合成的帧的首个seq和上次处理的seq是连续的
@@ -229,8 +381,8 @@ func (r *RTPComposer) composeOneSequential() bool {
 
 func (r *RTPComposer) composeOne() bool {
 	switch r.payloadType {
-	case RTPPacketTypeAVC:
-		return r.composeOneAVC()
+	case RTPPacketTypeAVC, RTPPacketTypeHEVC:
+		return r.composeOneVideo()
 	case RTPPacketTypeAAC:
 		return r.composeOneAAC()
 	}
@@ -294,8 +446,8 @@ func (r *RTPComposer) composeOneAAC() bool {
 		// pau, auSize
 		//nazalog.Debugf("%d %d %s", auSize, auIndex, hex.Dump(b[pau:pau+auSize]))
 		var outPkt AVPacket
-		outPkt.Timestamp = first.packet.header.timestamp / uint32(r.clockRate/1000)
-		outPkt.Timestamp += i * uint32((1024*1000)/r.clockRate)
+		// 一个AAC帧包含1024个采样
+		outPkt.Timestamp = first.packet.header.timestamp + i*1024
 		outPkt.Payload = b[pau : pau+auSize]
 		outPkt.PayloadType = RTPPacketTypeAAC
 
@@ -313,7 +465,7 @@ func (r *RTPComposer) composeOneAAC() bool {
 }
 
 // 从头部检查，是否可以合成一个完整的帧
-func (r *RTPComposer) composeOneAVC() bool {
+func (r *RTPComposer) composeOneVideo() bool {
 	first := r.list.head.next
 	if first == nil {
 		return false
@@ -321,16 +473,23 @@ func (r *RTPComposer) composeOneAVC() bool {
 
 	switch first.packet.positionType {
 	case PositionTypeSingle:
-		var pkt AVPacket
-		pkt.Timestamp = first.packet.header.timestamp / uint32(r.clockRate/1000)
-		pkt.Payload = first.packet.raw[first.packet.header.payloadOffset:]
-		pkt.PayloadType = RTPPacketTypeAVC
+		b := first.packet.raw[first.packet.header.payloadOffset:]
 
 		r.composedFlag = true
 		r.composedSeq = first.packet.header.seq
 		r.list.head.next = first.next
 		r.list.size--
-		r.onAVPacketComposed(pkt)
+
+		if r.payloadType == RTPPacketTypeHEVC && len(b) > 0 && hevc.ParseNALUType(b[0]) == NALUTypeHEVCAP {
+			r.outputHEVCAP(first.packet.header.timestamp, b)
+			return true
+		}
+
+		var pkt AVPacket
+		pkt.Timestamp = first.packet.header.timestamp
+		pkt.Payload = b
+		pkt.PayloadType = r.payloadType
+		r.output(pkt)
 
 		return true
 	case PositionTypeMultiStart:
@@ -350,18 +509,28 @@ func (r *RTPComposer) composeOneAVC() bool {
 				continue
 			} else if p.packet.positionType == PositionTypeMultiEnd {
 				var pkt AVPacket
-				pkt.Timestamp = p.packet.header.timestamp / uint32(r.clockRate/1000)
-
-				fuIndicator := first.packet.raw[first.packet.header.payloadOffset]
-				fuHeader := first.packet.raw[first.packet.header.payloadOffset+1]
-				naluType := (fuIndicator & 0xE0) | (fuHeader & 0x1F)
-				pkt.Payload = append(pkt.Payload, naluType)
-				pkt.PayloadType = RTPPacketTypeAVC
+				pkt.Timestamp = p.packet.header.timestamp
+				pkt.PayloadType = r.payloadType
+
+				// 使用FU的头信息，还原NALU的头
+				b := first.packet.raw[first.packet.header.payloadOffset:]
+				var fuHeaderLen uint32
+				if r.payloadType == RTPPacketTypeHEVC {
+					// PayloadHdr中除了Type，其他字段和原NALU头相同，Type使用FU header中的FuType
+					pkt.Payload = append(pkt.Payload, (b[0]&0x81)|((b[2]&0x3F)<<1), b[1])
+					fuHeaderLen = 3
+				} else {
+					fuIndicator := b[0]
+					fuHeader := b[1]
+					naluType := (fuIndicator & 0xE0) | (fuHeader & 0x1F)
+					pkt.Payload = append(pkt.Payload, naluType)
+					fuHeaderLen = 2
+				}
 
 				pp := first
 				packetCount := 0
 				for {
-					pkt.Payload = append(pkt.Payload, pp.packet.raw[pp.packet.header.payloadOffset+2:]...)
+					pkt.Payload = append(pkt.Payload, pp.packet.raw[pp.packet.header.payloadOffset+fuHeaderLen:]...)
 					packetCount++
 
 					if pp == p {
@@ -374,7 +543,7 @@ func (r *RTPComposer) composeOneAVC() bool {
 				r.composedSeq = p.packet.header.seq
 				r.list.head.next = p.next
 				r.list.size -= packetCount
-				r.onAVPacketComposed(pkt)
+				r.output(pkt)
 
 				return true
 			} else {
@@ -392,20 +561,46 @@ func (r *RTPComposer) composeOneAVC() bool {
 	return false
 }
 
-// h265
-//{
-//	originNALUType := (b[h.payloadOffset] >> 1) & 0x3F
-//	if originNALUType == 49 {
-//		header2 := b[h.payloadOffset+2]
-//
-//		startCode := (header2 & 0x80) != 0
-//		endCode := (header2 & 0x40) != 0
+// 将HEVC的aggregation packet拆分为多个NALU，逐个回调
 //
-//		naluType := header2 & 0x3F
+// 注意，没有处理sdp中sprop-max-don-diff大于0，也即携带DONL字段的情况
 //
-//		nazalog.Debugf("FUA. originNALUType=%d, naluType=%d, startCode=%t, endCode=%t %s", originNALUType, naluType, startCode, endCode, hex.Dump(b[12:32]))
+// rfc7798 4.4.2.  Aggregation Packets (APs)
 //
-//	} else {
-//		nazalog.Debugf("SINGLE. naluType=%d %s", originNALUType, hex.Dump(b[12:32]))
-//	}
-//}
+// 0                   1                   2                   3
+// 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1 2 3 4 5 6 7 8 9 0 1
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                          RTP Header                           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |   PayloadHdr (Type=48)        |         NALU 1 Size           |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |          NALU 1 HDR           |                               |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+         NALU 1 Payload        |
+// |                   . . .                                       |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |  . . .        | NALU 2 Size                   | NALU 2 HDR    |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// | NALU 2 HDR    |                                               |
+// +-+-+-+-+-+-+-+-+              NALU 2 Payload                   |
+// |                   . . .                                       |
+// |                               +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+// |                               :...OPTIONAL RTP padding        |
+// +-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
+func (r *RTPComposer) outputHEVCAP(timestamp uint32, b []byte) {
+	for i := 2; i+2 <= len(b); {
+		size := int(bele.BEUint16(b[i:]))
+		i += 2
+		if size == 0 || i+size > len(b) {
+			nazalog.Errorf("invalid hevc aggregation packet. len=%d, pos=%d, size=%d", len(b), i, size)
+			return
+		}
+
+		var pkt AVPacket
+		pkt.Timestamp = timestamp
+		pkt.Payload = b[i : i+size]
+		pkt.PayloadType = RTPPacketTypeHEVC
+		r.output(pkt)
+
+		i += size
+	}
+}
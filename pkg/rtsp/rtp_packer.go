@@ -0,0 +1,241 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"github.com/q191201771/naza/pkg/bele"
+	"github.com/q191201771/naza/pkg/nazalog"
+)
+
+// 传入帧数据，打包成RTP包
+// 一路音频或一路视频对应一个对象
+// 目前支持AVC，HEVC和AAC
+//
+// 与RTPComposer的作用相反
+
+// RTP包中payload部分的最大值，保证加上RTP头以及IP、UDP头后不超过MTU
+var rtpPayloadMaxSize = 1400
+
+type RTPPacker struct {
+	payloadType int   // 注意，使用的是packer内部的类型，而不是rtp包头中的动态类型
+	rtpPT       uint8 // rtp包头中的动态类型
+	clockRate   int
+	ssrc        uint32
+	seq         uint16
+
+	// 用于生成rtcp sr
+	lastTimestamp uint32
+	packetCount   uint32
+	octetCount    uint32
+}
+
+// @param payloadType 内部类型，RTPPacketTypeAVC，RTPPacketTypeHEVC或RTPPacketTypeAAC
+// @param rtpPT       rtp包头中使用的类型，和sdp中保持一致
+func NewRTPPacker(payloadType int, rtpPT uint8, clockRate int, ssrc uint32) *RTPPacker {
+	return &RTPPacker{
+		payloadType: payloadType,
+		rtpPT:       rtpPT,
+		clockRate:   clockRate,
+		ssrc:        ssrc,
+	}
+}
+
+// @param pkt 音频时，Payload为一帧raw AAC数据
+//            视频时，Payload为一帧AVCC（HVCC）格式的数据，也即每个NALU前有4字节的长度，一帧中可以包含多个NALU
+//            Timestamp的单位为毫秒
+//
+// @return 打包好的RTP包，每个元素为一个完整的RTP包
+func (r *RTPPacker) Pack(pkt AVPacket) (out [][]byte) {
+	ts := uint32(uint64(pkt.Timestamp) * uint64(r.clockRate) / 1000)
+
+	switch r.payloadType {
+	case RTPPacketTypeAVC:
+		out = r.packAVC(ts, pkt.Payload)
+	case RTPPacketTypeHEVC:
+		out = r.packHEVC(ts, pkt.Payload)
+	case RTPPacketTypeAAC:
+		out = r.packAAC(ts, pkt.Payload)
+	default:
+		nazalog.Errorf("unknown payload type. type=%d", r.payloadType)
+	}
+	return
+}
+
+func (r *RTPPacker) SSRC() uint32 {
+	return r.ssrc
+}
+
+// 获取生成rtcp sr所需要的信息
+//
+// @return timestamp   最近一个RTP包的时间戳，单位为clockRate
+//         packetCount 累计发送的RTP包个数
+//         octetCount  累计发送的RTP payload字节数
+func (r *RTPPacker) Stat() (timestamp uint32, packetCount uint32, octetCount uint32) {
+	return r.lastTimestamp, r.packetCount, r.octetCount
+}
+
+// rfc6184 5.6 Single NAL Unit Packet
+// rfc6184 5.8 Fragmentation Units (FUs)
+//
+// 一帧中最后一个NALU的最后一个RTP包的mark位设置为1
+func (r *RTPPacker) packAVC(ts uint32, payload []byte) (out [][]byte) {
+	nalus := splitAVCC(payload)
+	for i, nalu := range nalus {
+		lastNALU := i == len(nalus)-1
+
+		if len(nalu) <= rtpPayloadMaxSize {
+			var mark uint8
+			if lastNALU {
+				mark = 1
+			}
+			out = append(out, r.packRTP(mark, ts, nalu))
+			continue
+		}
+
+		// FU-A
+		fuIndicator := (nalu[0] & 0xE0) | NALUTypeFUA
+		naluType := nalu[0] & 0x1F
+		remain := nalu[1:]
+		first := true
+		for len(remain) > 0 {
+			n := rtpPayloadMaxSize - 2
+			if n > len(remain) {
+				n = len(remain)
+			}
+
+			fuHeader := naluType
+			if first {
+				fuHeader |= 0x80
+				first = false
+			}
+			var mark uint8
+			if n == len(remain) {
+				fuHeader |= 0x40
+				if lastNALU {
+					mark = 1
+				}
+			}
+
+			b := make([]byte, 2+n)
+			b[0] = fuIndicator
+			b[1] = fuHeader
+			copy(b[2:], remain[:n])
+			out = append(out, r.packRTP(mark, ts, b))
+
+			remain = remain[n:]
+		}
+	}
+	return
+}
+
+// rfc7798 4.4.1.  Single NAL Unit Packets
+// rfc7798 4.4.3.  Fragmentation Units
+//
+// 不使用aggregation packet，也不携带DONL字段
+// 一帧中最后一个NALU的最后一个RTP包的mark位设置为1
+func (r *RTPPacker) packHEVC(ts uint32, payload []byte) (out [][]byte) {
+	nalus := splitAVCC(payload)
+	for i, nalu := range nalus {
+		lastNALU := i == len(nalus)-1
+
+		if len(nalu) <= rtpPayloadMaxSize {
+			var mark uint8
+			if lastNALU {
+				mark = 1
+			}
+			out = append(out, r.packRTP(mark, ts, nalu))
+			continue
+		}
+
+		// FU，PayloadHdr除了Type为49，其他字段和原NALU头相同
+		if len(nalu) < 3 {
+			continue
+		}
+		payloadHdr0 := (nalu[0] & 0x81) | (NALUTypeHEVCFU << 1)
+		payloadHdr1 := nalu[1]
+		fuType := (nalu[0] >> 1) & 0x3F
+		remain := nalu[2:]
+		first := true
+		for len(remain) > 0 {
+			n := rtpPayloadMaxSize - 3
+			if n > len(remain) {
+				n = len(remain)
+			}
+
+			fuHeader := fuType
+			if first {
+				fuHeader |= 0x80
+				first = false
+			}
+			var mark uint8
+			if n == len(remain) {
+				fuHeader |= 0x40
+				if lastNALU {
+					mark = 1
+				}
+			}
+
+			b := make([]byte, 3+n)
+			b[0] = payloadHdr0
+			b[1] = payloadHdr1
+			b[2] = fuHeader
+			copy(b[3:], remain[:n])
+			out = append(out, r.packRTP(mark, ts, b))
+
+			remain = remain[n:]
+		}
+	}
+	return
+}
+
+// rfc3640 3.3.6.  High Bit-rate AAC
+//
+// 一个RTP包只包含一帧AAC数据，AU-headers-length为16，AU-size为13比特，AU-Index为3比特
+func (r *RTPPacker) packAAC(ts uint32, frame []byte) [][]byte {
+	b := make([]byte, 4+len(frame))
+	b[0] = 0
+	b[1] = 16
+	auSize := uint16(len(frame)) << 3
+	b[2] = uint8(auSize >> 8)
+	b[3] = uint8(auSize)
+	copy(b[4:], frame)
+	return [][]byte{r.packRTP(1, ts, b)}
+}
+
+func (r *RTPPacker) packRTP(mark uint8, ts uint32, payload []byte) []byte {
+	b := make([]byte, RTPFixedHeaderLength+len(payload))
+	b[0] = 0x80 // version=2
+	b[1] = (mark << 7) | (r.rtpPT & 0x7F)
+	b[2] = uint8(r.seq >> 8)
+	b[3] = uint8(r.seq)
+	bele.BEPutUint32(b[4:], ts)
+	bele.BEPutUint32(b[8:], r.ssrc)
+	copy(b[RTPFixedHeaderLength:], payload)
+
+	r.seq++
+	r.lastTimestamp = ts
+	r.packetCount++
+	r.octetCount += uint32(len(payload))
+	return b
+}
+
+// 将一帧AVCC（HVCC）格式的数据拆分为多个NALU
+func splitAVCC(payload []byte) (nalus [][]byte) {
+	for i := 0; i+4 <= len(payload); {
+		naluLen := int(bele.BEUint32(payload[i:]))
+		i += 4
+		if naluLen == 0 || i+naluLen > len(payload) {
+			nazalog.Errorf("invalid avcc payload. len=%d, pos=%d, nalu len=%d", len(payload), i, naluLen)
+			break
+		}
+		nalus = append(nalus, payload[i:i+naluLen])
+		i += naluLen
+	}
+	return
+}
@@ -0,0 +1,140 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import "time"
+
+// 作为RTP接收方，统计一个SSRC的丢包和抖动，用于生成rtcp rr中的report block
+//
+// rfc3550 A.1 RTP Data Header Validity Checks
+// rfc3550 A.3 Determining Number of Packets Expected and Lost
+// rfc3550 A.8 Estimating the Interarrival Jitter
+
+// rtcp rr中的一个report block，字段含义见rfc3550 6.4.1
+type ReportBlock struct {
+	SSRC               uint32
+	FractionLost       uint8
+	CumulativeLost     uint32 // 24b
+	ExtendedHighestSeq uint32
+	Jitter             uint32 // 单位为clockRate
+	LSR                uint32
+	DLSR               uint32 // 单位为1/65536秒
+}
+
+type RTPReceiverStat struct {
+	clockRate int
+
+	inited   bool
+	ssrc     uint32
+	baseSeq  uint16
+	maxSeq   uint16
+	cycles   uint32
+	received uint32
+
+	expectedPrior uint32
+	receivedPrior uint32
+
+	transit int64
+	jitter  float64
+
+	// 最近一次收到的rtcp sr
+	lastSR         uint32 // NTP时间戳的中间32位
+	lastSRRecvTime time.Time
+}
+
+func NewRTPReceiverStat(clockRate int) *RTPReceiverStat {
+	return &RTPReceiverStat{
+		clockRate: clockRate,
+	}
+}
+
+// @param now 收到RTP包的时间
+func (r *RTPReceiverStat) FeedRTPHeader(h RTPHeader, now time.Time) {
+	// 对端更换了SSRC，重新统计
+	if !r.inited || h.ssrc != r.ssrc {
+		*r = RTPReceiverStat{clockRate: r.clockRate}
+		r.inited = true
+		r.ssrc = h.ssrc
+		r.baseSeq = h.seq
+		r.maxSeq = h.seq
+		r.transit = r.calcTransit(h.timestamp, now)
+		r.received = 1
+		return
+	}
+
+	r.received++
+	if SubSeq(h.seq, r.maxSeq) > 0 {
+		// 序号翻转
+		if h.seq < r.maxSeq {
+			r.cycles += 65536
+		}
+		r.maxSeq = h.seq
+	}
+
+	transit := r.calcTransit(h.timestamp, now)
+	d := transit - r.transit
+	r.transit = transit
+	if d < 0 {
+		d = -d
+	}
+	r.jitter += (float64(d) - r.jitter) / 16
+}
+
+// @param now 收到rtcp sr的时间
+//
+// 注意，不是统计的SSRC发送的sr会被忽略
+func (r *RTPReceiverStat) FeedSR(sr SR, now time.Time) {
+	if !r.inited || sr.senderSSRC != r.ssrc {
+		return
+	}
+	r.lastSR = (sr.msw << 16) | (sr.lsw >> 16)
+	r.lastSRRecvTime = now
+}
+
+func (r *RTPReceiverStat) SSRC() uint32 {
+	return r.ssrc
+}
+
+func (r *RTPReceiverStat) HasData() bool {
+	return r.inited
+}
+
+// 生成report block，并开始下一个统计区间
+func (r *RTPReceiverStat) MakeReportBlock(now time.Time) (rb ReportBlock) {
+	extendedMax := r.cycles + uint32(r.maxSeq)
+	expected := extendedMax - uint32(r.baseSeq) + 1
+
+	rb.SSRC = r.ssrc
+	rb.ExtendedHighestSeq = extendedMax
+	rb.Jitter = uint32(r.jitter)
+
+	if expected > r.received {
+		rb.CumulativeLost = (expected - r.received) & 0xFFFFFF
+	}
+
+	expectedInterval := expected - r.expectedPrior
+	receivedInterval := r.received - r.receivedPrior
+	r.expectedPrior = expected
+	r.receivedPrior = r.received
+	if expectedInterval != 0 && expectedInterval > receivedInterval {
+		rb.FractionLost = uint8(((expectedInterval - receivedInterval) << 8) / expectedInterval)
+	}
+
+	if !r.lastSRRecvTime.IsZero() {
+		rb.LSR = r.lastSR
+		rb.DLSR = uint32(now.Sub(r.lastSRRecvTime) * 65536 / time.Second)
+	}
+	return
+}
+
+// 到达时间和RTP时间戳的差值，单位为clockRate
+func (r *RTPReceiverStat) calcTransit(timestamp uint32, now time.Time) int64 {
+	arrival := now.UnixNano() / int64(time.Millisecond) * int64(r.clockRate) / 1000
+	return arrival - int64(timestamp)
+}
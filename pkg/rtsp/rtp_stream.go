@@ -8,28 +8,84 @@
 
 package rtsp
 
+import (
+	"sync"
+	"time"
+)
+
+// 出现丢包时，jitter buffer等待丢失的包的最大时长
+var jitterBufferTimeoutMS = 500
+
 type OnAVPacket func(pkt AVPacket)
 
+// 接收的一路音频或一路视频
+// 合成帧数据，并统计接收情况用于生成rtcp rr
+//
+// 注意，信令协程（RTP/AVP/TCP方式），UDP的读取协程，以及发送rtcp rr时都会访问，所以内部加锁
 type Stream struct {
-	onAVPacket OnAVPacket
-	composer   *RTPComposer
+	payloadType int
+	onAVPacket  OnAVPacket
+	avSync      *AVSync
+
+	m        sync.Mutex
+	composer *RTPComposer
+	stat     *RTPReceiverStat
 }
 
-func NewStream(payloadType int, clockRate int, onAVPacket OnAVPacket) *Stream {
+// @param avSync 用于将RTP时间戳转换为毫秒，音频和视频的Stream使用同一个对象
+func NewStream(payloadType int, clockRate int, avSync *AVSync, onAVPacket OnAVPacket) *Stream {
 	var s Stream
+	s.payloadType = payloadType
 	s.onAVPacket = onAVPacket
-	s.composer = NewRTPComposer(payloadType, clockRate, composerItemMaxSize, s.onAVPacketComposed)
+	s.avSync = avSync
+	s.composer = NewRTPComposer(payloadType, clockRate, composerItemMaxSize, jitterBufferTimeoutMS, s.onAVPacketComposed)
+	s.stat = NewRTPReceiverStat(clockRate)
 	return &s
 }
 
 func (s *Stream) FeedAVCPacket(pkt RTPPacket) {
-	s.composer.Feed(pkt)
+	s.feedRTPPacket(pkt)
+}
+
+func (s *Stream) FeedHEVCPacket(pkt RTPPacket) {
+	s.feedRTPPacket(pkt)
 }
 
 func (s *Stream) FeedAACPacket(pkt RTPPacket) {
+	s.feedRTPPacket(pkt)
+}
+
+// 注意，不是这路流的SSRC发送的sr会被忽略
+func (s *Stream) FeedSR(sr SR) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if !s.stat.HasData() || s.stat.SSRC() != sr.senderSSRC {
+		return
+	}
+	s.stat.FeedSR(sr, time.Now())
+	s.avSync.FeedSR(s.payloadType, sr)
+}
+
+// 生成rtcp rr中的report block
+//
+// @return ok 还没有收到过RTP包时为false
+func (s *Stream) MakeReportBlock() (rb ReportBlock, ok bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if !s.stat.HasData() {
+		return
+	}
+	return s.stat.MakeReportBlock(time.Now()), true
+}
+
+func (s *Stream) feedRTPPacket(pkt RTPPacket) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.stat.FeedRTPHeader(pkt.header, time.Now())
 	s.composer.Feed(pkt)
 }
 
 func (s *Stream) onAVPacketComposed(pkt AVPacket) {
+	pkt.Timestamp = s.avSync.ToMS(s.payloadType, pkt.Timestamp, time.Now())
 	s.onAVPacket(pkt)
 }
@@ -10,8 +10,10 @@ package rtsp
 
 import (
 	"testing"
+	"time"
 
 	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
 )
 
 func TestCompareSeq(t *testing.T) {
@@ -61,3 +63,173 @@ func TestSubSeq(t *testing.T) {
 	assert.Equal(t, 3, SubSeq(1, 65534))
 	assert.Equal(t, 2, SubSeq(1, 65535))
 }
+
+func TestRTPPacker(t *testing.T) {
+	// 一个小的NALU，以及一个需要FU-A分片的大NALU
+	small := []byte{0x06, 0x01, 0x02, 0x03}
+	big := make([]byte, rtpPayloadMaxSize*2+100)
+	big[0] = 0x65
+	for i := 1; i < len(big); i++ {
+		big[i] = uint8(i)
+	}
+	var frame []byte
+	for _, nalu := range [][]byte{small, big} {
+		l := make([]byte, 4)
+		bele.BEPutUint32(l, uint32(len(nalu)))
+		frame = append(frame, l...)
+		frame = append(frame, nalu...)
+	}
+
+	var nalus [][]byte
+	composer := NewRTPComposer(RTPPacketTypeAVC, 90000, composerItemMaxSize, 0, func(pkt AVPacket) {
+		assert.Equal(t, uint32(3600), pkt.Timestamp)
+		nalus = append(nalus, pkt.Payload)
+	})
+
+	packer := NewRTPPacker(RTPPacketTypeAVC, RTPPacketTypeAVC, 90000, 1)
+	out := packer.Pack(AVPacket{Timestamp: 40, Payload: frame, PayloadType: RTPPacketTypeAVC})
+	assert.Equal(t, 4, len(out))
+	for i, b := range out {
+		h, err := parseRTPPacket(b)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, uint16(i), h.seq)
+		assert.Equal(t, uint32(3600), h.timestamp)
+		if i == len(out)-1 {
+			assert.Equal(t, uint8(1), h.mark)
+		} else {
+			assert.Equal(t, uint8(0), h.mark)
+		}
+		composer.Feed(RTPPacket{header: h, raw: b})
+	}
+	assert.Equal(t, [][]byte{small, big}, nalus)
+
+	ts, packetCount, _ := packer.Stat()
+	assert.Equal(t, uint32(3600), ts)
+	assert.Equal(t, uint32(4), packetCount)
+
+	// aac
+	aacFrame := []byte{0x21, 0x2b, 0x94, 0xa5}
+	composer = NewRTPComposer(RTPPacketTypeAAC, 44100, composerItemMaxSize, 0, func(pkt AVPacket) {
+		assert.Equal(t, aacFrame, pkt.Payload)
+	})
+	packer = NewRTPPacker(RTPPacketTypeAAC, RTPPacketTypeAAC, 44100, 2)
+	out = packer.Pack(AVPacket{Timestamp: 40, Payload: aacFrame, PayloadType: RTPPacketTypeAAC})
+	assert.Equal(t, 1, len(out))
+	h, err := parseRTPPacket(out[0])
+	assert.Equal(t, nil, err)
+	composer.Feed(RTPPacket{header: h, raw: out[0]})
+}
+
+func TestRTPPackerHEVC(t *testing.T) {
+	// 一个小的NALU，以及一个需要FU分片的大NALU
+	small := []byte{0x4e, 0x01, 0x05, 0x06}
+	big := make([]byte, rtpPayloadMaxSize*2+100)
+	big[0] = 0x26
+	big[1] = 0x01
+	for i := 2; i < len(big); i++ {
+		big[i] = uint8(i)
+	}
+	var frame []byte
+	for _, nalu := range [][]byte{small, big} {
+		l := make([]byte, 4)
+		bele.BEPutUint32(l, uint32(len(nalu)))
+		frame = append(frame, l...)
+		frame = append(frame, nalu...)
+	}
+
+	var nalus [][]byte
+	composer := NewRTPComposer(RTPPacketTypeHEVC, 90000, composerItemMaxSize, 0, func(pkt AVPacket) {
+		assert.Equal(t, RTPPacketTypeHEVC, pkt.PayloadType)
+		nalus = append(nalus, pkt.Payload)
+	})
+
+	packer := NewRTPPacker(RTPPacketTypeHEVC, RTPPacketTypeHEVC, 90000, 1)
+	out := packer.Pack(AVPacket{Timestamp: 40, Payload: frame, PayloadType: RTPPacketTypeHEVC})
+	assert.Equal(t, 4, len(out))
+	for i, b := range out {
+		h, err := parseRTPPacket(b)
+		assert.Equal(t, nil, err)
+		if i > 0 {
+			// PayloadHdr的Type为49，FU header的FuType为原NALU的类型
+			assert.Equal(t, uint8(NALUTypeHEVCFU), (b[RTPFixedHeaderLength]>>1)&0x3F)
+			assert.Equal(t, uint8(19), b[RTPFixedHeaderLength+2]&0x3F)
+		}
+		composer.Feed(RTPPacket{header: h, raw: b})
+	}
+	assert.Equal(t, [][]byte{small, big}, nalus)
+}
+
+func TestRTPComposerHEVCAP(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0c}
+	sps := []byte{0x42, 0x01, 0x01, 0x01}
+	pps := []byte{0x44, 0x01, 0xc1}
+
+	// PayloadHdr Type=48
+	payload := []byte{NALUTypeHEVCAP << 1, 0x01}
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		payload = append(payload, 0x00, uint8(len(nalu)))
+		payload = append(payload, nalu...)
+	}
+	packer := NewRTPPacker(RTPPacketTypeHEVC, RTPPacketTypeHEVC, 90000, 1)
+	b := packer.packRTP(0, 3600, payload)
+	h, err := parseRTPPacket(b)
+	assert.Equal(t, nil, err)
+
+	var nalus [][]byte
+	composer := NewRTPComposer(RTPPacketTypeHEVC, 90000, composerItemMaxSize, 0, func(pkt AVPacket) {
+		assert.Equal(t, uint32(3600), pkt.Timestamp)
+		nalus = append(nalus, pkt.Payload)
+	})
+	composer.Feed(RTPPacket{header: h, raw: b})
+	assert.Equal(t, [][]byte{vps, sps, pps}, nalus)
+}
+
+func TestRTPComposerJitterBuffer(t *testing.T) {
+	// 每个NALU使用一个RTP包，seq即为下标
+	nalus := [][]byte{
+		{0x65, 0x00}, // 0 IDR
+		{0x41, 0x01}, // 1
+		{0x41, 0x02}, // 2 丢失
+		{0x41, 0x03}, // 3
+		{0x67, 0x04}, // 4 SPS
+		{0x65, 0x05}, // 5 IDR
+		{0x41, 0x06}, // 6
+	}
+	packer := NewRTPPacker(RTPPacketTypeAVC, RTPPacketTypeAVC, 90000, 1)
+	var pkts []RTPPacket
+	for i, nalu := range nalus {
+		frame := make([]byte, 4+len(nalu))
+		bele.BEPutUint32(frame, uint32(len(nalu)))
+		copy(frame[4:], nalu)
+		b := packer.Pack(AVPacket{Timestamp: uint32(i * 40), Payload: frame, PayloadType: RTPPacketTypeAVC})[0]
+		h, err := parseRTPPacket(b)
+		assert.Equal(t, nil, err)
+		pkts = append(pkts, RTPPacket{header: h, raw: b})
+	}
+
+	var out [][]byte
+	composer := NewRTPComposer(RTPPacketTypeAVC, 90000, composerItemMaxSize, 500, func(pkt AVPacket) {
+		out = append(out, pkt.Payload)
+	})
+	now := time.Now()
+	composer.feed(pkts[0], now)
+	composer.feed(pkts[1], now)
+	// 乱序
+	composer.feed(pkts[4], now)
+	composer.feed(pkts[3], now)
+	assert.Equal(t, [][]byte{nalus[0], nalus[1]}, out)
+	assert.Equal(t, 0, composer.SkipCount())
+
+	// 还没有超时，继续等待丢失的包
+	composer.feed(pkts[5], now.Add(100*time.Millisecond))
+	assert.Equal(t, 2, len(out))
+
+	// 超时，跳过丢失的包，丢弃关键帧之前的帧
+	composer.feed(pkts[6], now.Add(600*time.Millisecond))
+	assert.Equal(t, [][]byte{nalus[0], nalus[1], nalus[4], nalus[5], nalus[6]}, out)
+	assert.Equal(t, 1, composer.SkipCount())
+
+	// 超时后才到达的包被丢弃
+	composer.feed(pkts[2], now.Add(700*time.Millisecond))
+	assert.Equal(t, 5, len(out))
+}
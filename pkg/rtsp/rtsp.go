@@ -8,6 +8,8 @@
 
 package rtsp
 
+import "errors"
+
 // 注意，正在学习以及实现rtsp，请不要使用这个package
 
 // TODO chef
@@ -15,6 +17,8 @@ package rtsp
 
 // rfc2326
 
+var ErrRTSP = errors.New("lal.rtsp: fxxk")
+
 const (
 	MethodOptions  = "OPTIONS"
 	MethodAnnounce = "ANNOUNCE"
@@ -22,11 +26,25 @@ const (
 	MethodRecord   = "RECORD"
 	MethodDescribe = "DESCRIBE"
 	MethodPlay     = "PLAY"
+	MethodTeardown = "TEARDOWN"
+)
+
+const (
+	HeaderFieldCSeq            = "CSeq"
+	HeaderFieldTransport       = "Transport"
+	HeaderFieldSession         = "Session"
+	HeaderFieldContentLength   = "Content-Length"
+	HeaderFieldContentType     = "Content-Type"
+	HeaderFieldContentBase     = "Content-Base"
+	HeaderFieldAccept          = "Accept"
+	HeaderFieldRange           = "Range"
+	HeaderFieldAuthorization   = "Authorization"
+	HeaderFieldWWWAuthenticate = "WWW-Authenticate"
 )
 
+// Transport字段中的协议
 const (
-	HeaderFieldCSeq      = "CSeq"
-	HeaderFieldTransport = "Transport"
+	TransportTCP = "RTP/AVP/TCP"
 )
 
 var (
@@ -39,4 +57,6 @@ var (
 	maxServerPort = uint16(16000)
 
 	udpMaxPacketLength = 1500
+
+	wChanSize = 1024 // SubSession使用信令连接发送数据时，channel的大小
 )
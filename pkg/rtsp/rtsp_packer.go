@@ -82,6 +82,15 @@ var ResponseSetupTmpl = "RTSP/1.0 200 OK\r\n" +
 	"Transport:RTP/AVP/UDP;unicast;client_port=%s;server_port=%d-%d\r\n" +
 	"\r\n"
 
+// rfc2326 10.4 SETUP, 10.12 Embedded (Interleaved) Binary Data
+// CSeq, Date, Session, Transport(interleaved)
+var ResponseSetupTCPTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"Date: %s\r\n" +
+	"Session: %s\r\n" +
+	"Transport:RTP/AVP/TCP;unicast;interleaved=%d-%d\r\n" +
+	"\r\n"
+
 // rfc2326 10.11 RECORD
 // CSeq, Session
 var ResponseRecordTmpl = "RTSP/1.0 200 OK\r\n" +
@@ -89,53 +98,35 @@ var ResponseRecordTmpl = "RTSP/1.0 200 OK\r\n" +
 	"Session: %s\r\n" +
 	"\r\n"
 
+// rfc2326 10.7 TEARDOWN
+// CSeq
+var ResponseTeardownTmpl = "RTSP/1.0 200 OK\r\n" +
+	"CSeq: %s\r\n" +
+	"\r\n"
+
 // rfc2326 10.2 DESCRIBE
-// CSeq, Date, Content-Length,
+// CSeq, Date, Content-Length, sdp
 var ResponseDescribeTmpl = "RTSP/1.0 200 OK\r\n" +
 	"CSeq: %s\r\n" +
 	"Date: %s\r\n" +
 	"Content-Type: application/sdp\r\n" +
 	"Content-Length: %d\r\n" +
 	"\r\n" +
-	SDPTmpl
-
-// rfc4566
-// * v=0
-//   Session Description Protocol Version (v)
-// * o=<username> <sess-id> <sess-version> <nettype> <addrtype> <unicast-address>
-//     <unicast-address>
-//   Owner/Creator, Session Id (o)
-// * s=
-//   Session Name (s)
-// * c=
-//   Connection Information (c)
-// * t=<start-time> <stop-time>
-//   Time Description, active time (t)
-// * a=
-//   Session Attribute | Media Attribute (a)
-// * m=
-//   Media Description, name and address (m)
-// * b=
-//   Bandwidth Information (b)
-var SDPTmpl = "v=0\r\n" +
-	"o=mhandley 2890844526 2890842807 IN IP4 126.16.64.4\r\n" +
-	//"s=SDP Seminar\r\n" +
-	//"i=A Seminar on the session description protocol\r\n" +
-	//"u=http://www.cs.ucl.ac.uk/staff/M.Handley/sdp.03.ps\r\n" +
-	//"e=mjh@isi.edu (Mark Handley)\r\n" +
-	//"c=IN IP4 224.2.17.12/127\r\n" +
-	//"t=2873397496 2873404696\r\n" +
-	"t=0 0\r\n" +
-	"a=recvonly\r\n"
-	//"m=audio 3456 RTP/AVP 0\r\n" +
-	//"m=video 2232 RTP/AVP 31\r\n" +
-	//"m=whiteboard 32416 UDP WB\r\n" +
-	//"a=orient:portrait\r\n"
+	"%s"
 
 // rfc2326 10.5 PLAY
+// CSeq, Date, Session, Range
 var PlayTmpl = "RTSP/1.0 200 OK\r\n" +
 	"CSeq: %s\r\n" +
 	"Date: %s\r\n" +
+	"Session: %s\r\n" +
+	"Range: npt=0.000-\r\n" +
+	"\r\n"
+
+// rfc2326 7.1.1 Status Code and Reason Phrase
+// CSeq
+var ResponseNotFoundTmpl = "RTSP/1.0 404 Not Found\r\n" +
+	"CSeq: %s\r\n" +
 	"\r\n"
 
 func PackResponseOptions(cseq string) string {
@@ -169,16 +160,52 @@ func PackResponseSetup(cseq string, transportC string, serverRTPPort uint16, ser
 	return fmt.Sprintf(ResponseSetupTmpl, cseq, date, sessionID, clientPort, serverRTPPort, serverRTCPPort)
 }
 
+// 对应的请求中的Transport字段例子:
+//   RTP/AVP/TCP;unicast;interleaved=0-1;mode=record
+//   RTP/AVP/TCP;unicast;interleaved=2-3
+func PackResponseSetupTCP(cseq string, rtpChannel int, rtcpChannel int) string {
+	date := time.Now().Format(time.RFC1123)
+	return fmt.Sprintf(ResponseSetupTCPTmpl, cseq, date, sessionID, rtpChannel, rtcpChannel)
+}
+
 func PackResponseRecord(cseq string) string {
 	return fmt.Sprintf(ResponseRecordTmpl, cseq, sessionID)
 }
 
-func PackResponseDescribe(cseq string) string {
+func PackResponseTeardown(cseq string) string {
+	return fmt.Sprintf(ResponseTeardownTmpl, cseq)
+}
+
+func PackResponseDescribe(cseq string, sdp []byte) string {
 	date := time.Now().Format(time.RFC1123)
-	return fmt.Sprintf(ResponseDescribeTmpl, cseq, date, 376)
+	return fmt.Sprintf(ResponseDescribeTmpl, cseq, date, len(sdp), string(sdp))
 }
 
 func PackResponsePlay(cseq string) string {
 	date := time.Now().Format(time.RFC1123)
-	return fmt.Sprintf(PlayTmpl, cseq, date)
+	return fmt.Sprintf(PlayTmpl, cseq, date, sessionID)
+}
+
+func PackResponseNotFound(cseq string) string {
+	return fmt.Sprintf(ResponseNotFoundTmpl, cseq)
+}
+
+// 客户端（PullSession，PushSession）发送的请求
+//
+// @param headers 除CSeq外的其他字段，可以为nil
+// @param body    可以为nil
+func PackRequest(method string, uri string, cseq int, headers map[string]string, body []byte) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, uri)
+	fmt.Fprintf(&b, "%s: %d\r\n", HeaderFieldCSeq, cseq)
+	fmt.Fprintf(&b, "User-Agent: %s\r\n", serverName)
+	for k, v := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	if len(body) > 0 {
+		fmt.Fprintf(&b, "%s: %d\r\n", HeaderFieldContentLength, len(body))
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	return b.String()
 }
@@ -15,15 +15,31 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/connection"
 	"github.com/q191201771/naza/pkg/nazahttp"
 	"github.com/q191201771/naza/pkg/nazalog"
 )
 
 type ServerObserver interface {
-	OnNewRTSPPubSession(session *PubSession)
+	// 返回true则允许推流，返回false（比如鉴权失败，或者流已经存在推流）则强制关闭这个连接
+	// 注意，上层应该在这个回调中调用PubSession.SetOnAVPacket注册音视频数据的监听
+	OnNewRTSPPubSession(session *PubSession) bool
+
 	OnDelRTSPPubSession(session *PubSession)
+
+	// 收到DESCRIBE信令时回调
+	// 返回true则允许拉流，返回false（比如鉴权失败，或者流不存在）则回复404
+	// 注意，上层应该在这个回调中调用SubSession.InitWithAVConfig设置音视频头信息
+	OnNewRTSPSubSessionDescribe(session *SubSession) bool
+
+	// 收到PLAY信令时回调
+	// 返回true则开始拉流，返回false则强制关闭这个连接
+	OnNewRTSPSubSessionPlay(session *SubSession) bool
+
+	// OnNewRTSPSubSessionDescribe返回true的SubSession，连接关闭时都会回调OnDelRTSPSubSession，不管是否已经PLAY
+	OnDelRTSPSubSession(session *SubSession)
 }
 
 // TODO chef:
@@ -34,17 +50,13 @@ type Server struct {
 
 	udpServerPool *UDPServerPool
 	ln            net.Listener
-
-	m                       sync.Mutex
-	presentation2PubSession map[string]*PubSession
 }
 
 func NewServer(addr string, obs ServerObserver) *Server {
 	return &Server{
-		addr:                    addr,
-		obs:                     obs,
-		udpServerPool:           NewUDPServerPool(minServerPort, maxServerPort),
-		presentation2PubSession: make(map[string]*PubSession),
+		addr:          addr,
+		obs:           obs,
+		udpServerPool: NewUDPServerPool(minServerPort, maxServerPort),
 	}
 }
 
@@ -67,10 +79,47 @@ func (s *Server) RunLoop() error {
 	}
 }
 
-func (s *Server) handleTCPConnect(conn net.Conn) {
-	nazalog.Debugf("> handleTCPConnect. conn=%p", conn)
+func (s *Server) Dispose() {
+	if s.ln == nil {
+		return
+	}
+	if err := s.ln.Close(); err != nil {
+		nazalog.Error(err)
+	}
+}
+
+func (s *Server) handleTCPConnect(rawConn net.Conn) {
+	nazalog.Debugf("> handleTCPConnect. conn=%p", rawConn)
+
+	// 使用RTP/AVP/TCP方式时，音视频数据也通过这个连接收发
+	conn := base.NewStatConn(connection.New(rawConn))
+
+	// 一个tcp连接上的信令，只对应一个PubSession或一个SubSession
+	var pubSession *PubSession
+	var subSession *SubSession
+	var isPlaying bool
+
 	r := bufio.NewReader(conn)
 	for {
+		interleaved, err := isInterleaved(r)
+		if err != nil {
+			nazalog.Errorf("read rtsp conn error. err=%v", err)
+			break
+		}
+		if interleaved {
+			channel, b, err := readInterleaved(r)
+			if err != nil {
+				nazalog.Errorf("read interleaved packet error. err=%v", err)
+				break
+			}
+			if pubSession != nil {
+				pubSession.FeedInterleavedPacket(b, channel)
+			} else if subSession != nil {
+				subSession.FeedInterleavedPacket(b, channel)
+			}
+			continue
+		}
+
 		requestLine, headers, err := nazahttp.ReadHTTPHeader(r)
 		if err != nil {
 			nazalog.Errorf("ReadHTTPHeader error. err=%v", err)
@@ -85,7 +134,7 @@ func (s *Server) handleTCPConnect(conn net.Conn) {
 		}
 
 		var body []byte
-		if contentLength, ok := headers["Content-Length"]; ok {
+		if contentLength, ok := headers[HeaderFieldContentLength]; ok {
 			if cl, err := strconv.Atoi(contentLength); err == nil {
 				body = make([]byte, cl)
 				l, err := io.ReadAtLeast(r, body, cl)
@@ -97,11 +146,11 @@ func (s *Server) handleTCPConnect(conn net.Conn) {
 				}
 			}
 		}
-		_ = body
 
 		// TODO chef:
 		// 1. header field not exist?
 		//
+		isClosed := false
 		switch method {
 		case MethodOptions:
 			// pub
@@ -112,84 +161,256 @@ func (s *Server) handleTCPConnect(conn net.Conn) {
 			// pub
 			nazalog.Info("< R ANNOUNCE")
 
-			u, err := url.Parse(uri)
-			if err != nil {
-				nazalog.Errorf("parse uri failed. uri=%s", uri)
+			if pubSession != nil || subSession != nil {
+				nazalog.Errorf("read ANNOUNCE but session already exist. uri=%s", uri)
+				isClosed = true
 				break
 			}
-			items := strings.Split(u.Path, "/")
-			if len(items) < 2 {
+
+			appName, streamName, rawQuery, err := parsePresentation(uri)
+			if err != nil {
 				nazalog.Errorf("uri invalid. uri=%s", uri)
+				isClosed = true
 				break
 			}
-			presentation := items[len(items)-1]
 
 			sdp, err := ParseSDP(body)
 			if err != nil {
 				nazalog.Errorf("parse sdp failed. err=%v", err)
+				isClosed = true
 				break
 			}
 
-			pubSession := NewPubSession(presentation)
-			pubSession.InitWithSDP(sdp)
+			session := NewPubSession(appName, streamName, conn)
+			session.RawQuery = rawQuery
+			session.InitWithSDP(sdp)
 
-			s.obs.OnNewRTSPPubSession(pubSession)
-
-			s.presentation2PubSession[presentation] = pubSession
+			if !s.obs.OnNewRTSPPubSession(session) {
+				nazalog.Warnf("[%s] dispose PubSession since publish refused.", session.UniqueKey)
+				session.Dispose()
+				isClosed = true
+				break
+			}
+			pubSession = session
 
 			resp := PackResponseAnnounce(headers[HeaderFieldCSeq])
 			_, _ = conn.Write([]byte(resp))
 		case MethodSetup:
-			// pub
 			nazalog.Info("< R SETUP")
 
-			u, err := url.Parse(uri)
-			if err != nil {
-				nazalog.Errorf("parse uri failed. uri=%s", uri)
+			if pubSession == nil && subSession == nil {
+				nazalog.Errorf("read SETUP but session not exist. uri=%s", uri)
+				isClosed = true
 				break
 			}
-			items := strings.Split(u.Path, "/")
-			if len(items) < 3 {
-				nazalog.Errorf("uri invalid. uri=%s", uri)
+
+			transport := headers[HeaderFieldTransport]
+			if strings.Contains(transport, TransportTCP) {
+				rtpChannel, rtcpChannel, err := parseTransportPair(transport, "interleaved")
+				if err != nil {
+					nazalog.Errorf("parse interleaved failed. transport=%s", transport)
+					isClosed = true
+					break
+				}
+				if pubSession != nil {
+					pubSession.SetupWithChannel(rtpChannel, rtcpChannel)
+				} else if err := subSession.SetupWithChannel(uri, rtpChannel, rtcpChannel); err != nil {
+					isClosed = true
+					break
+				}
+
+				resp := PackResponseSetupTCP(headers[HeaderFieldCSeq], rtpChannel, rtcpChannel)
+				_, _ = conn.Write([]byte(resp))
 				break
 			}
-			presentation := items[len(items)-2]
 
-			s.m.Lock()
-			session, ok := s.presentation2PubSession[presentation]
-			if !ok {
-				nazalog.Errorf("presentation invalid. presentation=%s", presentation)
+			if pubSession != nil {
+				// pub
+				udpConn, port, err := s.udpServerPool.Acquire()
+				if err != nil {
+					nazalog.Errorf("acquire udp server failed. err=%v", err)
+					isClosed = true
+					break
+				}
+				nazalog.Debugf("acquire udp conn. port=%d", port)
+				pubSession.AddConn(udpConn)
+
+				// 用于发送rtcp rr
+				if _, peerRTCPPort, err := parseTransportPair(transport, "client_port"); err == nil {
+					if peerIP, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+						pubSession.SetupRTCPPeer(udpConn, peerIP, peerRTCPPort)
+					}
+				}
+
+				resp := PackResponseSetup(headers[HeaderFieldCSeq], headers[HeaderFieldTransport], port, port)
+				_, _ = conn.Write([]byte(resp))
 				break
 			}
-			s.m.Unlock()
 
-			udpConn, port, err := s.udpServerPool.Acquire()
-			nazalog.Debugf("acquire udp conn. port=%d", port)
+			// sub
+			peerRTPPort, peerRTCPPort, err := parseTransportPair(transport, "client_port")
+			if err != nil {
+				nazalog.Errorf("[%s] parse client port failed. transport=%s", subSession.UniqueKey, transport)
+				isClosed = true
+				break
+			}
+			peerIP, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+			if err != nil {
+				nazalog.Errorf("[%s] parse remote addr failed. err=%+v", subSession.UniqueKey, err)
+				isClosed = true
+				break
+			}
+			rtpConn, rtcpConn, rtpPort, rtcpPort, err := s.udpServerPool.AcquirePair()
 			if err != nil {
-				nazalog.Errorf("acquire udp server failed. err=%v", err)
+				nazalog.Errorf("[%s] acquire udp server failed. err=%v", subSession.UniqueKey, err)
+				isClosed = true
+				break
+			}
+			nazalog.Debugf("[%s] acquire udp conn. port=%d-%d", subSession.UniqueKey, rtpPort, rtcpPort)
+			if err := subSession.SetupWithConn(uri, rtpConn, rtcpConn, peerIP, peerRTPPort, peerRTCPPort); err != nil {
+				_ = rtpConn.Close()
+				_ = rtcpConn.Close()
+				isClosed = true
 				break
 			}
-			session.AddConn(udpConn)
 
-			resp := PackResponseSetup(headers[HeaderFieldCSeq], headers[HeaderFieldTransport], port, port)
+			resp := PackResponseSetup(headers[HeaderFieldCSeq], transport, rtpPort, rtcpPort)
 			_, _ = conn.Write([]byte(resp))
 		case MethodRecord:
 			// pub
 			nazalog.Info("< R RECORD")
 			resp := PackResponseRecord(headers[HeaderFieldCSeq])
 			_, _ = conn.Write([]byte(resp))
+		case MethodTeardown:
+			nazalog.Info("< R TEARDOWN")
+			resp := PackResponseTeardown(headers[HeaderFieldCSeq])
+			_, _ = conn.Write([]byte(resp))
+			isClosed = true
 		case MethodDescribe:
+			// sub
 			nazalog.Info("< R DESCRIBE")
-			resp := PackResponseDescribe(headers[HeaderFieldCSeq])
+
+			if pubSession != nil || subSession != nil {
+				nazalog.Errorf("read DESCRIBE but session already exist. uri=%s", uri)
+				isClosed = true
+				break
+			}
+
+			appName, streamName, rawQuery, err := parsePresentation(uri)
+			if err != nil {
+				nazalog.Errorf("uri invalid. uri=%s", uri)
+				isClosed = true
+				break
+			}
+
+			session := NewSubSession(appName, streamName, conn)
+			session.RawQuery = rawQuery
+			if !s.obs.OnNewRTSPSubSessionDescribe(session) {
+				nazalog.Warnf("[%s] describe refused or stream not found.", session.UniqueKey)
+				session.Dispose()
+				resp := PackResponseNotFound(headers[HeaderFieldCSeq])
+				_, _ = conn.Write([]byte(resp))
+				isClosed = true
+				break
+			}
+			subSession = session
+
+			resp := PackResponseDescribe(headers[HeaderFieldCSeq], subSession.SDP())
 			_, _ = conn.Write([]byte(resp))
 		case MethodPlay:
+			// sub
 			nazalog.Info("< R PLAY")
+
+			if subSession == nil || isPlaying {
+				nazalog.Errorf("read PLAY but SubSession not exist or already playing. uri=%s", uri)
+				isClosed = true
+				break
+			}
+
+			// 开始拉流后，音视频数据可能通过信令连接发送，使用异步发送，避免阻塞上层
+			conn.ModWriteChanSize(wChanSize)
+
+			if !s.obs.OnNewRTSPSubSessionPlay(subSession) {
+				nazalog.Warnf("[%s] dispose SubSession since play refused.", subSession.UniqueKey)
+				isClosed = true
+				break
+			}
+			isPlaying = true
+
 			resp := PackResponsePlay(headers[HeaderFieldCSeq])
 			_, _ = conn.Write([]byte(resp))
 		default:
 			nazalog.Error(method)
 		}
+
+		if isClosed {
+			break
+		}
 	}
 	_ = conn.Close()
-	nazalog.Debugf("< handleTCPConnect. conn=%p", conn)
+
+	if pubSession != nil {
+		s.obs.OnDelRTSPPubSession(pubSession)
+		pubSession.Dispose()
+	}
+	if subSession != nil {
+		s.obs.OnDelRTSPSubSession(subSession)
+		subSession.Dispose()
+	}
+	nazalog.Debugf("< handleTCPConnect. conn=%p", rawConn)
+}
+
+// 从SETUP信令的Transport字段中解析出RTP和RTCP对应的一对值，比如对端端口，或者interleaved的channel
+//
+// 例子:
+// RTP/AVP;unicast;client_port=9300-9301, client_port     -> 9300, 9301
+// RTP/AVP/TCP;unicast;interleaved=0-1,   interleaved     -> 0, 1
+func parseTransportPair(transport string, key string) (first int, second int, err error) {
+	prefix := key + "="
+	for _, item := range strings.Split(transport, ";") {
+		item = strings.TrimSpace(item)
+		if !strings.HasPrefix(item, prefix) {
+			continue
+		}
+		values := strings.Split(strings.TrimPrefix(item, prefix), "-")
+		if len(values) != 2 {
+			break
+		}
+		if first, err = strconv.Atoi(values[0]); err != nil {
+			return
+		}
+		second, err = strconv.Atoi(values[1])
+		return
+	}
+	err = ErrRTSP
+	return
+}
+
+// 从ANNOUNCE或DESCRIBE信令的uri中解析出appName，streamName，以及rawQuery（?后面的参数，不包含?）
+//
+// 例子:
+// rtsp://localhost:5544/live/test110             -> live, test110, ""
+// rtsp://localhost:5544/test110                  -> "", test110, ""
+// rtsp://localhost:5544/live/test110?token=1234  -> live, test110, token=1234
+func parsePresentation(uri string) (appName string, streamName string, rawQuery string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	rawQuery = u.RawQuery
+	items := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch len(items) {
+	case 1:
+		streamName = items[0]
+	case 2:
+		appName = items[0]
+		streamName = items[1]
+	default:
+		err = ErrRTSP
+		return
+	}
+	if streamName == "" {
+		err = ErrRTSP
+	}
+	return
 }
@@ -0,0 +1,138 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/q191201771/naza/pkg/assert"
+	"github.com/q191201771/naza/pkg/bele"
+)
+
+func TestParsePresentation(t *testing.T) {
+	appName, streamName, rawQuery, err := parsePresentation("rtsp://localhost:5544/live/test110")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "live", appName)
+	assert.Equal(t, "test110", streamName)
+	assert.Equal(t, "", rawQuery)
+
+	appName, streamName, _, err = parsePresentation("rtsp://localhost:5544/test110")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "", appName)
+	assert.Equal(t, "test110", streamName)
+
+	appName, streamName, rawQuery, err = parsePresentation("rtsp://localhost:5544/live/test110?expire=1600000000&sign=abc")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "live", appName)
+	assert.Equal(t, "test110", streamName)
+	assert.Equal(t, "expire=1600000000&sign=abc", rawQuery)
+
+	_, _, _, err = parsePresentation("rtsp://localhost:5544/")
+	assert.IsNotNil(t, err)
+}
+
+func TestParseTransportPair(t *testing.T) {
+	first, second, err := parseTransportPair("RTP/AVP;unicast;client_port=9300-9301", "client_port")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 9300, first)
+	assert.Equal(t, 9301, second)
+
+	first, second, err = parseTransportPair("RTP/AVP/TCP;unicast;interleaved=2-3;mode=record", "interleaved")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, first)
+	assert.Equal(t, 3, second)
+
+	_, _, err = parseTransportPair("RTP/AVP/TCP;unicast", "interleaved")
+	assert.IsNotNil(t, err)
+}
+
+func TestInterleaved(t *testing.T) {
+	b := packInterleaved(3, []byte{1, 2, 3})
+	assert.Equal(t, []byte{'$', 3, 0, 3, 1, 2, 3}, b)
+
+	r := bufio.NewReader(bytes.NewReader(append(b, []byte("OPTIONS")...)))
+	ok, err := isInterleaved(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	channel, payload, err := readInterleaved(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 3, channel)
+	assert.Equal(t, []byte{1, 2, 3}, payload)
+	ok, err = isInterleaved(r)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+}
+
+// 只接收推流，把PubSession交给测试代码
+type testPubObserver struct {
+	pubChan chan *PubSession
+}
+
+func (o *testPubObserver) OnNewRTSPPubSession(session *PubSession) bool {
+	session.SetOnAVPacket(func(pkt AVPacket) {})
+	o.pubChan <- session
+	return true
+}
+func (o *testPubObserver) OnDelRTSPPubSession(session *PubSession)              {}
+func (o *testPubObserver) OnNewRTSPSubSessionDescribe(session *SubSession) bool { return false }
+func (o *testPubObserver) OnNewRTSPSubSessionPlay(session *SubSession) bool     { return false }
+func (o *testPubObserver) OnDelRTSPSubSession(session *SubSession)              {}
+
+func TestSessionStat(t *testing.T) {
+	obs := &testPubObserver{pubChan: make(chan *PubSession, 1)}
+	server := NewServer("127.0.0.1:15544", obs)
+	assert.Equal(t, nil, server.Listen())
+	go server.RunLoop()
+	defer server.Dispose()
+
+	// 分别使用RTP/AVP/TCP和UDP方式，统计信令连接和UDP上收发的数据
+	for i, overTCP := range []bool{true, false} {
+		push := NewPushSession(func(option *PushSessionOption) {
+			option.PushTimeoutMS = 5000
+			option.OverTCP = overTCP
+		})
+		assert.Equal(t, nil, push.InitWithAVConfig(nil, goldenSPS, goldenPPS, []byte{0x12, 0x10}))
+		assert.Equal(t, nil, push.Push(fmt.Sprintf("rtsp://127.0.0.1:15544/live/test%d", i)))
+		pub := <-obs.pubChan
+		// RECORD信令已经完成，之后的数据都是音视频数据
+		pushBytesBefore := push.GetStat().WroteBytesSum
+		pubBytesBefore := pub.GetStat().ReadBytesSum
+
+		nalu := []byte{0x65, 0x88, 0x84, 0x00}
+		frame := make([]byte, 4+len(nalu))
+		bele.BEPutUint32(frame, uint32(len(nalu)))
+		copy(frame[4:], nalu)
+		for j := 0; j < 10; j++ {
+			push.WriteAVPacket(AVPacket{Timestamp: uint32(j * 40), Payload: frame, PayloadType: RTPPacketTypeAVC})
+			push.WriteAVPacket(AVPacket{Timestamp: uint32(j * 40), Payload: []byte{0x21, 0x00, 0x49}, PayloadType: RTPPacketTypeAAC})
+		}
+
+		pushStat := push.GetStat()
+		assert.Equal(t, uint64(10), pushStat.VideoCount)
+		assert.Equal(t, uint64(10), pushStat.AudioCount)
+		assert.Equal(t, uint32(360), pushStat.LastTimestamp)
+		// 10个视频RTP包（12字节头，4字节NALU），10个音频RTP包（12字节头，4字节AU头，3字节数据），另外还有rtcp sr
+		assert.Equal(t, true, pushStat.WroteBytesSum-pushBytesBefore >= 10*(12+4)+10*(12+4+3))
+
+		var pubStat = pub.GetStat()
+		for k := 0; k < 50 && (pubStat.VideoCount < 10 || pubStat.AudioCount < 10); k++ {
+			time.Sleep(20 * time.Millisecond)
+			pubStat = pub.GetStat()
+		}
+		assert.Equal(t, uint64(10), pubStat.VideoCount)
+		assert.Equal(t, uint64(10), pubStat.AudioCount)
+		assert.Equal(t, pushStat.WroteBytesSum-pushBytesBefore, pubStat.ReadBytesSum-pubBytesBefore)
+
+		push.Dispose()
+	}
+}
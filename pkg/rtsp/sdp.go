@@ -10,16 +10,41 @@ package rtsp
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/q191201771/lal/pkg/aac"
 )
 
 var ErrSDP = errors.New("lal.sdp: fxxk")
 
+// a=rtpmap中的encoding name
+const (
+	EncodingNameH264 = "H264"
+	EncodingNameH265 = "H265"
+	EncodingNameAAC  = "MPEG4-GENERIC"
+)
+
+// PackSDP生成的sdp中，每路流的a=control
+const (
+	ControlVideo = "streamid=0"
+	ControlAudio = "streamid=1"
+)
+
 type SDP struct {
 	ARTPMapList   []ARTPMap
 	AFmtPBaseList []AFmtPBase
+	MediaDescList []MediaDesc
+}
+
+// 一个m=开始的媒体描述，以及其中的a=control
+type MediaDesc struct {
+	Media       string // video, audio
+	PayloadType int    // m=中的第一个fmt
+	Control     string // a=control的值，可能是相对路径，也可能是绝对路径，为空表示没有
 }
 
 type ARTPMap struct {
@@ -39,8 +64,21 @@ func ParseSDP(b []byte) (SDP, error) {
 	var sdp SDP
 
 	s := string(b)
-	lines := strings.Split(s, "\r\n")
+	// 注意，有的设备使用\n作为行结束符
+	lines := strings.Split(s, "\n")
 	for _, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "m=") {
+			md, err := ParseMediaDesc(line)
+			if err != nil {
+				return sdp, err
+			}
+			sdp.MediaDescList = append(sdp.MediaDescList, md)
+		}
+		// 只关心媒体级别的a=control，会话级别的忽略
+		if strings.HasPrefix(line, "a=control:") && len(sdp.MediaDescList) > 0 {
+			sdp.MediaDescList[len(sdp.MediaDescList)-1].Control = strings.TrimPrefix(line, "a=control:")
+		}
 		if strings.HasPrefix(line, "a=rtpmap") {
 			aRTPMap, err := ParseARTPMap(line)
 			if err != nil {
@@ -60,6 +98,23 @@ func ParseSDP(b []byte) (SDP, error) {
 	return sdp, nil
 }
 
+// 例子见单元测试
+func ParseMediaDesc(s string) (ret MediaDesc, err error) {
+	// rfc4566 5.14.  Media Descriptions ("m=")
+	//
+	// m=<media> <port> <proto> <fmt> ...
+	//
+
+	items := strings.Split(strings.TrimPrefix(s, "m="), " ")
+	if len(items) < 4 {
+		err = ErrSDP
+		return
+	}
+	ret.Media = items[0]
+	ret.PayloadType, err = strconv.Atoi(items[3])
+	return
+}
+
 // 例子见单元测试
 func ParseARTPMap(s string) (ret ARTPMap, err error) {
 	// rfc 3640 3.3.1.  General
@@ -128,6 +183,10 @@ func ParseAFmtPBase(s string) (ret AFmtPBase, err error) {
 	items = strings.Split(items[1], ";")
 	for _, pp := range items {
 		pp = strings.TrimSpace(pp)
+		// 有的设备在最后一个参数后面也加了分号
+		if pp == "" {
+			continue
+		}
 		kv := strings.SplitN(pp, "=", 2)
 		if len(kv) != 2 {
 			err = ErrSDP
@@ -141,11 +200,6 @@ func ParseAFmtPBase(s string) (ret AFmtPBase, err error) {
 
 // 例子见单元测试
 func ParseSPSPPS(a AFmtPBase) (sps, pps []byte, err error) {
-	if a.Format != RTPPacketTypeAVC {
-		err = ErrSDP
-		return
-	}
-
 	v, ok := a.Parameters["sprop-parameter-sets"]
 	if !ok {
 		err = ErrSDP
@@ -167,3 +221,111 @@ func ParseSPSPPS(a AFmtPBase) (sps, pps []byte, err error) {
 
 	return
 }
+
+// 例子见单元测试
+func ParseVPSSPSPPS(a AFmtPBase) (vps, sps, pps []byte, err error) {
+	// rfc7798 7.1.  Media Type Registration
+	// sprop-vps, sprop-sps, sprop-pps: base64编码，可能包含多个，使用逗号分隔，这里只取第一个
+	var items [3][]byte
+	for i, name := range []string{"sprop-vps", "sprop-sps", "sprop-pps"} {
+		v, ok := a.Parameters[name]
+		if !ok {
+			err = ErrSDP
+			return
+		}
+		items[i], err = base64.StdEncoding.DecodeString(strings.SplitN(v, ",", 2)[0])
+		if err != nil {
+			return
+		}
+	}
+	return items[0], items[1], items[2], nil
+}
+
+// 例子见单元测试
+func ParseASC(a AFmtPBase) ([]byte, error) {
+	// rfc 3640 4.1.  MIME Type Registration
+	// config: a hexadecimal representation of an octet string that expresses the media payload configuration
+	v, ok := a.Parameters["config"]
+	if !ok {
+		return nil, ErrSDP
+	}
+
+	asc, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(asc) < 2 {
+		return nil, ErrSDP
+	}
+	return asc, nil
+}
+
+// rfc4566
+// * v=0
+//   Session Description Protocol Version (v)
+// * o=<username> <sess-id> <sess-version> <nettype> <addrtype> <unicast-address>
+//   Owner/Creator, Session Id (o)
+// * s=
+//   Session Name (s)
+// * c=
+//   Connection Information (c)
+// * t=<start-time> <stop-time>
+//   Time Description, active time (t)
+// * a=
+//   Session Attribute | Media Attribute (a)
+// * m=
+//   Media Description, name and address (m)
+// * b=
+//   Bandwidth Information (b)
+//
+// 使用音视频头信息生成sdp，例子见单元测试
+//
+// @param vps      不包含start code，不为nil时表示视频为HEVC，否则为AVC
+// @param sps, pps 不包含start code，为nil时表示没有视频
+// @param asc      AAC的AudioSpecificConfig，为nil时表示没有音频
+func PackSDP(vps, sps, pps, asc []byte) ([]byte, error) {
+	hasVideo := sps != nil && pps != nil
+	hasAudio := asc != nil
+	if !hasVideo && !hasAudio {
+		return nil, ErrSDP
+	}
+
+	var b strings.Builder
+	b.WriteString("v=0\r\n")
+	b.WriteString("o=- 0 0 IN IP4 127.0.0.1\r\n")
+	b.WriteString("s=No Name\r\n")
+	b.WriteString("c=IN IP4 127.0.0.1\r\n")
+	b.WriteString("t=0 0\r\n")
+	b.WriteString("a=tool:" + serverName + "\r\n")
+
+	if hasVideo && vps != nil {
+		fmt.Fprintf(&b, "m=video 0 RTP/AVP %d\r\n", RTPPacketTypeHEVC)
+		fmt.Fprintf(&b, "a=rtpmap:%d %s/%d\r\n", RTPPacketTypeHEVC, EncodingNameH265, 90000)
+		fmt.Fprintf(&b, "a=fmtp:%d sprop-vps=%s; sprop-sps=%s; sprop-pps=%s\r\n",
+			RTPPacketTypeHEVC, base64.StdEncoding.EncodeToString(vps), base64.StdEncoding.EncodeToString(sps), base64.StdEncoding.EncodeToString(pps))
+		b.WriteString("a=control:" + ControlVideo + "\r\n")
+	} else if hasVideo {
+		if len(sps) < 4 {
+			return nil, ErrSDP
+		}
+		fmt.Fprintf(&b, "m=video 0 RTP/AVP %d\r\n", RTPPacketTypeAVC)
+		fmt.Fprintf(&b, "a=rtpmap:%d %s/%d\r\n", RTPPacketTypeAVC, EncodingNameH264, 90000)
+		fmt.Fprintf(&b, "a=fmtp:%d packetization-mode=1; sprop-parameter-sets=%s,%s; profile-level-id=%s\r\n",
+			RTPPacketTypeAVC, base64.StdEncoding.EncodeToString(sps), base64.StdEncoding.EncodeToString(pps), hex.EncodeToString(sps[1:4]))
+		b.WriteString("a=control:" + ControlVideo + "\r\n")
+	}
+
+	if hasAudio {
+		samplingFrequency, channels, err := aac.ParseSamplingFrequencyAndChannels(asc)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&b, "m=audio 0 RTP/AVP %d\r\n", RTPPacketTypeAAC)
+		fmt.Fprintf(&b, "a=rtpmap:%d %s/%d/%d\r\n", RTPPacketTypeAAC, EncodingNameAAC, samplingFrequency, channels)
+		fmt.Fprintf(&b, "a=fmtp:%d profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3; config=%s\r\n",
+			RTPPacketTypeAAC, hex.EncodeToString(asc))
+		b.WriteString("a=control:" + ControlAudio + "\r\n")
+	}
+
+	return []byte(b.String()), nil
+}
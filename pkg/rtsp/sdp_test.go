@@ -9,6 +9,7 @@
 package rtsp
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/q191201771/naza/pkg/nazalog"
@@ -41,10 +42,32 @@ var goldenPPS = []byte{
 	0x68, 0xEB, 0xEC, 0xB2, 0x2C,
 }
 
+var goldenVPS = []byte{
+	0x40, 0x01, 0x0c, 0x01, 0xff, 0xff, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0x95, 0x98, 0x09,
+}
+
+var goldenHEVCSPS = []byte{
+	0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16,
+	0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70, 0x80, 0x00, 0x01, 0xf4, 0x80, 0x00, 0x3a, 0x98, 0x04,
+}
+
+var goldenHEVCPPS = []byte{
+	0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40,
+}
+
 func TestParseSDP(t *testing.T) {
 	sdp, err := ParseSDP([]byte(goldenSDP))
 	assert.Equal(t, nil, err)
 	nazalog.Debugf("sdp=%+v", sdp)
+	assert.Equal(t, []MediaDesc{
+		{Media: "video", PayloadType: 96, Control: "streamid=0"},
+		{Media: "audio", PayloadType: 97, Control: "streamid=1"},
+	}, sdp.MediaDescList)
+
+	// 使用\n作为行结束符
+	sdp2, err := ParseSDP([]byte(strings.Replace(goldenSDP, "\r\n", "\n", -1)))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, sdp, sdp2)
 }
 
 func TestParseARTPMap(t *testing.T) {
@@ -96,6 +119,11 @@ func TestParseFmtPBase(t *testing.T) {
 		assert.Equal(t, nil, err)
 		assert.Equal(t, out, actual)
 	}
+
+	// 最后一个参数后面带分号
+	actual, err := ParseAFmtPBase("a=fmtp:96 packetization-mode=1;profile-level-id=640020;")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, AFmtPBase{Format: 96, Parameters: map[string]string{"packetization-mode": "1", "profile-level-id": "640020"}}, actual)
 }
 
 func TestParseSPSPPS(t *testing.T) {
@@ -107,3 +135,59 @@ func TestParseSPSPPS(t *testing.T) {
 	assert.Equal(t, goldenSPS, sps)
 	assert.Equal(t, goldenPPS, pps)
 }
+
+func TestParseVPSSPSPPS(t *testing.T) {
+	s := "a=fmtp:98 sprop-vps=QAEMAf//AWAAAAMAkAAAAwAAAwBdlZgJ; sprop-sps=QgEBAWAAAAMAkAAAAwAAAwBdoAKAgC0WWVmkkyvAWnCAAAH0gAA6mAQ=; sprop-pps=RAHBcrRiQA=="
+	f, err := ParseAFmtPBase(s)
+	assert.Equal(t, nil, err)
+	vps, sps, pps, err := ParseVPSSPSPPS(f)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, goldenVPS, vps)
+	assert.Equal(t, goldenHEVCSPS, sps)
+	assert.Equal(t, goldenHEVCPPS, pps)
+
+	_, _, _, err = ParseVPSSPSPPS(AFmtPBase{Format: 98, Parameters: map[string]string{"sprop-vps": "QAEMAf//AWAAAAMAkAAAAwAAAwBdlZgJ"}})
+	assert.Equal(t, ErrSDP, err)
+}
+
+func TestParseASC(t *testing.T) {
+	s := "a=fmtp:97 profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3; config=1210"
+	f, err := ParseAFmtPBase(s)
+	assert.Equal(t, nil, err)
+	asc, err := ParseASC(f)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{0x12, 0x10}, asc)
+}
+
+func TestPackSDP(t *testing.T) {
+	b, err := PackSDP(nil, goldenSPS, goldenPPS, []byte{0x12, 0x10})
+	assert.Equal(t, nil, err)
+	sdp, err := ParseSDP(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(sdp.ARTPMapList))
+	assert.Equal(t, ARTPMap{PayloadType: 96, EncodingName: "H264", ClockRate: 90000}, sdp.ARTPMapList[0])
+	assert.Equal(t, ARTPMap{PayloadType: 97, EncodingName: "MPEG4-GENERIC", ClockRate: 44100, EncodingParameters: "2"}, sdp.ARTPMapList[1])
+	assert.Equal(t, 2, len(sdp.AFmtPBaseList))
+	sps, pps, err := ParseSPSPPS(sdp.AFmtPBaseList[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, goldenSPS, sps)
+	assert.Equal(t, goldenPPS, pps)
+	asc, err := ParseASC(sdp.AFmtPBaseList[1])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte{0x12, 0x10}, asc)
+
+	// hevc
+	b, err = PackSDP(goldenVPS, goldenHEVCSPS, goldenHEVCPPS, nil)
+	assert.Equal(t, nil, err)
+	sdp, err = ParseSDP(b)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []ARTPMap{{PayloadType: 98, EncodingName: "H265", ClockRate: 90000}}, sdp.ARTPMapList)
+	vps, sps, pps, err := ParseVPSSPSPPS(sdp.AFmtPBaseList[0])
+	assert.Equal(t, nil, err)
+	assert.Equal(t, goldenVPS, vps)
+	assert.Equal(t, goldenHEVCSPS, sps)
+	assert.Equal(t, goldenHEVCPPS, pps)
+
+	_, err = PackSDP(nil, nil, nil, nil)
+	assert.IsNotNil(t, err)
+}
@@ -0,0 +1,114 @@
+// Copyright 2020, Chef.  All rights reserved.
+// https://github.com/q191201771/lal
+//
+// Use of this source code is governed by a MIT-style license
+// that can be found in the License file.
+//
+// Author: Chef (191201771@qq.com)
+
+package rtsp
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/q191201771/lal/pkg/base"
+	"github.com/q191201771/naza/pkg/nazalog"
+	"github.com/q191201771/naza/pkg/unique"
+)
+
+type SubSession struct {
+	UniqueKey  string
+	AppName    string
+	StreamName string // presentation
+	RawQuery   string // DESCRIBE信令的uri中?后面的参数，不包含?
+
+	*baseOutSession
+
+	cmdConn   *base.StatConn
+	startTime time.Time
+}
+
+// @param cmdConn 接收rtsp信令的tcp连接，Dispose时会被关闭
+//                使用RTP/AVP/TCP方式时，音视频数据也通过这个连接发送
+func NewSubSession(appName string, streamName string, cmdConn *base.StatConn) *SubSession {
+	uk := unique.GenUniqueKey("RTSPSUB")
+	nazalog.Infof("[%s] lifecycle new rtsp SubSession. appName=%s, streamName=%s", uk, appName, streamName)
+	return &SubSession{
+		UniqueKey:      uk,
+		AppName:        appName,
+		StreamName:     streamName,
+		baseOutSession: newBaseOutSession(uk, cmdConn),
+		cmdConn:        cmdConn,
+		startTime:      time.Now(),
+	}
+}
+
+// 处理SETUP信令
+//
+// @param uri          SETUP信令中的uri，通过结尾的a=control确定是音频还是视频
+// @param rtpConn      本地用于发送RTP的UDP连接
+// @param rtcpConn     本地用于发送RTCP的UDP连接
+// @param peerIP       对端IP
+// @param peerRTPPort  对端接收RTP的端口
+// @param peerRTCPPort 对端接收RTCP的端口
+func (s *SubSession) SetupWithConn(uri string, rtpConn, rtcpConn *net.UDPConn, peerIP string, peerRTPPort, peerRTCPPort int) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	track, err := s.getTrackForSetup(uri)
+	if err != nil {
+		return err
+	}
+	s.setupTrackWithConn(track, rtpConn, rtcpConn, peerIP, peerRTPPort, peerRTCPPort)
+	return nil
+}
+
+// 处理RTP/AVP/TCP方式的SETUP信令
+//
+// @param uri 见SetupWithConn
+func (s *SubSession) SetupWithChannel(uri string, rtpChannel int, rtcpChannel int) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	track, err := s.getTrackForSetup(uri)
+	if err != nil {
+		return err
+	}
+	s.setupTrackWithChannel(track, rtpChannel, rtcpChannel)
+	return nil
+}
+
+func (s *SubSession) GetStat() base.StatSession {
+	return base.NewStatSession(base.ProtocolRTSP, s.UniqueKey, s.startTime, s.cmdConn.RemoteAddr().String(), s.cmdConn, &s.statAV)
+}
+
+func (s *SubSession) Dispose() {
+	nazalog.Infof("[%s] lifecycle dispose rtsp SubSession.", s.UniqueKey)
+
+	s.baseOutSession.dispose()
+
+	if s.cmdConn != nil {
+		_ = s.cmdConn.Close()
+	}
+}
+
+// 注意，调用方需持有锁
+func (s *SubSession) getTrackForSetup(uri string) (*outTrack, error) {
+	var track *outTrack
+	if strings.HasSuffix(uri, ControlVideo) {
+		track = s.videoTrack
+	} else if strings.HasSuffix(uri, ControlAudio) {
+		track = s.audioTrack
+	}
+	if track == nil {
+		nazalog.Errorf("[%s] setup but track not exist. uri=%s", s.UniqueKey, uri)
+		return nil, ErrRTSP
+	}
+	if s.isTrackSetup(track) {
+		nazalog.Errorf("[%s] setup but track already setup. uri=%s", s.UniqueKey, uri)
+		return nil, ErrRTSP
+	}
+	return track, nil
+}
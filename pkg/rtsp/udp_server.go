@@ -54,9 +54,21 @@ func (s *UDPServer) RunLoop() error {
 	for {
 		b := make([]byte, udpMaxPacketLength)
 		l, a, e := s.conn.ReadFromUDP(b)
-		if e != nil && (l < 0 || l > udpMaxPacketLength) {
+		if e != nil {
+			// 比如conn被关闭了
+			return e
+		}
+		if l < 0 || l > udpMaxPacketLength {
 			nazalog.Errorf("ReadFromUDP length invalid. length=%d", l)
+			continue
 		}
 		s.onReadUDPPacket(b[:l], a.String(), e)
 	}
 }
+
+func (s *UDPServer) Dispose() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -69,3 +69,37 @@ func (u *UDPServerPool) Acquire() (*net.UDPConn, uint16, error) {
 		return conn, p, nil
 	}
 }
+
+// 获取两个相邻的UDP端口，第一个端口为偶数，一般用于RTP和RTCP
+func (u *UDPServerPool) AcquirePair() (rtpConn *net.UDPConn, rtcpConn *net.UDPConn, rtpPort uint16, rtcpPort uint16, err error) {
+	u.m.Lock()
+	defer u.m.Unlock()
+
+	minPort := u.minPort + (u.minPort % 2)
+	if minPort < u.minPort || minPort >= u.maxPort {
+		return nil, nil, 0, 0, ErrUDP
+	}
+
+	for p := minPort; p < u.maxPort; p += 2 {
+		rtpConn, err = listenUDPWithPort(p)
+		if err != nil {
+			continue
+		}
+		rtcpConn, err = listenUDPWithPort(p + 1)
+		if err != nil {
+			_ = rtpConn.Close()
+			continue
+		}
+		return rtpConn, rtcpConn, p, p + 1, nil
+	}
+	return nil, nil, 0, 0, ErrUDP
+}
+
+func listenUDPWithPort(port uint16) (*net.UDPConn, error) {
+	addr := fmt.Sprintf(":%d", port)
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenUDP("udp", udpAddr)
+}